@@ -0,0 +1,200 @@
+package auth_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	raw, prefix, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(raw, auth.APIKeyPrefix))
+	assert.Len(t, raw, len(auth.APIKeyPrefix)+64)
+	assert.True(t, strings.HasPrefix(raw, prefix))
+	assert.Less(t, len(prefix), len(raw))
+	assert.Equal(t, auth.HashAPIKey(raw), hash)
+	assert.NotContains(t, hash, raw)
+
+	other, _, _, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, raw, other)
+}
+
+func TestRequire_APIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	raw, _, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		opts           auth.RequireOptions
+		header         string
+		setupMock      func(m *testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name:   "スコープを持つキー->200",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersRead}, Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}, nil)
+				m.On("TouchAPIKeyLastUsed", mock.Anything, int64(9)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "最終利用日時の更新に失敗しても通す->200",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header: "apikey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersRead}, Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}, nil)
+				m.On("TouchAPIKeyLastUsed", mock.Anything, int64(9)).Return(errors.New("db error"))
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "スコープ不足->403",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersWrite}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersRead}, Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageAPIKeyScope,
+		},
+		{
+			name:   "スコープを宣言していないルート->403",
+			opts:   auth.RequireOptions{},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersRead, auth.ScopeOrdersWrite}, Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageAPIKeyScope,
+		},
+		{
+			name:   "スコープがあっても持ち主のロールが不足->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}, Scopes: []string{auth.ScopeOrdersWrite}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersWrite}, Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}, nil)
+				m.On("TouchAPIKeyLastUsed", mock.Anything, int64(9)).Return(nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageStaff,
+		},
+		{
+			name:   "管理者アカウントのキーはスコープがあっても403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}, Scopes: []string{auth.ScopeOrdersWrite}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 1, Scopes: []string{auth.ScopeOrdersWrite}, Role: auth.RoleAdmin, Status: auth.UserStatusActive,
+				}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageAPIKeyScope,
+		},
+		{
+			name:   "持ち主が停止中->403",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+					ID: 9, UserID: 3, Scopes: []string{auth.ScopeOrdersRead}, Role: auth.RoleMember, Status: auth.UserStatusSuspended,
+				}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageSuspended,
+		},
+		{
+			name:   "失効済み・期限切れ・未登録のキー->401",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageAPIKey,
+		},
+		{
+			name:           "形式の違うキーはDBを引かずに401",
+			opts:           auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header:         "ApiKey not-a-key",
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageAPIKey,
+		},
+		{
+			name:   "DBエラー->500",
+			opts:   auth.RequireOptions{Scopes: []string{auth.ScopeOrdersRead}},
+			header: "ApiKey " + raw,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{}, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			opts := tt.opts
+			opts.Queries = mockDB
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/protected", auth.Require(opts), func(c *gin.Context) {
+				p := auth.MustPrincipal(c)
+				c.JSON(http.StatusOK, gin.H{"user_id": p.UserID, "api_key_id": p.APIKeyID, "session_id": p.SessionID})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, float64(3), body["user_id"])
+				assert.Equal(t, float64(9), body["api_key_id"])
+				assert.Equal(t, "", body["session_id"])
+			}
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -1,40 +1,43 @@
 package auth
 
 import (
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenGenerator はアクセストークンを発行する。
+// role は role クレームとして埋め込み、Require の RecheckPolicy によっては DB を引かずに使う。
+// sessionID はリフレッシュトークンのファミリーIDで、sid クレームとして埋め込む
 type TokenGenerator interface {
-	GenerateToken(userID int64) (string, error)
+	GenerateToken(userID int64, role, sessionID string) (string, error)
 }
 
 type DefaultTokenGenerator struct{}
 
-func (d DefaultTokenGenerator) GenerateToken(userID int64) (string, error) {
+func (d DefaultTokenGenerator) GenerateToken(userID int64, role, sessionID string) (string, error) {
+	ks, err := currentKeySet()
+	if err != nil {
+		return "", err
+	}
 	claims := jwt.MapClaims{
 		"user.id": userID,
+		"role":    role,
 		"exp":     time.Now().Add(time.Minute * 15).Unix(),
 		"iat":     time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(getJWTSecret())
-}
-
-func getJWTSecret() []byte {
-	s := os.Getenv("JWT_SECRET")
-	return []byte(s)
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+	return ks.signToken(claims)
 }
 
 func ValidateToken(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return getJWTSecret(), nil
-	})
+	ks, err := currentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	return jwt.Parse(tokenString, ks.keyfunc, jwt.WithValidMethods(ks.validMethods()))
 }
 
 // Validate is an alias for ValidateToken so tests can override it.
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const rsaMinBits = 2048
+
+var ErrNoSigningKey = errors.New("jwt signing key is not configured")
+
+// jwtKey は署名・検証に使う鍵1本分。
+// kid が空のものは JWT_SECRET による HS256 で、JWKS には公開しない
+type jwtKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   any // 秘密鍵 / HMAC シークレット。検証専用なら nil
+	verify any // 公開鍵 / HMAC シークレット
+	jwk    *JWK
+}
+
+// KeySet は署名鍵1本と、ローテーション期間中も受け付ける検証鍵をまとめたもの
+type KeySet struct {
+	signing *jwtKey
+	verify  map[string]*jwtKey
+}
+
+// JWK は RFC 7517 の公開鍵表現。RSA は n/e、Ed25519 は crv/x を使う
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeySetFromEnv は起動時に鍵を読み込む。
+//   - JWT_PRIVATE_KEY_FILE: 署名用の秘密鍵(RSA / Ed25519 の PEM)。未設定なら JWT_SECRET の HS256 で署名する
+//   - JWT_VERIFY_KEY_FILES: ローテーション前の鍵などの検証専用鍵(カンマ区切り)
+//   - JWT_SECRET: HS256 のシークレット。秘密鍵と併用した場合は移行期間の検証専用になる
+//
+// 署名に使える鍵が無ければエラーを返し、空のシークレットで署名しないようにする。
+func LoadKeySetFromEnv() (*KeySet, error) {
+	var verifyFiles []string
+	for _, f := range strings.Split(os.Getenv("JWT_VERIFY_KEY_FILES"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			verifyFiles = append(verifyFiles, f)
+		}
+	}
+	return LoadKeySet(os.Getenv("JWT_PRIVATE_KEY_FILE"), verifyFiles, os.Getenv("JWT_SECRET"))
+}
+
+func LoadKeySet(privateKeyFile string, verifyKeyFiles []string, hmacSecret string) (*KeySet, error) {
+	ks := &KeySet{verify: map[string]*jwtKey{}}
+
+	if hmacSecret != "" {
+		ks.verify[""] = &jwtKey{
+			method: jwt.SigningMethodHS256,
+			sign:   []byte(hmacSecret),
+			verify: []byte(hmacSecret),
+		}
+	}
+
+	if privateKeyFile != "" {
+		key, err := loadKeyFile(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key.sign == nil {
+			return nil, fmt.Errorf("%s: signing key must be a private key", privateKeyFile)
+		}
+		ks.signing = key
+		ks.verify[key.kid] = key
+	} else if hmac, ok := ks.verify[""]; ok {
+		ks.signing = hmac
+	} else {
+		return nil, fmt.Errorf("%w: set JWT_PRIVATE_KEY_FILE or JWT_SECRET", ErrNoSigningKey)
+	}
+
+	for _, path := range verifyKeyFiles {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ks.verify[key.kid]; dup {
+			continue
+		}
+		// 検証専用の鍵で署名しないよう秘密鍵は捨てる
+		key.sign = nil
+		ks.verify[key.kid] = key
+	}
+	return ks, nil
+}
+
+// SigningKeyID は署名に使う鍵の kid。HS256 なら空
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.kid
+}
+
+func (ks *KeySet) signToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	if ks.signing.kid != "" {
+		token.Header["kid"] = ks.signing.kid
+	}
+	return token.SignedString(ks.signing.sign)
+}
+
+// keyfunc は kid から検証鍵を選ぶ。kid の無いトークンは HS256 のシークレットでのみ検証する。
+// 鍵ごとにアルゴリズムを固定し、公開鍵を HMAC シークレットとして使わせない(alg confusion 対策)
+func (ks *KeySet) keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return key.verify, nil
+}
+
+func (ks *KeySet) validMethods() []string {
+	var methods []string
+	for _, key := range ks.verify {
+		if alg := key.method.Alg(); !slices.Contains(methods, alg) {
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWKS は公開鍵を署名鍵・kid の順で返す。HS256 のシークレットは含めない
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	var kids []string
+	for kid, key := range ks.verify {
+		if key.jwk != nil && kid != ks.signing.kid {
+			kids = append(kids, kid)
+		}
+	}
+	slices.Sort(kids)
+	if ks.signing.jwk != nil {
+		kids = append([]string{ks.signing.kid}, kids...)
+	}
+	for _, kid := range kids {
+		set.Keys = append(set.Keys, *ks.verify[kid].jwk)
+	}
+	return set
+}
+
+// 起動時に SetKeySet で登録した鍵。未登録(テストなど)の間は JWT_SECRET を都度読む
+var installedKeySet atomic.Pointer[KeySet]
+
+func SetKeySet(ks *KeySet) {
+	installedKeySet.Store(ks)
+}
+
+func currentKeySet() (*KeySet, error) {
+	if ks := installedKeySet.Load(); ks != nil {
+		return ks, nil
+	}
+	return LoadKeySet("", nil, os.Getenv("JWT_SECRET"))
+}
+
+// PublicJWKS は現在の鍵の JWKS を返す
+func PublicJWKS() (JWKSet, error) {
+	ks, err := currentKeySet()
+	if err != nil {
+		return JWKSet{}, err
+	}
+	return ks.JWKS(), nil
+}
+
+func loadKeyFile(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, err := newAsymmetricKey(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func newAsymmetricKey(parsed any) (*jwtKey, error) {
+	key := &jwtKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.sign = k
+		key.verify = &k.PublicKey
+	case *rsa.PublicKey:
+		key.verify = k
+	case ed25519.PrivateKey:
+		key.sign = k
+		key.verify = k.Public().(ed25519.PublicKey)
+	case ed25519.PublicKey:
+		key.verify = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch pub := key.verify.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < rsaMinBits {
+			return nil, fmt.Errorf("rsa key must be at least %d bits", rsaMinBits)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.jwk = &JWK{
+			Kty: "RSA",
+			N:   b64(pub.N.Bytes()),
+			E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+		key.kid = thumbprint(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, key.jwk.E, key.jwk.N))
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.jwk = &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(pub),
+		}
+		key.kid = thumbprint(fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, key.jwk.X))
+	}
+	key.jwk.Kid = key.kid
+	key.jwk.Use = "sig"
+	key.jwk.Alg = key.method.Alg()
+	return key, nil
+}
+
+// thumbprint は RFC 7638 の JWK Thumbprint。鍵から kid を決めるので設定で指定しなくてよい
+func thumbprint(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
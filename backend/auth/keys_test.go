@@ -0,0 +1,231 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sol_coffeesys/backend/auth"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+	return path
+}
+
+func writeRSAKey(t *testing.T, bits int) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, "rsa.pem", "PRIVATE KEY", der), key
+}
+
+func writeEd25519Key(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, "ed25519.pem", "PRIVATE KEY", der)
+}
+
+// テスト中だけ鍵を差し替え、終了時に未登録(JWT_SECRET を読む状態)へ戻す
+func installKeySet(t *testing.T, ks *auth.KeySet) {
+	t.Helper()
+	auth.SetKeySet(ks)
+	t.Cleanup(func() { auth.SetKeySet(nil) })
+}
+
+func TestLoadKeySet(t *testing.T) {
+	rsaPath, _ := writeRSAKey(t, 2048)
+	weakPath, _ := writeRSAKey(t, 1024)
+	edPath := writeEd25519Key(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	pubPath := writePEM(t, "pub.pem", "PUBLIC KEY", pubDER)
+
+	tests := []struct {
+		name       string
+		privateKey string
+		verifyKeys []string
+		secret     string
+		wantErr    bool
+		wantKid    bool
+	}{
+		{name: "RSA秘密鍵", privateKey: rsaPath, wantKid: true},
+		{name: "Ed25519秘密鍵", privateKey: edPath, wantKid: true},
+		{name: "JWT_SECRETのみ(HS256)", secret: "secret", wantKid: false},
+		{name: "鍵もシークレットも無いと起動できない", wantErr: true},
+		{name: "検証鍵だけでは署名できない", verifyKeys: []string{pubPath}, wantErr: true},
+		{name: "署名鍵に公開鍵は使えない", privateKey: pubPath, wantErr: true},
+		{name: "2048bit未満のRSA鍵は拒否", privateKey: weakPath, wantErr: true},
+		{name: "存在しないファイル", privateKey: filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := auth.LoadKeySet(tt.privateKey, tt.verifyKeys, tt.secret)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKid, ks.SigningKeyID() != "")
+		})
+	}
+}
+
+func TestLoadKeySetFromEnv_EmptySecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_PRIVATE_KEY_FILE", "")
+	t.Setenv("JWT_VERIFY_KEY_FILES", "")
+
+	_, err := auth.LoadKeySetFromEnv()
+	assert.ErrorIs(t, err, auth.ErrNoSigningKey)
+}
+
+func TestGenerateAndValidate_Asymmetric(t *testing.T) {
+	rsaPath, _ := writeRSAKey(t, 2048)
+	edPath := writeEd25519Key(t)
+
+	for name, path := range map[string]string{"RS256": rsaPath, "EdDSA": edPath} {
+		t.Run(name, func(t *testing.T) {
+			ks, err := auth.LoadKeySet(path, nil, "")
+			require.NoError(t, err)
+			installKeySet(t, ks)
+
+			signed, err := auth.DefaultTokenGenerator{}.GenerateToken(7, auth.RoleMember, "family-1")
+			require.NoError(t, err)
+
+			token, err := auth.ValidateToken(signed)
+			require.NoError(t, err)
+			assert.Equal(t, name, token.Method.Alg())
+			assert.Equal(t, ks.SigningKeyID(), token.Header["kid"])
+			claims := token.Claims.(jwt.MapClaims)
+			assert.Equal(t, float64(7), claims["user.id"])
+			assert.Equal(t, auth.RoleMember, claims["role"])
+			assert.Equal(t, "family-1", claims["sid"])
+		})
+	}
+}
+
+func TestValidateToken_KeyRotation(t *testing.T) {
+	oldPath, _ := writeRSAKey(t, 2048)
+	newPath := writeEd25519Key(t)
+
+	oldKeys, err := auth.LoadKeySet(oldPath, nil, "")
+	require.NoError(t, err)
+	installKeySet(t, oldKeys)
+	issuedBeforeRotation, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	require.NoError(t, err)
+
+	// 新しい鍵で署名しつつ、旧鍵で署名済みのトークンも受け付ける
+	rotating, err := auth.LoadKeySet(newPath, []string{oldPath}, "")
+	require.NoError(t, err)
+	auth.SetKeySet(rotating)
+	_, err = auth.ValidateToken(issuedBeforeRotation)
+	assert.NoError(t, err)
+
+	issuedAfterRotation, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	require.NoError(t, err)
+	token, err := auth.ValidateToken(issuedAfterRotation)
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", token.Method.Alg())
+
+	jwks := rotating.JWKS()
+	if assert.Len(t, jwks.Keys, 2) {
+		assert.Equal(t, rotating.SigningKeyID(), jwks.Keys[0].Kid)
+		assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+		assert.Equal(t, oldKeys.SigningKeyID(), jwks.Keys[1].Kid)
+		assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	}
+
+	// 旧鍵を外すと旧トークンは検証できない
+	rotated, err := auth.LoadKeySet(newPath, nil, "")
+	require.NoError(t, err)
+	auth.SetKeySet(rotated)
+	_, err = auth.ValidateToken(issuedBeforeRotation)
+	assert.Error(t, err)
+}
+
+func TestValidateToken_RejectsForgedTokens(t *testing.T) {
+	rsaPath, rsaKey := writeRSAKey(t, 2048)
+	ks, err := auth.LoadKeySet(rsaPath, nil, "")
+	require.NoError(t, err)
+	installKeySet(t, ks)
+
+	claims := jwt.MapClaims{"user.id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+
+	// 公開鍵を HMAC シークレットとして使った alg confusion
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = ks.SigningKeyID()
+	forged, err := hs.SignedString(pubPEM)
+	require.NoError(t, err)
+	_, err = auth.ValidateToken(forged)
+	assert.Error(t, err)
+
+	// 署名なし
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = auth.ValidateToken(none)
+	assert.Error(t, err)
+
+	// 知らない kid
+	other, _ := writeRSAKey(t, 2048)
+	otherKeys, err := auth.LoadKeySet(other, nil, "")
+	require.NoError(t, err)
+	auth.SetKeySet(otherKeys)
+	unknown, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	require.NoError(t, err)
+	auth.SetKeySet(ks)
+	_, err = auth.ValidateToken(unknown)
+	assert.Error(t, err)
+}
+
+func TestValidateToken_HS256Migration(t *testing.T) {
+	t.Setenv("JWT_SECRET", "legacy-secret")
+	legacy, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	require.NoError(t, err)
+
+	// 秘密鍵へ移行後も、JWT_SECRET を残している間は kid の無い HS256 トークンを受け付ける
+	rsaPath, _ := writeRSAKey(t, 2048)
+	ks, err := auth.LoadKeySet(rsaPath, nil, "legacy-secret")
+	require.NoError(t, err)
+	installKeySet(t, ks)
+
+	_, err = auth.ValidateToken(legacy)
+	assert.NoError(t, err)
+
+	signed, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	require.NoError(t, err)
+	token, err := auth.ValidateToken(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", token.Method.Alg())
+
+	// HS256 のシークレットは JWKS に出さない
+	assert.Len(t, ks.JWKS().Keys, 1)
+}
+
+func TestGenerateToken_NoKey(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+
+	_, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	assert.ErrorIs(t, err, auth.ErrNoSigningKey)
+}
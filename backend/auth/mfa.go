@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MFAChallengeTTL はパスワード確認後に確認コードを入力するまでの猶予
+const MFAChallengeTTL = 5 * time.Minute
+
+const mfaChallengeType = "mfa_challenge"
+
+var ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+
+// GenerateMFAChallenge はパスワードを確認済みで2段階目を待っているユーザーを表すトークンを発行する。
+// user.id クレームを持たないので、アクセストークンとしては使えない
+func GenerateMFAChallenge(userID int64) (string, error) {
+	ks, err := currentKeySet()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return ks.signToken(jwt.MapClaims{
+		"mfa.uid": userID,
+		"typ":     mfaChallengeType,
+		"exp":     now.Add(MFAChallengeTTL).Unix(),
+		"iat":     now.Unix(),
+	})
+}
+
+// ValidateMFAChallenge は GenerateMFAChallenge のトークンを検証してユーザーIDを返す
+func ValidateMFAChallenge(tokenString string) (int64, error) {
+	token, err := ValidateToken(tokenString)
+	if err != nil || token == nil || !token.Valid {
+		return 0, ErrInvalidMFAChallenge
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != mfaChallengeType {
+		return 0, ErrInvalidMFAChallenge
+	}
+	uid, ok := claims["mfa.uid"].(float64)
+	if !ok || uid <= 0 {
+		return 0, ErrInvalidMFAChallenge
+	}
+	return int64(uid), nil
+}
+
+// checkMFAEnrolled は TOTP の登録が完了していなければ ForbiddenError を返す。
+// TOTP を有効にしたユーザーはログイン時に必ずコードを求められるため、登録済みかどうかだけを見ればよい
+func checkMFAEnrolled(ctx context.Context, queries db.Querier, userID int64) error {
+	t, err := queries.GetUserTOTP(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon)
+	}
+	if err != nil || !t.EnabledAt.Valid {
+		return apperror.NewAccountForbiddenError(apperror.ForbiddenReasonMFARequired, apperror.ForbiddenMessageMFARequired)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMFAChallenge(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	challenge, err := auth.GenerateMFAChallenge(7)
+	require.NoError(t, err)
+	userID, err := auth.ValidateMFAChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), userID)
+
+	// アクセストークンはチャレンジとして受け付けない
+	access, err := auth.DefaultTokenGenerator{}.GenerateToken(7, auth.RoleAdmin, "")
+	require.NoError(t, err)
+	_, err = auth.ValidateMFAChallenge(access)
+	assert.ErrorIs(t, err, auth.ErrInvalidMFAChallenge)
+
+	_, err = auth.ValidateMFAChallenge("not-a-token")
+	assert.ErrorIs(t, err, auth.ErrInvalidMFAChallenge)
+}
+
+func TestMFAChallenge_NotAnAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	challenge, err := auth.GenerateMFAChallenge(1)
+	require.NoError(t, err)
+
+	// パスワードだけ確認した段階のトークンで保護されたAPIを呼べてはいけない
+	mockDB := new(testutil.MockDB)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/protected", auth.RequireAuth(mockDB), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockDB.AssertExpectations(t)
+}
@@ -3,8 +3,11 @@ package auth
 import (
 	"database/sql"
 	"errors"
+	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
@@ -13,154 +16,201 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AdminOnly(queries db.Querier) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenStr, err := tokenFromRequest(c)
-		if err != nil {
+// RecheckPolicy はトークンの role クレームを信用せず DB でロール・アカウント状態を確認する条件
+type RecheckPolicy int
 
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
-		}
-		token, err := Validate(tokenStr)
-		if err != nil || token == nil || !token.Valid {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
+const (
+	// RecheckAlways は毎リクエスト DB を引く(既定)。停止・ロール変更が即時に反映される
+	RecheckAlways RecheckPolicy = iota
+	// RecheckStale は発行(iat)から StaleAfter を過ぎたトークンだけ DB で確認する
+	RecheckStale
+	// RecheckNever は role クレームだけで判定する。停止・ロール変更はアクセストークンの期限(15分)まで反映されない
+	RecheckNever
+)
+
+type RequireOptions struct {
+	Queries db.Querier
+	// Roles は許可するロール。空ならロールを問わず認証済みであればよい
+	Roles      []string
+	Recheck    RecheckPolicy
+	StaleAfter time.Duration
+	// RequireMFA は管理者のプリンシパルに、ロールの確認に加えて TOTP を有効化済みであることを求める。
+	// スタッフと共用のルートでも、管理者だけはパスワードのみのセッションで通さない
+	RequireMFA bool
+	// Scopes は Authorization: ApiKey で呼ぶ場合にキーが持つべきスコープ。空ならそのルートは API キーでは呼べない
+	Scopes []string
+}
+
+// Require はアクセストークン(または API キー)を検証し、ロールを確認したうえで Principal をコンテキストに載せる。
+// role クレームを持たない古いトークンは Recheck に関わらず DB で確認する
+func Require(opts RequireOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if raw, ok := apiKeyFromRequest(c.Request); ok {
+			p, err := opts.authenticateAPIKey(c.Request.Context(), raw)
+			if err == nil {
+				err = opts.authorize(c, p.UserID, p.Role)
+			}
+			if err != nil {
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+			SetPrincipal(c, p)
+			c.Next()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
+		claims, ok := claimsFromRequest(c)
 		if !ok {
 			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
 			c.Abort()
 			return
 		}
-
-		rawID, ok := claims["user.id"]
+		userID, ok := userIDFromClaims(claims)
 		if !ok {
 			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
 			c.Abort()
 			return
 		}
 
-		var userID int64
-		switch v := rawID.(type) {
-		case float64:
-			userID = int64(v)
-		case string:
-			id, perr := strconv.ParseInt(v, 10, 64)
-			if perr != nil {
-				_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		role, hasRole := claims["role"].(string)
+		if !hasRole || opts.needsRecheck(claims) {
+			// トランザクションの外なので行ロックは取らない
+			user, err := opts.Queries.GetUserByID(c.Request.Context(), userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+					c.Abort()
+					return
+				}
+				_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
 				c.Abort()
 				return
 			}
-			userID = id
-		}
-
-		user, err := queries.GetUserForUpdate(c.Request.Context(), userID)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+			if err := CheckUserStatus(user.Status); err != nil {
+				_ = c.Error(err)
 				c.Abort()
 				return
 			}
-			_ = c.Error(apperror.NewInternalError("GetUserForUpdate", err, apperror.InternalServerMessageCommon))
-			c.Abort()
-			return
+			userID, role = user.ID, user.Role
 		}
 
-		if user.Role != "admin" {
-			_ = c.Error(apperror.NewForbiddenError("admin", "user", apperror.ForbiddenMessageAdmin))
+		if err := opts.authorize(c, userID, role); err != nil {
+			_ = c.Error(err)
 			c.Abort()
 			return
 		}
 
-		c.Set("userID", user.ID)
+		sid, _ := claims["sid"].(string)
+		SetPrincipal(c, Principal{UserID: userID, Role: role, SessionID: sid})
 		c.Next()
+	}
+}
 
+// authorize はロールと MFA のポリシーを確認する。JWT と API キーで共通
+func (o RequireOptions) authorize(c *gin.Context, userID int64, role string) error {
+	if len(o.Roles) > 0 && !slices.Contains(o.Roles, role) {
+		return apperror.NewForbiddenError(strings.Join(o.Roles, ","), role, forbiddenMessage(o.Roles))
+	}
+	if o.RequireMFA && role == RoleAdmin {
+		return checkMFAEnrolled(c.Request.Context(), o.Queries, userID)
 	}
+	return nil
 }
 
-func RequireAuth(queries db.Querier) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenStr, err := tokenFromRequest(c)
-		if err != nil {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
-		}
+func AdminOnly(queries db.Querier) gin.HandlerFunc {
+	return Require(RequireOptions{Queries: queries, Roles: []string{RoleAdmin}})
+}
 
-		token, err := Validate(tokenStr)
-		if err != nil || token == nil || !token.Valid {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
-		}
+func RequireAuth(queries db.Querier) gin.HandlerFunc {
+	return Require(RequireOptions{Queries: queries})
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
+func (o RequireOptions) needsRecheck(claims jwt.MapClaims) bool {
+	switch o.Recheck {
+	case RecheckNever:
+		return false
+	case RecheckStale:
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			return true
 		}
+		return time.Since(iat.Time) > o.StaleAfter
+	default:
+		return true
+	}
+}
 
-		rawID, ok := claims["user.id"]
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
-		}
+func forbiddenMessage(roles []string) string {
+	switch {
+	case len(roles) == 1 && roles[0] == RoleAdmin:
+		return apperror.ForbiddenMessageAdmin
+	case slices.Contains(roles, RoleStaff):
+		return apperror.ForbiddenMessageStaff
+	}
+	return apperror.ForbiddenMessageGeneric
+}
 
-		var userID int64
-		switch v := rawID.(type) {
-		case int:
-			userID = int64(v)
-		case int32:
-			userID = int64(v)
-		case int64:
-			userID = v
-		case float64:
-			userID = int64(v)
-		case string:
-			id, perr := strconv.ParseInt(v, 10, 64)
-			if perr != nil {
-				_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-				c.Abort()
-				return
-			}
-			userID = id
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			c.Abort()
-			return
-		}
+func claimsFromRequest(c *gin.Context) (jwt.MapClaims, bool) {
+	tokenStr, err := tokenFromRequest(c)
+	if err != nil {
+		return nil, false
+	}
+	token, err := Validate(tokenStr)
+	if err != nil || token == nil || !token.Valid {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, ok
+}
 
-		user, err := queries.GetUserForUpdate(c.Request.Context(), userID)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-				c.Abort()
-				return
-			}
-			_ = c.Error(apperror.NewInternalError("GetUserForUpdate", err, apperror.InternalServerMessageCommon))
-			c.Abort()
-			return
-		}
-		c.Set("userID", user.ID)
-		c.Next()
+func userIDFromClaims(claims jwt.MapClaims) (int64, bool) {
+	switch v := claims["user.id"].(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case string:
+		id, err := strconv.ParseInt(v, 10, 64)
+		return id, err == nil
 	}
+	return 0, false
 }
 
+// AccessTokenCookie はアクセストークンを入れる Cookie の名前
+const AccessTokenCookie = "access_token"
+
 func tokenFromRequest(c *gin.Context) (string, error) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader != "" {
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
-			return parts[1], nil
-		}
+	if token, ok := bearerToken(c.Request); ok {
+		return token, nil
 	}
-	if cookie, err := c.Request.Cookie("access_token"); err == nil {
+	if cookie, err := c.Request.Cookie(AccessTokenCookie); err == nil {
 		return cookie.Value, nil
 	}
 	return "", errors.New("no token")
 }
+
+// AuthenticatesByCookie はアクセストークンを Bearer / ApiKey ヘッダではなく Cookie で送っているかを返す。
+// Require と同じくヘッダを優先する。ブラウザが自動で付ける Cookie 認証だけが CSRF の対象になる
+func AuthenticatesByCookie(r *http.Request) bool {
+	if _, ok := bearerToken(r); ok {
+		return false
+	}
+	if _, ok := apiKeyFromRequest(r); ok {
+		return false
+	}
+	_, err := r.Cookie(AccessTokenCookie)
+	return err == nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+		return parts[1], true
+	}
+	return "", false
+}
@@ -14,6 +14,7 @@ import (
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -61,6 +62,30 @@ func (f *FakeQuerier) ListCategories(ctx context.Context) ([]db.Category, error)
 func (f *FakeQuerier) ListProducts(ctx context.Context) ([]db.Product, error) {
 	return []db.Product{}, nil
 }
+
+func (f *FakeQuerier) SearchProductsByID(ctx context.Context, arg db.SearchProductsByIDParams) ([]db.Product, error) {
+	return []db.Product{}, nil
+}
+
+func (f *FakeQuerier) SearchProductsByPriceAsc(ctx context.Context, arg db.SearchProductsByPriceAscParams) ([]db.Product, error) {
+	return []db.Product{}, nil
+}
+
+func (f *FakeQuerier) SearchProductsByPriceDesc(ctx context.Context, arg db.SearchProductsByPriceDescParams) ([]db.Product, error) {
+	return []db.Product{}, nil
+}
+
+func (f *FakeQuerier) SearchProductsByName(ctx context.Context, arg db.SearchProductsByNameParams) ([]db.Product, error) {
+	return []db.Product{}, nil
+}
+
+func (f *FakeQuerier) SearchProductsByNewest(ctx context.Context, arg db.SearchProductsByNewestParams) ([]db.Product, error) {
+	return []db.Product{}, nil
+}
+
+func (f *FakeQuerier) CountProducts(ctx context.Context, arg db.CountProductsParams) (int64, error) {
+	return 0, nil
+}
 func (f *FakeQuerier) UpdateCategory(ctx context.Context, arg db.UpdateCategoryParams) (db.Category, error) {
 	return db.Category{}, nil
 }
@@ -185,7 +210,11 @@ func (f *FakeQuerier) ListOrderItemsByOrderID(ctx context.Context, orderID int64
 	return []db.OrderItem{}, nil
 }
 
-func (f *FakeQuerier) ListOrdersByUser(ctx context.Context, userID int64) ([]db.ListOrdersByUserRow, error) {
+func (f *FakeQuerier) ListOrderItemsByOrderIDs(ctx context.Context, orderIds []int64) ([]db.OrderItem, error) {
+	return []db.OrderItem{}, nil
+}
+
+func (f *FakeQuerier) ListOrdersByUser(ctx context.Context, arg db.ListOrdersByUserParams) ([]db.ListOrdersByUserRow, error) {
 	return []db.ListOrdersByUserRow{}, nil
 }
 
@@ -209,10 +238,215 @@ func (f *FakeQuerier) RevokeRefreshTokenByHash(ctx context.Context, tokenHash st
 	return nil
 }
 
+func (f *FakeQuerier) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return nil
+}
+
+func (f *FakeQuerier) ListActiveSessionsByUser(ctx context.Context, userID int64) ([]db.ListActiveSessionsByUserRow, error) {
+	return nil, nil
+}
+
+func (f *FakeQuerier) RevokeSessionByUser(ctx context.Context, arg db.RevokeSessionByUserParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) RevokeOtherSessionsByUser(ctx context.Context, arg db.RevokeOtherSessionsByUserParams) error {
+	return nil
+}
+
 func (f *FakeQuerier) RevokeAllRefreshTokensByUser(ctx context.Context, userID int64) error {
 	return nil
 }
 
+func (f *FakeQuerier) CreatePayment(ctx context.Context, arg db.CreatePaymentParams) (db.Payment, error) {
+	return db.Payment{}, nil
+}
+
+func (f *FakeQuerier) GetPaymentByOrderID(ctx context.Context, orderID int64) (db.Payment, error) {
+	return db.Payment{}, nil
+}
+
+func (f *FakeQuerier) UpdatePaymentStatus(ctx context.Context, arg db.UpdatePaymentStatusParams) (db.Payment, error) {
+	return db.Payment{}, nil
+}
+
+func (f *FakeQuerier) RetryPayment(ctx context.Context, arg db.RetryPaymentParams) (db.Payment, error) {
+	return db.Payment{}, nil
+}
+
+func (f *FakeQuerier) SaveUserSuspension(ctx context.Context, arg db.SaveUserSuspensionParams) error {
+	return nil
+}
+
+func (f *FakeQuerier) DeleteUserSuspension(ctx context.Context, userID int64) (string, error) {
+	return "", nil
+}
+
+func (f *FakeQuerier) CreateIdempotencyKey(ctx context.Context, arg db.CreateIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	return db.IdempotencyKey{}, nil
+}
+
+func (f *FakeQuerier) GetIdempotencyKey(ctx context.Context, arg db.GetIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	return db.IdempotencyKey{}, nil
+}
+
+func (f *FakeQuerier) SaveIdempotencyResponse(ctx context.Context, arg db.SaveIdempotencyResponseParams) error {
+	return nil
+}
+
+func (f *FakeQuerier) DeleteIdempotencyKey(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) ResetPasswordByToken(ctx context.Context, arg db.ResetPasswordByTokenParams) (int64, error) {
+	return 0, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error) {
+	u, ok := f.users[arg.ID]
+	if !ok {
+		return db.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (f *FakeQuerier) UpdateUserStatus(ctx context.Context, arg db.UpdateUserStatusParams) (db.User, error) {
+	u, ok := f.users[arg.ID]
+	if !ok {
+		return db.User{}, sql.ErrNoRows
+	}
+	u.Status = arg.Status
+	return u, nil
+}
+
+func (f *FakeQuerier) UpdateUserLastLogin(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.SearchUsersRow, error) {
+	return []db.SearchUsersRow{}, nil
+}
+
+func (f *FakeQuerier) CountUsers(ctx context.Context, arg db.CountUsersParams) (int64, error) {
+	return int64(len(f.users)), nil
+}
+
+func (f *FakeQuerier) CountActiveRefreshTokensByUser(ctx context.Context, userID int64) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) UpdateUserPassword(ctx context.Context, arg db.UpdateUserPasswordParams) error {
+	return nil
+}
+
+func (f *FakeQuerier) GetLoginThrottle(ctx context.Context, throttleKey string) (db.LoginThrottle, error) {
+	return db.LoginThrottle{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) RecordLoginFailure(ctx context.Context, arg db.RecordLoginFailureParams) (int32, error) {
+	return 1, nil
+}
+
+func (f *FakeQuerier) LockLoginThrottle(ctx context.Context, arg db.LockLoginThrottleParams) error {
+	return nil
+}
+
+func (f *FakeQuerier) GetUserTOTP(ctx context.Context, userID int64) (db.UserTotp, error) {
+	return db.UserTotp{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) UpsertPendingUserTOTP(ctx context.Context, arg db.UpsertPendingUserTOTPParams) (db.UserTotp, error) {
+	return db.UserTotp{}, nil
+}
+
+func (f *FakeQuerier) EnableUserTOTP(ctx context.Context, arg db.EnableUserTOTPParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) ConsumeTOTPStep(ctx context.Context, arg db.ConsumeTOTPStepParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) DeleteUserTOTP(ctx context.Context, userID int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) CreateRecoveryCode(ctx context.Context, arg db.CreateRecoveryCodeParams) error {
+	return nil
+}
+
+func (f *FakeQuerier) DeleteRecoveryCodesByUser(ctx context.Context, userID int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) ConsumeRecoveryCode(ctx context.Context, arg db.ConsumeRecoveryCodeParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) MarkUserEmailVerified(ctx context.Context, id int64) (db.User, error) {
+	return db.User{}, nil
+}
+
+func (f *FakeQuerier) ResetLoginThrottle(ctx context.Context, throttleKey string) error {
+	return nil
+}
+
+func (f *FakeQuerier) ConsumeRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) ListUsersByEmailFold(ctx context.Context, email string) ([]db.User, error) {
+	return []db.User{}, nil
+}
+
+func (f *FakeQuerier) DeleteStaleLoginThrottles(ctx context.Context, staleBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error) {
+	return db.ApiKey{}, nil
+}
+
+func (f *FakeQuerier) ListAPIKeysByUser(ctx context.Context, userID int64) ([]db.ApiKey, error) {
+	return nil, nil
+}
+
+func (f *FakeQuerier) RevokeAPIKeyByUser(ctx context.Context, arg db.RevokeAPIKeyByUserParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *FakeQuerier) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (db.GetActiveAPIKeyByHashRow, error) {
+	return db.GetActiveAPIKeyByHashRow{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) TouchAPIKeyLastUsed(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) RevokeAllAPIKeysByUser(ctx context.Context, userID int64) error {
+	return nil
+}
+
+func (f *FakeQuerier) GetUserIdentity(ctx context.Context, arg db.GetUserIdentityParams) (db.UserIdentity, error) {
+	return db.UserIdentity{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) CreateUserIdentity(ctx context.Context, arg db.CreateUserIdentityParams) (db.UserIdentity, error) {
+	return db.UserIdentity{}, nil
+}
+
+func (f *FakeQuerier) TouchUserIdentityLogin(ctx context.Context, arg db.TouchUserIdentityLoginParams) error {
+	return nil
+}
+
 // DB接続エラー用のQuerier
 type BadQuerier struct{ *FakeQuerier }
 
@@ -265,6 +499,7 @@ func TestAdminOnly(t *testing.T) {
 	users := map[int64]db.User{
 		1: {ID: 1, Role: "admin"},
 		2: {ID: 2, Role: "member"},
+		4: {ID: 4, Role: "admin", Status: auth.UserStatusSuspended},
 	}
 	fq := &FakeQuerier{users: users}
 
@@ -316,6 +551,14 @@ func TestAdminOnly(t *testing.T) {
 			},
 			expectedStatus: http.StatusNoContent,
 		},
+		{
+			name:       "停止中のadmin->403",
+			authHeader: "Bearer valid-suspended-admin",
+			validateFunc: func(ts string) (*jwt.Token, error) {
+				return &jwt.Token{Valid: true, Claims: jwt.MapClaims{"user.id": float64(4)}}, nil
+			},
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name:       "DB該当ユーザー未検出->401",
 			authHeader: "Bearer valid-missing-user",
@@ -466,7 +709,7 @@ func TestRequireAuth(t *testing.T) {
 		{
 			name: "authorized",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
+				m.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
 			},
 			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim(int64(42)), nil },
 			authHeader:     "Bearer valid",
@@ -476,13 +719,31 @@ func TestRequireAuth(t *testing.T) {
 		{
 			name: "authorized claims->string",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
+				m.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
 			},
 			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim("42"), nil },
 			authHeader:     "Bearer valid",
 			expectedStatus: http.StatusOK,
 			expectedUserID: float64(42), // JSON decode yields float64 for numbers
 		},
+		{
+			name: "suspended user",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Status: auth.UserStatusSuspended}, nil)
+			},
+			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim(int64(42)), nil },
+			authHeader:     "Bearer valid",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "pending user",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Status: auth.UserStatusPending}, nil)
+			},
+			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim(int64(42)), nil },
+			authHeader:     "Bearer valid",
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name:           "no header",
 			setupMock:      nil,
@@ -516,7 +777,7 @@ func TestRequireAuth(t *testing.T) {
 		{
 			name: "user not found",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(99)).Return(db.User{}, sql.ErrNoRows)
+				m.On("GetUserByID", mock.Anything, int64(99)).Return(db.User{}, sql.ErrNoRows)
 			},
 			validateStub: func(s string) (*jwt.Token, error) {
 				return makeTokenWithClaim(int64(99)), nil
@@ -527,7 +788,7 @@ func TestRequireAuth(t *testing.T) {
 		{
 			name: "db error",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(100)).Return(db.User{}, errors.New("db"))
+				m.On("GetUserByID", mock.Anything, int64(100)).Return(db.User{}, errors.New("db"))
 			},
 			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim(int64(100)), nil },
 			authHeader:     "Bearer t3",
@@ -536,7 +797,7 @@ func TestRequireAuth(t *testing.T) {
 		{
 			name: "cookie authroized",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
+				m.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Name: "u"}, nil)
 			},
 			validateStub:   func(s string) (*jwt.Token, error) { return makeTokenWithClaim(int64(42)), nil },
 			authHeader:     "",
@@ -588,3 +849,331 @@ func TestRequireAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireAuth_SetsSessionID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	tests := []struct {
+		name      string
+		sessionID string
+	}{
+		{name: "sid 付きのトークン", sessionID: "family-1"},
+		{name: "sid の無いトークン", sessionID: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			mockDB.On("GetUserByID", mock.Anything, int64(42)).Return(db.User{ID: 42, Role: auth.RoleMember}, nil)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/protected", auth.RequireAuth(mockDB), func(c *gin.Context) {
+				p := auth.MustPrincipal(c)
+				c.JSON(http.StatusOK, gin.H{"session_id": p.SessionID, "user_id": p.UserID, "role": p.Role})
+			})
+
+			token, err := auth.DefaultTokenGenerator{}.GenerateToken(42, auth.RoleMember, tt.sessionID)
+			assert.NoError(t, err)
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var body map[string]any
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.sessionID, body["session_id"])
+			assert.Equal(t, float64(42), body["user_id"])
+			assert.Equal(t, auth.RoleMember, body["role"])
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fresh := float64(time.Now().Unix())
+	stale := float64(time.Now().Add(-10 * time.Minute).Unix())
+
+	tests := []struct {
+		name           string
+		opts           auth.RequireOptions
+		claims         jwt.MapClaims
+		setupMock      func(m *testutil.MockDB)
+		expectedStatus int
+		expectedRole   string
+		expectedErrMsg string
+	}{
+		{
+			name:   "staffを許可するルートにstaff->200",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}},
+			claims: jwt.MapClaims{"user.id": float64(5), "role": auth.RoleStaff},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(5)).Return(db.User{ID: 5, Role: auth.RoleStaff}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleStaff,
+		},
+		{
+			name:   "staffを許可するルートにmember->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}},
+			claims: jwt.MapClaims{"user.id": float64(2), "role": auth.RoleMember},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{ID: 2, Role: auth.RoleMember}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "RecheckAlways: クレームがadminでもDBのロールで判定->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}},
+			claims: jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleMember}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "RecheckNever: roleクレームだけで判定しDBを引かない->200",
+			opts:           auth.RequireOptions{Roles: []string{auth.RoleAdmin}, Recheck: auth.RecheckNever},
+			claims:         jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleAdmin,
+		},
+		{
+			name:           "RecheckNever: roleクレームで権限不足->403",
+			opts:           auth.RequireOptions{Roles: []string{auth.RoleAdmin}, Recheck: auth.RecheckNever},
+			claims:         jwt.MapClaims{"user.id": float64(2), "role": auth.RoleMember},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "RecheckNever: roleクレームの無い古いトークンはDBで確認->200",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}, Recheck: auth.RecheckNever},
+			claims: jwt.MapClaims{"user.id": float64(1)},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleAdmin}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleAdmin,
+		},
+		{
+			name:           "RecheckStale: 発行直後のトークンはDBを引かない->200",
+			opts:           auth.RequireOptions{Recheck: auth.RecheckStale, StaleAfter: time.Minute},
+			claims:         jwt.MapClaims{"user.id": float64(2), "role": auth.RoleMember, "iat": fresh},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleMember,
+		},
+		{
+			name:   "RecheckStale: 古いトークンはDBで確認し停止中なら403",
+			opts:   auth.RequireOptions{Recheck: auth.RecheckStale, StaleAfter: time.Minute},
+			claims: jwt.MapClaims{"user.id": float64(2), "role": auth.RoleMember, "iat": stale},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{ID: 2, Role: auth.RoleMember, Status: auth.UserStatusSuspended}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "RequireMFA: TOTP有効のadmin->200",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleAdmin}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{UserID: 1, EnabledAt: sql.NullTime{Time: time.Now(), Valid: true}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleAdmin,
+		},
+		{
+			name:   "RequireMFA: 登録途中(未有効化)のadmin->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleAdmin}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{UserID: 1}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageMFARequired,
+		},
+		{
+			name:   "RequireMFA: 未登録のadmin->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleAdmin}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageMFARequired,
+		},
+		{
+			name:   "RequireMFA: スタッフと共用のルートでもadminにはTOTPを求める->403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(1), "role": auth.RoleAdmin},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: auth.RoleAdmin}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedErrMsg: apperror.ForbiddenMessageMFARequired,
+		},
+		{
+			name:   "RequireMFA: staffには登録状況を見ない->200",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin, auth.RoleStaff}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(3), "role": auth.RoleStaff},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(3)).Return(db.User{ID: 3, Role: auth.RoleStaff}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedRole:   auth.RoleStaff,
+		},
+		{
+			name:   "RequireMFA: ロール不足なら登録状況を見ずに403",
+			opts:   auth.RequireOptions{Roles: []string{auth.RoleAdmin}, RequireMFA: true},
+			claims: jwt.MapClaims{"user.id": float64(2), "role": auth.RoleMember},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{ID: 2, Role: auth.RoleMember}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			opts := tt.opts
+			opts.Queries = mockDB
+
+			orgValidate := auth.Validate
+			auth.Validate = func(string) (*jwt.Token, error) {
+				return &jwt.Token{Valid: true, Claims: tt.claims}, nil
+			}
+			defer func() { auth.Validate = orgValidate }()
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/protected", auth.Require(opts), func(c *gin.Context) {
+				p := auth.MustPrincipal(c)
+				c.JSON(http.StatusOK, gin.H{"user_id": p.UserID, "role": p.Role})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer t")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				var body map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedRole, body["role"])
+			}
+			if tt.expectedErrMsg != "" {
+				var body map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPrincipalFrom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Principal が載っていれば返す", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		auth.SetPrincipal(c, auth.Principal{UserID: 7, Role: auth.RoleStaff, SessionID: "family-1"})
+
+		p, err := auth.PrincipalFrom(c)
+		assert.NoError(t, err)
+		assert.Equal(t, auth.Principal{UserID: 7, Role: auth.RoleStaff, SessionID: "family-1"}, p)
+		assert.Equal(t, p, auth.MustPrincipal(c))
+		// ログの user_id 用のキーにも載る
+		assert.Equal(t, int64(7), c.GetInt64("userID"))
+	})
+
+	t.Run("認証ミドルウェアを通っていなければ401", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+		_, err := auth.PrincipalFrom(c)
+		var ue *apperror.UnauthorizedError
+		assert.ErrorAs(t, err, &ue)
+		assert.Panics(t, func() { auth.MustPrincipal(c) })
+	})
+}
+
+func TestAuthenticatesByCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		cookie bool
+		want   bool
+	}{
+		{name: "Cookieのみ", cookie: true, want: true},
+		{name: "Bearerヘッダ優先", header: "Bearer token", cookie: true, want: false},
+		{name: "ApiKeyヘッダ優先", header: "ApiKey sol_abc", cookie: true, want: false},
+		{name: "Bearer以外のAuthorizationはCookieを使う", header: "Basic abc", cookie: true, want: true},
+		{name: "どちらも無い", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: "token"})
+			}
+			assert.Equal(t, tt.want, auth.AuthenticatesByCookie(req))
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		user           db.User
+		userErr        error
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{name: "確認済み->200", user: db.User{ID: 1, Status: auth.UserStatusActive}, expectedStatus: http.StatusOK},
+		{name: "未確認->403", user: db.User{ID: 1, Status: auth.UserStatusUnverified}, expectedStatus: http.StatusForbidden, expectedErrMsg: apperror.ForbiddenMessageUnverified},
+		{name: "ユーザーが存在しない->401", userErr: sql.ErrNoRows, expectedStatus: http.StatusUnauthorized},
+		{name: "DBエラー->500", userErr: errors.New("db error"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(testutil.MockDB)
+			m.On("GetUserByID", mock.Anything, int64(1)).Return(tt.user, tt.userErr)
+
+			r := gin.New()
+			r.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			r.POST("/api/orders", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1, Role: auth.RoleMember})
+				c.Next()
+			}, auth.RequireVerifiedEmail(m), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/orders", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			m.AssertExpectations(t)
+		})
+	}
+}
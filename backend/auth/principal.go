@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Principal は認証済みリクエストの主体。Require が検証後にコンテキストへ載せる
+type Principal struct {
+	UserID    int64
+	Role      string
+	SessionID string // アクセストークンの sid。sid を持たない古いトークンでは空
+	APIKeyID  int64  // Authorization: ApiKey で認証した場合のキーID。アクセストークンなら0
+}
+
+const principalKey = "auth.principal"
+
+// SetPrincipal は Principal をコンテキストに載せる。
+// ログ(LogEvent / ErrorHandler)が user_id を出せるよう logging.CtxKeyUserID にも載せる
+func SetPrincipal(c *gin.Context, p Principal) {
+	c.Set(principalKey, p)
+	c.Set(logging.CtxKeyUserID, p.UserID)
+}
+
+// PrincipalFrom は Require が載せた Principal を返す。
+// 載っていなければ(認証ミドルウェアを通っていないルート)UnauthorizedError を返す
+func PrincipalFrom(c *gin.Context) (Principal, error) {
+	if raw, ok := c.Get(principalKey); ok {
+		if p, ok := raw.(Principal); ok {
+			return p, nil
+		}
+	}
+	return Principal{}, apperror.NewUnauthorizedError("principal_missing", apperror.UnauthorizedMessageAuth)
+}
+
+// MustPrincipal は Require の後ろでしか使わないミドルウェア向け。
+// Principal が無いのはルート定義の誤りなので panic する
+func MustPrincipal(c *gin.Context) Principal {
+	p, err := PrincipalFrom(c)
+	if err != nil {
+		panic("auth: MustPrincipal called on a route without auth.Require")
+	}
+	return p
+}
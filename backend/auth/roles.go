@@ -0,0 +1,16 @@
+package auth
+
+// users.role の取りうる値(DB の CHECK 制約と揃える)
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleStaff  = "staff" // 店舗スタッフ(バリスタ)。注文の状態更新など店舗オペレーションのみ行える
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleMember, RoleStaff:
+		return true
+	}
+	return false
+}
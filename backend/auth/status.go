@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// users.status の取りうる値(DB の CHECK 制約と揃える)
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDisabled  = "disabled"
+	UserStatusPending   = "pending"
+	// UserStatusUnverified はメールアドレス確認待ち。ログインはできる(確認メールの再送のため)
+	UserStatusUnverified = "unverified"
+)
+
+// CheckUserStatus はログイン・リフレッシュ・認証済みリクエストの各入口で呼び、
+// 利用できない状態のアカウントなら ForbiddenError を返す。
+func CheckUserStatus(status string) error {
+	switch status {
+	case UserStatusSuspended:
+		return apperror.NewAccountForbiddenError(apperror.ForbiddenReasonAccountSuspended, apperror.ForbiddenMessageSuspended)
+	case UserStatusDisabled:
+		return apperror.NewAccountForbiddenError(apperror.ForbiddenReasonAccountDisabled, apperror.ForbiddenMessageDisabled)
+	case UserStatusPending:
+		return apperror.NewAccountForbiddenError(apperror.ForbiddenReasonAccountPending, apperror.ForbiddenMessagePending)
+	}
+	return nil
+}
+
+// RequireVerifiedEmail はメールアドレスを確認済みのユーザーだけを通す。Require の後ろに置く
+func RequireVerifiedEmail(queries db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := queries.GetUserByID(c.Request.Context(), MustPrincipal(c).UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+				c.Abort()
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
+			c.Abort()
+			return
+		}
+		if user.Status == UserStatusUnverified {
+			_ = c.Error(apperror.NewAccountForbiddenError(apperror.ForbiddenReasonEmailUnverified, apperror.ForbiddenMessageUnverified))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
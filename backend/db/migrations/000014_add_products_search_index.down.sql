@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_products_search_trgm;
+
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,5 @@
+-- 商品のキーワード検索 (ILIKE '%...%') 用。日本語の部分一致に対応するため全文検索ではなく pg_trgm を使う
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_trgm ON products
+    USING GIN ((name || ' ' || COALESCE(description, '') || ' ' || sku) gin_trgm_ops);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_reset_token;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS reset_token_expires_at;
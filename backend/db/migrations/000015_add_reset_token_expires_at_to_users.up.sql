@@ -0,0 +1,6 @@
+-- reset_token にはトークンそのものではなく SHA-256 ハッシュを保存する
+ALTER TABLE users
+ADD COLUMN reset_token_expires_at TIMESTAMP WITH TIME ZONE NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_reset_token ON users(reset_token)
+    WHERE reset_token IS NOT NULL;
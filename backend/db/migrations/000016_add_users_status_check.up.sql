@@ -0,0 +1,3 @@
+-- active 以外はログイン・リフレッシュ・認証済みリクエストを拒否する
+ALTER TABLE users
+ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'disabled', 'pending'));
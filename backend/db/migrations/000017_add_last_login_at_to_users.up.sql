@@ -0,0 +1,3 @@
+-- ログイン成功時に更新する。管理画面でのユーザー確認用
+ALTER TABLE users
+ADD COLUMN last_login_at TIMESTAMP WITH TIME ZONE NULL;
@@ -0,0 +1,14 @@
+-- 1回のログインから rotate で連なるリフレッシュトークンを同じ family_id でまとめる。
+-- 失効済みトークンが再提示されたらファミリーごと失効させる
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id TEXT NULL;
+
+-- 既存トークンはそれぞれ単独のファミリーとして扱う
+UPDATE refresh_tokens
+SET family_id = md5(id::text || token_hash)
+WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
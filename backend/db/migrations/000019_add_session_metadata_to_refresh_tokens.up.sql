@@ -0,0 +1,4 @@
+-- セッション一覧で端末を見分けるため、ログイン・リフレッシュ時の User-Agent と IP を保存する
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- staff(店舗スタッフ)を追加し、ロールを admin / member / staff に限定する
+ALTER TABLE users
+ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'member', 'staff'));
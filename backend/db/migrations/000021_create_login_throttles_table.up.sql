@@ -0,0 +1,11 @@
+-- ログイン試行の失敗回数とロック状態。キーは "account:<email>" / "ip:<address>"
+-- 複数インスタンスで同じカウンタを共有するため DB に置く
+CREATE TABLE login_throttles (
+    throttle_key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_login_throttles_updated_at ON login_throttles (updated_at);
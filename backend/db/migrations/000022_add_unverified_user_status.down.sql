@@ -0,0 +1,11 @@
+-- 未確認の会員を active に戻すと確認を経ずに注文できてしまうため、残っている間は戻さない
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE status = 'unverified') THEN
+        RAISE EXCEPTION 'users with status unverified remain; verify or disable them before rolling back';
+    END IF;
+END
+$$;
+ALTER TABLE users DROP CONSTRAINT users_status_check;
+ALTER TABLE users
+ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'disabled', 'pending'));
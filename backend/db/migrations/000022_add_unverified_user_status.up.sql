@@ -0,0 +1,4 @@
+-- unverified: メールアドレスの確認が済んでいない会員。ログインはできるが注文は設定で制限する
+ALTER TABLE users DROP CONSTRAINT users_status_check;
+ALTER TABLE users
+ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'disabled', 'pending', 'unverified'));
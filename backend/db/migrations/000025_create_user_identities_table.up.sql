@@ -0,0 +1,14 @@
+-- 外部 IdP(Google・LINE などの OpenID Connect プロバイダ)のアカウントと会員の紐付け。
+-- subject は IdP 内で不変の利用者ID(ID トークンの sub)。email はログイン時点の値の控えで、照合には使わない
+CREATE TABLE user_identities (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE NULL,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
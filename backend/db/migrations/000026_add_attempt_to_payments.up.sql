@@ -0,0 +1,3 @@
+-- 決済サービスへの冪等キーは payments.id と attempt から作る。
+-- 失敗した決済をやり直すときだけ attempt を進め、結果の分からない試行は同じキーで問い合わせ直す
+ALTER TABLE payments ADD COLUMN attempt INTEGER NOT NULL DEFAULT 1;
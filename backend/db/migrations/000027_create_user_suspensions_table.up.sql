@@ -0,0 +1,7 @@
+-- 管理者がアカウントを停止する直前の状態。再開時にこの状態へ戻す
+-- (メールアドレス未確認のまま停止されたユーザーを、再開で確認済みにしないため)
+CREATE TABLE user_suspensions (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    previous_status VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+-- 商品一覧の並び替えと keyset ページング用。(並び替えキー, id) の行比較と ORDER BY をインデックスで引く
+-- price_desc / newest は同じインデックスを逆順に走査する
+CREATE INDEX IF NOT EXISTS idx_products_price_id ON products(price, id);
+CREATE INDEX IF NOT EXISTS idx_products_name_id ON products(name, id);
+CREATE INDEX IF NOT EXISTS idx_products_created_at_id ON products(created_at, id);
@@ -0,0 +1,2 @@
+-- 外部 IdP でのログインで、大文字小文字を区別せずにメールアドレスから会員を引く
+CREATE INDEX IF NOT EXISTS idx_users_lower_email ON users (LOWER(email));
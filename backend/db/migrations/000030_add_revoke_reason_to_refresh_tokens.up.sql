@@ -0,0 +1,4 @@
+-- rotate で失効したトークンとログアウト等で失効したトークンを見分けるため、失効理由を保存する。
+-- 再利用検知は rotate 済み('rotated')のトークンが再提示されたときだけ行う
+ALTER TABLE refresh_tokens
+ADD COLUMN revoke_reason TEXT;
@@ -9,6 +9,20 @@ import (
 	"time"
 )
 
+type ApiKey struct {
+	ID         int64        `json:"id"`
+	UserID     int64        `json:"user_id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	KeyHash    string       `json:"key_hash"`
+	Scopes     []string     `json:"scopes"`
+	ExpiresAt  sql.NullTime `json:"expires_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
 type Cart struct {
 	ID        int64     `json:"id"`
 	UserID    int64     `json:"user_id"`
@@ -34,6 +48,28 @@ type Category struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 }
 
+type IdempotencyKey struct {
+	ID             int64         `json:"id"`
+	UserID         int64         `json:"user_id"`
+	IdempotencyKey string        `json:"idempotency_key"`
+	RequestMethod  string        `json:"request_method"`
+	RequestPath    string        `json:"request_path"`
+	RequestHash    string        `json:"request_hash"`
+	ResponseStatus sql.NullInt32 `json:"response_status"`
+	ResponseBody   []byte        `json:"response_body"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+type LoginThrottle struct {
+	ThrottleKey     string       `json:"throttle_key"`
+	Failures        int32        `json:"failures"`
+	WindowStartedAt time.Time    `json:"window_started_at"`
+	LockedUntil     sql.NullTime `json:"locked_until"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
 type Order struct {
 	ID          int64        `json:"id"`
 	UserID      int64        `json:"user_id"`
@@ -64,6 +100,7 @@ type Payment struct {
 	ExternalTransactionID sql.NullString `json:"external_transaction_id"`
 	CreatedAt             time.Time      `json:"created_at"`
 	UpdatedAt             time.Time      `json:"updated_at"`
+	Attempt               int32          `json:"attempt"`
 }
 
 type Product struct {
@@ -81,23 +118,62 @@ type Product struct {
 }
 
 type RefreshToken struct {
+	ID           int64          `json:"id"`
+	UserID       int64          `json:"user_id"`
+	TokenHash    string         `json:"token_hash"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	RevokedAt    sql.NullTime   `json:"revoked_at"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	FamilyID     string         `json:"family_id"`
+	UserAgent    string         `json:"user_agent"`
+	IpAddress    string         `json:"ip_address"`
+	RevokeReason sql.NullString `json:"revoke_reason"`
+}
+
+type User struct {
+	ID                  int64          `json:"id"`
+	Name                string         `json:"name"`
+	Email               string         `json:"email"`
+	PasswordHash        string         `json:"password_hash"`
+	Role                string         `json:"role"`
+	Status              string         `json:"status"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	ResetToken          sql.NullString `json:"reset_token"`
+	ResetTokenExpiresAt sql.NullTime   `json:"reset_token_expires_at"`
+	LastLoginAt         sql.NullTime   `json:"last_login_at"`
+}
+
+type UserIdentity struct {
+	ID          int64          `json:"id"`
+	UserID      int64          `json:"user_id"`
+	Provider    string         `json:"provider"`
+	Subject     string         `json:"subject"`
+	Email       sql.NullString `json:"email"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastLoginAt sql.NullTime   `json:"last_login_at"`
+}
+
+type UserRecoveryCode struct {
 	ID        int64        `json:"id"`
 	UserID    int64        `json:"user_id"`
-	TokenHash string       `json:"token_hash"`
-	ExpiresAt time.Time    `json:"expires_at"`
-	RevokedAt sql.NullTime `json:"revoked_at"`
+	CodeHash  string       `json:"code_hash"`
+	UsedAt    sql.NullTime `json:"used_at"`
 	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
 }
 
-type User struct {
-	ID           int64          `json:"id"`
-	Name         string         `json:"name"`
-	Email        string         `json:"email"`
-	PasswordHash string         `json:"password_hash"`
-	Role         string         `json:"role"`
-	Status       string         `json:"status"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	ResetToken   sql.NullString `json:"reset_token"`
+type UserSuspension struct {
+	UserID         int64     `json:"user_id"`
+	PreviousStatus string    `json:"previous_status"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type UserTotp struct {
+	UserID       int64        `json:"user_id"`
+	Secret       string       `json:"secret"`
+	EnabledAt    sql.NullTime `json:"enabled_at"`
+	LastUsedStep int64        `json:"last_used_step"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
 }
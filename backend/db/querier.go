@@ -6,6 +6,7 @@ package db
 
 import (
 	"context"
+	"time"
 )
 
 type Querier interface {
@@ -13,47 +14,140 @@ type Querier interface {
 	AddCartItem(ctx context.Context, arg AddCartItemParams) (CartItem, error)
 	ClearCart(ctx context.Context, cartID int64) error
 	ClearCartByUser(ctx context.Context, userID int64) error
+	ConsumeRecoveryCode(ctx context.Context, arg ConsumeRecoveryCodeParams) (int64, error)
+	// rotate で提示されたトークンを失効させ、失効理由を 'rotated' にする。同じトークンが並行して使われたら後の方は 0 件になる
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (int64, error)
+	// 受け付けたタイムステップより古い(同じ)コードは 0 件になり、再利用を防ぐ
+	ConsumeTOTPStep(ctx context.Context, arg ConsumeTOTPStepParams) (int64, error)
+	CountActiveRefreshTokensByUser(ctx context.Context, userID int64) (int64, error)
+	// SearchProductsBy* と同じ条件での総件数
+	CountProducts(ctx context.Context, arg CountProductsParams) (int64, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	// SearchUsers と同じ条件での総件数
+	CountUsers(ctx context.Context, arg CountUsersParams) (int64, error)
+	CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error)
 	CreateCart(ctx context.Context, userID int64) (Cart, error)
 	CreateCategory(ctx context.Context, arg CreateCategoryParams) (Category, error)
+	// 既にキーが存在する場合は行を返さない (sql.ErrNoRows)
+	CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) (IdempotencyKey, error)
 	CreateOrder(ctx context.Context, arg CreateOrderParams) (CreateOrderRow, error)
 	CreateOrderItem(ctx context.Context, arg CreateOrderItemParams) (OrderItem, error)
+	CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error)
 	CreateProduct(ctx context.Context, arg CreateProductParams) (Product, error)
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error
 	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error)
 	DeleteCategory(ctx context.Context, id int64) error
+	// 期限切れのキーをまとめて消す。期限内に再送されなかったキーはここでしか消えない
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+	DeleteIdempotencyKey(ctx context.Context, id int64) error
 	DeleteProduct(ctx context.Context, id int64) error
+	DeleteRecoveryCodesByUser(ctx context.Context, userID int64) error
+	// 失敗のたびに updated_at が進むので、stale_before より古い行は集計期間が終わっている。ロック中の行は残す
+	DeleteStaleLoginThrottles(ctx context.Context, staleBefore time.Time) (int64, error)
+	DeleteUserSuspension(ctx context.Context, userID int64) (string, error)
+	DeleteUserTOTP(ctx context.Context, userID int64) error
+	EnableUserTOTP(ctx context.Context, arg EnableUserTOTPParams) (int64, error)
+	// 失効済み・期限切れのキーは sql.ErrNoRows。持ち主のロールとアカウント状態も合わせて返す
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (GetActiveAPIKeyByHashRow, error)
 	GetCartByUser(ctx context.Context, userID int64) (Cart, error)
 	GetCartItemByID(ctx context.Context, id int64) (CartItem, error)
 	GetCategory(ctx context.Context, id int64) (Category, error)
+	GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error)
+	GetLoginThrottle(ctx context.Context, throttleKey string) (LoginThrottle, error)
 	// Requires UNIQUE(user_id) on carts
 	GetOrCreateCartForUser(ctx context.Context, userID int64) (Cart, error)
 	GetOrderByID(ctx context.Context, id int64) (GetOrderByIDRow, error)
 	GetOrderByIDForUpdate(ctx context.Context, id int64) (GetOrderByIDForUpdateRow, error)
 	GetOrderCountByUser(ctx context.Context, userID int64) (int64, error)
+	GetPaymentByOrderID(ctx context.Context, orderID int64) (Payment, error)
 	GetProduct(ctx context.Context, id int64) (Product, error)
 	GetProductForUpdate(ctx context.Context, id int64) (Product, error)
 	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id int64) (User, error)
 	GetUserForUpdate(ctx context.Context, id int64) (User, error)
+	GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error)
+	GetUserTOTP(ctx context.Context, userID int64) (UserTotp, error)
+	// 有効なリフレッシュトークン1件を1セッションとして返す。
+	// rotate のたびに行が作られるため、created_at は最終利用日時、ファミリー最古の created_at がログイン日時になる
+	ListActiveSessionsByUser(ctx context.Context, userID int64) ([]ListActiveSessionsByUserRow, error)
+	ListAPIKeysByUser(ctx context.Context, userID int64) ([]ApiKey, error)
 	ListCartItems(ctx context.Context, cartID int64) ([]ListCartItemsRow, error)
 	ListCartItemsByUser(ctx context.Context, userID int64) ([]ListCartItemsByUserRow, error)
 	ListCategories(ctx context.Context) ([]Category, error)
 	ListOrderItemsByOrderID(ctx context.Context, orderID int64) ([]OrderItem, error)
-	ListOrdersByUser(ctx context.Context, userID int64) ([]ListOrdersByUserRow, error)
+	// 注文一覧の明細を1回で取得する (N+1回避)
+	ListOrderItemsByOrderIDs(ctx context.Context, orderIds []int64) ([]OrderItem, error)
+	// (created_at, id) の降順で keyset ページングする。cursor_* が NULL なら先頭ページ
+	ListOrdersByUser(ctx context.Context, arg ListOrdersByUserParams) ([]ListOrdersByUserRow, error)
 	ListProducts(ctx context.Context) ([]Product, error)
+	// 大文字小文字を区別せずにメールアドレスで引く。登録時にアドレスを正規化していないので、
+	// 大文字小文字だけが違うアドレスの会員が複数見つかることがある
+	ListUsersByEmailFold(ctx context.Context, email string) ([]User, error)
+	// ロック解除後は失敗回数を数え直す
+	LockLoginThrottle(ctx context.Context, arg LockLoginThrottleParams) error
+	// 確認待ちのときだけ有効化する。停止中などのアカウントは確認リンクで復帰させない
+	MarkUserEmailVerified(ctx context.Context, id int64) (User, error)
+	// window_start より前に始まった集計期間は破棄し、1 から数え直す
+	RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (int32, error)
 	RemoveCartItem(ctx context.Context, id int64) error
 	RemoveCartItemByUser(ctx context.Context, arg RemoveCartItemByUserParams) error
+	ResetLoginThrottle(ctx context.Context, throttleKey string) error
+	// 有効期限内のトークンに限りパスワードを更新し、同時にトークンを消費する
+	ResetPasswordByToken(ctx context.Context, arg ResetPasswordByTokenParams) (int64, error)
+	// 失敗した決済をやり直す。決済サービスへの冪等キーを変えるため attempt を進める
+	RetryPayment(ctx context.Context, arg RetryPaymentParams) (Payment, error)
+	RevokeAllAPIKeysByUser(ctx context.Context, userID int64) error
 	RevokeAllRefreshTokensByUser(ctx context.Context, userID int64) error
+	// 他人のキー・失効済みのキーは0件
+	RevokeAPIKeyByUser(ctx context.Context, arg RevokeAPIKeyByUserParams) (int64, error)
+	// 操作中のセッション(ファミリー)以外を全て失効させる
+	RevokeOtherSessionsByUser(ctx context.Context, arg RevokeOtherSessionsByUserParams) error
 	RevokeRefreshTokenByHash(ctx context.Context, tokenHash string) error
+	// 再利用を検知したファミリーの有効なトークンを全て失効させる
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	// 指定したトークンが属するファミリーを失効させる。他人のトークンなら0件
+	RevokeSessionByUser(ctx context.Context, arg RevokeSessionByUserParams) (int64, error)
+	SaveIdempotencyResponse(ctx context.Context, arg SaveIdempotencyResponseParams) error
+	// 停止する直前の状態を残す。再開で DeleteUserSuspension が返す
+	SaveUserSuspension(ctx context.Context, arg SaveUserSuspensionParams) error
+	// 絞り込みをしたうえで id の昇順に keyset ページングする。cursor_id が NULL なら先頭ページ
+	// 並び順ごとにクエリを分け、(並び替えキー, id) の複合インデックスを使えるようにしている
+	// keyword は LIKE のワイルドカードをエスケープ済みであること
+	SearchProductsByID(ctx context.Context, arg SearchProductsByIDParams) ([]Product, error)
+	// SearchProductsByID と同じ条件で名前順に並べる
+	SearchProductsByName(ctx context.Context, arg SearchProductsByNameParams) ([]Product, error)
+	// SearchProductsByID と同じ条件で新しい順に並べる
+	SearchProductsByNewest(ctx context.Context, arg SearchProductsByNewestParams) ([]Product, error)
+	// SearchProductsByID と同じ条件で価格の安い順に並べる
+	SearchProductsByPriceAsc(ctx context.Context, arg SearchProductsByPriceAscParams) ([]Product, error)
+	// SearchProductsByID と同じ条件で価格の高い順に並べる
+	SearchProductsByPriceDesc(ctx context.Context, arg SearchProductsByPriceDescParams) ([]Product, error)
+	// 管理画面用。password_hash / reset_token は取得しない
+	// keyword は LIKE のワイルドカードをエスケープ済みであること。cursor_id が NULL なら先頭ページ
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error)
 	SetResetToken(ctx context.Context, arg SetResetTokenParams) (User, error)
+	// 毎リクエストの書き込みを避けるため、最終利用日時は1分単位でしか更新しない
+	TouchAPIKeyLastUsed(ctx context.Context, id int64) error
+	// IdP 側でメールアドレスが変わっていれば控えも更新する
+	TouchUserIdentityLogin(ctx context.Context, arg TouchUserIdentityLoginParams) error
 	UpdateCartItemQty(ctx context.Context, arg UpdateCartItemQtyParams) (CartItem, error)
 	UpdateCartItemQtyByUser(ctx context.Context, arg UpdateCartItemQtyByUserParams) (CartItem, error)
 	UpdateCategory(ctx context.Context, arg UpdateCategoryParams) (Category, error)
 	UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (UpdateOrderStatusRow, error)
+	UpdatePaymentStatus(ctx context.Context, arg UpdatePaymentStatusParams) (Payment, error)
 	UpdateProduct(ctx context.Context, arg UpdateProductParams) (Product, error)
 	UpdateProductStock(ctx context.Context, arg UpdateProductStockParams) (UpdateProductStockRow, error)
+	UpdateUserLastLogin(ctx context.Context, id int64) error
+	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error
+	// NULL の項目は変更しない。有効な会員がメールアドレスを変えたら、新しいアドレスを確認するまで unverified に戻す
+	UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error)
 	UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error)
+	UpdateUserStatus(ctx context.Context, arg UpdateUserStatusParams) (User, error)
+	// 有効化済みのシークレットは上書きしない(その場合は 0 件で sql.ErrNoRows になる)
+	UpsertPendingUserTOTP(ctx context.Context, arg UpsertPendingUserTOTPParams) (UserTotp, error)
 }
 
 var _ Querier = (*Queries)(nil)
@@ -9,6 +9,8 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 const addCartItem = `-- name: AddCartItem :one
@@ -71,6 +73,203 @@ func (q *Queries) ClearCartByUser(ctx context.Context, userID int64) error {
 	return err
 }
 
+const consumeRecoveryCode = `-- name: ConsumeRecoveryCode :execrows
+UPDATE user_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL
+`
+
+type ConsumeRecoveryCodeParams struct {
+	UserID   int64  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) ConsumeRecoveryCode(ctx context.Context, arg ConsumeRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, consumeRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const consumeRefreshToken = `-- name: ConsumeRefreshToken :execrows
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    revoke_reason = 'rotated',
+    updated_at = NOW()
+WHERE token_hash = $1
+AND revoked_at IS NULL
+`
+
+// rotate で提示されたトークンを失効させ、失効理由を 'rotated' にする。同じトークンが並行して使われたら後の方は 0 件になる
+func (q *Queries) ConsumeRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, consumeRefreshToken, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const consumeTOTPStep = `-- name: ConsumeTOTPStep :execrows
+UPDATE user_totp
+SET
+    last_used_step = $2,
+    updated_at = NOW()
+WHERE user_id = $1
+AND enabled_at IS NOT NULL
+AND last_used_step < $2
+`
+
+type ConsumeTOTPStepParams struct {
+	UserID       int64 `json:"user_id"`
+	LastUsedStep int64 `json:"last_used_step"`
+}
+
+// 受け付けたタイムステップより古い(同じ)コードは 0 件になり、再利用を防ぐ
+func (q *Queries) ConsumeTOTPStep(ctx context.Context, arg ConsumeTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, consumeTOTPStep, arg.UserID, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countActiveRefreshTokensByUser = `-- name: CountActiveRefreshTokensByUser :one
+SELECT COUNT(*)
+FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+`
+
+func (q *Queries) CountActiveRefreshTokensByUser(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countActiveRefreshTokensByUser, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countProducts = `-- name: CountProducts :one
+SELECT COUNT(*)
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+`
+
+type CountProductsParams struct {
+	CategoryID  sql.NullInt64  `json:"category_id"`
+	MinPrice    sql.NullInt32  `json:"min_price"`
+	MaxPrice    sql.NullInt32  `json:"max_price"`
+	IsAvailable sql.NullBool   `json:"is_available"`
+	InStock     bool           `json:"in_stock"`
+	Keyword     sql.NullString `json:"keyword"`
+}
+
+// SearchProductsBy* と同じ条件での総件数
+func (q *Queries) CountProducts(ctx context.Context, arg CountProductsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countProducts,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUnusedRecoveryCodes = `-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*)
+FROM user_recovery_codes
+WHERE user_id = $1
+AND used_at IS NULL
+`
+
+func (q *Queries) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnusedRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*)
+FROM users
+WHERE ($1::text IS NULL OR role = $1::text)
+  AND ($2::text IS NULL OR status = $2::text)
+  AND (
+    $3::text IS NULL
+    OR (name || ' ' || email) ILIKE '%' || $3::text || '%'
+  )
+`
+
+type CountUsersParams struct {
+	Role    sql.NullString `json:"role"`
+	Status  sql.NullString `json:"status"`
+	Keyword sql.NullString `json:"keyword"`
+}
+
+// SearchUsers と同じ条件での総件数
+func (q *Queries) CountUsers(ctx context.Context, arg CountUsersParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers, arg.Role, arg.Status, arg.Keyword)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at, updated_at
+`
+
+type CreateAPIKeyParams struct {
+	UserID    int64        `json:"user_id"`
+	Name      string       `json:"name"`
+	Prefix    string       `json:"prefix"`
+	KeyHash   string       `json:"key_hash"`
+	Scopes    []string     `json:"scopes"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, createAPIKey,
+		arg.UserID,
+		arg.Name,
+		arg.Prefix,
+		arg.KeyHash,
+		pq.Array(arg.Scopes),
+		arg.ExpiresAt,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const createCart = `-- name: CreateCart :one
  INSERT INTO carts (user_id, created_at, updated_at)
  VALUES($1, NOW(), NOW())
@@ -116,6 +315,52 @@ func (q *Queries) CreateCategory(ctx context.Context, arg CreateCategoryParams)
 	return i, err
 }
 
+const createIdempotencyKey = `-- name: CreateIdempotencyKey :one
+INSERT INTO idempotency_keys (
+    user_id, idempotency_key, request_method, request_path, request_hash, expires_at, created_at, updated_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT (user_id, idempotency_key) DO NOTHING
+RETURNING id, user_id, idempotency_key, request_method, request_path, request_hash, response_status, response_body, expires_at, created_at, updated_at
+`
+
+type CreateIdempotencyKeyParams struct {
+	UserID         int64     `json:"user_id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	RequestMethod  string    `json:"request_method"`
+	RequestPath    string    `json:"request_path"`
+	RequestHash    string    `json:"request_hash"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// 既にキーが存在する場合は行を返さない (sql.ErrNoRows)
+func (q *Queries) CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, createIdempotencyKey,
+		arg.UserID,
+		arg.IdempotencyKey,
+		arg.RequestMethod,
+		arg.RequestPath,
+		arg.RequestHash,
+		arg.ExpiresAt,
+	)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.IdempotencyKey,
+		&i.RequestMethod,
+		&i.RequestPath,
+		&i.RequestHash,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const createOrder = `-- name: CreateOrder :one
 INSERT INTO orders (
     user_id, total, status, created_at, updated_at
@@ -193,6 +438,44 @@ func (q *Queries) CreateOrderItem(ctx context.Context, arg CreateOrderItemParams
 	return i, err
 }
 
+const createPayment = `-- name: CreatePayment :one
+INSERT INTO payments (
+    order_id, amount, status, payment_method, created_at, updated_at
+) VALUES (
+    $1, $2, $3, $4, NOW(), NOW()
+)
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt
+`
+
+type CreatePaymentParams struct {
+	OrderID       int64          `json:"order_id"`
+	Amount        int64          `json:"amount"`
+	Status        string         `json:"status"`
+	PaymentMethod sql.NullString `json:"payment_method"`
+}
+
+func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, createPayment,
+		arg.OrderID,
+		arg.Amount,
+		arg.Status,
+		arg.PaymentMethod,
+	)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Amount,
+		&i.Status,
+		&i.PaymentMethod,
+		&i.ExternalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
 const createProduct = `-- name: CreateProduct :one
 INSERT INTO products (
     name, price, is_available, category_id, sku, description, image_url, stock_quantity
@@ -241,20 +524,45 @@ func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (P
 	return i, err
 }
 
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO user_recovery_codes (user_id, code_hash)
+VALUES ($1, $2)
+`
+
+type CreateRecoveryCodeParams struct {
+	UserID   int64  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (user_id, token_hash, expires_at, revoked_at, created_at, updated_at)
-VALUES ($1, $2, $3, NULL, NOW(), NOW())
-RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at
+INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, user_agent, ip_address, revoked_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, NULL, NOW(), NOW())
+RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason
 `
 
 type CreateRefreshTokenParams struct {
 	UserID    int64     `json:"user_id"`
 	TokenHash string    `json:"token_hash"`
 	ExpiresAt time.Time `json:"expires_at"`
+	FamilyID  string    `json:"family_id"`
+	UserAgent string    `json:"user_agent"`
+	IpAddress string    `json:"ip_address"`
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.UserID, arg.TokenHash, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.UserID,
+		arg.TokenHash,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.ID,
@@ -264,17 +572,21 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.RevokedAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.RevokeReason,
 	)
 	return i, err
 }
 
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (
-    name, email, password_hash, role
+    name, email, password_hash, role, status
 ) VALUES (
-    $1, $2, $3, $4
+    $1, $2, $3, $4, $5
 )
-RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
 `
 
 type CreateUserParams struct {
@@ -282,6 +594,7 @@ type CreateUserParams struct {
 	Email        string `json:"email"`
 	PasswordHash string `json:"password_hash"`
 	Role         string `json:"role"`
+	Status       string `json:"status"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
@@ -290,6 +603,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		arg.Email,
 		arg.PasswordHash,
 		arg.Role,
+		arg.Status,
 	)
 	var i User
 	err := row.Scan(
@@ -302,6 +616,41 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, NOW())
+RETURNING id, user_id, provider, subject, email, created_at, last_login_at
+`
+
+type CreateUserIdentityParams struct {
+	UserID   int64          `json:"user_id"`
+	Provider string         `json:"provider"`
+	Subject  string         `json:"subject"`
+	Email    sql.NullString `json:"email"`
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRowContext(ctx, createUserIdentity,
+		arg.UserID,
+		arg.Provider,
+		arg.Subject,
+		arg.Email,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
@@ -316,6 +665,30 @@ func (q *Queries) DeleteCategory(ctx context.Context, id int64) error {
 	return err
 }
 
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1
+`
+
+// 期限切れのキーをまとめて消す。期限内に再送されなかったキーはここでしか消えない
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE id = $1
+`
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, id)
+	return err
+}
+
 const deleteProduct = `-- name: DeleteProduct :exec
 DELETE FROM products
 WHERE id = $1
@@ -326,6 +699,113 @@ func (q *Queries) DeleteProduct(ctx context.Context, id int64) error {
 	return err
 }
 
+const deleteRecoveryCodesByUser = `-- name: DeleteRecoveryCodesByUser :exec
+DELETE FROM user_recovery_codes
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodesByUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodesByUser, userID)
+	return err
+}
+
+const deleteStaleLoginThrottles = `-- name: DeleteStaleLoginThrottles :execrows
+DELETE FROM login_throttles
+WHERE updated_at < $1
+  AND (locked_until IS NULL OR locked_until < NOW())
+`
+
+// 失敗のたびに updated_at が進むので、stale_before より古い行は集計期間が終わっている。ロック中の行は残す
+func (q *Queries) DeleteStaleLoginThrottles(ctx context.Context, staleBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStaleLoginThrottles, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserSuspension = `-- name: DeleteUserSuspension :one
+DELETE FROM user_suspensions
+WHERE user_id = $1
+RETURNING previous_status
+`
+
+func (q *Queries) DeleteUserSuspension(ctx context.Context, userID int64) (string, error) {
+	row := q.db.QueryRowContext(ctx, deleteUserSuspension, userID)
+	var previous_status string
+	err := row.Scan(&previous_status)
+	return previous_status, err
+}
+
+const deleteUserTOTP = `-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserTOTP(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserTOTP, userID)
+	return err
+}
+
+const enableUserTOTP = `-- name: EnableUserTOTP :execrows
+UPDATE user_totp
+SET
+    enabled_at = NOW(),
+    last_used_step = $2,
+    updated_at = NOW()
+WHERE user_id = $1
+AND enabled_at IS NULL
+`
+
+type EnableUserTOTPParams struct {
+	UserID       int64 `json:"user_id"`
+	LastUsedStep int64 `json:"last_used_step"`
+}
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, arg EnableUserTOTPParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enableUserTOTP, arg.UserID, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getActiveAPIKeyByHash = `-- name: GetActiveAPIKeyByHash :one
+SELECT
+    k.id,
+    k.user_id,
+    k.scopes,
+    u.role,
+    u.status
+FROM api_keys k
+JOIN users u ON u.id = k.user_id
+WHERE k.key_hash = $1
+AND k.revoked_at IS NULL
+AND (k.expires_at IS NULL OR k.expires_at > NOW())
+`
+
+type GetActiveAPIKeyByHashRow struct {
+	ID     int64    `json:"id"`
+	UserID int64    `json:"user_id"`
+	Scopes []string `json:"scopes"`
+	Role   string   `json:"role"`
+	Status string   `json:"status"`
+}
+
+// 失効済み・期限切れのキーは sql.ErrNoRows。持ち主のロールとアカウント状態も合わせて返す
+func (q *Queries) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (GetActiveAPIKeyByHashRow, error) {
+	row := q.db.QueryRowContext(ctx, getActiveAPIKeyByHash, keyHash)
+	var i GetActiveAPIKeyByHashRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.Role,
+		&i.Status,
+	)
+	return i, err
+}
+
 const getCartByUser = `-- name: GetCartByUser :one
  SELECT id, user_id, created_at, updated_at
  FROM carts
@@ -386,6 +866,57 @@ func (q *Queries) GetCategory(ctx context.Context, id int64) (Category, error) {
 	return i, err
 }
 
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT id, user_id, idempotency_key, request_method, request_path, request_hash, response_status, response_body, expires_at, created_at, updated_at
+FROM idempotency_keys
+WHERE user_id = $1
+AND idempotency_key = $2
+LIMIT 1
+`
+
+type GetIdempotencyKeyParams struct {
+	UserID         int64  `json:"user_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.UserID, arg.IdempotencyKey)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.IdempotencyKey,
+		&i.RequestMethod,
+		&i.RequestPath,
+		&i.RequestHash,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getLoginThrottle = `-- name: GetLoginThrottle :one
+SELECT throttle_key, failures, window_started_at, locked_until, updated_at
+FROM login_throttles
+WHERE throttle_key = $1
+`
+
+func (q *Queries) GetLoginThrottle(ctx context.Context, throttleKey string) (LoginThrottle, error) {
+	row := q.db.QueryRowContext(ctx, getLoginThrottle, throttleKey)
+	var i LoginThrottle
+	err := row.Scan(
+		&i.ThrottleKey,
+		&i.Failures,
+		&i.WindowStartedAt,
+		&i.LockedUntil,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getOrCreateCartForUser = `-- name: GetOrCreateCartForUser :one
  INSERT INTO carts(user_id, created_at, updated_at)
  VALUES($1, NOW(), NOW())
@@ -482,6 +1013,31 @@ func (q *Queries) GetOrderCountByUser(ctx context.Context, userID int64) (int64,
 	return count, err
 }
 
+const getPaymentByOrderID = `-- name: GetPaymentByOrderID :one
+SELECT
+    id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt
+FROM payments
+WHERE order_id = $1
+LIMIT 1
+`
+
+func (q *Queries) GetPaymentByOrderID(ctx context.Context, orderID int64) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, getPaymentByOrderID, orderID)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Amount,
+		&i.Status,
+		&i.PaymentMethod,
+		&i.ExternalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
 const getProduct = `-- name: GetProduct :one
 SELECT
     id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
@@ -536,7 +1092,7 @@ func (q *Queries) GetProductForUpdate(ctx context.Context, id int64) (Product, e
 }
 
 const getRefreshTokenByHash = `-- name: GetRefreshTokenByHash :one
-SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason
 FROM refresh_tokens
 WHERE token_hash = $1
 LIMIT 1
@@ -553,12 +1109,16 @@ func (q *Queries) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (
 		&i.RevokedAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.RevokeReason,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token FROM users 
+SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users 
 WHERE email = $1 LIMIT 1
 `
 
@@ -575,12 +1135,14 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token FROM users
+SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users
 WHERE id = $1 LIMIT 1
 `
 
@@ -597,12 +1159,14 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
 const getUserForUpdate = `-- name: GetUserForUpdate :one
-SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token FROM users
+SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users
 WHERE id = $1 LIMIT 1
 `
 
@@ -619,20 +1183,170 @@ func (q *Queries) GetUserForUpdate(ctx context.Context, id int64) (User, error)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
-const listCartItems = `-- name: ListCartItems :many
- SELECT
-    ci.id,
-    ci.cart_id,
-    ci.product_id,
-    ci.quantity,
-    ci.price,
-    ci.created_at,
-    ci.updated_at,
-    p.name AS product_name,
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT id, user_id, provider, subject, email, created_at, last_login_at
+FROM user_identities
+WHERE provider = $1
+AND subject = $2
+`
+
+type GetUserIdentityParams struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRowContext(ctx, getUserIdentity, arg.Provider, arg.Subject)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const getUserTOTP = `-- name: GetUserTOTP :one
+SELECT user_id, secret, enabled_at, last_used_step, created_at, updated_at
+FROM user_totp
+WHERE user_id = $1
+`
+
+func (q *Queries) GetUserTOTP(ctx context.Context, userID int64) (UserTotp, error) {
+	row := q.db.QueryRowContext(ctx, getUserTOTP, userID)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.EnabledAt,
+		&i.LastUsedStep,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const listActiveSessionsByUser = `-- name: ListActiveSessionsByUser :many
+SELECT
+    rt.id,
+    rt.family_id,
+    rt.user_agent,
+    rt.ip_address,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id)::timestamptz AS signed_in_at,
+    rt.created_at AS last_used_at,
+    rt.expires_at
+FROM refresh_tokens rt
+WHERE rt.user_id = $1
+AND rt.revoked_at IS NULL
+AND rt.expires_at > NOW()
+ORDER BY rt.created_at DESC, rt.id DESC
+`
+
+type ListActiveSessionsByUserRow struct {
+	ID         int64     `json:"id"`
+	FamilyID   string    `json:"family_id"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// 有効なリフレッシュトークン1件を1セッションとして返す。
+// rotate のたびに行が作られるため、created_at は最終利用日時、ファミリー最古の created_at がログイン日時になる
+func (q *Queries) ListActiveSessionsByUser(ctx context.Context, userID int64) ([]ListActiveSessionsByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveSessionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActiveSessionsByUserRow
+	for rows.Next() {
+		var i ListActiveSessionsByUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.SignedInAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAPIKeysByUser = `-- name: ListAPIKeysByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at, updated_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+`
+
+func (q *Queries) ListAPIKeysByUser(ctx context.Context, userID int64) ([]ApiKey, error) {
+	rows, err := q.db.QueryContext(ctx, listAPIKeysByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.KeyHash,
+			pq.Array(&i.Scopes),
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCartItems = `-- name: ListCartItems :many
+ SELECT
+    ci.id,
+    ci.cart_id,
+    ci.product_id,
+    ci.quantity,
+    ci.price,
+    ci.created_at,
+    ci.updated_at,
+    p.name AS product_name,
     p.price AS product_price,
     p.stock_quantity AS product_stock
 FROM cart_items ci
@@ -789,30 +1503,874 @@ func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
 	return items, nil
 }
 
-const listOrderItemsByOrderID = `-- name: ListOrderItemsByOrderID :many
+const listOrderItemsByOrderID = `-- name: ListOrderItemsByOrderID :many
+SELECT
+    id, order_id, product_id, quantity, unit_price, product_name_snapshot, created_at, updated_at
+FROM order_items
+WHERE order_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListOrderItemsByOrderID(ctx context.Context, orderID int64) ([]OrderItem, error) {
+	rows, err := q.db.QueryContext(ctx, listOrderItemsByOrderID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderItem
+	for rows.Next() {
+		var i OrderItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.UnitPrice,
+			&i.ProductNameSnapshot,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderItemsByOrderIDs = `-- name: ListOrderItemsByOrderIDs :many
+SELECT
+    id, order_id, product_id, quantity, unit_price, product_name_snapshot, created_at, updated_at
+FROM order_items
+WHERE order_id = ANY($1::bigint[])
+ORDER BY order_id, id
+`
+
+// 注文一覧の明細を1回で取得する (N+1回避)
+func (q *Queries) ListOrderItemsByOrderIDs(ctx context.Context, orderIds []int64) ([]OrderItem, error) {
+	rows, err := q.db.QueryContext(ctx, listOrderItemsByOrderIDs, pq.Array(orderIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderItem
+	for rows.Next() {
+		var i OrderItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.UnitPrice,
+			&i.ProductNameSnapshot,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrdersByUser = `-- name: ListOrdersByUser :many
+SELECT
+    id, user_id, total, status, created_at, updated_at
+FROM orders
+WHERE user_id = $1
+  AND ($2::text IS NULL OR status = $2::text)
+  AND ($3::timestamptz IS NULL OR created_at >= $3::timestamptz)
+  AND ($4::timestamptz IS NULL OR created_at < $4::timestamptz)
+  AND (
+    $5::timestamptz IS NULL
+    OR (created_at, id) < ($5::timestamptz, $6::bigint)
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT $7
+`
+
+type ListOrdersByUserParams struct {
+	UserID          int64          `json:"user_id"`
+	Status          sql.NullString `json:"status"`
+	CreatedFrom     sql.NullTime   `json:"created_from"`
+	CreatedTo       sql.NullTime   `json:"created_to"`
+	CursorCreatedAt sql.NullTime   `json:"cursor_created_at"`
+	CursorID        sql.NullInt64  `json:"cursor_id"`
+	PageLimit       int32          `json:"page_limit"`
+}
+
+type ListOrdersByUserRow struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Total     int64     `json:"total"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// (created_at, id) の降順で keyset ページングする。cursor_* が NULL なら先頭ページ
+func (q *Queries) ListOrdersByUser(ctx context.Context, arg ListOrdersByUserParams) ([]ListOrdersByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, listOrdersByUser,
+		arg.UserID,
+		arg.Status,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrdersByUserRow
+	for rows.Next() {
+		var i ListOrdersByUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Total,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProducts = `-- name: ListProducts :many
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+ORDER BY id
+`
+
+func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, listProducts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersByEmailFold = `-- name: ListUsersByEmailFold :many
+SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users
+WHERE LOWER(email) = LOWER($1)
+ORDER BY id
+`
+
+// 大文字小文字を区別せずにメールアドレスで引く。登録時にアドレスを正規化していないので、
+// 大文字小文字だけが違うアドレスの会員が複数見つかることがある
+func (q *Queries) ListUsersByEmailFold(ctx context.Context, email string) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersByEmailFold, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.PasswordHash,
+			&i.Role,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ResetToken,
+			&i.ResetTokenExpiresAt,
+			&i.LastLoginAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockLoginThrottle = `-- name: LockLoginThrottle :exec
+UPDATE login_throttles
+SET
+    failures = 0,
+    window_started_at = NOW(),
+    locked_until = $2,
+    updated_at = NOW()
+WHERE throttle_key = $1
+`
+
+type LockLoginThrottleParams struct {
+	ThrottleKey string       `json:"throttle_key"`
+	LockedUntil sql.NullTime `json:"locked_until"`
+}
+
+// ロック解除後は失敗回数を数え直す
+func (q *Queries) LockLoginThrottle(ctx context.Context, arg LockLoginThrottleParams) error {
+	_, err := q.db.ExecContext(ctx, lockLoginThrottle, arg.ThrottleKey, arg.LockedUntil)
+	return err
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :one
+UPDATE users
+SET status = 'active',
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'unverified'
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
+`
+
+// 確認待ちのときだけ有効化する。停止中などのアカウントは確認リンクで復帰させない
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, markUserEmailVerified, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (throttle_key, failures, window_started_at, updated_at)
+VALUES ($1, 1, NOW(), NOW())
+ON CONFLICT (throttle_key) DO UPDATE
+SET
+    failures = CASE WHEN login_throttles.window_started_at < $2 THEN 1 ELSE login_throttles.failures + 1 END,
+    window_started_at = CASE WHEN login_throttles.window_started_at < $2 THEN NOW() ELSE login_throttles.window_started_at END,
+    updated_at = NOW()
+RETURNING failures
+`
+
+type RecordLoginFailureParams struct {
+	ThrottleKey string    `json:"throttle_key"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+// window_start より前に始まった集計期間は破棄し、1 から数え直す
+func (q *Queries) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordLoginFailure, arg.ThrottleKey, arg.WindowStart)
+	var failures int32
+	err := row.Scan(&failures)
+	return failures, err
+}
+
+const removeCartItem = `-- name: RemoveCartItem :exec
+DELETE FROM cart_items
+WHERE id = $1
+`
+
+func (q *Queries) RemoveCartItem(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, removeCartItem, id)
+	return err
+}
+
+const removeCartItemByUser = `-- name: RemoveCartItemByUser :exec
+DELETE FROM cart_items ci
+USING carts c
+WHERE ci.id = $1
+AND ci.cart_id = c.id
+AND c.user_id = $2
+`
+
+type RemoveCartItemByUserParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) RemoveCartItemByUser(ctx context.Context, arg RemoveCartItemByUserParams) error {
+	_, err := q.db.ExecContext(ctx, removeCartItemByUser, arg.ID, arg.UserID)
+	return err
+}
+
+const resetLoginThrottle = `-- name: ResetLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE throttle_key = $1
+`
+
+func (q *Queries) ResetLoginThrottle(ctx context.Context, throttleKey string) error {
+	_, err := q.db.ExecContext(ctx, resetLoginThrottle, throttleKey)
+	return err
+}
+
+const resetPasswordByToken = `-- name: ResetPasswordByToken :one
+UPDATE users
+SET password_hash = $1,
+    reset_token = NULL,
+    reset_token_expires_at = NULL,
+    updated_at = NOW()
+WHERE reset_token = $2::text
+  AND reset_token_expires_at > NOW()
+RETURNING id
+`
+
+type ResetPasswordByTokenParams struct {
+	PasswordHash string `json:"password_hash"`
+	ResetToken   string `json:"reset_token"`
+}
+
+// 有効期限内のトークンに限りパスワードを更新し、同時にトークンを消費する
+func (q *Queries) ResetPasswordByToken(ctx context.Context, arg ResetPasswordByTokenParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, resetPasswordByToken, arg.PasswordHash, arg.ResetToken)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const retryPayment = `-- name: RetryPayment :one
+UPDATE payments
+SET
+    status = 'pending',
+    attempt = attempt + 1,
+    payment_method = $2,
+    external_transaction_id = NULL,
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'failed'
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt
+`
+
+type RetryPaymentParams struct {
+	ID            int64          `json:"id"`
+	PaymentMethod sql.NullString `json:"payment_method"`
+}
+
+// 失敗した決済をやり直す。決済サービスへの冪等キーを変えるため attempt を進める
+func (q *Queries) RetryPayment(ctx context.Context, arg RetryPaymentParams) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, retryPayment, arg.ID, arg.PaymentMethod)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Amount,
+		&i.Status,
+		&i.PaymentMethod,
+		&i.ExternalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
+const revokeAllAPIKeysByUser = `-- name: RevokeAllAPIKeysByUser :exec
+UPDATE api_keys
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllAPIKeysByUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, revokeAllAPIKeysByUser, userID)
+	return err
+}
+
+const revokeAllRefreshTokensByUser = `-- name: RevokeAllRefreshTokensByUser :exec
+UPDATE refresh_tokens
+SET 
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokensByUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensByUser, userID)
+	return err
+}
+
+const revokeAPIKeyByUser = `-- name: RevokeAPIKeyByUser :execrows
+UPDATE api_keys
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+AND user_id = $2
+AND revoked_at IS NULL
+`
+
+type RevokeAPIKeyByUserParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+// 他人のキー・失効済みのキーは0件
+func (q *Queries) RevokeAPIKeyByUser(ctx context.Context, arg RevokeAPIKeyByUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeAPIKeyByUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeOtherSessionsByUser = `-- name: RevokeOtherSessionsByUser :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL
+`
+
+type RevokeOtherSessionsByUserParams struct {
+	UserID   int64  `json:"user_id"`
+	FamilyID string `json:"family_id"`
+}
+
+// 操作中のセッション(ファミリー)以外を全て失効させる
+func (q *Queries) RevokeOtherSessionsByUser(ctx context.Context, arg RevokeOtherSessionsByUserParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherSessionsByUser, arg.UserID, arg.FamilyID)
+	return err
+}
+
+const revokeRefreshTokenByHash = `-- name: RevokeRefreshTokenByHash :exec
+UPDATE refresh_tokens
+SET 
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE token_hash = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenByHash(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenByHash, tokenHash)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL
+`
+
+// 再利用を検知したファミリーの有効なトークンを全て失効させる
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeSessionByUser = `-- name: RevokeSessionByUser :execrows
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = (
+    SELECT s.family_id FROM refresh_tokens s
+    WHERE s.id = $1 AND s.user_id = $2
+)
+AND revoked_at IS NULL
+`
+
+type RevokeSessionByUserParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+// 指定したトークンが属するファミリーを失効させる。他人のトークンなら0件
+func (q *Queries) RevokeSessionByUser(ctx context.Context, arg RevokeSessionByUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSessionByUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const saveIdempotencyResponse = `-- name: SaveIdempotencyResponse :exec
+UPDATE idempotency_keys
+SET
+    response_status = $2,
+    response_body = $3,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type SaveIdempotencyResponseParams struct {
+	ID             int64         `json:"id"`
+	ResponseStatus sql.NullInt32 `json:"response_status"`
+	ResponseBody   []byte        `json:"response_body"`
+}
+
+func (q *Queries) SaveIdempotencyResponse(ctx context.Context, arg SaveIdempotencyResponseParams) error {
+	_, err := q.db.ExecContext(ctx, saveIdempotencyResponse, arg.ID, arg.ResponseStatus, arg.ResponseBody)
+	return err
+}
+
+const saveUserSuspension = `-- name: SaveUserSuspension :exec
+INSERT INTO user_suspensions (user_id, previous_status, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET
+    previous_status = EXCLUDED.previous_status,
+    created_at = NOW()
+`
+
+type SaveUserSuspensionParams struct {
+	UserID         int64  `json:"user_id"`
+	PreviousStatus string `json:"previous_status"`
+}
+
+// 停止する直前の状態を残す。再開で DeleteUserSuspension が返す
+func (q *Queries) SaveUserSuspension(ctx context.Context, arg SaveUserSuspensionParams) error {
+	_, err := q.db.ExecContext(ctx, saveUserSuspension, arg.UserID, arg.PreviousStatus)
+	return err
+}
+
+const searchProductsByID = `-- name: SearchProductsByID :many
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+  AND ($7::bigint IS NULL OR id > $7::bigint)
+ORDER BY id ASC
+LIMIT $8
+`
+
+type SearchProductsByIDParams struct {
+	CategoryID  sql.NullInt64  `json:"category_id"`
+	MinPrice    sql.NullInt32  `json:"min_price"`
+	MaxPrice    sql.NullInt32  `json:"max_price"`
+	IsAvailable sql.NullBool   `json:"is_available"`
+	InStock     bool           `json:"in_stock"`
+	Keyword     sql.NullString `json:"keyword"`
+	CursorID    sql.NullInt64  `json:"cursor_id"`
+	PageLimit   int32          `json:"page_limit"`
+}
+
+// 絞り込みをしたうえで id の昇順に keyset ページングする。cursor_id が NULL なら先頭ページ
+// 並び順ごとにクエリを分け、(並び替えキー, id) の複合インデックスを使えるようにしている
+// keyword は LIKE のワイルドカードをエスケープ済みであること
+func (q *Queries) SearchProductsByID(ctx context.Context, arg SearchProductsByIDParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, searchProductsByID,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchProductsByName = `-- name: SearchProductsByName :many
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+  AND ($7::bigint IS NULL OR (name, id) > ($8::text, $7::bigint))
+ORDER BY name ASC, id ASC
+LIMIT $9
+`
+
+type SearchProductsByNameParams struct {
+	CategoryID  sql.NullInt64  `json:"category_id"`
+	MinPrice    sql.NullInt32  `json:"min_price"`
+	MaxPrice    sql.NullInt32  `json:"max_price"`
+	IsAvailable sql.NullBool   `json:"is_available"`
+	InStock     bool           `json:"in_stock"`
+	Keyword     sql.NullString `json:"keyword"`
+	CursorID    sql.NullInt64  `json:"cursor_id"`
+	CursorName  sql.NullString `json:"cursor_name"`
+	PageLimit   int32          `json:"page_limit"`
+}
+
+// SearchProductsByID と同じ条件で名前順に並べる
+func (q *Queries) SearchProductsByName(ctx context.Context, arg SearchProductsByNameParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, searchProductsByName,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+		arg.CursorID,
+		arg.CursorName,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchProductsByNewest = `-- name: SearchProductsByNewest :many
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+  AND ($7::bigint IS NULL OR (created_at, id) < ($8::timestamptz, $7::bigint))
+ORDER BY created_at DESC, id DESC
+LIMIT $9
+`
+
+type SearchProductsByNewestParams struct {
+	CategoryID      sql.NullInt64  `json:"category_id"`
+	MinPrice        sql.NullInt32  `json:"min_price"`
+	MaxPrice        sql.NullInt32  `json:"max_price"`
+	IsAvailable     sql.NullBool   `json:"is_available"`
+	InStock         bool           `json:"in_stock"`
+	Keyword         sql.NullString `json:"keyword"`
+	CursorID        sql.NullInt64  `json:"cursor_id"`
+	CursorCreatedAt sql.NullTime   `json:"cursor_created_at"`
+	PageLimit       int32          `json:"page_limit"`
+}
+
+// SearchProductsByID と同じ条件で新しい順に並べる
+func (q *Queries) SearchProductsByNewest(ctx context.Context, arg SearchProductsByNewestParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, searchProductsByNewest,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+		arg.CursorID,
+		arg.CursorCreatedAt,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchProductsByPriceAsc = `-- name: SearchProductsByPriceAsc :many
 SELECT
-    id, order_id, product_id, quantity, unit_price, product_name_snapshot, created_at, updated_at
-FROM order_items
-WHERE order_id = $1
-ORDER BY id
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+  AND ($7::bigint IS NULL OR (price, id) > ($8::integer, $7::bigint))
+ORDER BY price ASC, id ASC
+LIMIT $9
 `
 
-func (q *Queries) ListOrderItemsByOrderID(ctx context.Context, orderID int64) ([]OrderItem, error) {
-	rows, err := q.db.QueryContext(ctx, listOrderItemsByOrderID, orderID)
+type SearchProductsByPriceAscParams struct {
+	CategoryID  sql.NullInt64  `json:"category_id"`
+	MinPrice    sql.NullInt32  `json:"min_price"`
+	MaxPrice    sql.NullInt32  `json:"max_price"`
+	IsAvailable sql.NullBool   `json:"is_available"`
+	InStock     bool           `json:"in_stock"`
+	Keyword     sql.NullString `json:"keyword"`
+	CursorID    sql.NullInt64  `json:"cursor_id"`
+	CursorPrice sql.NullInt32  `json:"cursor_price"`
+	PageLimit   int32          `json:"page_limit"`
+}
+
+// SearchProductsByID と同じ条件で価格の安い順に並べる
+func (q *Queries) SearchProductsByPriceAsc(ctx context.Context, arg SearchProductsByPriceAscParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, searchProductsByPriceAsc,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+		arg.CursorID,
+		arg.CursorPrice,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []OrderItem
+	var items []Product
 	for rows.Next() {
-		var i OrderItem
+		var i Product
 		if err := rows.Scan(
 			&i.ID,
-			&i.OrderID,
-			&i.ProductID,
-			&i.Quantity,
-			&i.UnitPrice,
-			&i.ProductNameSnapshot,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -829,37 +2387,66 @@ func (q *Queries) ListOrderItemsByOrderID(ctx context.Context, orderID int64) ([
 	return items, nil
 }
 
-const listOrdersByUser = `-- name: ListOrdersByUser :many
+const searchProductsByPriceDesc = `-- name: SearchProductsByPriceDesc :many
 SELECT
-    id, user_id, total, status, created_at, updated_at
-FROM orders
-WHERE user_id = $1
-ORDER BY created_at DESC
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE ($1::bigint IS NULL OR category_id = $1::bigint)
+  AND ($2::integer IS NULL OR price >= $2::integer)
+  AND ($3::integer IS NULL OR price <= $3::integer)
+  AND ($4::boolean IS NULL OR is_available = $4::boolean)
+  AND (NOT $5::boolean OR stock_quantity > 0)
+  AND (
+    $6::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || $6::text || '%'
+  )
+  AND ($7::bigint IS NULL OR (price, id) < ($8::integer, $7::bigint))
+ORDER BY price DESC, id DESC
+LIMIT $9
 `
 
-type ListOrdersByUserRow struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Total     int64     `json:"total"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
-
-func (q *Queries) ListOrdersByUser(ctx context.Context, userID int64) ([]ListOrdersByUserRow, error) {
-	rows, err := q.db.QueryContext(ctx, listOrdersByUser, userID)
+type SearchProductsByPriceDescParams struct {
+	CategoryID  sql.NullInt64  `json:"category_id"`
+	MinPrice    sql.NullInt32  `json:"min_price"`
+	MaxPrice    sql.NullInt32  `json:"max_price"`
+	IsAvailable sql.NullBool   `json:"is_available"`
+	InStock     bool           `json:"in_stock"`
+	Keyword     sql.NullString `json:"keyword"`
+	CursorID    sql.NullInt64  `json:"cursor_id"`
+	CursorPrice sql.NullInt32  `json:"cursor_price"`
+	PageLimit   int32          `json:"page_limit"`
+}
+
+// SearchProductsByID と同じ条件で価格の高い順に並べる
+func (q *Queries) SearchProductsByPriceDesc(ctx context.Context, arg SearchProductsByPriceDescParams) ([]Product, error) {
+	rows, err := q.db.QueryContext(ctx, searchProductsByPriceDesc,
+		arg.CategoryID,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.IsAvailable,
+		arg.InStock,
+		arg.Keyword,
+		arg.CursorID,
+		arg.CursorPrice,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListOrdersByUserRow
+	var items []Product
 	for rows.Next() {
-		var i ListOrdersByUserRow
+		var i Product
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.Total,
-			&i.Status,
+			&i.Name,
+			&i.Price,
+			&i.IsAvailable,
+			&i.CategoryID,
+			&i.Sku,
+			&i.Description,
+			&i.ImageUrl,
+			&i.StockQuantity,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -876,34 +2463,65 @@ func (q *Queries) ListOrdersByUser(ctx context.Context, userID int64) ([]ListOrd
 	return items, nil
 }
 
-const listProducts = `-- name: ListProducts :many
-SELECT
-    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
-FROM products
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, name, email, role, status, created_at, updated_at, last_login_at
+FROM users
+WHERE ($1::text IS NULL OR role = $1::text)
+  AND ($2::text IS NULL OR status = $2::text)
+  AND (
+    $3::text IS NULL
+    OR (name || ' ' || email) ILIKE '%' || $3::text || '%'
+  )
+  AND ($4::bigint IS NULL OR id > $4::bigint)
 ORDER BY id
+LIMIT $5
 `
 
-func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
-	rows, err := q.db.QueryContext(ctx, listProducts)
+type SearchUsersParams struct {
+	Role      sql.NullString `json:"role"`
+	Status    sql.NullString `json:"status"`
+	Keyword   sql.NullString `json:"keyword"`
+	CursorID  sql.NullInt64  `json:"cursor_id"`
+	PageLimit int32          `json:"page_limit"`
+}
+
+type SearchUsersRow struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Email       string       `json:"email"`
+	Role        string       `json:"role"`
+	Status      string       `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	LastLoginAt sql.NullTime `json:"last_login_at"`
+}
+
+// 管理画面用。password_hash / reset_token は取得しない
+// keyword は LIKE のワイルドカードをエスケープ済みであること。cursor_id が NULL なら先頭ページ
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchUsers,
+		arg.Role,
+		arg.Status,
+		arg.Keyword,
+		arg.CursorID,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Product
+	var items []SearchUsersRow
 	for rows.Next() {
-		var i Product
+		var i SearchUsersRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.Name,
-			&i.Price,
-			&i.IsAvailable,
-			&i.CategoryID,
-			&i.Sku,
-			&i.Description,
-			&i.ImageUrl,
-			&i.StockQuantity,
+			&i.Email,
+			&i.Role,
+			&i.Status,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.LastLoginAt,
 		); err != nil {
 			return nil, err
 		}
@@ -918,77 +2536,23 @@ func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
 	return items, nil
 }
 
-const removeCartItem = `-- name: RemoveCartItem :exec
-DELETE FROM cart_items
-WHERE id = $1
-`
-
-func (q *Queries) RemoveCartItem(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, removeCartItem, id)
-	return err
-}
-
-const removeCartItemByUser = `-- name: RemoveCartItemByUser :exec
-DELETE FROM cart_items ci
-USING carts c
-WHERE ci.id = $1
-AND ci.cart_id = c.id
-AND c.user_id = $2
-`
-
-type RemoveCartItemByUserParams struct {
-	ID     int64 `json:"id"`
-	UserID int64 `json:"user_id"`
-}
-
-func (q *Queries) RemoveCartItemByUser(ctx context.Context, arg RemoveCartItemByUserParams) error {
-	_, err := q.db.ExecContext(ctx, removeCartItemByUser, arg.ID, arg.UserID)
-	return err
-}
-
-const revokeAllRefreshTokensByUser = `-- name: RevokeAllRefreshTokensByUser :exec
-UPDATE refresh_tokens
-SET 
-    revoked_at = NOW(),
-    updated_at = NOW()
-WHERE user_id = $1
-AND revoked_at IS NULL
-`
-
-func (q *Queries) RevokeAllRefreshTokensByUser(ctx context.Context, userID int64) error {
-	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensByUser, userID)
-	return err
-}
-
-const revokeRefreshTokenByHash = `-- name: RevokeRefreshTokenByHash :exec
-UPDATE refresh_tokens
-SET 
-    revoked_at = NOW(),
-    updated_at = NOW()
-WHERE token_hash = $1
-AND revoked_at IS NULL
-`
-
-func (q *Queries) RevokeRefreshTokenByHash(ctx context.Context, tokenHash string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshTokenByHash, tokenHash)
-	return err
-}
-
 const setResetToken = `-- name: SetResetToken :one
 UPDATE users
 SET reset_token = $1,
+    reset_token_expires_at = $2,
     updated_at = NOW()
-WHERE id = $2
-RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token
+WHERE id = $3
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
 `
 
 type SetResetTokenParams struct {
-	ResetToken sql.NullString `json:"reset_token"`
-	ID         int64          `json:"id"`
+	ResetToken          sql.NullString `json:"reset_token"`
+	ResetTokenExpiresAt sql.NullTime   `json:"reset_token_expires_at"`
+	ID                  int64          `json:"id"`
 }
 
 func (q *Queries) SetResetToken(ctx context.Context, arg SetResetTokenParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, setResetToken, arg.ResetToken, arg.ID)
+	row := q.db.QueryRowContext(ctx, setResetToken, arg.ResetToken, arg.ResetTokenExpiresAt, arg.ID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -1000,10 +2564,44 @@ func (q *Queries) SetResetToken(ctx context.Context, arg SetResetTokenParams) (U
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
+const touchAPIKeyLastUsed = `-- name: TouchAPIKeyLastUsed :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+`
+
+// 毎リクエストの書き込みを避けるため、最終利用日時は1分単位でしか更新しない
+func (q *Queries) TouchAPIKeyLastUsed(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, touchAPIKeyLastUsed, id)
+	return err
+}
+
+const touchUserIdentityLogin = `-- name: TouchUserIdentityLogin :exec
+UPDATE user_identities
+SET
+    email = $2,
+    last_login_at = NOW()
+WHERE id = $1
+`
+
+type TouchUserIdentityLoginParams struct {
+	ID    int64          `json:"id"`
+	Email sql.NullString `json:"email"`
+}
+
+// IdP 側でメールアドレスが変わっていれば控えも更新する
+func (q *Queries) TouchUserIdentityLogin(ctx context.Context, arg TouchUserIdentityLoginParams) error {
+	_, err := q.db.ExecContext(ctx, touchUserIdentityLogin, arg.ID, arg.Email)
+	return err
+}
+
 const updateCartItemQty = `-- name: UpdateCartItemQty :one
 UPDATE cart_items
 SET quantity = $2, updated_at = NOW()
@@ -1128,6 +2726,46 @@ func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusPa
 	return i, err
 }
 
+const updatePaymentStatus = `-- name: UpdatePaymentStatus :one
+UPDATE payments
+SET
+    status = $2,
+    payment_method = $3,
+    external_transaction_id = $4,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt
+`
+
+type UpdatePaymentStatusParams struct {
+	ID                    int64          `json:"id"`
+	Status                string         `json:"status"`
+	PaymentMethod         sql.NullString `json:"payment_method"`
+	ExternalTransactionID sql.NullString `json:"external_transaction_id"`
+}
+
+func (q *Queries) UpdatePaymentStatus(ctx context.Context, arg UpdatePaymentStatusParams) (Payment, error) {
+	row := q.db.QueryRowContext(ctx, updatePaymentStatus,
+		arg.ID,
+		arg.Status,
+		arg.PaymentMethod,
+		arg.ExternalTransactionID,
+	)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Amount,
+		&i.Status,
+		&i.PaymentMethod,
+		&i.ExternalTransactionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempt,
+	)
+	return i, err
+}
+
 const updateProduct = `-- name: UpdateProduct :one
 UPDATE products
 SET
@@ -1211,12 +2849,79 @@ func (q *Queries) UpdateProductStock(ctx context.Context, arg UpdateProductStock
 	return i, err
 }
 
+const updateUserLastLogin = `-- name: UpdateUserLastLogin :exec
+UPDATE users
+SET last_login_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) UpdateUserLastLogin(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, updateUserLastLogin, id)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET password_hash = $1,
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type UpdateUserPasswordParams struct {
+	PasswordHash string `json:"password_hash"`
+	ID           int64  `json:"id"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.PasswordHash, arg.ID)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET name = COALESCE($1, name),
+    email = COALESCE($2, email),
+    status = CASE
+        WHEN status = 'active' AND LOWER($2) <> LOWER(email) THEN 'unverified'
+        ELSE status
+    END,
+    updated_at = NOW()
+WHERE id = $3
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
+`
+
+type UpdateUserProfileParams struct {
+	Name  sql.NullString `json:"name"`
+	Email sql.NullString `json:"email"`
+	ID    int64          `json:"id"`
+}
+
+// NULL の項目は変更しない。有効な会員がメールアドレスを変えたら、新しいアドレスを確認するまで unverified に戻す
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile, arg.Name, arg.Email, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
 const updateUserRole = `-- name: UpdateUserRole :one
 UPDATE users
 SET role = $1,
     updated_at = NOW()
 WHERE id = $2
-RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
 `
 
 type UpdateUserRoleParams struct {
@@ -1237,6 +2942,72 @@ func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const updateUserStatus = `-- name: UpdateUserStatus :one
+UPDATE users
+SET status = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at
+`
+
+type UpdateUserStatusParams struct {
+	Status string `json:"status"`
+	ID     int64  `json:"id"`
+}
+
+func (q *Queries) UpdateUserStatus(ctx context.Context, arg UpdateUserStatusParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserStatus, arg.Status, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ResetToken,
+		&i.ResetTokenExpiresAt,
+		&i.LastLoginAt,
+	)
+	return i, err
+}
+
+const upsertPendingUserTOTP = `-- name: UpsertPendingUserTOTP :one
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET
+    secret = EXCLUDED.secret,
+    last_used_step = 0,
+    updated_at = NOW()
+WHERE user_totp.enabled_at IS NULL
+RETURNING user_id, secret, enabled_at, last_used_step, created_at, updated_at
+`
+
+type UpsertPendingUserTOTPParams struct {
+	UserID int64  `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+// 有効化済みのシークレットは上書きしない(その場合は 0 件で sql.ErrNoRows になる)
+func (q *Queries) UpsertPendingUserTOTP(ctx context.Context, arg UpsertPendingUserTOTPParams) (UserTotp, error) {
+	row := q.db.QueryRowContext(ctx, upsertPendingUserTOTP, arg.UserID, arg.Secret)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.EnabledAt,
+		&i.LastUsedStep,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
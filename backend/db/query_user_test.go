@@ -36,12 +36,12 @@ func TestGetUserByID(t *testing.T) {
 			name: "正常系: ユーザーが存在する",
 			id:   1,
 			mockSetup: func(m sqlmock.Sqlmock) {
-				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token"}
+				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token", "reset_token_expires_at", "last_login_at"}
 				rows := sqlmock.NewRows(cols).AddRow(
 					int64(1), "Alice", "alice@example.com", "hash", "member", "active",
-					time.Now(), time.Now(), sql.NullString{Valid: false},
+					time.Now(), time.Now(), sql.NullString{Valid: false}, sql.NullTime{Valid: false}, sql.NullTime{Valid: false},
 				)
-				m.ExpectQuery(regexp.QuoteMeta("SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token FROM users\nWHERE id = $1 LIMIT 1")).
+				m.ExpectQuery(regexp.QuoteMeta("SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users\nWHERE id = $1 LIMIT 1")).
 					WithArgs(int64(1)).
 					WillReturnRows(rows)
 			},
@@ -52,7 +52,7 @@ func TestGetUserByID(t *testing.T) {
 			name: "異常系: ユーザーが存在しない",
 			id:   999,
 			mockSetup: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(regexp.QuoteMeta("SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token FROM users\nWHERE id = $1 LIMIT 1")).
+				m.ExpectQuery(regexp.QuoteMeta("SELECT id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at FROM users\nWHERE id = $1 LIMIT 1")).
 					WithArgs(int64(999)).
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -95,12 +95,12 @@ func TestUpdateUserRole(t *testing.T) {
 			id:      2,
 			newRole: "admin",
 			mockSetup: func(m sqlmock.Sqlmock) {
-				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token"}
+				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token", "reset_token_expires_at", "last_login_at"}
 				rows := sqlmock.NewRows(cols).AddRow(
 					int64(2), "Bob", "bob@example.com", "hash", "admin", "active",
-					time.Now(), time.Now(), sql.NullString{Valid: false},
+					time.Now(), time.Now(), sql.NullString{Valid: false}, sql.NullTime{Valid: false}, sql.NullTime{Valid: false},
 				)
-				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET role = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token")).
+				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET role = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at")).
 					WithArgs("admin", int64(2)).
 					WillReturnRows(rows)
 			},
@@ -112,7 +112,7 @@ func TestUpdateUserRole(t *testing.T) {
 			id:      999,
 			newRole: "admin",
 			mockSetup: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET role = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token")).
+				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET role = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at")).
 					WithArgs("admin", int64(999)).
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -145,27 +145,31 @@ func TestUpdateUserRole(t *testing.T) {
 
 // TestSetResetToken テーブル駆動テスト
 func TestSetResetToken(t *testing.T) {
+	expiresAt := sql.NullTime{Time: time.Now().Add(30 * time.Minute), Valid: true}
+
 	tests := []struct {
 		name               string
 		id                 int64
 		token              sql.NullString
+		expiresAt          sql.NullTime
 		mockSetup          func(sqlmock.Sqlmock)
 		expectedErr        bool
 		expectedTokenValid bool
 		expectedToken      string
 	}{
 		{
-			name:  "正常系: トークンを保存",
-			id:    3,
-			token: sql.NullString{String: "tok123abc", Valid: true},
+			name:      "正常系: トークンを保存",
+			id:        3,
+			token:     sql.NullString{String: "tok123abc", Valid: true},
+			expiresAt: expiresAt,
 			mockSetup: func(m sqlmock.Sqlmock) {
-				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token"}
+				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token", "reset_token_expires_at", "last_login_at"}
 				rows := sqlmock.NewRows(cols).AddRow(
 					int64(3), "Carol", "carol@example.com", "hash", "member", "active",
-					time.Now(), time.Now(), sql.NullString{String: "tok123abc", Valid: true},
+					time.Now(), time.Now(), sql.NullString{String: "tok123abc", Valid: true}, expiresAt, sql.NullTime{Valid: false},
 				)
-				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token")).
-					WithArgs(sql.NullString{String: "tok123abc", Valid: true}, int64(3)).
+				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    reset_token_expires_at = $2,\n    updated_at = NOW()\nWHERE id = $3\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at")).
+					WithArgs(sql.NullString{String: "tok123abc", Valid: true}, expiresAt, int64(3)).
 					WillReturnRows(rows)
 			},
 			expectedErr:        false,
@@ -177,25 +181,26 @@ func TestSetResetToken(t *testing.T) {
 			id:    3,
 			token: sql.NullString{Valid: false},
 			mockSetup: func(m sqlmock.Sqlmock) {
-				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token"}
+				cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token", "reset_token_expires_at", "last_login_at"}
 				rows := sqlmock.NewRows(cols).AddRow(
 					int64(3), "Carol", "carol@example.com", "hash", "member", "active",
-					time.Now(), time.Now(), sql.NullString{Valid: false},
+					time.Now(), time.Now(), sql.NullString{Valid: false}, sql.NullTime{Valid: false}, sql.NullTime{Valid: false},
 				)
-				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token")).
-					WithArgs(sql.NullString{Valid: false}, int64(3)).
+				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    reset_token_expires_at = $2,\n    updated_at = NOW()\nWHERE id = $3\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at")).
+					WithArgs(sql.NullString{Valid: false}, sql.NullTime{}, int64(3)).
 					WillReturnRows(rows)
 			},
 			expectedErr:        false,
 			expectedTokenValid: false,
 		},
 		{
-			name:  "異常系: ユーザーが存在しない",
-			id:    999,
-			token: sql.NullString{String: "tok", Valid: true},
+			name:      "異常系: ユーザーが存在しない",
+			id:        999,
+			token:     sql.NullString{String: "tok", Valid: true},
+			expiresAt: expiresAt,
 			mockSetup: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    updated_at = NOW()\nWHERE id = $2\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token")).
-					WithArgs(sql.NullString{String: "tok", Valid: true}, int64(999)).
+				m.ExpectQuery(regexp.QuoteMeta("UPDATE users\nSET reset_token = $1,\n    reset_token_expires_at = $2,\n    updated_at = NOW()\nWHERE id = $3\nRETURNING id, name, email, password_hash, role, status, created_at, updated_at, reset_token, reset_token_expires_at, last_login_at")).
+					WithArgs(sql.NullString{String: "tok", Valid: true}, expiresAt, int64(999)).
 					WillReturnError(sql.ErrNoRows)
 			},
 			expectedErr: true,
@@ -210,8 +215,9 @@ func TestSetResetToken(t *testing.T) {
 			tt.mockSetup(mock)
 
 			res, err := q.SetResetToken(context.Background(), db.SetResetTokenParams{
-				ResetToken: tt.token,
-				ID:         tt.id,
+				ResetToken:          tt.token,
+				ResetTokenExpiresAt: tt.expiresAt,
+				ID:                  tt.id,
 			})
 			if tt.expectedErr {
 				assert.Error(t, err)
@@ -235,7 +241,7 @@ func TestCreateRefreshToken(t *testing.T) {
 	now := time.Now()
 	expiresAt := now.Add(14 * 24 * time.Hour)
 
-	cols := []string{"id", "user_id", "token_hash", "expires_at", "revoked_at", "created_at", "updated_at"}
+	cols := []string{"id", "user_id", "token_hash", "expires_at", "revoked_at", "created_at", "updated_at", "family_id", "user_agent", "ip_address", "revoke_reason"}
 	rows := sqlmock.NewRows(cols).AddRow(
 		int64(2),
 		int64(10),
@@ -244,22 +250,30 @@ func TestCreateRefreshToken(t *testing.T) {
 		sql.NullTime{Valid: false},
 		now,
 		now,
+		"family_abc",
+		"Mozilla/5.0",
+		"192.0.2.1",
+		sql.NullString{},
 	)
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO refresh_tokens (user_id, token_hash, expires_at, revoked_at, created_at, updated_at)
-VALUES ($1, $2, $3, NULL, NOW(), NOW())
-RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at`)).
-		WithArgs(int64(10), "hash_abc", expiresAt).
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, user_agent, ip_address, revoked_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, NULL, NOW(), NOW())
+RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason`)).
+		WithArgs(int64(10), "hash_abc", expiresAt, "family_abc", "Mozilla/5.0", "192.0.2.1").
 		WillReturnRows(rows)
 
 	got, err := q.CreateRefreshToken(context.Background(), db.CreateRefreshTokenParams{
 		UserID:    10,
 		TokenHash: "hash_abc",
 		ExpiresAt: expiresAt,
+		FamilyID:  "family_abc",
+		UserAgent: "Mozilla/5.0",
+		IpAddress: "192.0.2.1",
 	})
 
 	assert.NoError(t, err)
 	assert.Equal(t, int64(10), got.UserID)
 	assert.Equal(t, "hash_abc", got.TokenHash)
+	assert.Equal(t, "family_abc", got.FamilyID)
 	assert.False(t, got.RevokedAt.Valid)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -276,7 +290,7 @@ func TestGetRefreshTokenByHash(t *testing.T) {
 			hash: "hash_ok",
 			mockSetUp: func(m sqlmock.Sqlmock) {
 				now := time.Now()
-				cols := []string{"id", "user_id", "token_hash", "expires_at", "revoked_at", "created_at", "updated_at"}
+				cols := []string{"id", "user_id", "token_hash", "expires_at", "revoked_at", "created_at", "updated_at", "family_id", "user_agent", "ip_address", "revoke_reason"}
 				rows := sqlmock.NewRows(cols).AddRow(
 					int64(2),
 					int64(20),
@@ -285,8 +299,12 @@ func TestGetRefreshTokenByHash(t *testing.T) {
 					sql.NullTime{Valid: false},
 					now,
 					now,
+					"family_ok",
+					"Mozilla/5.0",
+					"192.0.2.1",
+					sql.NullString{},
 				)
-				m.ExpectQuery(regexp.QuoteMeta(`SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at
+				m.ExpectQuery(regexp.QuoteMeta(`SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason
 FROM refresh_tokens
 WHERE token_hash = $1
 LIMIT 1`)).
@@ -299,7 +317,7 @@ LIMIT 1`)).
 			name: "異常系：対象なし",
 			hash: "not_found",
 			mockSetUp: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(regexp.QuoteMeta(`SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at
+				m.ExpectQuery(regexp.QuoteMeta(`SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason
 FROM refresh_tokens
 WHERE token_hash = $1
 LIMIT 1`)).
@@ -346,6 +364,84 @@ AND revoked_at IS NULL`)).
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestRevokeRefreshTokenFamily(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL`)).
+		WithArgs("family_reused").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	err := q.RevokeRefreshTokenFamily(context.Background(), "family_reused")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListActiveSessionsByUser(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	now := time.Now()
+	cols := []string{"id", "family_id", "user_agent", "ip_address", "signed_in_at", "last_used_at", "expires_at"}
+	rows := sqlmock.NewRows(cols).
+		AddRow(int64(5), "family_b", "iPad", "192.0.2.2", now.Add(-time.Hour), now, now.Add(24*time.Hour)).
+		AddRow(int64(3), "family_a", "Mozilla/5.0", "192.0.2.1", now.Add(-2*time.Hour), now.Add(-time.Hour), now.Add(24*time.Hour))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM refresh_tokens rt
+WHERE rt.user_id = $1
+AND rt.revoked_at IS NULL
+AND rt.expires_at > NOW()
+ORDER BY rt.created_at DESC, rt.id DESC`)).
+		WithArgs(int64(40)).
+		WillReturnRows(rows)
+
+	got, err := q.ListActiveSessionsByUser(context.Background(), 40)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "family_b", got[0].FamilyID)
+		assert.Equal(t, "iPad", got[0].UserAgent)
+		assert.Equal(t, "192.0.2.1", got[1].IpAddress)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeSessionByUser(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`WHERE family_id = (
+    SELECT s.family_id FROM refresh_tokens s
+    WHERE s.id = $1 AND s.user_id = $2
+)
+AND revoked_at IS NULL`)).
+		WithArgs(int64(5), int64(40)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := q.RevokeSessionByUser(context.Background(), db.RevokeSessionByUserParams{ID: 5, UserID: 40})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeOtherSessionsByUser(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL`)).
+		WithArgs(int64(40), "family_a").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err := q.RevokeOtherSessionsByUser(context.Background(), db.RevokeOtherSessionsByUserParams{UserID: 40, FamilyID: "family_a"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestRevokeAllRefreshTokensByUser(t *testing.T) {
 	q, mock, cleanup := setupMock(t)
 	defer cleanup()
@@ -363,3 +459,214 @@ AND revoked_at IS NULL`)).
 	assert.NoError(t, mock.ExpectationsWereMet())
 
 }
+
+func TestRecordLoginFailure(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	windowStart := time.Now().Add(-15 * time.Minute)
+	mock.ExpectQuery(regexp.QuoteMeta(`ON CONFLICT (throttle_key) DO UPDATE`)).
+		WithArgs("account:user@example.com", windowStart).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(int32(3)))
+
+	failures, err := q.RecordLoginFailure(context.Background(), db.RecordLoginFailureParams{
+		ThrottleKey: "account:user@example.com",
+		WindowStart: windowStart,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), failures)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteStaleLoginThrottles(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	staleBefore := time.Now().Add(-15 * time.Minute)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM login_throttles
+WHERE updated_at < $1
+  AND (locked_until IS NULL OR locked_until < NOW())`)).
+		WithArgs(staleBefore).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+
+	deleted, err := q.DeleteStaleLoginThrottles(context.Background(), staleBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLockLoginThrottle(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	until := time.Now().Add(15 * time.Minute)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE login_throttles
+SET
+    failures = 0,
+    window_started_at = NOW(),
+    locked_until = $2,
+    updated_at = NOW()
+WHERE throttle_key = $1`)).
+		WithArgs("ip:192.0.2.1", sql.NullTime{Time: until, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := q.LockLoginThrottle(context.Background(), db.LockLoginThrottleParams{
+		ThrottleKey: "ip:192.0.2.1",
+		LockedUntil: sql.NullTime{Time: until, Valid: true},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkUserEmailVerified(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	cols := []string{"id", "name", "email", "password_hash", "role", "status", "created_at", "updated_at", "reset_token", "reset_token_expires_at", "last_login_at"}
+	rows := sqlmock.NewRows(cols).AddRow(
+		int64(5), "Carol", "carol@example.com", "hash", "member", "active",
+		time.Now(), time.Now(), sql.NullString{Valid: false}, sql.NullTime{Valid: false}, sql.NullTime{Valid: false},
+	)
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE id = $1 AND status = 'unverified'`)).
+		WithArgs(int64(5)).
+		WillReturnRows(rows)
+
+	user, err := q.MarkUserEmailVerified(context.Background(), int64(5))
+	assert.NoError(t, err)
+	assert.Equal(t, "active", user.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertPendingUserTOTP_Enabled(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	// 有効化済みなら更新されず行が返らない
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE user_totp.enabled_at IS NULL`)).
+		WithArgs(int64(1), "SECRET").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled_at", "last_used_step", "created_at", "updated_at"}))
+
+	_, err := q.UpsertPendingUserTOTP(context.Background(), db.UpsertPendingUserTOTPParams{UserID: 1, Secret: "SECRET"})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConsumeTOTPStep(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`AND last_used_step < $2`)).
+		WithArgs(int64(1), int64(58000000)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := q.ConsumeTOTPStep(context.Background(), db.ConsumeTOTPStepParams{UserID: 1, LastUsedStep: 58000000})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`AND code_hash = $2 AND used_at IS NULL`)).
+		WithArgs(int64(1), "hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := q.ConsumeRecoveryCode(context.Background(), db.ConsumeRecoveryCodeParams{UserID: 1, CodeHash: "hash"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	expiresAt := time.Now().Add(90 * 24 * time.Hour)
+	cols := []string{"id", "user_id", "name", "prefix", "key_hash", "scopes", "expires_at", "last_used_at", "revoked_at", "created_at", "updated_at"}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)`)).
+		WithArgs(int64(1), "pos", "sol_abcd1234", "hash", `{"orders:read","orders:write"}`, expiresAt).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(
+			int64(3), int64(1), "pos", "sol_abcd1234", "hash", `{orders:read,orders:write}`,
+			expiresAt, nil, nil, time.Now(), time.Now(),
+		))
+
+	key, err := q.CreateAPIKey(context.Background(), db.CreateAPIKeyParams{
+		UserID:    1,
+		Name:      "pos",
+		Prefix:    "sol_abcd1234",
+		KeyHash:   "hash",
+		Scopes:    []string{"orders:read", "orders:write"},
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders:read", "orders:write"}, key.Scopes)
+	assert.False(t, key.LastUsedAt.Valid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetActiveAPIKeyByHash(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())`)).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "role", "status"}).
+			AddRow(int64(3), int64(1), `{orders:read}`, "staff", "active"))
+
+	key, err := q.GetActiveAPIKeyByHash(context.Background(), "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders:read"}, key.Scopes)
+	assert.Equal(t, "staff", key.Role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetUserIdentity(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	cols := []string{"id", "user_id", "provider", "subject", "email", "created_at", "last_login_at"}
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM user_identities WHERE provider = $1 AND subject = $2`)).
+		WithArgs("google", "sub-1").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(int64(4), int64(1), "google", "sub-1", "user@example.com", time.Now(), nil))
+
+	identity, err := q.GetUserIdentity(context.Background(), db.GetUserIdentityParams{Provider: "google", Subject: "sub-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), identity.UserID)
+	assert.Equal(t, sql.NullString{String: "user@example.com", Valid: true}, identity.Email)
+	assert.False(t, identity.LastLoginAt.Valid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserIdentity(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	cols := []string{"id", "user_id", "provider", "subject", "email", "created_at", "last_login_at"}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)`)).
+		WithArgs(int64(1), "line", "U123", nil).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(int64(5), int64(1), "line", "U123", nil, time.Now(), time.Now()))
+
+	identity, err := q.CreateUserIdentity(context.Background(), db.CreateUserIdentityParams{
+		UserID:   1,
+		Provider: "line",
+		Subject:  "U123",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), identity.ID)
+	assert.False(t, identity.Email.Valid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAllAPIKeysByUser(t *testing.T) {
+	q, mock, cleanup := setupMock(t)
+	defer cleanup()
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`)).
+		WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	assert.NoError(t, q.RevokeAllAPIKeysByUser(context.Background(), 1))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/txn"
+	"sol_coffeesys/backend/pkg/validation"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+var validUserStatuses = map[string]struct{}{
+	auth.UserStatusActive:     {},
+	auth.UserStatusSuspended:  {},
+	auth.UserStatusDisabled:   {},
+	auth.UserStatusPending:    {},
+	auth.UserStatusUnverified: {},
+}
+
+const userKeywordMaxLength = 100
+
+// ユーザー一覧の絞り込み条件。空文字は条件なし
+type listUsersFilter struct {
+	Keyword string
+	Role    string
+	Status  string
+	Cursor  *userCursor
+	Limit   int32
+}
+
+// 直前ページ末尾のユーザーID。一覧は ID 昇順で固定
+type userCursor struct {
+	ID int64 `json:"id"`
+}
+
+type userPage struct {
+	Users      []db.SearchUsersRow
+	NextCursor *userCursor
+	// 件数の集計は重いので最初のページ(cursor なし)でだけ返す
+	Total *int64
+}
+
+func listUsersLogic(ctx context.Context, q db.Querier, filter listUsersFilter) (*userPage, error) {
+	var count db.CountUsersParams
+	if filter.Role != "" {
+		count.Role = sql.NullString{String: filter.Role, Valid: true}
+	}
+	if filter.Status != "" {
+		count.Status = sql.NullString{String: filter.Status, Valid: true}
+	}
+	if filter.Keyword != "" {
+		count.Keyword = sql.NullString{String: escapeLike(filter.Keyword), Valid: true}
+	}
+
+	params := db.SearchUsersParams{
+		Role:    count.Role,
+		Status:  count.Status,
+		Keyword: count.Keyword,
+		// 次ページの有無を判定するため1件多く取得する
+		PageLimit: filter.Limit + 1,
+	}
+	if filter.Cursor != nil {
+		params.CursorID = sql.NullInt64{Int64: filter.Cursor.ID, Valid: true}
+	}
+
+	users, err := q.SearchUsers(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	page := &userPage{Users: users}
+	if filter.Cursor == nil {
+		total, err := q.CountUsers(ctx, count)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	if len(users) > int(filter.Limit) {
+		page.Users = users[:filter.Limit]
+		page.NextCursor = &userCursor{ID: page.Users[len(page.Users)-1].ID}
+	}
+	return page, nil
+}
+
+func parseListUsersFilter(c *gin.Context) (listUsersFilter, error) {
+	var filter listUsersFilter
+
+	limit, err := parsePageLimit(c.Query("limit"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = limit
+
+	filter.Keyword = strings.TrimSpace(c.Query("q"))
+	if utf8.RuneCountInString(filter.Keyword) > userKeywordMaxLength {
+		return filter, apperror.NewValidationError("q", nil, "max_length", "")
+	}
+
+	if raw := c.Query("role"); raw != "" {
+		if err := validation.ValidateRole(raw); err != nil {
+			return filter, apperror.NewValidationError("role", raw, "", "")
+		}
+		filter.Role = raw
+	}
+	if raw := c.Query("status"); raw != "" {
+		if _, ok := validUserStatuses[raw]; !ok {
+			return filter, apperror.NewValidationError("status", raw, "", "")
+		}
+		filter.Status = raw
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		var cur userCursor
+		if err := decodeCursor(raw, &cur); err != nil {
+			return filter, err
+		}
+		filter.Cursor = &cur
+	}
+	return filter, nil
+}
+
+// ＋＋ユーザー一覧取得機能（管理者）＋＋
+func ListUsersHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := parseListUsersFilter(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		page, err := listUsersLogic(c.Request.Context(), q, filter)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("ListUsers", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		var nextCursor *string
+		if page.NextCursor != nil {
+			encoded, err := encodeCursor(page.NextCursor)
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("EncodeCursor", err, apperror.InternalServerMessageCommon))
+				return
+			}
+			nextCursor = &encoded
+		}
+
+		resp := make([]AdminUserResponse, 0, len(page.Users))
+		for _, u := range page.Users {
+			resp = append(resp, adminUserFromRow(u))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"users":       resp,
+			"next_cursor": nextCursor,
+			"total":       page.Total,
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "admin_users_listed",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
+
+// ＋＋ユーザー詳細取得機能（管理者）＋＋
+func GetUserDetailHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError("id", nil, "", ""))
+			return
+		}
+
+		ctx := c.Request.Context()
+		user, err := q.GetUserByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewNotFoundError("user", userID, ""))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		orderCount, err := q.GetOrderCountByUser(ctx, userID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GetOrderCountByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		sessionCount, err := q.CountActiveRefreshTokensByUser(ctx, userID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("CountActiveRefreshTokensByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user": AdminUserDetailResponse{
+				AdminUserResponse:  adminUserFromUser(user),
+				OrderCount:         orderCount,
+				ActiveSessionCount: sessionCount,
+			},
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "admin_user_viewed",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.Int64("target_user_id", userID)},
+		})
+	}
+}
+
+// 管理者によるアカウント状態の遷移表。disabled はここでは扱わない終端状態。
+var userStatusTransitions = map[string][]string{
+	auth.UserStatusActive:     {auth.UserStatusSuspended},
+	auth.UserStatusPending:    {auth.UserStatusSuspended},
+	auth.UserStatusUnverified: {auth.UserStatusSuspended},
+	auth.UserStatusSuspended:  {auth.UserStatusActive},
+	auth.UserStatusDisabled:   {},
+}
+
+// changeUserStatusLogic は対象ユーザーの状態を next に変更する。
+// 停止時はリフレッシュトークンを全て失効させ、発行済みのセッションを延長できないようにする。
+// 再開(suspended→active)では停止する直前の状態に戻す。
+func changeUserStatusLogic(ctx context.Context, qtx db.Querier, userID int64, next string) (db.User, error) {
+	user, err := qtx.GetUserForUpdate(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.User{}, apperror.NewNotFoundError("user", userID, "")
+		}
+		return db.User{}, err
+	}
+
+	if !slices.Contains(userStatusTransitions[user.Status], next) {
+		return db.User{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageUserStatus)
+	}
+
+	status := next
+	switch {
+	case next == auth.UserStatusSuspended:
+		if err := qtx.SaveUserSuspension(ctx, db.SaveUserSuspensionParams{
+			UserID:         userID,
+			PreviousStatus: user.Status,
+		}); err != nil {
+			return db.User{}, err
+		}
+	case user.Status == auth.UserStatusSuspended:
+		status, err = statusBeforeSuspension(ctx, qtx, userID)
+		if err != nil {
+			return db.User{}, err
+		}
+	}
+
+	updated, err := qtx.UpdateUserStatus(ctx, db.UpdateUserStatusParams{
+		Status: status,
+		ID:     userID,
+	})
+	if err != nil {
+		return db.User{}, err
+	}
+
+	if next == auth.UserStatusSuspended {
+		if err := qtx.RevokeAllRefreshTokensByUser(ctx, userID); err != nil {
+			return db.User{}, err
+		}
+	}
+	return updated, nil
+}
+
+// statusBeforeSuspension は停止する直前の状態を取り出す。
+// 記録が無い(記録を始める前に停止された)場合は、メールアドレスを確認済みとみなさず unverified に戻す
+func statusBeforeSuspension(ctx context.Context, qtx db.Querier, userID int64) (string, error) {
+	previous, err := qtx.DeleteUserSuspension(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.UserStatusUnverified, nil
+		}
+		return "", err
+	}
+	return previous, nil
+}
+
+func userStatusHandler(runner txn.Runner, next, op, event string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError("id", nil, "", ""))
+			return
+		}
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if principal.UserID == userID {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageSuspendSelf))
+			return
+		}
+
+		var user db.User
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			var err error
+			user, err = changeUserStatusLogic(c.Request.Context(), qtx, userID, next)
+			return err
+		})
+		if err != nil {
+			_ = c.Error(txError(op, err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user": adminUserFromUser(user)})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  event,
+			Status: http.StatusOK,
+			Level:  slog.LevelWarn,
+			Extra: []slog.Attr{
+				slog.Int64("target_user_id", userID),
+				slog.String("user_status", user.Status),
+			},
+		})
+	}
+}
+
+// ＋＋アカウント停止・再開機能（管理者）＋＋
+func SuspendUserHandler(runner txn.Runner) gin.HandlerFunc {
+	return userStatusHandler(runner, auth.UserStatusSuspended, "SuspendUser", "admin_user_suspended")
+}
+
+func ReactivateUserHandler(runner txn.Runner) gin.HandlerFunc {
+	return userStatusHandler(runner, auth.UserStatusActive, "ReactivateUser", "admin_user_reactivated")
+}
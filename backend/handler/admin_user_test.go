@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUserStatusHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const adminID = int64(1)
+
+	tests := []struct {
+		name           string
+		path           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		expectedUser   string
+	}{
+		{
+			name: "U1: 有効なユーザーを停止しリフレッシュトークンを全て失効",
+			path: "/api/admin/users/2/suspend",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusActive}, nil)
+				m.On("SaveUserSuspension", mock.Anything, db.SaveUserSuspensionParams{UserID: 2, PreviousStatus: auth.UserStatusActive}).Return(nil)
+				m.On("UpdateUserStatus", mock.Anything, db.UpdateUserStatusParams{Status: auth.UserStatusSuspended, ID: 2}).
+					Return(db.User{ID: 2, Status: auth.UserStatusSuspended, PasswordHash: "secret"}, nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(2)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedUser:   auth.UserStatusSuspended,
+		},
+		{
+			name: "U2: 停止中のユーザーを再開(トークンは失効させない)",
+			path: "/api/admin/users/2/reactivate",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusSuspended}, nil)
+				m.On("DeleteUserSuspension", mock.Anything, int64(2)).Return(auth.UserStatusActive, nil)
+				m.On("UpdateUserStatus", mock.Anything, db.UpdateUserStatusParams{Status: auth.UserStatusActive, ID: 2}).
+					Return(db.User{ID: 2, Status: auth.UserStatusActive}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedUser:   auth.UserStatusActive,
+		},
+		{
+			name: "U2-2: メールアドレス未確認のまま停止されたユーザーは unverified に戻す",
+			path: "/api/admin/users/2/reactivate",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusSuspended}, nil)
+				m.On("DeleteUserSuspension", mock.Anything, int64(2)).Return(auth.UserStatusUnverified, nil)
+				m.On("UpdateUserStatus", mock.Anything, db.UpdateUserStatusParams{Status: auth.UserStatusUnverified, ID: 2}).
+					Return(db.User{ID: 2, Status: auth.UserStatusUnverified}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedUser:   auth.UserStatusUnverified,
+		},
+		{
+			name: "U2-3: 停止前の状態の記録が無ければ unverified に戻す",
+			path: "/api/admin/users/2/reactivate",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusSuspended}, nil)
+				m.On("DeleteUserSuspension", mock.Anything, int64(2)).Return("", sql.ErrNoRows)
+				m.On("UpdateUserStatus", mock.Anything, db.UpdateUserStatusParams{Status: auth.UserStatusUnverified, ID: 2}).
+					Return(db.User{ID: 2, Status: auth.UserStatusUnverified}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedUser:   auth.UserStatusUnverified,
+		},
+		{
+			name:           "U3: 自分自身は停止できない",
+			path:           "/api/admin/users/1/suspend",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageSuspendSelf,
+		},
+		{
+			name: "U4: 停止中のユーザーを再度停止",
+			path: "/api/admin/users/2/suspend",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusSuspended}, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageUserStatus,
+		},
+		{
+			name: "U5: 無効化されたユーザーは再開できない",
+			path: "/api/admin/users/2/reactivate",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusDisabled}, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageUserStatus,
+		},
+		{
+			name: "U6: 対象ユーザーが存在しない",
+			path: "/api/admin/users/99/suspend",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(99)).Return(db.User{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedErrMsg: apperror.NotFoundMessageUser,
+		},
+		{
+			name:           "U7: IDが不正",
+			path:           "/api/admin/users/abc/suspend",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageID,
+		},
+		{
+			name: "U8: 失効処理のDBエラー",
+			path: "/api/admin/users/2/suspend",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Status: auth.UserStatusActive}, nil)
+				m.On("SaveUserSuspension", mock.Anything, mock.Anything).Return(nil)
+				m.On("UpdateUserStatus", mock.Anything, mock.Anything).Return(db.User{ID: 2, Status: auth.UserStatusSuspended}, nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(2)).Return(errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			runner := testutil.TxRunner{Querier: mockDB}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.Use(func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: adminID})
+				c.Next()
+			})
+			router.POST("/api/admin/users/:id/suspend", SuspendUserHandler(runner))
+			router.POST("/api/admin/users/:id/reactivate", ReactivateUserHandler(runner))
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			if tt.expectedUser != "" {
+				var body map[string]map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedUser, body["user"]["status"])
+				assert.NotContains(t, body["user"], "password_hash")
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListUsersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	row := func(id int64) db.SearchUsersRow {
+		return db.SearchUsersRow{ID: id, Name: "user", Email: "u@example.com", Role: "member", Status: auth.UserStatusActive, CreatedAt: now, UpdatedAt: now}
+	}
+	nextCursor, err := encodeCursor(userCursor{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		expectedCount  int
+		expectedNext   any
+		expectedTotal  any
+	}{
+		{
+			name:  "U1: 絞り込みなし",
+			query: "",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchUsers", mock.Anything, db.SearchUsersParams{PageLimit: defaultPageLimit + 1}).
+					Return([]db.SearchUsersRow{row(1), row(2)}, nil)
+				m.On("CountUsers", mock.Anything, db.CountUsersParams{}).Return(int64(2), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedNext:   nil,
+			expectedTotal:  float64(2),
+		},
+		{
+			name:  "U2: キーワード・ロール・ステータスで絞り込み、次ページあり",
+			query: "?q=100%25_off&role=member&status=active&limit=2",
+			setupMock: func(m *testutil.MockDB) {
+				want := db.CountUsersParams{
+					Role:    sql.NullString{String: "member", Valid: true},
+					Status:  sql.NullString{String: auth.UserStatusActive, Valid: true},
+					Keyword: sql.NullString{String: `100\%\_off`, Valid: true},
+				}
+				m.On("SearchUsers", mock.Anything, db.SearchUsersParams{
+					Role: want.Role, Status: want.Status, Keyword: want.Keyword, PageLimit: 3,
+				}).Return([]db.SearchUsersRow{row(1), row(2), row(3)}, nil)
+				m.On("CountUsers", mock.Anything, want).Return(int64(5), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedNext:   nextCursor,
+			expectedTotal:  float64(5),
+		},
+		{
+			// 件数は最初のページでだけ数える
+			name:  "U3: cursor 指定",
+			query: "?cursor=" + nextCursor,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchUsers", mock.Anything, db.SearchUsersParams{
+					CursorID:  sql.NullInt64{Int64: 2, Valid: true},
+					PageLimit: defaultPageLimit + 1,
+				}).Return([]db.SearchUsersRow{row(3)}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			expectedNext:   nil,
+		},
+		{
+			name:           "U4: 不正なステータス",
+			query:          "?status=banned",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageStatus,
+		},
+		{
+			name:           "U5: 不正なロール",
+			query:          "?role=owner",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRole,
+		},
+		{
+			name:           "U6: 不正なcursor",
+			query:          "?cursor=!!!",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCursor,
+		},
+		{
+			name:  "U7: DBエラー",
+			query: "",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchUsers", mock.Anything, mock.Anything).Return([]db.SearchUsersRow{}, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/api/admin/users", ListUsersHandler(mockDB))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/users"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				users := body["users"].([]any)
+				assert.Len(t, users, tt.expectedCount)
+				assert.Equal(t, tt.expectedNext, body["next_cursor"])
+				assert.Equal(t, tt.expectedTotal, body["total"])
+				for _, u := range users {
+					assert.NotContains(t, u, "password_hash")
+					assert.NotContains(t, u, "reset_token")
+				}
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUserDetailHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lastLogin := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		path           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "U1: 注文数・有効なセッション数・最終ログイン日時を返す",
+			path: "/api/admin/users/2",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{
+					ID: 2, Name: "bob", Email: "bob@example.com", Role: "member", Status: auth.UserStatusActive,
+					PasswordHash: "secret_hash",
+					ResetToken:   sql.NullString{String: "secret_token", Valid: true},
+					LastLoginAt:  sql.NullTime{Time: lastLogin, Valid: true},
+				}, nil)
+				m.On("GetOrderCountByUser", mock.Anything, int64(2)).Return(int64(3), nil)
+				m.On("CountActiveRefreshTokensByUser", mock.Anything, int64(2)).Return(int64(2), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "U2: ユーザーが存在しない",
+			path: "/api/admin/users/99",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(99)).Return(db.User{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedErrMsg: apperror.NotFoundMessageUser,
+		},
+		{
+			name:           "U3: IDが不正",
+			path:           "/api/admin/users/abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageID,
+		},
+		{
+			name: "U4: 注文数取得のDBエラー",
+			path: "/api/admin/users/2",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{ID: 2}, nil)
+				m.On("GetOrderCountByUser", mock.Anything, int64(2)).Return(int64(0), errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/api/admin/users/:id", GetUserDetailHandler(mockDB))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				var body map[string]map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				user := body["user"]
+				assert.Equal(t, float64(3), user["order_count"])
+				assert.Equal(t, float64(2), user["active_session_count"])
+				assert.Equal(t, lastLogin.Format(time.RFC3339), user["last_login_at"])
+				assert.NotContains(t, w.Body.String(), "secret_hash")
+				assert.NotContains(t, w.Body.String(), "secret_token")
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
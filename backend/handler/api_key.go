@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyNameMaxLength     = 100
+	apiKeyDefaultExpiryDays = 90
+	apiKeyMaxExpiryDays     = 365
+)
+
+// CreateAPIKeyRequest の expires_in_days を省略すると90日
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays *int     `json:"expires_in_days"`
+}
+
+// APIKeyResponse はキー1件分。key_hash は返さず、平文は発行時のレスポンスにだけ含める
+type APIKeyResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func toAPIKeyResponse(k db.ApiKey) APIKeyResponse {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     scopes,
+		ExpiresAt:  nullTimePtr(k.ExpiresAt),
+		LastUsedAt: nullTimePtr(k.LastUsedAt),
+		RevokedAt:  nullTimePtr(k.RevokedAt),
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+// apiKeyOwner はキーの持ち主を決める。
+// 本人用のルートは Principal、管理者用のルートはパスの :id を使う
+type apiKeyOwner func(c *gin.Context, q db.Querier) (apiKeyHolder, bool)
+
+// apiKeyHolder はキーの持ち主。Role は管理者への発行を断るのに使う
+type apiKeyHolder struct {
+	UserID int64
+	Role   string
+}
+
+func ownerFromPrincipal(c *gin.Context, _ db.Querier) (apiKeyHolder, bool) {
+	principal, err := auth.PrincipalFrom(c)
+	if err != nil {
+		_ = c.Error(err)
+		return apiKeyHolder{}, false
+	}
+	return apiKeyHolder{UserID: principal.UserID, Role: principal.Role}, true
+}
+
+func ownerFromUserParam(c *gin.Context, q db.Querier) (apiKeyHolder, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		_ = c.Error(apperror.NewValidationError("id", nil, "", ""))
+		return apiKeyHolder{}, false
+	}
+	user, err := q.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = c.Error(apperror.NewNotFoundError("user", userID, ""))
+			return apiKeyHolder{}, false
+		}
+		_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
+		return apiKeyHolder{}, false
+	}
+	return apiKeyHolder{UserID: user.ID, Role: user.Role}, true
+}
+
+// refuseAdminHolder は管理者アカウントへの発行を断る。
+// 管理者権限のキーは TOTP も通らずに管理者ルートを呼べてしまうため、本人用・管理者用のどちらのルートでも作らせない
+func refuseAdminHolder(owner apiKeyOwner) apiKeyOwner {
+	return func(c *gin.Context, q db.Querier) (apiKeyHolder, bool) {
+		holder, ok := owner(c, q)
+		if !ok {
+			return apiKeyHolder{}, false
+		}
+		if holder.Role == auth.RoleAdmin {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageAPIKeyAdmin))
+			return apiKeyHolder{}, false
+		}
+		return holder, true
+	}
+}
+
+// validateCreateAPIKeyRequest は名前・スコープ・有効期限を検証し、正規化した値を返す
+func validateCreateAPIKeyRequest(req CreateAPIKeyRequest) (string, []string, time.Time, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", nil, time.Time{}, apperror.NewValidationError("name", nil, "required", apperror.ValidationMessageName)
+	}
+	if utf8.RuneCountInString(name) > apiKeyNameMaxLength {
+		return "", nil, time.Time{}, apperror.NewValidationError("name", nil, "max", apperror.ValidationMessageNameLength)
+	}
+
+	if len(req.Scopes) == 0 {
+		return "", nil, time.Time{}, apperror.NewValidationError("scopes", nil, "required", apperror.ValidationMessageAPIKeyScope)
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, s := range req.Scopes {
+		if !auth.ValidScope(s) {
+			return "", nil, time.Time{}, apperror.NewValidationError("scopes", s, "oneof", apperror.ValidationMessageAPIKeyScope)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	days := apiKeyDefaultExpiryDays
+	if req.ExpiresInDays != nil {
+		days = *req.ExpiresInDays
+	}
+	if days < 1 || days > apiKeyMaxExpiryDays {
+		return "", nil, time.Time{}, apperror.NewValidationError("expires_in_days", days, "range", apperror.ValidationMessageAPIKeyExpiry)
+	}
+	return name, scopes, time.Now().AddDate(0, 0, days), nil
+}
+
+// ＋＋APIキー発行機能＋＋
+// 平文のキーはこのレスポンスでしか返さない。呼び出し時のロールの確認は持ち主のロールで行う
+func createAPIKeyHandler(q db.Querier, owner apiKeyOwner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+		name, scopes, expiresAt, err := validateCreateAPIKeyRequest(req)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		holder, ok := owner(c, q)
+		if !ok {
+			return
+		}
+		userID := holder.UserID
+
+		raw, prefix, hash, err := auth.GenerateAPIKey()
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GenerateAPIKey", err, apperror.InternalServerMessageGenToken))
+			return
+		}
+		key, err := q.CreateAPIKey(c.Request.Context(), db.CreateAPIKeyParams{
+			UserID:    userID,
+			Name:      name,
+			Prefix:    prefix,
+			KeyHash:   hash,
+			Scopes:    scopes,
+			ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+		})
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("CreateAPIKey", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, gin.H{"api_key": toAPIKeyResponse(key), "key": raw})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_api_key_created",
+			Status: http.StatusCreated,
+			Level:  slog.LevelWarn,
+			Extra: []slog.Attr{
+				slog.Int64("target_user_id", userID),
+				slog.Int64("api_key_id", key.ID),
+				slog.String("scopes", strings.Join(scopes, ",")),
+			},
+		})
+	}
+}
+
+// ＋＋APIキー一覧取得機能＋＋
+// 失効済み・期限切れのキーも含めて新しい順に返す
+func listAPIKeysHandler(q db.Querier, owner apiKeyOwner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		holder, ok := owner(c, q)
+		if !ok {
+			return
+		}
+		userID := holder.UserID
+
+		keys, err := q.ListAPIKeysByUser(c.Request.Context(), userID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("ListAPIKeysByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		resp := make([]APIKeyResponse, 0, len(keys))
+		for _, k := range keys {
+			resp = append(resp, toAPIKeyResponse(k))
+		}
+		c.JSON(http.StatusOK, gin.H{"api_keys": resp})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_api_keys_listed",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.Int64("target_user_id", userID)},
+		})
+	}
+}
+
+// ＋＋APIキー失効機能＋＋
+func revokeAPIKeyHandler(q db.Querier, owner apiKeyOwner, keyParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := strconv.ParseInt(c.Param(keyParam), 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError(keyParam, nil, "", ""))
+			return
+		}
+		holder, ok := owner(c, q)
+		if !ok {
+			return
+		}
+		userID := holder.UserID
+
+		revoked, err := q.RevokeAPIKeyByUser(c.Request.Context(), db.RevokeAPIKeyByUserParams{
+			ID:     keyID,
+			UserID: userID,
+		})
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("RevokeAPIKeyByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// 他人のキー・失効済みのキーは区別せず 404
+		if revoked == 0 {
+			_ = c.Error(apperror.NewNotFoundError("api_key", keyID, ""))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_api_key_revoked",
+			Status: http.StatusNoContent,
+			Level:  slog.LevelWarn,
+			Extra: []slog.Attr{
+				slog.Int64("target_user_id", userID),
+				slog.Int64("api_key_id", keyID),
+			},
+		})
+	}
+}
+
+func CreateMyAPIKeyHandler(q db.Querier) gin.HandlerFunc {
+	return createAPIKeyHandler(q, refuseAdminHolder(ownerFromPrincipal))
+}
+
+func ListMyAPIKeysHandler(q db.Querier) gin.HandlerFunc {
+	return listAPIKeysHandler(q, ownerFromPrincipal)
+}
+
+func RevokeMyAPIKeyHandler(q db.Querier) gin.HandlerFunc {
+	return revokeAPIKeyHandler(q, ownerFromPrincipal, "id")
+}
+
+// 管理者が POS 端末などの連携用アカウントにキーを発行・失効させる
+func CreateUserAPIKeyHandler(q db.Querier) gin.HandlerFunc {
+	return createAPIKeyHandler(q, refuseAdminHolder(ownerFromUserParam))
+}
+
+func ListUserAPIKeysHandler(q db.Querier) gin.HandlerFunc {
+	return listAPIKeysHandler(q, ownerFromUserParam)
+}
+
+func RevokeUserAPIKeyHandler(q db.Querier) gin.HandlerFunc {
+	return revokeAPIKeyHandler(q, ownerFromUserParam, "keyId")
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateMyAPIKeyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		role           string
+		body           map[string]any
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		expectedDays   int
+	}{
+		{
+			name: "正常系：期限を省略すると90日",
+			body: map[string]any{"name": " POS連携 ", "scopes": []string{auth.ScopeOrdersRead, auth.ScopeOrdersWrite, auth.ScopeOrdersRead}},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(p db.CreateAPIKeyParams) bool {
+					return p.UserID == 1 && p.Name == "POS連携" && len(p.Scopes) == 2
+				})).Return(db.ApiKey{ID: 5, UserID: 1, Name: "POS連携", Scopes: []string{auth.ScopeOrdersRead, auth.ScopeOrdersWrite}}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedDays:   90,
+		},
+		{
+			name: "正常系：期限を指定",
+			role: auth.RoleStaff,
+			body: map[string]any{"name": "nightly", "scopes": []string{auth.ScopeOrdersWrite}, "expires_in_days": 7},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateAPIKey", mock.Anything, mock.Anything).Return(db.ApiKey{ID: 6, UserID: 1}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedDays:   7,
+		},
+		{
+			name:           "異常系：未知のスコープ",
+			body:           map[string]any{"name": "nightly", "scopes": []string{"users:write"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageAPIKeyScope,
+		},
+		{
+			name:           "異常系：管理者ルート向けだった旧スコープ",
+			body:           map[string]any{"name": "nightly", "scopes": []string{"products:write"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageAPIKeyScope,
+		},
+		{
+			name:           "異常系：管理者は自分にも発行できない",
+			role:           auth.RoleAdmin,
+			body:           map[string]any{"name": "nightly", "scopes": []string{auth.ScopeOrdersRead}},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageAPIKeyAdmin,
+		},
+		{
+			name:           "異常系：スコープが空",
+			body:           map[string]any{"name": "nightly", "scopes": []string{}},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageAPIKeyScope,
+		},
+		{
+			name:           "異常系：期限が長すぎる",
+			body:           map[string]any{"name": "nightly", "scopes": []string{auth.ScopeOrdersRead}, "expires_in_days": 366},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageAPIKeyExpiry,
+		},
+		{
+			name:           "異常系：名前が空白のみ",
+			body:           map[string]any{"name": "  ", "scopes": []string{auth.ScopeOrdersRead}},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			role := tt.role
+			if role == "" {
+				role = auth.RoleMember
+			}
+			router.POST("/api/me/api-keys", withPrincipal(auth.Principal{UserID: 1, Role: role}), CreateMyAPIKeyHandler(mockDB))
+
+			w := postJSON(router, "/api/me/api-keys", tt.body)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+				mockDB.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+				return
+			}
+
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			raw := body["key"].(string)
+			assert.NotContains(t, body["api_key"], "key_hash")
+
+			// 保存するのは返した平文のハッシュと先頭部分だけ
+			params := mockDB.Calls[0].Arguments.Get(1).(db.CreateAPIKeyParams)
+			assert.Equal(t, auth.HashAPIKey(raw), params.KeyHash)
+			assert.Equal(t, raw[:len(params.Prefix)], params.Prefix)
+			assert.NotEqual(t, raw, params.Prefix)
+			want := time.Now().AddDate(0, 0, tt.expectedDays)
+			assert.WithinDuration(t, want, params.ExpiresAt.Time, time.Minute)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListMyAPIKeysHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	mockDB := new(testutil.MockDB)
+	mockDB.On("ListAPIKeysByUser", mock.Anything, int64(1)).Return([]db.ApiKey{
+		{ID: 2, UserID: 1, Name: "pos", Prefix: "sol_abcd1234", KeyHash: "secret-hash", Scopes: []string{auth.ScopeOrdersRead}, LastUsedAt: sql.NullTime{Time: now, Valid: true}, CreatedAt: now},
+		{ID: 1, UserID: 1, Name: "old", Prefix: "sol_0000ffff", KeyHash: "old-hash", RevokedAt: sql.NullTime{Time: now, Valid: true}, CreatedAt: now},
+	}, nil)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/api/me/api-keys", withPrincipal(auth.Principal{UserID: 1}), ListMyAPIKeysHandler(mockDB))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/me/api-keys", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "hash")
+
+	var body struct {
+		APIKeys []APIKeyResponse `json:"api_keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.APIKeys, 2)
+	assert.Equal(t, "sol_abcd1234", body.APIKeys[0].Prefix)
+	assert.NotNil(t, body.APIKeys[0].LastUsedAt)
+	assert.Nil(t, body.APIKeys[0].RevokedAt)
+	assert.NotNil(t, body.APIKeys[1].RevokedAt)
+	assert.Equal(t, []string{}, body.APIKeys[1].Scopes)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("正常系：本人のキーを失効", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("RevokeAPIKeyByUser", mock.Anything, db.RevokeAPIKeyByUserParams{ID: 5, UserID: 1}).Return(int64(1), nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.DELETE("/api/me/api-keys/:id", withPrincipal(auth.Principal{UserID: 1}), RevokeMyAPIKeyHandler(mockDB))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/me/api-keys/5", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("異常系：他人のキー・失効済みは404", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("RevokeAPIKeyByUser", mock.Anything, db.RevokeAPIKeyByUserParams{ID: 5, UserID: 1}).Return(int64(0), nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.DELETE("/api/me/api-keys/:id", withPrincipal(auth.Principal{UserID: 1}), RevokeMyAPIKeyHandler(mockDB))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/me/api-keys/5", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), apperror.NotFoundMessageAPIKey)
+	})
+
+	t.Run("正常系：管理者が指定ユーザーのキーを失効", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(7)).Return(db.User{ID: 7}, nil)
+		mockDB.On("RevokeAPIKeyByUser", mock.Anything, db.RevokeAPIKeyByUserParams{ID: 5, UserID: 7}).Return(int64(1), nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.DELETE("/api/admin/users/:id/api-keys/:keyId", withPrincipal(auth.Principal{UserID: 1, Role: auth.RoleAdmin}), RevokeUserAPIKeyHandler(mockDB))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/admin/users/7/api-keys/5", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestCreateUserAPIKeyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("正常系：指定ユーザーに発行", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(7)).Return(db.User{ID: 7, Role: auth.RoleStaff}, nil)
+		mockDB.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(p db.CreateAPIKeyParams) bool {
+			return p.UserID == 7
+		})).Return(db.ApiKey{ID: 3, UserID: 7}, nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.POST("/api/admin/users/:id/api-keys", withPrincipal(auth.Principal{UserID: 1, Role: auth.RoleAdmin}), CreateUserAPIKeyHandler(mockDB))
+
+		w := postJSON(router, "/api/admin/users/7/api-keys", map[string]any{"name": "POS 1号機", "scopes": []string{auth.ScopeOrdersWrite}})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("異常系：管理者アカウントには発行しない", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(8)).Return(db.User{ID: 8, Role: auth.RoleAdmin}, nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.POST("/api/admin/users/:id/api-keys", withPrincipal(auth.Principal{UserID: 1, Role: auth.RoleAdmin}), CreateUserAPIKeyHandler(mockDB))
+
+		w := postJSON(router, "/api/admin/users/8/api-keys", map[string]any{"name": "batch", "scopes": []string{auth.ScopeOrdersRead}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), apperror.BusinessLogicMessageAPIKeyAdmin)
+		mockDB.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+	})
+
+	t.Run("異常系：存在しないユーザー", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(99)).Return(db.User{}, sql.ErrNoRows)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.POST("/api/admin/users/:id/api-keys", withPrincipal(auth.Principal{UserID: 1, Role: auth.RoleAdmin}), CreateUserAPIKeyHandler(mockDB))
+
+		w := postJSON(router, "/api/admin/users/99/api-keys", map[string]any{"name": "pos", "scopes": []string{auth.ScopeOrdersWrite}})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), apperror.NotFoundMessageUser)
+		mockDB.AssertNotCalled(t, "CreateAPIKey", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setAuthCookies はアクセストークンとリフレッシュトークンを HttpOnly Cookie にセットする
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string, refreshExpiresAt time.Time) {
+	accessCookie := &http.Cookie{
+		Name:     "access_token",
+		Value:    accessToken,
+		HttpOnly: true,
+		Path:     "/",
+		Expires:  time.Now().Add(15 * time.Minute),
+		MaxAge:   15 * 60,
+		SameSite: http.SameSiteLaxMode,
+	}
+	refreshCookie := &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		HttpOnly: true,
+		Path:     "/api/refresh",
+		Expires:  refreshExpiresAt,
+		MaxAge:   14 * 24 * 60 * 60,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if gin.Mode() == gin.ReleaseMode {
+		accessCookie.Secure = true
+		refreshCookie.Secure = true
+	}
+
+	http.SetCookie(c.Writer, accessCookie)
+	http.SetCookie(c.Writer, refreshCookie)
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log/slog"
 	"net/http"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
@@ -15,26 +16,13 @@ import (
 
 func GetCartHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		raw, exists := c.Get("userID")
-		if !exists {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		var userID int64
-		switch v := raw.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 
-		rows, err := q.ListCartItemsByUser(c.Request.Context(), userID)
+		rows, err := q.ListCartItemsByUser(c.Request.Context(), principal.UserID)
 		if err != nil {
 			_ = c.Error(apperror.NewInternalError("ListCartItemsByUser", err, apperror.InternalServerMessageCommon))
 			return
@@ -72,9 +60,9 @@ type addToCartRequest struct {
 
 func AddToCartHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		uid, ok := c.Get("userID")
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 
@@ -88,19 +76,6 @@ func AddToCartHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		var userID int64
-		switch v := uid.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
 		product, err := q.GetProduct(c.Request.Context(), req.ProductID)
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -111,7 +86,7 @@ func AddToCartHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		cart, err := q.GetOrCreateCartForUser(c.Request.Context(), userID)
+		cart, err := q.GetOrCreateCartForUser(c.Request.Context(), principal.UserID)
 		if err != nil {
 			_ = c.Error(apperror.NewInternalError("GetOrCreateCartForUser", err, apperror.InternalServerMessageCommon))
 			return
@@ -151,9 +126,9 @@ func UpdateCartItemHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		uid, ok := c.Get("userID")
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 		var req updateCartItemRequest
@@ -167,23 +142,10 @@ func UpdateCartItemHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		var userID int64
-		switch v := uid.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
 		item, err := q.UpdateCartItemQtyByUser(c.Request.Context(), db.UpdateCartItemQtyByUserParams{
 			ID:       id,
 			Quantity: req.Quantity,
-			UserID:   userID,
+			UserID:   principal.UserID,
 		})
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -213,21 +175,9 @@ func RemoveCartItemHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		uid, ok := c.Get("userID")
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-		var userID int64
-		switch v := uid.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 
@@ -241,10 +191,10 @@ func RemoveCartItemHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		cart, err := q.GetCartByUser(c.Request.Context(), userID)
+		cart, err := q.GetCartByUser(c.Request.Context(), principal.UserID)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				_ = c.Error(apperror.NewNotFoundError("cart", userID, ""))
+				_ = c.Error(apperror.NewNotFoundError("cart", principal.UserID, ""))
 			} else {
 				_ = c.Error(apperror.NewInternalError("GetCartByUser", err, apperror.InternalServerMessageCommon))
 			}
@@ -258,7 +208,7 @@ func RemoveCartItemHandler(q db.Querier) gin.HandlerFunc {
 
 		if err := q.RemoveCartItemByUser(c.Request.Context(), db.RemoveCartItemByUserParams{
 			ID:     id,
-			UserID: userID,
+			UserID: principal.UserID,
 		}); err != nil {
 			_ = c.Error(apperror.NewInternalError("RemoveCartItemByUser", err, apperror.InternalServerMessageCommon))
 			return
@@ -277,25 +227,13 @@ func RemoveCartItemHandler(q db.Querier) gin.HandlerFunc {
 
 func ClearCartHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		uid, ok := c.Get("userID")
-		if !ok {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-		var userID int64
-		switch v := uid.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 
-		if err := q.ClearCartByUser(c.Request.Context(), userID); err != nil {
+		if err := q.ClearCartByUser(c.Request.Context(), principal.UserID); err != nil {
 			_ = c.Error(apperror.NewInternalError("ClearCartByUser", err, apperror.InternalServerMessageCommon))
 			return
 		}
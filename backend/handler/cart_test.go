@@ -7,6 +7,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
 	testutil "sol_coffeesys/backend/handler/testutil"
@@ -25,7 +26,7 @@ func TestGetCartHandler(t *testing.T) {
 
 	tests := []struct {
 		name            string
-		userID          interface{}
+		userID          int64
 		setupMock       func(*testutil.MockDB)
 		expectedStatus  int
 		expectedItemLen int
@@ -72,85 +73,6 @@ func TestGetCartHandler(t *testing.T) {
 			setupMock:      nil,
 			expectedStatus: http.StatusUnauthorized,
 		},
-		{
-			name:            "invalid type userID",
-			setupMock:       nil,
-			userID:          "not-an-id",
-			expectedStatus:  http.StatusUnauthorized,
-			expectedItemLen: 0,
-		},
-		{
-			name: "userID as Int",
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("ListCartItemsByUser", mock.Anything, int64(45)).Return(
-					[]db.ListCartItemsByUserRow{
-						{
-							ID:           1,
-							CartID:       10,
-							ProductID:    100,
-							Quantity:     2,
-							Price:        1500,
-							CreatedAt:    now,
-							UpdatedAt:    now,
-							ProductName:  "Coffee",
-							ProductPrice: 750,
-							ProductStock: 50,
-						},
-						{
-							ID:           2,
-							CartID:       10,
-							ProductID:    101,
-							Quantity:     1,
-							Price:        2000,
-							CreatedAt:    now,
-							UpdatedAt:    now,
-							ProductName:  "Tea",
-							ProductPrice: 2000,
-							ProductStock: 30,
-						},
-					}, nil)
-			},
-			userID:          int(45),
-			expectedStatus:  http.StatusOK,
-			expectedItemLen: 2,
-		},
-		{
-			name: "userID as float64",
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("ListCartItemsByUser", mock.Anything, int64(46)).Return(
-					[]db.ListCartItemsByUserRow{
-						{
-							ID:           1,
-							CartID:       10,
-							ProductID:    100,
-							Quantity:     2,
-							Price:        1500,
-							CreatedAt:    now,
-							UpdatedAt:    now,
-							ProductName:  "Coffee",
-							ProductPrice: 750,
-							ProductStock: 50,
-						},
-						{
-							ID:           2,
-							CartID:       10,
-							ProductID:    101,
-							Quantity:     1,
-							Price:        2000,
-							CreatedAt:    now,
-							UpdatedAt:    now,
-							ProductName:  "Tea",
-							ProductPrice: 2000,
-							ProductStock: 30,
-						},
-					}, nil)
-			},
-			userID:          float64(46),
-			expectedStatus:  http.StatusOK,
-			expectedItemLen: 2,
-		},
 		{
 			name: "empty cart",
 			setupMock: func(m *testutil.MockDB) {
@@ -192,7 +114,7 @@ func TestGetCartHandler(t *testing.T) {
 				})
 			} else {
 				router.GET("/api/cart", func(c *gin.Context) {
-					c.Set("userID", tt.userID)
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 					handler.GetCartHandler(mockDB)(c)
 				})
 			}
@@ -224,7 +146,7 @@ func TestAddToCartHandler(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		userID         interface{}
+		userID         int64
 		body           map[string]interface{}
 		setupMock      func(*testutil.MockDB)
 		expectedStatus int
@@ -288,7 +210,6 @@ func TestAddToCartHandler(t *testing.T) {
 		},
 		{
 			name:           "unauthorized",
-			userID:         nil,
 			body:           map[string]interface{}{"product_id": 100, "quantity": 1},
 			setupMock:      nil,
 			expectedStatus: http.StatusUnauthorized,
@@ -347,72 +268,6 @@ func TestAddToCartHandler(t *testing.T) {
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
-		{
-			name:   "userID as int",
-			userID: int(42),
-			body:   map[string]interface{}{"product_id": 100, "quantity": 2},
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("GetProduct", mock.Anything, int64(100)).Return(
-					db.Product{
-						ID:            100,
-						Name:          "Coffee",
-						Price:         750,
-						StockQuantity: 50,
-						CreatedAt:     now,
-						UpdatedAt:     now,
-					}, nil)
-				m.On("GetOrCreateCartForUser", mock.Anything, int64(42)).Return(
-					db.Cart{
-						ID:     10,
-						UserID: 42,
-					}, nil)
-				m.On("AddCartItem", mock.Anything, mock.Anything).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  2,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-			},
-			expectedStatus: http.StatusCreated,
-		},
-		{
-			name:   "userID as float",
-			userID: float64(42),
-			body:   map[string]interface{}{"product_id": 100, "quantity": 2},
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("GetProduct", mock.Anything, int64(100)).Return(
-					db.Product{
-						ID:            100,
-						Name:          "Coffee",
-						Price:         750,
-						StockQuantity: 50,
-						CreatedAt:     now,
-						UpdatedAt:     now,
-					}, nil)
-				m.On("GetOrCreateCartForUser", mock.Anything, int64(42)).Return(
-					db.Cart{
-						ID:     10,
-						UserID: 42,
-					}, nil)
-				m.On("AddCartItem", mock.Anything, mock.Anything).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  2,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-			},
-			expectedStatus: http.StatusCreated,
-		},
 		{
 			name:           "missing userID",
 			body:           map[string]interface{}{"product_id": 100, "quantity": 2},
@@ -421,14 +276,6 @@ func TestAddToCartHandler(t *testing.T) {
 		},
 		{
 			name:           "unauthorized",
-			userID:         nil,
-			body:           map[string]interface{}{"product_id": 100, "quantity": 2},
-			setupMock:      nil,
-			expectedStatus: http.StatusUnauthorized,
-		},
-		{
-			name:           "invalid type userID",
-			userID:         "not-an-id",
 			body:           map[string]interface{}{"product_id": 100, "quantity": 2},
 			setupMock:      nil,
 			expectedStatus: http.StatusUnauthorized,
@@ -452,8 +299,8 @@ func TestAddToCartHandler(t *testing.T) {
 			}
 
 			router.POST("/api/cart/items", func(c *gin.Context) {
-				if tt.userID != nil {
-					c.Set("userID", tt.userID)
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				}
 				handler.AddToCartHandler(mockDB)(c)
 			})
@@ -481,7 +328,7 @@ func TestUpdateCartItemHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		itemID         string
-		userID         interface{}
+		userID         int64
 		body           map[string]interface{}
 		setupMock      func(*testutil.MockDB)
 		expectedStatus int
@@ -528,7 +375,6 @@ func TestUpdateCartItemHandler(t *testing.T) {
 		},
 		{
 			name:           "unauthorized",
-			userID:         nil,
 			itemID:         "1",
 			expectedStatus: http.StatusUnauthorized,
 			setupMock:      nil,
@@ -568,68 +414,12 @@ func TestUpdateCartItemHandler(t *testing.T) {
 				}).Return(db.CartItem{}, errors.New("db connection failed"))
 			},
 		},
-		{
-			name:           "userID as int",
-			itemID:         "1",
-			userID:         int(42),
-			expectedStatus: http.StatusOK,
-			body:           map[string]interface{}{"quantity": 5},
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("UpdateCartItemQtyByUser", mock.Anything, db.UpdateCartItemQtyByUserParams{
-					ID:       1,
-					Quantity: 5,
-					UserID:   42,
-				}).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  5,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-			},
-		},
-		{
-			name:           "userID as float",
-			itemID:         "1",
-			userID:         float64(42),
-			expectedStatus: http.StatusOK,
-			body:           map[string]interface{}{"quantity": 5},
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("UpdateCartItemQtyByUser", mock.Anything, db.UpdateCartItemQtyByUserParams{
-					ID:       1,
-					Quantity: 5,
-					UserID:   42,
-				}).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  5,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-			},
-		},
 		{
 			name:           "missing userID",
 			itemID:         "1",
 			expectedStatus: http.StatusUnauthorized,
 			setupMock:      nil,
 		},
-		{
-			name:           "invalid type userID",
-			userID:         "non-an-id",
-			itemID:         "1",
-			body:           map[string]interface{}{"quantity": 1},
-			expectedStatus: http.StatusUnauthorized,
-			setupMock:      nil,
-		},
 		{
 			name:           "invalid JSON type",
 			userID:         int64(50),
@@ -650,8 +440,8 @@ func TestUpdateCartItemHandler(t *testing.T) {
 			}
 
 			router.PUT("/api/cart/items/:id", func(c *gin.Context) {
-				if tt.userID != nil {
-					c.Set("userID", tt.userID)
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				}
 				handler.UpdateCartItemHandler(mockDB)(c)
 			})
@@ -677,7 +467,7 @@ func TestRemoveCartItemHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		itemID         string
-		userID         interface{}
+		userID         int64
 		setupMock      func(*testutil.MockDB)
 		expectedStatus int
 	}{
@@ -770,7 +560,6 @@ func TestRemoveCartItemHandler(t *testing.T) {
 		{
 			name:           "unauthorized(userID nil)",
 			expectedStatus: http.StatusUnauthorized,
-			userID:         nil,
 			itemID:         "1",
 			setupMock:      nil,
 		},
@@ -841,67 +630,6 @@ func TestRemoveCartItemHandler(t *testing.T) {
 				m.On("RemoveCartItemByUser", mock.Anything, db.RemoveCartItemByUserParams{ID: 1, UserID: 42}).Return(errors.New("db access failed"))
 			},
 		},
-		{
-			name:           "userID as int",
-			expectedStatus: http.StatusNoContent,
-			userID:         int(42),
-			itemID:         "1",
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("GetCartItemByID", mock.Anything, int64(1)).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  5,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-				m.On("GetCartByUser", mock.Anything, int64(42)).Return(
-					db.Cart{
-						ID:        10,
-						UserID:    42,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-				m.On("RemoveCartItemByUser", mock.Anything, db.RemoveCartItemByUserParams{ID: 1, UserID: 42}).Return(nil)
-			},
-		},
-		{
-			name:           "userID as float64",
-			expectedStatus: http.StatusNoContent,
-			userID:         float64(42),
-			itemID:         "1",
-			setupMock: func(m *testutil.MockDB) {
-				now := time.Now()
-				m.On("GetCartItemByID", mock.Anything, int64(1)).Return(
-					db.CartItem{
-						ID:        1,
-						CartID:    10,
-						ProductID: 100,
-						Quantity:  5,
-						Price:     1500,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-				m.On("GetCartByUser", mock.Anything, int64(42)).Return(
-					db.Cart{
-						ID:        10,
-						UserID:    42,
-						CreatedAt: now,
-						UpdatedAt: now,
-					}, nil)
-				m.On("RemoveCartItemByUser", mock.Anything, db.RemoveCartItemByUserParams{ID: 1, UserID: 42}).Return(nil)
-			},
-		},
-		{
-			name:           "invalid type userID",
-			expectedStatus: http.StatusUnauthorized,
-			userID:         "not-an-id",
-			itemID:         "1",
-			setupMock:      nil,
-		},
 	}
 
 	for _, tt := range tests {
@@ -915,8 +643,8 @@ func TestRemoveCartItemHandler(t *testing.T) {
 			}
 
 			router.DELETE("/api/cart/items/:id", func(c *gin.Context) {
-				if tt.userID != nil {
-					c.Set("userID", tt.userID)
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				}
 				handler.RemoveCartItemHandler(mockDB)(c)
 			})
@@ -935,7 +663,7 @@ func TestClearCartHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	tests := []struct {
 		name           string
-		userID         interface{}
+		userID         int64
 		setupMock      func(*testutil.MockDB)
 		expectedStatus int
 	}{
@@ -950,7 +678,6 @@ func TestClearCartHandler(t *testing.T) {
 		{
 			name:           "unauthorized(missing userID)",
 			expectedStatus: http.StatusUnauthorized,
-			userID:         nil,
 			setupMock:      nil,
 		},
 		{
@@ -961,28 +688,6 @@ func TestClearCartHandler(t *testing.T) {
 				m.On("ClearCartByUser", mock.Anything, int64(1)).Return(errors.New("db access error"))
 			},
 		},
-		{
-			name:           "userID as int",
-			expectedStatus: http.StatusNoContent,
-			userID:         int(1),
-			setupMock: func(m *testutil.MockDB) {
-				m.On("ClearCartByUser", mock.Anything, int64(1)).Return(nil)
-			},
-		},
-		{
-			name:           "userID as float",
-			expectedStatus: http.StatusNoContent,
-			userID:         float64(1),
-			setupMock: func(m *testutil.MockDB) {
-				m.On("ClearCartByUser", mock.Anything, int64(1)).Return(nil)
-			},
-		},
-		{
-			name:           "invalid type userID",
-			expectedStatus: http.StatusUnauthorized,
-			userID:         "not-an-id",
-			setupMock:      nil,
-		},
 	}
 
 	for _, tt := range tests {
@@ -995,8 +700,8 @@ func TestClearCartHandler(t *testing.T) {
 			}
 
 			router.DELETE("/api/cart", func(c *gin.Context) {
-				if tt.userID != nil {
-					c.Set("userID", tt.userID)
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				}
 				handler.ClearCartHandler(mockDB)(c)
 			})
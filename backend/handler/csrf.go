@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+const csrfTokenBytes = 32
+
+// ＋＋CSRFトークン発行機能＋＋
+// Cookie 認証のクライアントが状態を変えるリクエストの前に呼ぶ。
+// 発行済みのトークンがあればそれを返し、無ければ新しく発行して Cookie にも入れる。
+// クライアントはレスポンスの csrf_token を X-CSRF-Token ヘッダで送り返す
+func CSRFTokenHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cookie, err := c.Request.Cookie(middleware.CSRFCookieName); err == nil && len(cookie.Value) == csrfTokenBytes*2 {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusOK, gin.H{"csrf_token": cookie.Value})
+			return
+		}
+
+		raw := make([]byte, csrfTokenBytes)
+		if _, err := rand.Read(raw); err != nil {
+			_ = c.Error(apperror.NewInternalError("NewCSRFToken", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		token := hex.EncodeToString(raw)
+
+		// JS からは読ませず、レスポンスの値をヘッダに載せてもらう
+		cookie := &http.Cookie{
+			Name:     middleware.CSRFCookieName,
+			Value:    token,
+			HttpOnly: true,
+			Path:     "/",
+			SameSite: http.SameSiteLaxMode,
+		}
+		if gin.Mode() == gin.ReleaseMode {
+			cookie.Secure = true
+		}
+		http.SetCookie(c.Writer, cookie)
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"csrf_token": token})
+	}
+}
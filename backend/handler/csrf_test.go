@@ -0,0 +1,67 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFTokenHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/csrf", handler.CSRFTokenHandler())
+
+	get := func(cookies ...*http.Cookie) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/csrf", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w, body["csrf_token"]
+	}
+
+	t.Run("新しいトークンを発行してCookieにも入れる", func(t *testing.T) {
+		w, token := get()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, token, 64)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var cookie *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == middleware.CSRFCookieName {
+				cookie = c
+			}
+		}
+		require.NotNil(t, cookie)
+		assert.Equal(t, token, cookie.Value)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, "/", cookie.Path)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	})
+
+	t.Run("発行済みのトークンはそのまま返す", func(t *testing.T) {
+		existing := strings.Repeat("a", 64)
+		w, token := get(&http.Cookie{Name: middleware.CSRFCookieName, Value: existing})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, existing, token)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("形式の違うCookieは作り直す", func(t *testing.T) {
+		w, token := get(&http.Cookie{Name: middleware.CSRFCookieName, Value: "short"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, "short", token)
+		assert.Len(t, token, 64)
+	})
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/emailverify"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testVerifyURL = "http://localhost:3000/verify-email"
+
+func newTestEmailVerifier(sender *fakeMailSender, now time.Time) *EmailVerifier {
+	signer := emailverify.NewSigner([]byte("test-secret"), time.Hour).WithClock(func() time.Time { return now })
+	return &EmailVerifier{Signer: signer, Sender: sender, VerifyURL: testVerifyURL}
+}
+
+func TestVerifyEmailHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	issuer := newTestEmailVerifier(&fakeMailSender{}, now)
+	validToken, err := issuer.Signer.Issue(1, "alice@example.com")
+	require.NoError(t, err)
+	expiredToken, err := issuer.Signer.WithClock(func() time.Time { return now.Add(-2 * time.Hour) }).Issue(1, "alice@example.com")
+	require.NoError(t, err)
+
+	unverified := db.User{ID: 1, Name: "Alice", Email: "alice@example.com", Role: auth.RoleMember, Status: auth.UserStatusUnverified}
+	active := unverified
+	active.Status = auth.UserStatusActive
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "正常系：unverified のユーザーを active にする",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(unverified, nil)
+				m.On("MarkUserEmailVerified", mock.Anything, int64(1)).Return(active, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "正常系：確認済みなら何もせず成功を返す",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(active, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "異常系：期限切れのトークン",
+			body:           `{"token":"` + expiredToken + `"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageVerifyExpired,
+		},
+		{
+			name:           "異常系：改ざんされたトークン",
+			body:           `{"token":"` + validToken + `x"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageVerifyToken,
+		},
+		{
+			name:           "異常系：token が無い",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+		{
+			name: "異常系：ユーザーが存在しない",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageVerifyToken,
+		},
+		{
+			name: "異常系：発行後にメールアドレスが変わっている",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				changed := unverified
+				changed.Email = "bob@example.com"
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(changed, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageVerifyToken,
+		},
+		{
+			name: "異常系：停止中のアカウントは有効化しない",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				suspended := unverified
+				suspended.Status = auth.UserStatusSuspended
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(suspended, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageVerifyToken,
+		},
+		{
+			name: "異常系：DBエラー",
+			body: `{"token":"` + validToken + `"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(unverified, nil)
+				m.On("MarkUserEmailVerified", mock.Anything, int64(1)).Return(db.User{}, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/verify-email", VerifyEmailHandler(mockDB, newTestEmailVerifier(&fakeMailSender{}, now)))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/verify-email", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				user := body["user"].(map[string]any)
+				assert.Equal(t, true, user["email_verified"])
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResendVerificationEmailHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	unverified := db.User{ID: 1, Name: "Alice", Email: "alice@example.com", Role: auth.RoleMember, Status: auth.UserStatusUnverified}
+
+	tests := []struct {
+		name           string
+		mailErr        error
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		expectedSent   int
+	}{
+		{
+			name: "正常系：確認メールを送り直す",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(unverified, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedSent:   1,
+		},
+		{
+			name: "異常系：確認済み",
+			setupMock: func(m *testutil.MockDB) {
+				active := unverified
+				active.Status = auth.UserStatusActive
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(active, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageVerified,
+		},
+		{
+			name:    "異常系：メール送信に失敗",
+			mailErr: errors.New("smtp down"),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(unverified, nil)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+			expectedSent:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			tt.setupMock(mockDB)
+			sender := &fakeMailSender{err: tt.mailErr}
+			verifier := newTestEmailVerifier(sender, now)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/verify-email/resend", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1})
+				c.Next()
+			}, ResendVerificationEmailHandler(mockDB, verifier))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/verify-email/resend", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+
+			require.Len(t, sender.sent, tt.expectedSent)
+			if tt.expectedSent > 0 {
+				// メールのリンクに載ったトークンでそのまま確認できる
+				msg := sender.sent[0]
+				assert.Equal(t, "alice@example.com", msg.To)
+				claims, err := verifier.Signer.Verify(extractResetToken(t, msg.Body, testVerifyURL))
+				require.NoError(t, err)
+				assert.Equal(t, int64(1), claims.UserID)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
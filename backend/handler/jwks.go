@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"net/http"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ＋＋JWKS公開機能＋＋
+// 他サービスがアクセストークンを検証するための公開鍵一覧。HS256 で運用中は keys が空になる
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set, err := auth.PublicJWKS()
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("PublicJWKS", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// ローテーション時は新しい鍵を検証鍵として先に配布しておく前提で、短めにキャッシュさせる
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, set)
+	}
+}
@@ -0,0 +1,63 @@
+package handler_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ed25519.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	ks, err := auth.LoadKeySet(path, nil, "hmac-secret")
+	require.NoError(t, err)
+	auth.SetKeySet(ks)
+	t.Cleanup(func() { auth.SetKeySet(nil) })
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/.well-known/jwks.json", handler.JWKSHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+
+	var body struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.Len(t, body.Keys, 1) {
+		assert.Equal(t, ks.SigningKeyID(), body.Keys[0]["kid"])
+		assert.Equal(t, "EdDSA", body.Keys[0]["alg"])
+		assert.Equal(t, "sig", body.Keys[0]["use"])
+		assert.NotEmpty(t, body.Keys[0]["x"])
+	}
+	// 秘密鍵・HMAC シークレットは含まない
+	assert.NotContains(t, w.Body.String(), `"d"`)
+	assert.NotContains(t, w.Body.String(), "hmac-secret")
+}
@@ -1,48 +1,35 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log/slog"
 	"net/http"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
-	"strconv"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/txn"
+	"sol_coffeesys/backend/pkg/validation"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func MeHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		raw, exist := c.Get("userID")
-		if !exist {
-			_ = c.Error(apperror.NewUnauthorizedError("token_not_found", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		var userID int64
-
-		switch v := raw.(type) {
-		case int:
-			userID = int64(v)
-		case int32:
-			userID = int64(v)
-		case int64:
-			userID = v
-		case float64:
-			userID = int64(v)
-		case string:
-			id, err := strconv.ParseInt(v, 10, 64)
-			if err != nil {
-				_ = c.Error(apperror.NewUnauthorizedError("userID_parse_failed", apperror.UnauthorizedMessageAuth))
-				return
-			}
-			userID = id
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("userID_type_is_invalid", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
-		user, err := q.GetUserForUpdate(c.Request.Context(), userID)
+
+		user, err := q.GetUserForUpdate(c.Request.Context(), principal.UserID)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				_ = c.Error(apperror.NewUnauthorizedError("userID_is_not_authenticated", apperror.UnauthorizedMessageAuth))
@@ -53,20 +40,251 @@ func MeHandler(q db.Querier) gin.HandlerFunc {
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"user": gin.H{
-				"id":    user.ID,
-				"name":  user.Name,
-				"email": user.Email,
-				"role":  user.Role,
+			"user": toUserResponse(user),
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_me_fetched",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
+
+// ＋＋プロフィール更新機能＋＋
+type UpdateMeRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+// UpdateMeHandler はメールアドレスを変えた会員を unverified に戻し、新しいアドレスへ確認メールを送る。
+// 新しいアドレスの持ち主であることを確認するまで、確認済みが必要な操作(注文など)はできない
+func UpdateMeHandler(q db.Querier, verifier *EmailVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var req UpdateMeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+		if req.Name == nil && req.Email == nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "empty", apperror.ValidationMessageRequest))
+			return
+		}
+
+		params := db.UpdateUserProfileParams{ID: principal.UserID}
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if err := validation.ValidateName(name); err != nil {
+				_ = c.Error(apperror.NewValidationError("name", nil, "", ""))
+				return
+			}
+			params.Name = sql.NullString{String: name, Valid: true}
+		}
+		if req.Email != nil {
+			email := strings.TrimSpace(*req.Email)
+			if err := validation.ValidateEmail(email); err != nil {
+				_ = c.Error(apperror.NewValidationError("email", nil, "", ""))
+				return
+			}
+			params.Email = sql.NullString{String: email, Valid: true}
+		}
+
+		user, err := q.UpdateUserProfile(c.Request.Context(), params)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				_ = c.Error(apperror.NewValidationError("email", params.Email.String, "", apperror.ValidationMessageConflictedEmail))
+				return
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewUnauthorizedError("userID_is_not_authenticated", apperror.UnauthorizedMessageAuth))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("UpdateUserProfile", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		// 登録時と同じく、送信に失敗しても変更は成功とし、再送 API から送り直してもらう
+		verificationSent := false
+		if params.Email.Valid && user.Status == auth.UserStatusUnverified {
+			if err := verifier.send(c.Request.Context(), user); err != nil {
+				slog.ErrorContext(c.Request.Context(), "verification mail failed",
+					"request_id", c.GetString(logging.CtxKeyRequestID), "user_id", user.ID, "error", err)
+			} else {
+				verificationSent = true
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user": toUserResponse(user),
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_profile_updated",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra: []slog.Attr{
+				slog.Bool("name_changed", params.Name.Valid),
+				slog.Bool("email_changed", params.Email.Valid),
+				slog.Bool("verification_sent", verificationSent),
 			},
 		})
+	}
+}
+
+// ＋＋パスワード変更機能＋＋
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// changePasswordLogic はパスワードを更新して全てのリフレッシュトークンを失効させ、
+// 操作中の端末用に新しいセッションのリフレッシュトークンを発行する
+func changePasswordLogic(ctx context.Context, qtx db.Querier, userID int64, passwordHash, sessionID string, meta SessionMeta) (string, time.Time, error) {
+	if err := qtx.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+		PasswordHash: passwordHash,
+		ID:           userID,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := qtx.RevokeAllRefreshTokensByUser(ctx, userID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	refreshToken, _, expiresAt, err := GenerateRefreshToken(ctx, qtx, userID, sessionID, meta)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return refreshToken, expiresAt, nil
+}
+
+// ChangePasswordHandler は現在のパスワードを確認してから変更する。
+// 他の端末のセッションは全て失効させ、操作中の端末には新しいトークンを Cookie で返す。
+// 現在のパスワードの誤りはログインと同じ loginguard のカウンタで数え、奪われたセッションからの総当たりを防ぐ
+func ChangePasswordHandler(q db.Querier, runner txn.Runner, tokenGenerator auth.TokenGenerator, guard *loginguard.Guard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+		if err := validation.ValidatePassword(req.NewPassword); err != nil {
+			_ = c.Error(apperror.NewValidationError("password", nil, "", ""))
+			return
+		}
+
+		user, err := q.GetUserForUpdate(c.Request.Context(), principal.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewUnauthorizedError("userID_is_not_authenticated", apperror.UnauthorizedMessageAuth))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserForUpdate", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// パスワードを持たない会員は確認できる現在のパスワードが無い。
+		// ログイン中のセッションだけで設定させず、メールアドレスの持ち主であることをパスワード再設定で確かめる
+		if user.PasswordHash == "" {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageNoPassword))
+			return
+		}
+
+		ctx := c.Request.Context()
+		ip := c.ClientIP()
+
+		// ロック中は現在のパスワードを照合しない(正しいパスワードでも弾く)
+		retryAfter, err := guard.Check(ctx, ip, user.Email)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("LoginGuardCheck", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if retryAfter > 0 {
+			_ = c.Error(apperror.NewTooManyRequestsError("password_locked", retryAfter, apperror.TooManyRequestsMessagePassword))
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "auth_password_change_throttled",
+				Status: http.StatusTooManyRequests,
+				Level:  slog.LevelWarn,
+			})
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+			currentPasswordFailed(c, guard, ip, user.Email)
+			return
+		}
+		if err := guard.RecordSuccess(ctx, user.Email); err != nil {
+			_ = c.Error(apperror.NewInternalError("LoginGuardReset", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		hashed, err := HashPassword(req.NewPassword)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		sessionID, err := newSessionID()
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("NewSessionID", err, apperror.InternalServerMessageRefresh))
+			return
+		}
+
+		var refreshToken string
+		var expiresAt time.Time
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			var err error
+			refreshToken, expiresAt, err = changePasswordLogic(c.Request.Context(), qtx, principal.UserID, hashed, sessionID, sessionMetaFrom(c))
+			return err
+		})
+		if err != nil {
+			_ = c.Error(txError("ChangePassword", err))
+			return
+		}
+
+		accessToken, err := tokenGenerator.GenerateToken(principal.UserID, user.Role, sessionID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GenerateToken", err, apperror.InternalServerMessageGenToken))
+			return
+		}
+		setAuthCookies(c, accessToken, refreshToken, expiresAt)
 
-		c.Set("userID", userID)
+		c.JSON(http.StatusOK, gin.H{"message": "パスワードを変更しました"})
 
 		logging.LogEvent(c, logging.EventInput{
-			Event:  "auth_me_fetched",
+			Event:  "auth_password_changed",
 			Status: http.StatusOK,
 			Level:  slog.LevelInfo,
 		})
 	}
 }
+
+// currentPasswordFailed は現在のパスワードの誤りを数え、上限に達したら 429、そうでなければ 400 を返す
+func currentPasswordFailed(c *gin.Context, guard *loginguard.Guard, ip, email string) {
+	retryAfter, err := guard.RecordFailure(c.Request.Context(), ip, email)
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("LoginGuardRecordFailure", err, apperror.InternalServerMessageCommon))
+		return
+	}
+	if retryAfter > 0 {
+		_ = c.Error(apperror.NewTooManyRequestsError("password_locked", retryAfter, apperror.TooManyRequestsMessagePassword))
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_password_change_locked",
+			Status: http.StatusTooManyRequests,
+			Level:  slog.LevelWarn,
+			Extra:  []slog.Attr{slog.Duration("lockout", retryAfter)},
+		})
+		return
+	}
+	_ = c.Error(apperror.NewValidationError("current_password", nil, "mismatch", ""))
+}
@@ -1,16 +1,28 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/loginguard"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestMeHandler(t *testing.T) {
@@ -18,7 +30,7 @@ func TestMeHandler(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		userID         any
+		userID         int64
 		setupMock      func(*testutil.MockDB)
 		expectedStatus int
 		checkResponse  func(*testing.T, *httptest.ResponseRecorder)
@@ -46,6 +58,23 @@ func TestMeHandler(t *testing.T) {
 				assert.Equal(t, "Taro", user["name"])
 				assert.Equal(t, "taro@example.com", user["email"])
 				assert.Equal(t, "member", user["role"])
+				assert.Equal(t, false, user["has_password"])
+			},
+		},
+		{
+			name:   "正常系：パスワードを持つ会員は has_password が true",
+			userID: int64(1),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+					Return(db.User{ID: 1, Name: "Taro", Email: "taro@example.com", Role: "member", PasswordHash: "hash"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var response map[string]any
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				user, ok := response["user"].(map[string]any)
+				assert.True(t, ok)
+				assert.Equal(t, true, user["has_password"])
 			},
 		},
 	}
@@ -58,7 +87,7 @@ func TestMeHandler(t *testing.T) {
 				tt.setupMock(mockDB)
 			}
 			router.GET("/api/me", func(c *gin.Context) {
-				c.Set("userID", tt.userID)
+				auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				MeHandler(mockDB)(c)
 			})
 
@@ -74,3 +103,309 @@ func TestMeHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateMeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*testutil.MockDB)
+		mailErr        error
+		expectedStatus int
+		expectedErrMsg string
+		// wantMailTo は確認メールの宛先。空なら送らない
+		wantMailTo string
+	}{
+		{
+			name: "U1: 名前とメールアドレスを更新すると未確認に戻り、新しいアドレスに確認メールを送る",
+			body: `{"name":" Jiro ","email":"jiro@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, db.UpdateUserProfileParams{
+					ID:    1,
+					Name:  sql.NullString{String: "Jiro", Valid: true},
+					Email: sql.NullString{String: "jiro@example.com", Valid: true},
+				}).Return(db.User{ID: 1, Name: "Jiro", Email: "jiro@example.com", Role: "member", Status: auth.UserStatusUnverified, PasswordHash: "secret"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			wantMailTo:     "jiro@example.com",
+		},
+		{
+			name: "U1-2: 大文字小文字だけの変更は確認済みのまま",
+			body: `{"email":"Taro@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, mock.Anything).
+					Return(db.User{ID: 1, Name: "Taro", Email: "Taro@example.com", Role: "member", Status: auth.UserStatusActive}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "U1-3: 確認メールの送信に失敗しても変更は成功",
+			body: `{"email":"jiro@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, mock.Anything).
+					Return(db.User{ID: 1, Name: "Taro", Email: "jiro@example.com", Role: "member", Status: auth.UserStatusUnverified}, nil)
+			},
+			mailErr:        errors.New("smtp down"),
+			expectedStatus: http.StatusOK,
+			wantMailTo:     "jiro@example.com",
+		},
+		{
+			name: "U2: 名前だけ更新",
+			body: `{"name":"Jiro"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, db.UpdateUserProfileParams{
+					ID:   1,
+					Name: sql.NullString{String: "Jiro", Valid: true},
+				}).Return(db.User{ID: 1, Name: "Jiro", Email: "taro@example.com", Role: "member", Status: auth.UserStatusUnverified}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "U3: 既に使われているメールアドレス",
+			body: `{"email":"used@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, mock.Anything).Return(db.User{}, &pq.Error{Code: "23505"})
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageConflictedEmail,
+		},
+		{
+			name:           "U4: メールアドレスの形式不正",
+			body:           `{"email":"invalid"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageEmail,
+		},
+		{
+			name:           "U5: 空の名前",
+			body:           `{"name":"  "}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageName,
+		},
+		{
+			name:           "U6: 更新項目なし",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+		{
+			name: "U7: DBエラー",
+			body: `{"name":"Jiro"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("UpdateUserProfile", mock.Anything, mock.Anything).Return(db.User{}, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			sender := &fakeMailSender{err: tt.mailErr}
+			verifier := newTestEmailVerifier(sender, time.Now())
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.PATCH("/api/me", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1})
+				UpdateMeHandler(mockDB, verifier)(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/me", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				assert.NotContains(t, w.Body.String(), "password_hash")
+			}
+			if tt.wantMailTo == "" {
+				assert.Empty(t, sender.sent)
+			} else {
+				require.Len(t, sender.sent, 1)
+				assert.Equal(t, tt.wantMailTo, sender.sent[0].To)
+				var body struct {
+					User struct {
+						EmailVerified bool `json:"email_verified"`
+					} `json:"user"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.False(t, body.User.EmailVerified)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+type stubTokenGenerator struct {
+	token string
+	err   error
+}
+
+func (g stubTokenGenerator) GenerateToken(userID int64, role, sessionID string) (string, error) {
+	return g.token, g.err
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	current, err := bcrypt.GenerateFromPassword([]byte("current123"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	user := db.User{ID: 1, Name: "Taro", Email: "taro@example.com", Role: "member", PasswordHash: string(current)}
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		wantCookies    bool
+	}{
+		{
+			name: "U1: パスワードを変更し他のセッションを失効、操作中の端末には再発行",
+			body: `{"current_password":"current123","new_password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+				m.On("UpdateUserPassword", mock.Anything, mock.MatchedBy(func(arg db.UpdateUserPasswordParams) bool {
+					return arg.ID == 1 && bcrypt.CompareHashAndPassword([]byte(arg.PasswordHash), []byte("newpassword1")) == nil
+				})).Return(nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(1)).Return(nil)
+				m.On("CreateRefreshToken", mock.Anything, mock.MatchedBy(func(arg db.CreateRefreshTokenParams) bool {
+					return arg.UserID == 1
+				})).Return(db.RefreshToken{ID: 9, UserID: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			wantCookies:    true,
+		},
+		{
+			name: "U2-2: パスワードを持たない会員にはパスワード再設定を案内する",
+			body: `{"current_password":"anything1","new_password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				passwordless := user
+				passwordless.PasswordHash = ""
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(passwordless, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageNoPassword,
+		},
+		{
+			name: "U2: 現在のパスワードが違う",
+			body: `{"current_password":"wrongpass","new_password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCurrentPassword,
+		},
+		{
+			name:           "U3: 新しいパスワードの形式不正",
+			body:           `{"current_password":"current123","new_password":"short"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessagePassword,
+		},
+		{
+			name:           "U4: 現在のパスワードなし",
+			body:           `{"new_password":"newpassword1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+		{
+			name: "U5: 失効処理のDBエラー",
+			body: `{"current_password":"current123","new_password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+				m.On("UpdateUserPassword", mock.Anything, mock.Anything).Return(nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(1)).Return(errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/me/password", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1})
+				ChangePasswordHandler(mockDB, testutil.TxRunner{Querier: mockDB}, stubTokenGenerator{token: "access"}, loginguard.New(loginguard.NewMemoryStore(nil), loginguard.DefaultOptions()))(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/me/password", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+
+			cookies := map[string]string{}
+			for _, ck := range w.Result().Cookies() {
+				cookies[ck.Name] = ck.Value
+			}
+			if tt.wantCookies {
+				assert.Equal(t, "access", cookies["access_token"])
+				assert.Len(t, cookies["refresh_token"], 64)
+			} else {
+				assert.Empty(t, cookies)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+// 現在のパスワードの誤りはログインと同じ上限でロックされ、ロック中は正しいパスワードでも変更できない
+func TestChangePasswordHandler_Lockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	current, err := bcrypt.GenerateFromPassword([]byte("current123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := db.User{ID: 1, Name: "Taro", Email: "taro@example.com", Role: "member", PasswordHash: string(current)}
+	mockDB := new(testutil.MockDB)
+	mockDB.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+
+	opts := loginguard.DefaultOptions()
+	guard := loginguard.New(loginguard.NewMemoryStore(nil), opts)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/me/password", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: 1})
+		ChangePasswordHandler(mockDB, testutil.TxRunner{Querier: mockDB}, stubTokenGenerator{token: "access"}, guard)(c)
+	})
+
+	for i := 1; i < opts.Account.MaxFailures; i++ {
+		w := postJSON(router, "/api/me/password", map[string]any{"current_password": "wrongpass", "new_password": "newpassword1"})
+		require.Equal(t, http.StatusBadRequest, w.Code, "attempt %d", i)
+	}
+	w := postJSON(router, "/api/me/password", map[string]any{"current_password": "wrongpass", "new_password": "newpassword1"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), apperror.TooManyRequestsMessagePassword)
+
+	w = postJSON(router, "/api/me/password", map[string]any{"current_password": "current123", "new_password": "newpassword1"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	mockDB.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything)
+
+	// 同じアカウントのログインもロックされる
+	locked, err := guard.Check(context.Background(), "198.51.100.1", user.Email)
+	require.NoError(t, err)
+	assert.Positive(t, locked)
+}
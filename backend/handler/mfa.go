@@ -0,0 +1,505 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/redaction"
+	"sol_coffeesys/backend/pkg/totp"
+	"sol_coffeesys/backend/pkg/txn"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// totpIssuer は認証アプリに表示されるサービス名
+	totpIssuer = "sol coffee"
+	// totpSkew は端末の時計のずれとして前後何ステップ(30秒)まで許すか
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	// 紛らわしい 0/o, 1/l/i を除いた英数字
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+// totpNow はテストで時刻を固定するために差し替える
+var totpNow = time.Now
+
+func hasTOTPEnabled(ctx context.Context, q db.Querier, userID int64) (bool, error) {
+	t, err := q.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.EnabledAt.Valid, nil
+}
+
+// generateRecoveryCodes は "xxxxx-xxxxx" 形式のリカバリーコードを返す
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeLength)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		for j, b := range buf {
+			if j == recoveryCodeLength/2 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)])
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode は大文字・ハイフン・空白の違いを無視してハッシュする
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceRecoveryCodes は既存のリカバリーコードを捨てて codes のハッシュを保存する
+func replaceRecoveryCodes(ctx context.Context, qtx db.Querier, userID int64, codes []string) error {
+	if err := qtx.DeleteRecoveryCodesByUser(ctx, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if err := qtx.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySecondFactor は TOTP のコードかリカバリーコードのどちらかを確認し、使ったものを消費する。
+// 戻り値の method はログ用("totp" / "recovery_code")
+func verifySecondFactor(ctx context.Context, q db.Querier, t db.UserTotp, code, recoveryCode string) (method string, ok bool, err error) {
+	switch {
+	case code != "":
+		step, matched, err := totp.Validate(t.Secret, code, totpNow(), totpSkew)
+		if err != nil || !matched {
+			return "totp", false, err
+		}
+		// 一度使ったコード(とそれより前のコード)はもう通さない
+		n, err := q.ConsumeTOTPStep(ctx, db.ConsumeTOTPStepParams{UserID: t.UserID, LastUsedStep: step})
+		return "totp", n == 1, err
+	case recoveryCode != "":
+		n, err := q.ConsumeRecoveryCode(ctx, db.ConsumeRecoveryCodeParams{UserID: t.UserID, CodeHash: hashRecoveryCode(recoveryCode)})
+		return "recovery_code", n == 1, err
+	}
+	return "", false, nil
+}
+
+// ＋＋2段階認証の状態取得機能＋＋
+func GetMFAStatusHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		enabled, err := hasTOTPEnabled(c.Request.Context(), q, principal.UserID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		var remaining int64
+		if enabled {
+			remaining, err = q.CountUnusedRecoveryCodes(c.Request.Context(), principal.UserID)
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("CountUnusedRecoveryCodes", err, apperror.InternalServerMessageCommon))
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"totp_enabled":             enabled,
+			"recovery_codes_remaining": remaining,
+		})
+	}
+}
+
+// ＋＋TOTP登録開始機能＋＋
+// シークレットを発行して未有効の状態で保存する。コードを確認する(EnableTOTPHandler)まではログインに影響しない
+func SetupTOTPHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		user, err := q.GetUserByID(c.Request.Context(), principal.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewNotFoundError("user", principal.UserID, ""))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GenerateTOTPSecret", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if _, err := q.UpsertPendingUserTOTP(c.Request.Context(), db.UpsertPendingUserTOTPParams{
+			UserID: user.ID,
+			Secret: secret,
+		}); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageMFAEnabled))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("UpsertPendingUserTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_mfa_setup_started",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
+
+// ＋＋TOTP有効化機能＋＋
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// EnableTOTPHandler は認証アプリのコードを確認して TOTP を有効にし、リカバリーコードを一度だけ返す。
+// 有効化前に発行された他の端末のセッションは2段階目を経ていないので失効させる
+func EnableTOTPHandler(q db.Querier, runner txn.Runner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+
+		t, err := q.GetUserTOTP(c.Request.Context(), principal.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageMFASetup))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if t.EnabledAt.Valid {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageMFAEnabled))
+			return
+		}
+
+		step, ok, err := totp.Validate(t.Secret, req.Code, totpNow(), totpSkew)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("ValidateTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if !ok {
+			_ = c.Error(apperror.NewValidationError("code", nil, "mismatch", apperror.ValidationMessageMFACode))
+			return
+		}
+
+		codes, err := generateRecoveryCodes()
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("GenerateRecoveryCodes", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			n, err := qtx.EnableUserTOTP(c.Request.Context(), db.EnableUserTOTPParams{
+				UserID:       principal.UserID,
+				LastUsedStep: step,
+			})
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return apperror.NewBusinessLogicError(apperror.BusinessLogicMessageMFAEnabled)
+			}
+			if err := replaceRecoveryCodes(c.Request.Context(), qtx, principal.UserID, codes); err != nil {
+				return err
+			}
+			return qtx.RevokeOtherSessionsByUser(c.Request.Context(), db.RevokeOtherSessionsByUserParams{
+				UserID:   principal.UserID,
+				FamilyID: principal.SessionID,
+			})
+		})
+		if err != nil {
+			_ = c.Error(txError("EnableTOTP", err))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "2段階認証を有効にしました",
+			"recovery_codes": codes,
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_mfa_enabled",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
+
+// ＋＋TOTP無効化機能＋＋
+type DisableTOTPRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableTOTPHandler はパスワードと2段階目(コードかリカバリーコード)の両方を確認してから TOTP を解除する
+func DisableTOTPHandler(q db.Querier, runner txn.Runner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var req DisableTOTPRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+
+		user, err := q.GetUserForUpdate(c.Request.Context(), principal.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewUnauthorizedError("userID_is_not_authenticated", apperror.UnauthorizedMessageAuth))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserForUpdate", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// パスワードを持たない会員はパスワード再設定で設定してから解除する
+		if user.PasswordHash == "" {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageNoPassword))
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			_ = c.Error(apperror.NewValidationError("current_password", nil, "mismatch", ""))
+			return
+		}
+
+		t, err := q.GetUserTOTP(c.Request.Context(), principal.UserID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			_ = c.Error(apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if err != nil || !t.EnabledAt.Valid {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageMFADisabled))
+			return
+		}
+
+		method, ok, err := verifySecondFactor(c.Request.Context(), q, t, req.Code, req.RecoveryCode)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("VerifySecondFactor", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if !ok {
+			_ = c.Error(apperror.NewValidationError("code", nil, "mismatch", apperror.ValidationMessageMFACode))
+			return
+		}
+
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			if err := qtx.DeleteUserTOTP(c.Request.Context(), principal.UserID); err != nil {
+				return err
+			}
+			return qtx.DeleteRecoveryCodesByUser(c.Request.Context(), principal.UserID)
+		})
+		if err != nil {
+			_ = c.Error(txError("DisableTOTP", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "2段階認証を解除しました"})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_mfa_disabled",
+			Status: http.StatusOK,
+			Level:  slog.LevelWarn,
+			Extra:  []slog.Attr{slog.String("method", method)},
+		})
+	}
+}
+
+// ＋＋ログイン(2段階目)機能＋＋
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMFAHandler は LoginUserHandler が返した mfa_token と確認コードを受け取り、Cookie を発行する。
+// コードの誤りはパスワードの誤りと同じく loginguard で数え、総当たりを防ぐ
+func LoginMFAHandler(q db.Querier, tokenGenerator auth.TokenGenerator, guard *loginguard.Guard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LoginMFARequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+
+		userID, err := auth.ValidateMFAChallenge(req.MFAToken)
+		if err != nil {
+			_ = c.Error(apperror.NewUnauthorizedError("mfa_challenge_invalid", apperror.UnauthorizedMessageMFAChallenge))
+			return
+		}
+
+		ctx := c.Request.Context()
+		ip := c.ClientIP()
+
+		user, err := q.GetUserByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				_ = c.Error(apperror.NewUnauthorizedError("mfa_challenge_invalid", apperror.UnauthorizedMessageMFAChallenge))
+				return
+			}
+			_ = c.Error(apperror.NewInternalError("GetUserByID", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// チャレンジ発行後に停止された場合
+		if err := auth.CheckUserStatus(user.Status); err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		retryAfter, err := guard.Check(ctx, ip, user.Email)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("LoginGuardCheck", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if retryAfter > 0 {
+			_ = c.Error(apperror.NewTooManyRequestsError("login_locked", retryAfter, apperror.TooManyRequestsMessageLogin))
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "auth_login_throttled",
+				Status: http.StatusTooManyRequests,
+				Level:  slog.LevelWarn,
+				Extra:  []slog.Attr{slog.String("email", redaction.MaskEmail(user.Email))},
+			})
+			return
+		}
+
+		t, err := q.GetUserTOTP(ctx, user.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			_ = c.Error(apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// チャレンジ発行後に TOTP を解除された場合はパスワードからやり直してもらう
+		if err != nil || !t.EnabledAt.Valid {
+			_ = c.Error(apperror.NewUnauthorizedError("mfa_not_enabled", apperror.UnauthorizedMessageMFAChallenge))
+			return
+		}
+
+		method, ok, err := verifySecondFactor(ctx, q, t, req.Code, req.RecoveryCode)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("VerifySecondFactor", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		if !ok {
+			mfaFailed(c, guard, ip, user.Email, method)
+			return
+		}
+		if err := guard.RecordSuccess(ctx, user.Email); err != nil {
+			_ = c.Error(apperror.NewInternalError("LoginGuardReset", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		if !startSession(c, q, tokenGenerator, user) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "ログイン成功",
+			"user":    toUserResponse(user),
+		})
+
+		c.Set("userID", user.ID)
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_login_succeeded",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.String("mfa_method", method)},
+		})
+	}
+}
+
+// mfaFailed は loginFailed と同じく失敗を数え、上限に達したら 429 を返す
+func mfaFailed(c *gin.Context, guard *loginguard.Guard, ip, email, method string) {
+	retryAfter, err := guard.RecordFailure(c.Request.Context(), ip, email)
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("LoginGuardRecordFailure", err, apperror.InternalServerMessageCommon))
+		return
+	}
+	if retryAfter > 0 {
+		_ = c.Error(apperror.NewTooManyRequestsError("login_locked", retryAfter, apperror.TooManyRequestsMessageLogin))
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_login_locked",
+			Status: http.StatusTooManyRequests,
+			Level:  slog.LevelWarn,
+			Extra: []slog.Attr{
+				slog.String("email", redaction.MaskEmail(email)),
+				slog.String("reason", "mfa_mismatch"),
+				slog.Duration("lockout", retryAfter),
+			},
+		})
+		return
+	}
+	_ = c.Error(apperror.NewUnauthorizedError("mfa_mismatch", apperror.UnauthorizedMessageMFACode))
+	logging.LogEvent(c, logging.EventInput{
+		Event:  "auth_login_mfa_failed",
+		Status: http.StatusUnauthorized,
+		Level:  slog.LevelWarn,
+		Extra: []slog.Attr{
+			slog.String("email", redaction.MaskEmail(email)),
+			slog.String("method", method),
+		},
+	})
+}
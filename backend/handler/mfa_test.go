@@ -0,0 +1,494 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/totp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// fixTOTPClock は totpNow を固定し、その時刻で有効なコードとステップを返す
+func fixTOTPClock(t *testing.T) (string, int64) {
+	t.Helper()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := totpNow
+	totpNow = func() time.Time { return now }
+	t.Cleanup(func() { totpNow = original })
+
+	step := totp.Step(now)
+	code, err := totp.CodeAt(testTOTPSecret, step)
+	require.NoError(t, err)
+	return code, step
+}
+
+func enabledTOTP(userID int64) db.UserTotp {
+	return db.UserTotp{
+		UserID:    userID,
+		Secret:    testTOTPSecret,
+		EnabledAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}
+}
+
+func postJSON(router *gin.Engine, path string, body any) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func withPrincipal(p auth.Principal) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth.SetPrincipal(c, p)
+		c.Next()
+	}
+}
+
+func TestLoginMFAHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	code, step := fixTOTPClock(t)
+
+	user := db.User{ID: 1, Email: "admin@example.com", Role: auth.RoleAdmin, Status: auth.UserStatusActive}
+	challenge, err := auth.GenerateMFAChallenge(1)
+	require.NoError(t, err)
+	accessToken, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleAdmin, "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		body           map[string]any
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "正常系：TOTPのコードでログイン",
+			body: map[string]any{"mfa_token": challenge, "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+				m.On("ConsumeTOTPStep", mock.Anything, db.ConsumeTOTPStepParams{UserID: 1, LastUsedStep: step}).Return(int64(1), nil)
+				m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(db.RefreshToken{ID: 1, UserID: 1}, nil)
+				m.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "正常系：リカバリーコードでログイン",
+			body: map[string]any{"mfa_token": challenge, "recovery_code": "ABCDE-FGHJK"},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+				m.On("ConsumeRecoveryCode", mock.Anything, db.ConsumeRecoveryCodeParams{UserID: 1, CodeHash: hashRecoveryCode("abcdefghjk")}).Return(int64(1), nil)
+				m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(db.RefreshToken{ID: 1, UserID: 1}, nil)
+				m.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "異常系：使用済みのコード",
+			body: map[string]any{"mfa_token": challenge, "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+				m.On("ConsumeTOTPStep", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageMFACode,
+		},
+		{
+			name: "異常系：コードの誤り",
+			body: map[string]any{"mfa_token": challenge, "code": "000000"},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageMFACode,
+		},
+		{
+			name:           "異常系：アクセストークンはmfa_tokenとして使えない",
+			body:           map[string]any{"mfa_token": accessToken, "code": code},
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageMFAChallenge,
+		},
+		{
+			name: "異常系：チャレンジ発行後にTOTPが解除された",
+			body: map[string]any{"mfa_token": challenge, "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageMFAChallenge,
+		},
+		{
+			name:           "異常系：コードもリカバリーコードも無い",
+			body:           map[string]any{"mfa_token": challenge},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			guard := loginguard.New(loginguard.NewMemoryStore(nil), loginguard.DefaultOptions())
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/login/mfa", LoginMFAHandler(mockDB, stubTokenGenerator{token: "access"}, guard))
+
+			w := postJSON(router, "/api/login/mfa", tt.body)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+				assert.Empty(t, w.Result().Cookies())
+			} else {
+				assert.Equal(t, "admin@example.com", body["user"].(map[string]any)["email"])
+				assert.NotEmpty(t, w.Result().Cookies())
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginMFAHandler_Lockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	code, _ := fixTOTPClock(t)
+
+	challenge, err := auth.GenerateMFAChallenge(1)
+	require.NoError(t, err)
+	mockDB := new(testutil.MockDB)
+	mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Email: "admin@example.com", Status: auth.UserStatusActive}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+
+	opts := loginguard.DefaultOptions()
+	guard := loginguard.New(loginguard.NewMemoryStore(nil), opts)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/login/mfa", LoginMFAHandler(mockDB, stubTokenGenerator{token: "access"}, guard))
+
+	// パスワードと同じ上限でロックされる
+	for i := 1; i < opts.Account.MaxFailures; i++ {
+		w := postJSON(router, "/api/login/mfa", map[string]any{"mfa_token": challenge, "code": "000000"})
+		require.Equal(t, http.StatusUnauthorized, w.Code, "attempt %d", i)
+	}
+	w := postJSON(router, "/api/login/mfa", map[string]any{"mfa_token": challenge, "code": "000000"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// ロック中は正しいコードでも通さない
+	w = postJSON(router, "/api/login/mfa", map[string]any{"mfa_token": challenge, "code": code})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	mockDB.AssertNotCalled(t, "ConsumeTOTPStep", mock.Anything, mock.Anything)
+}
+
+// パスワードでログインし直しても確認コードの失敗回数はリセットされない
+func TestLoginMFAHandler_LockoutAcrossPasswordLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	fixTOTPClock(t)
+
+	hashed, err := HashPassword("password123")
+	require.NoError(t, err)
+	user := db.User{ID: 1, Email: "admin@example.com", PasswordHash: hashed, Role: auth.RoleAdmin, Status: auth.UserStatusActive}
+	mockDB := new(testutil.MockDB)
+	mockDB.On("GetUserByEmail", mock.Anything, user.Email).Return(user, nil)
+	mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(user, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+
+	opts := loginguard.DefaultOptions()
+	guard := loginguard.New(loginguard.NewMemoryStore(nil), opts)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/login", LoginUserHandler(mockDB, stubTokenGenerator{token: "access"}, guard))
+	router.POST("/api/login/mfa", LoginMFAHandler(mockDB, stubTokenGenerator{token: "access"}, guard))
+
+	login := func() string {
+		w := postJSON(router, "/api/login", map[string]any{"email": user.Email, "password": "password123"})
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body["mfa_token"].(string)
+	}
+
+	for i := 1; i < opts.Account.MaxFailures; i++ {
+		w := postJSON(router, "/api/login/mfa", map[string]any{"mfa_token": login(), "code": "000000"})
+		require.Equal(t, http.StatusUnauthorized, w.Code, "attempt %d", i)
+	}
+	w := postJSON(router, "/api/login/mfa", map[string]any{"mfa_token": login(), "code": "000000"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// ロック中はパスワードが正しくても2段階目に進めない
+	w = postJSON(router, "/api/login", map[string]any{"email": user.Email, "password": "password123"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	mockDB.AssertNotCalled(t, "ConsumeTOTPStep", mock.Anything, mock.Anything)
+}
+
+func TestSetupTOTPHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("正常系：シークレットとotpauth URIを返す", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Email: "admin@example.com"}, nil)
+		mockDB.On("UpsertPendingUserTOTP", mock.Anything, mock.MatchedBy(func(p db.UpsertPendingUserTOTPParams) bool {
+			return p.UserID == 1 && len(p.Secret) == 32
+		})).Return(db.UserTotp{UserID: 1}, nil)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.POST("/api/me/mfa/totp/setup", withPrincipal(auth.Principal{UserID: 1}), SetupTOTPHandler(mockDB))
+
+		w := postJSON(router, "/api/me/mfa/totp/setup", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		u, err := url.Parse(body["otpauth_uri"])
+		require.NoError(t, err)
+		assert.Equal(t, body["secret"], u.Query().Get("secret"))
+		assert.Contains(t, u.Path, "admin@example.com")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("異常系：有効化済み", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Email: "admin@example.com"}, nil)
+		mockDB.On("UpsertPendingUserTOTP", mock.Anything, mock.Anything).Return(db.UserTotp{}, sql.ErrNoRows)
+
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		router.POST("/api/me/mfa/totp/setup", withPrincipal(auth.Principal{UserID: 1}), SetupTOTPHandler(mockDB))
+
+		w := postJSON(router, "/api/me/mfa/totp/setup", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), apperror.BusinessLogicMessageMFAEnabled)
+	})
+}
+
+func TestEnableTOTPHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	code, step := fixTOTPClock(t)
+	pending := db.UserTotp{UserID: 1, Secret: testTOTPSecret}
+
+	tests := []struct {
+		name           string
+		code           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "正常系：有効化してリカバリーコードを返す",
+			code: code,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(pending, nil)
+				m.On("EnableUserTOTP", mock.Anything, db.EnableUserTOTPParams{UserID: 1, LastUsedStep: step}).Return(int64(1), nil)
+				m.On("DeleteRecoveryCodesByUser", mock.Anything, int64(1)).Return(nil)
+				m.On("CreateRecoveryCode", mock.Anything, mock.Anything).Return(nil).Times(recoveryCodeCount)
+				m.On("RevokeOtherSessionsByUser", mock.Anything, db.RevokeOtherSessionsByUserParams{UserID: 1, FamilyID: "family-1"}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "異常系：コードの誤り",
+			code: "000000",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(pending, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageMFACode,
+		},
+		{
+			name: "異常系：登録を開始していない",
+			code: code,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageMFASetup,
+		},
+		{
+			name: "異常系：有効化済み",
+			code: code,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageMFAEnabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			tt.setupMock(mockDB)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/me/mfa/totp/enable",
+				withPrincipal(auth.Principal{UserID: 1, SessionID: "family-1"}),
+				EnableTOTPHandler(mockDB, testutil.TxRunner{Querier: mockDB}))
+
+			w := postJSON(router, "/api/me/mfa/totp/enable", map[string]string{"code": tt.code})
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				codes := body["recovery_codes"].([]any)
+				assert.Len(t, codes, recoveryCodeCount)
+				// 保存するのはハッシュだけ
+				for _, call := range mockDB.Calls {
+					if call.Method == "CreateRecoveryCode" {
+						saved := call.Arguments.Get(1).(db.CreateRecoveryCodeParams).CodeHash
+						assert.Len(t, saved, 64)
+						assert.NotContains(t, w.Body.String(), saved)
+					}
+				}
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDisableTOTPHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	code, step := fixTOTPClock(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := db.User{ID: 1, PasswordHash: string(hash)}
+
+	tests := []struct {
+		name           string
+		body           map[string]string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "正常系：パスワードとコードで解除",
+			body: map[string]string{"password": "password123", "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+				m.On("ConsumeTOTPStep", mock.Anything, db.ConsumeTOTPStepParams{UserID: 1, LastUsedStep: step}).Return(int64(1), nil)
+				m.On("DeleteUserTOTP", mock.Anything, int64(1)).Return(nil)
+				m.On("DeleteRecoveryCodesByUser", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "異常系：パスワードの誤り",
+			body: map[string]string{"password": "wrongpassword", "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCurrentPassword,
+		},
+		{
+			name: "異常系：パスワードを持たない会員",
+			body: map[string]string{"password": "anything1", "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(db.User{ID: 1}, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageNoPassword,
+		},
+		{
+			name: "異常系：使用済みのリカバリーコード",
+			body: map[string]string{"password": "password123", "recovery_code": "abcde-fghjk"},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(enabledTOTP(1), nil)
+				m.On("ConsumeRecoveryCode", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageMFACode,
+		},
+		{
+			name: "異常系：有効になっていない",
+			body: map[string]string{"password": "password123", "code": code},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(user, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.BusinessLogicMessageMFADisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			tt.setupMock(mockDB)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/me/mfa/totp/disable", withPrincipal(auth.Principal{UserID: 1}),
+				DisableTOTPHandler(mockDB, testutil.TxRunner{Querier: mockDB}))
+
+			w := postJSON(router, "/api/me/mfa/totp/disable", tt.body)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body map[string]any
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		assert.Len(t, c, recoveryCodeLength+1)
+		assert.Equal(t, byte('-'), c[recoveryCodeLength/2])
+		assert.False(t, seen[c])
+		seen[c] = true
+	}
+
+	// 入力の揺れ(大文字・ハイフン・空白)を吸収する
+	assert.Equal(t, hashRecoveryCode("abcde-fghjk"), hashRecoveryCode(" ABCDE FGHJK "))
+	assert.Equal(t, hashRecoveryCode("abcde-fghjk"), hashRecoveryCode(strings.ReplaceAll("abcde-fghjk", "-", "")))
+	assert.NotEqual(t, hashRecoveryCode("abcde-fghjk"), hashRecoveryCode("abcde-fghjm"))
+}
@@ -0,0 +1,363 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/oidc"
+	"sol_coffeesys/backend/pkg/txn"
+	"sol_coffeesys/backend/pkg/validation"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// oidcCookiePath はログイン開始とコールバックにだけ state の Cookie を送らせる
+	oidcCookiePath    = "/api/auth/oidc"
+	oidcNameMaxLength = 255
+)
+
+// ログイン画面に ?error= で返す理由。画面側で文言を出し分ける
+const (
+	oidcErrorInvalidState  = "oidc_invalid_state"
+	oidcErrorDenied        = "oidc_denied"
+	oidcErrorUnavailable   = "oidc_unavailable"
+	oidcErrorFailed        = "oidc_failed"
+	oidcErrorEmailRequired = "oidc_email_required"
+	oidcErrorEmailConflict = "oidc_email_conflict"
+	oidcErrorLinkPassword  = "oidc_link_requires_password"
+	oidcErrorAccount       = "account_unavailable"
+	oidcErrorServer        = "server_error"
+)
+
+var (
+	errOIDCEmailRequired = errors.New("id token has no valid email")
+	errOIDCEmailConflict = errors.New("email belongs to an existing user but is not verified by the provider")
+	errOIDCLinkPassword  = errors.New("email belongs to a staff or admin user who must sign in with a password")
+)
+
+// OIDCLogin は外部 IdP でのログインに必要なものをまとめる。
+// SuccessURL はログイン後に戻すフロントエンドの URL。LoginURL はフロントエンドのログイン画面で、
+// 失敗時は ?error=、2段階認証が必要なときは #mfa_token= を付けて戻す
+type OIDCLogin struct {
+	Providers  map[string]*oidc.Provider
+	Sealer     *oidc.StateSealer
+	SuccessURL string
+	LoginURL   string
+}
+
+func (l *OIDCLogin) provider(c *gin.Context) (*oidc.Provider, bool) {
+	p, ok := l.Providers[c.Param("provider")]
+	if !ok {
+		_ = c.Error(apperror.NewNotFoundError("oidc", c.Param("provider"), ""))
+	}
+	return p, ok
+}
+
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		HttpOnly: true,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		// IdP からのリダイレクト(別サイトからのトップレベル遷移)でも送られるよう Lax にする
+		SameSite: http.SameSiteLaxMode,
+		Secure:   gin.Mode() == gin.ReleaseMode,
+	})
+}
+
+// fail はログイン画面へ理由付きで戻す。ブラウザの遷移中なので JSON のエラーは返さない
+func (l *OIDCLogin) fail(c *gin.Context, provider, reason string, level slog.Level, err error) {
+	u, parseErr := url.Parse(l.LoginURL)
+	if parseErr != nil {
+		u = &url.URL{Path: "/"}
+	}
+	q := u.Query()
+	q.Set("error", reason)
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+
+	attrs := []slog.Attr{slog.String("provider", provider), slog.String("reason", reason)}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logging.LogEvent(c, logging.EventInput{
+		Event:  "auth_oidc_login_failed",
+		Status: http.StatusFound,
+		Level:  level,
+		Extra:  attrs,
+	})
+}
+
+// ＋＋外部IDログイン開始機能＋＋
+// state・nonce・PKCE の code_verifier を署名付き Cookie に預け、IdP の認可画面へリダイレクトする
+func OIDCLoginHandler(l *OIDCLogin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := l.provider(c)
+		if !ok {
+			return
+		}
+
+		st := oidc.LoginState{Provider: p.Name()}
+		for _, v := range []*string{&st.State, &st.Nonce, &st.CodeVerifier} {
+			token, err := oidc.RandomToken()
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("RandomToken", err, apperror.InternalServerMessageGenToken))
+				return
+			}
+			*v = token
+		}
+		sealed, err := l.Sealer.Seal(st)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("SealState", err, apperror.InternalServerMessageGenToken))
+			return
+		}
+		authURL, err := p.AuthCodeURL(c.Request.Context(), st.State, st.Nonce, oidc.CodeChallenge(st.CodeVerifier))
+		if err != nil {
+			l.fail(c, p.Name(), oidcErrorUnavailable, slog.LevelError, err)
+			return
+		}
+
+		setOIDCStateCookie(c, sealed, int(l.Sealer.TTL().Seconds()))
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, authURL)
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_oidc_login_started",
+			Status: http.StatusFound,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.String("provider", p.Name())},
+		})
+	}
+}
+
+// ＋＋外部IDログイン完了機能＋＋
+// IdP から戻ってきた認可コードを ID トークンに交換して検証し、会員に紐付けて通常のログインと同じ Cookie を発行する
+func OIDCCallbackHandler(l *OIDCLogin, q db.Querier, runner txn.Runner, tokenGenerator auth.TokenGenerator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := l.provider(c)
+		if !ok {
+			return
+		}
+		ctx := c.Request.Context()
+
+		// state の Cookie は成否にかかわらず1回で捨てる
+		sealed, _ := c.Cookie(oidcStateCookie)
+		setOIDCStateCookie(c, "", -1)
+		st, err := l.Sealer.Open(sealed)
+		if err != nil || st.Provider != p.Name() || !hmac.Equal([]byte(c.Query("state")), []byte(st.State)) {
+			l.fail(c, p.Name(), oidcErrorInvalidState, slog.LevelWarn, err)
+			return
+		}
+		if e := c.Query("error"); e != "" {
+			l.fail(c, p.Name(), oidcErrorDenied, slog.LevelInfo, errors.New(e))
+			return
+		}
+		code := c.Query("code")
+		if code == "" {
+			l.fail(c, p.Name(), oidcErrorInvalidState, slog.LevelWarn, nil)
+			return
+		}
+
+		rawIDToken, err := p.Exchange(ctx, code, st.CodeVerifier)
+		if err != nil {
+			l.fail(c, p.Name(), oidcErrorFailed, slog.LevelError, err)
+			return
+		}
+		claims, err := p.VerifyIDToken(ctx, rawIDToken, st.Nonce)
+		if err != nil {
+			l.fail(c, p.Name(), oidcErrorFailed, slog.LevelWarn, err)
+			return
+		}
+
+		user, outcome, err := resolveOIDCUser(ctx, runner, p.Name(), claims)
+		switch {
+		case errors.Is(err, errOIDCEmailRequired):
+			l.fail(c, p.Name(), oidcErrorEmailRequired, slog.LevelInfo, err)
+			return
+		case errors.Is(err, errOIDCEmailConflict):
+			l.fail(c, p.Name(), oidcErrorEmailConflict, slog.LevelWarn, err)
+			return
+		case errors.Is(err, errOIDCLinkPassword):
+			l.fail(c, p.Name(), oidcErrorLinkPassword, slog.LevelWarn, err)
+			return
+		case err != nil:
+			l.fail(c, p.Name(), oidcErrorServer, slog.LevelError, err)
+			return
+		}
+		c.Set("userID", user.ID)
+
+		if err := auth.CheckUserStatus(user.Status); err != nil {
+			l.fail(c, p.Name(), oidcErrorAccount, slog.LevelWarn, err)
+			return
+		}
+
+		// TOTP を有効にしているユーザーにはパスワードログインと同じく確認コードを求める。
+		// mfa_token はサーバーに送られない URL フラグメントで渡す
+		totpEnabled, err := hasTOTPEnabled(ctx, q, user.ID)
+		if err != nil {
+			l.fail(c, p.Name(), oidcErrorServer, slog.LevelError, err)
+			return
+		}
+		if totpEnabled {
+			mfaToken, err := auth.GenerateMFAChallenge(user.ID)
+			if err != nil {
+				l.fail(c, p.Name(), oidcErrorServer, slog.LevelError, err)
+				return
+			}
+			c.Redirect(http.StatusFound, strings.SplitN(l.LoginURL, "#", 2)[0]+"#mfa_token="+url.QueryEscape(mfaToken))
+
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "auth_oidc_login_mfa_required",
+				Status: http.StatusFound,
+				Level:  slog.LevelInfo,
+				Extra:  []slog.Attr{slog.String("provider", p.Name()), slog.String("outcome", outcome)},
+			})
+			return
+		}
+
+		if !startSession(c, q, tokenGenerator, user) {
+			l.fail(c, p.Name(), oidcErrorServer, slog.LevelError, c.Errors.Last())
+			return
+		}
+		c.Redirect(http.StatusFound, l.SuccessURL)
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_oidc_login_succeeded",
+			Status: http.StatusFound,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.String("provider", p.Name()), slog.String("outcome", outcome)},
+		})
+	}
+}
+
+// resolveOIDCUser は外部IDに対応する会員を返す。outcome は
+// existing(紐付け済み)・linked(同じメールアドレスの会員に紐付けた)・created(会員を新規作成した)のいずれか
+func resolveOIDCUser(ctx context.Context, runner txn.Runner, provider string, claims oidc.Claims) (db.User, string, error) {
+	var (
+		user    db.User
+		outcome string
+	)
+	// 登録時と違い IdP ごとに表記が揺れるので、小文字にそろえて照合・保存する
+	address := strings.ToLower(strings.TrimSpace(claims.Email))
+	email := sql.NullString{String: address, Valid: address != ""}
+
+	err := runner.RunInTx(ctx, func(qtx db.Querier) error {
+		identity, err := qtx.GetUserIdentity(ctx, db.GetUserIdentityParams{Provider: provider, Subject: claims.Subject})
+		if err == nil {
+			if user, err = qtx.GetUserByID(ctx, identity.UserID); err != nil {
+				return err
+			}
+			outcome = "existing"
+			return qtx.TouchUserIdentityLogin(ctx, db.TouchUserIdentityLoginParams{ID: identity.ID, Email: email})
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if validation.ValidateEmail(address) != nil {
+			return errOIDCEmailRequired
+		}
+		users, err := qtx.ListUsersByEmailFold(ctx, address)
+		if err != nil {
+			return err
+		}
+		switch len(users) {
+		case 0:
+			status := auth.UserStatusUnverified
+			if claims.EmailVerified {
+				status = auth.UserStatusActive
+			}
+			// パスワードは持たない(空のハッシュはパスワードログインで必ず不一致になる)。
+			// 使いたくなったらパスワード再設定から設定してもらう
+			user, err = qtx.CreateUser(ctx, db.CreateUserParams{
+				Name:         oidcDisplayName(claims),
+				Email:        address,
+				PasswordHash: "",
+				Role:         auth.RoleMember,
+				Status:       status,
+			})
+			if err != nil {
+				return err
+			}
+			outcome = "created"
+		case 1:
+			user = users[0]
+			// 既存の会員に紐付けるのは、IdP がそのメールアドレスの所有を確認済みのときだけ。
+			// そうでなければ他人のアドレスを名乗った IdP アカウントで乗っ取れてしまう
+			if !claims.EmailVerified {
+				return errOIDCEmailConflict
+			}
+			// 管理者・スタッフは IdP 側のアカウントを乗っ取られたときの被害が大きいので、
+			// メールアドレスが一致しても自動では紐付けず、パスワードでログインしてもらう
+			if user.Role != auth.RoleMember {
+				return errOIDCLinkPassword
+			}
+			if user.Status == auth.UserStatusUnverified {
+				if err := discardUnverifiedCredentials(ctx, qtx, user.ID); err != nil {
+					return err
+				}
+				if user, err = qtx.MarkUserEmailVerified(ctx, user.ID); err != nil {
+					return err
+				}
+			}
+			outcome = "linked"
+		default:
+			// 大文字小文字だけが違うアドレスの会員が複数いると、どれに紐付けるべきか決められない
+			return errOIDCEmailConflict
+		}
+
+		_, err = qtx.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    email,
+		})
+		return err
+	})
+	return user, outcome, err
+}
+
+// discardUnverifiedCredentials はメールアドレス未確認の会員に付いている認証情報をすべて無効にする。
+// 未確認の会員は他人のアドレスで先に登録された(乗っ取りの仕込み)かもしれないので、
+// IdP でアドレスの持ち主と確認できた利用者に紐付ける前に、登録者が設定したパスワード・セッション・
+// API キー・2段階認証を消す。パスワードを使いたければパスワード再設定から設定し直してもらう
+func discardUnverifiedCredentials(ctx context.Context, qtx db.Querier, userID int64) error {
+	if err := qtx.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{ID: userID, PasswordHash: ""}); err != nil {
+		return err
+	}
+	if err := qtx.RevokeAllRefreshTokensByUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := qtx.RevokeAllAPIKeysByUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := qtx.DeleteRecoveryCodesByUser(ctx, userID); err != nil {
+		return err
+	}
+	return qtx.DeleteUserTOTP(ctx, userID)
+}
+
+// oidcDisplayName は IdP の表示名を会員名にする。無ければメールアドレスの @ より前を使う
+func oidcDisplayName(claims oidc.Claims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if utf8.RuneCountInString(name) > oidcNameMaxLength {
+		name = string([]rune(name)[:oidcNameMaxLength])
+	}
+	return name
+}
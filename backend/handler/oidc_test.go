@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/oidc"
+	"sol_coffeesys/backend/pkg/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oidcTestSuccessURL = "http://localhost:3000/"
+	oidcTestLoginURL   = "http://localhost:3000/login"
+)
+
+func newOIDCTestRouter(idp *oidctest.Server, mockDB *testutil.MockDB) *gin.Engine {
+	l := &OIDCLogin{
+		Providers: map[string]*oidc.Provider{
+			"mock": oidc.NewProvider(oidc.Config{
+				Name:         "mock",
+				Issuer:       idp.Issuer(),
+				ClientID:     idp.ClientID,
+				ClientSecret: idp.ClientSecret,
+				RedirectURL:  "http://localhost:8080/api/auth/oidc/mock/callback",
+			}, nil),
+		},
+		Sealer:     oidc.NewStateSealer([]byte("state-secret"), time.Minute),
+		SuccessURL: oidcTestSuccessURL,
+		LoginURL:   oidcTestLoginURL,
+	}
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/api/auth/oidc/:provider/login", OIDCLoginHandler(l))
+	router.GET("/api/auth/oidc/:provider/callback",
+		OIDCCallbackHandler(l, mockDB, testutil.TxRunner{Querier: mockDB}, stubTokenGenerator{token: "access"}))
+	return router
+}
+
+func findCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// runOIDCLogin はブラウザの代わりにログイン開始 → IdP の認可 → コールバックまでを辿り、コールバックの応答を返す。
+// editCallback でコールバックの URL を書き換えられる
+func runOIDCLogin(t *testing.T, router *gin.Engine, idp *oidctest.Server, editCallback func(*url.URL)) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	stateCookie := findCookie(w, oidcStateCookie)
+	require.NotNil(t, stateCookie)
+
+	callback, err := idp.Authorize(w.Header().Get("Location"))
+	require.NoError(t, err)
+	if editCallback != nil {
+		editCallback(callback)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	req.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func expectSession(m *testutil.MockDB, userID int64) {
+	m.On("GetUserTOTP", mock.Anything, userID).Return(db.UserTotp{}, sql.ErrNoRows)
+	m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(db.RefreshToken{ID: 1, UserID: userID}, nil)
+	m.On("UpdateUserLastLogin", mock.Anything, userID).Return(nil)
+}
+
+func TestOIDCCallbackHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	verified := oidctest.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: true, Name: "外部 太郎"}
+	unverified := oidctest.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: false}
+	identity := db.UserIdentity{ID: 4, UserID: 1, Provider: "mock", Subject: "sub-1"}
+	activeUser := db.User{ID: 1, Email: "user@example.com", Role: auth.RoleMember, Status: auth.UserStatusActive}
+
+	tests := []struct {
+		name          string
+		idpUser       oidctest.User
+		deny          bool
+		mutateIDToken func(jwt.MapClaims)
+		setupMock     func(*testutil.MockDB)
+		wantLocation  string
+		wantError     string
+		wantSession   bool
+	}{
+		{
+			name:    "正常系：初回ログインで会員を作成",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, db.GetUserIdentityParams{Provider: "mock", Subject: "sub-1"}).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").Return([]db.User{}, nil)
+				m.On("CreateUser", mock.Anything, db.CreateUserParams{
+					Name: "外部 太郎", Email: "user@example.com", PasswordHash: "", Role: auth.RoleMember, Status: auth.UserStatusActive,
+				}).Return(db.User{ID: 7, Email: "user@example.com", Role: auth.RoleMember, Status: auth.UserStatusActive}, nil)
+				m.On("CreateUserIdentity", mock.Anything, db.CreateUserIdentityParams{
+					UserID: 7, Provider: "mock", Subject: "sub-1", Email: sql.NullString{String: "user@example.com", Valid: true},
+				}).Return(db.UserIdentity{ID: 9}, nil)
+				expectSession(m, 7)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			name:    "正常系：IdP が未確認のメールアドレスなら unverified で作成",
+			idpUser: unverified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").Return([]db.User{}, nil)
+				m.On("CreateUser", mock.Anything, mock.MatchedBy(func(p db.CreateUserParams) bool {
+					return p.Status == auth.UserStatusUnverified && p.Name == "user"
+				})).Return(db.User{ID: 7, Role: auth.RoleMember, Status: auth.UserStatusUnverified}, nil)
+				m.On("CreateUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{ID: 9}, nil)
+				expectSession(m, 7)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			name:    "正常系：紐付け済みの外部ID",
+			idpUser: unverified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(identity, nil)
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(activeUser, nil)
+				m.On("TouchUserIdentityLogin", mock.Anything, db.TouchUserIdentityLoginParams{
+					ID: 4, Email: sql.NullString{String: "user@example.com", Valid: true},
+				}).Return(nil)
+				expectSession(m, 1)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			name:    "正常系：確認済みの会員には認証情報を残したまま紐付け",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").Return([]db.User{activeUser}, nil)
+				m.On("CreateUserIdentity", mock.Anything, mock.MatchedBy(func(p db.CreateUserIdentityParams) bool {
+					return p.UserID == 1
+				})).Return(db.UserIdentity{ID: 9}, nil)
+				expectSession(m, 1)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			// 他人のアドレスで先に登録しておく乗っ取りを防ぐため、登録者のパスワード・セッション・キーを無効にする
+			name:    "正常系：未確認の会員は認証情報を消してから紐付け、確認済みにする",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").
+					Return([]db.User{{ID: 1, Email: "user@example.com", PasswordHash: "attacker-hash", Role: auth.RoleMember, Status: auth.UserStatusUnverified}}, nil)
+				m.On("UpdateUserPassword", mock.Anything, db.UpdateUserPasswordParams{ID: 1, PasswordHash: ""}).Return(nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(1)).Return(nil)
+				m.On("RevokeAllAPIKeysByUser", mock.Anything, int64(1)).Return(nil)
+				m.On("DeleteRecoveryCodesByUser", mock.Anything, int64(1)).Return(nil)
+				m.On("DeleteUserTOTP", mock.Anything, int64(1)).Return(nil)
+				m.On("MarkUserEmailVerified", mock.Anything, int64(1)).Return(activeUser, nil)
+				m.On("CreateUserIdentity", mock.Anything, mock.MatchedBy(func(p db.CreateUserIdentityParams) bool {
+					return p.UserID == 1
+				})).Return(db.UserIdentity{ID: 9}, nil)
+				expectSession(m, 1)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			name:    "正常系：TOTP を有効にした会員は確認コードの画面へ",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(identity, nil)
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(activeUser, nil)
+				m.On("TouchUserIdentityLogin", mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).
+					Return(db.UserTotp{UserID: 1, EnabledAt: sql.NullTime{Time: time.Now(), Valid: true}}, nil)
+			},
+			wantLocation: oidcTestLoginURL + "#mfa_token=",
+		},
+		{
+			// IdP ごとの表記揺れで別の会員を作らないよう、大文字小文字を区別せずに既存の会員を探す
+			name:    "正常系：大文字を含むメールアドレスも小文字にそろえて紐付け",
+			idpUser: oidctest.User{Subject: "sub-1", Email: " User@Example.COM", EmailVerified: true},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").
+					Return([]db.User{{ID: 1, Email: "User@example.com", Role: auth.RoleMember, Status: auth.UserStatusActive}}, nil)
+				m.On("CreateUserIdentity", mock.Anything, db.CreateUserIdentityParams{
+					UserID: 1, Provider: "mock", Subject: "sub-1", Email: sql.NullString{String: "user@example.com", Valid: true},
+				}).Return(db.UserIdentity{ID: 9}, nil)
+				expectSession(m, 1)
+			},
+			wantLocation: oidcTestSuccessURL,
+			wantSession:  true,
+		},
+		{
+			name:    "異常系：スタッフ・管理者には自動で紐付けない",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").
+					Return([]db.User{{ID: 1, Email: "user@example.com", Role: auth.RoleStaff, Status: auth.UserStatusActive}}, nil)
+			},
+			wantError: oidcErrorLinkPassword,
+		},
+		{
+			name:    "異常系：大文字小文字だけ違う会員が複数いれば紐付けない",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").Return([]db.User{
+					{ID: 1, Email: "user@example.com", Role: auth.RoleMember, Status: auth.UserStatusActive},
+					{ID: 2, Email: "User@example.com", Role: auth.RoleMember, Status: auth.UserStatusActive},
+				}, nil)
+			},
+			wantError: oidcErrorEmailConflict,
+		},
+		{
+			name:    "異常系：IdP が未確認のメールアドレスでは既存の会員に紐付けない",
+			idpUser: unverified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+				m.On("ListUsersByEmailFold", mock.Anything, "user@example.com").Return([]db.User{activeUser}, nil)
+			},
+			wantError: oidcErrorEmailConflict,
+		},
+		{
+			name:    "異常系：メールアドレスが無い",
+			idpUser: oidctest.User{Subject: "sub-1"},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(db.UserIdentity{}, sql.ErrNoRows)
+			},
+			wantError: oidcErrorEmailRequired,
+		},
+		{
+			name:    "異常系：停止中の会員",
+			idpUser: verified,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserIdentity", mock.Anything, mock.Anything).Return(identity, nil)
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Status: auth.UserStatusSuspended}, nil)
+				m.On("TouchUserIdentityLogin", mock.Anything, mock.Anything).Return(nil)
+			},
+			wantError: oidcErrorAccount,
+		},
+		{
+			name:      "異常系：利用者が IdP で同意しなかった",
+			idpUser:   verified,
+			deny:      true,
+			wantError: oidcErrorDenied,
+		},
+		{
+			name:          "異常系：ID トークンの nonce が違う",
+			idpUser:       verified,
+			mutateIDToken: func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+			wantError:     oidcErrorFailed,
+		},
+		{
+			name:          "異常系：ID トークンが別のクライアント宛て",
+			idpUser:       verified,
+			mutateIDToken: func(c jwt.MapClaims) { c["aud"] = "other-client" },
+			wantError:     oidcErrorFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := oidctest.NewServer("client-1", "secret-1")
+			defer idp.Close()
+			idp.SetUser(tt.idpUser)
+			idp.SetDeny(tt.deny)
+			idp.MutateIDToken(tt.mutateIDToken)
+
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			w := runOIDCLogin(t, newOIDCTestRouter(idp, mockDB), idp, nil)
+
+			assert.Equal(t, http.StatusFound, w.Code)
+			location := w.Header().Get("Location")
+			if tt.wantError != "" {
+				assert.Equal(t, oidcTestLoginURL+"?error="+tt.wantError, location)
+			} else {
+				assert.True(t, strings.HasPrefix(location, tt.wantLocation), "Location = %s", location)
+			}
+
+			// state の Cookie は成否にかかわらず消す
+			cleared := findCookie(w, oidcStateCookie)
+			require.NotNil(t, cleared)
+			assert.Negative(t, cleared.MaxAge)
+
+			access := findCookie(w, "access_token")
+			if tt.wantSession {
+				require.NotNil(t, access)
+				assert.Equal(t, "access", access.Value)
+				assert.NotNil(t, findCookie(w, "refresh_token"))
+			} else {
+				assert.Nil(t, access)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOIDCCallbackHandler_InvalidState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := oidctest.NewServer("client-1", "secret-1")
+	defer idp.Close()
+
+	t.Run("state が Cookie と違う(ログイン CSRF)", func(t *testing.T) {
+		mockDB := new(testutil.MockDB)
+		w := runOIDCLogin(t, newOIDCTestRouter(idp, mockDB), idp, func(u *url.URL) {
+			q := u.Query()
+			q.Set("state", "attacker-state")
+			u.RawQuery = q.Encode()
+		})
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, oidcTestLoginURL+"?error="+oidcErrorInvalidState, w.Header().Get("Location"))
+		assert.Nil(t, findCookie(w, "access_token"))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("Cookie が無い", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOIDCTestRouter(idp, new(testutil.MockDB)).
+			ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?state=s&code=c", nil))
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, oidcTestLoginURL+"?error="+oidcErrorInvalidState, w.Header().Get("Location"))
+	})
+}
+
+func TestOIDCLoginHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := oidctest.NewServer("client-1", "secret-1")
+	defer idp.Close()
+	router := newOIDCTestRouter(idp, new(testutil.MockDB))
+
+	t.Run("IdP の認可画面へ PKCE 付きでリダイレクト", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+
+		require.Equal(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, idp.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+		assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+		assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+		assert.NotEmpty(t, u.Query().Get("nonce"))
+
+		c := findCookie(w, oidcStateCookie)
+		require.NotNil(t, c)
+		assert.True(t, c.HttpOnly)
+		assert.Equal(t, "/api/auth/oidc", c.Path)
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+		// Cookie に code_verifier をそのまま置かない(署名付きの値のみ)
+		assert.NotContains(t, c.Value, u.Query().Get("state"))
+	})
+
+	t.Run("設定に無い IdP は 404", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/unknown/login", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		var resp map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, apperror.NotFoundMessageOIDC, resp["error"])
+	})
+
+	t.Run("IdP に接続できなければログイン画面へ戻す", func(t *testing.T) {
+		down := oidctest.NewServer("client-1", "secret-1")
+		down.Close()
+		w := httptest.NewRecorder()
+		newOIDCTestRouter(down, new(testutil.MockDB)).
+			ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, oidcTestLoginURL+"?error="+oidcErrorUnavailable, w.Header().Get("Location"))
+		assert.Nil(t, findCookie(w, oidcStateCookie))
+	})
+}
@@ -1,16 +1,21 @@
 package handler
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/txn"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,6 +44,12 @@ func createOrderLogic(ctx context.Context, qtx db.Querier, userID int64) (*db.Cr
 		total += item.Price
 	}
 
+	// ロック順序を商品ID昇順に固定し、複数商品の注文同士でデッドロックしないようにする
+	items = slices.Clone(items)
+	slices.SortFunc(items, func(a, b db.ListCartItemsByUserRow) int {
+		return cmp.Compare(a.ProductID, b.ProductID)
+	})
+
 	// 各商品の検証 - 在庫確認
 	for _, item := range items {
 		// 商品情報を取得
@@ -59,7 +70,7 @@ func createOrderLogic(ctx context.Context, qtx db.Querier, userID int64) (*db.Cr
 	order, err := qtx.CreateOrder(ctx, db.CreateOrderParams{
 		UserID: userID,
 		Total:  total,
-		Status: "pending",
+		Status: OrderStatusPending,
 	})
 	if err != nil {
 		return nil, err
@@ -95,54 +106,23 @@ func createOrderLogic(ctx context.Context, qtx db.Querier, userID int64) (*db.Cr
 	return &order, nil
 }
 
-func CreateOrderHandler(conn *sql.DB, queries *db.Queries) gin.HandlerFunc {
+func CreateOrderHandler(runner txn.Runner) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		raw, exists := c.Get("userID")
-		if !exists {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		var userID int64
-		switch v := raw.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("userID_parse_failed", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		tx, err := conn.BeginTx(c.Request.Context(), nil)
+		principal, err := auth.PrincipalFrom(c)
 		if err != nil {
-			_ = c.Error(apperror.NewInternalError("BeginTx", err, apperror.InternalServerMessageCommon))
+			_ = c.Error(err)
 			return
 		}
 
-		qtx := queries.WithTx(tx)
-		order, err := createOrderLogic(c.Request.Context(), qtx, userID)
+		// 同時注文でデッドロック・直列化失敗になった場合はトランザクションごとやり直す
+		var order *db.CreateOrderRow
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			var err error
+			order, err = createOrderLogic(c.Request.Context(), qtx, principal.UserID)
+			return err
+		}, txn.WithRetry(orderTxMaxAttempts), txn.OnRetry(logTxRetry("CreateOrder")))
 		if err != nil {
-			_ = tx.Rollback()
-
-			var ve *apperror.ValidationError
-			var ce *apperror.ConflictError
-			var ne *apperror.NotFoundError
-			var be *apperror.BusinessLogicError
-
-			if errors.As(err, &ve) || errors.As(err, &ne) || errors.As(err, &ce) || errors.As(err, &be) {
-				_ = c.Error(err)
-				return
-			}
-
-			_ = c.Error(apperror.NewInternalError("CreateOrder", err, apperror.InternalServerMessageCommon))
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			_ = c.Error(apperror.NewInternalError("Commit", err, apperror.InternalServerMessageCommon))
+			_ = c.Error(txError("CreateOrder", err))
 			return
 		}
 		c.JSON(http.StatusCreated, gin.H{"order": order})
@@ -168,28 +148,20 @@ func cancelOrderLogic(ctx context.Context, qtx db.Querier, orderID int64, userID
 		return nil, apperror.NewNotFoundError("order", orderID, "")
 	}
 
-	if ord.Status != "pending" {
-		return nil, apperror.NewBusinessLogicError("この注文はキャンセルできません")
+	if !canTransitionOrder(ord.Status, OrderStatusCancelled) {
+		return nil, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageCancel)
 	}
-
-	items, err := qtx.ListOrderItemsByOrderID(ctx, orderID)
-	if err != nil {
+	if err := ensureNoPaymentInFlight(ctx, qtx, orderID); err != nil {
 		return nil, err
 	}
 
-	for _, it := range items {
-		_, err := qtx.UpdateProductStock(ctx, db.UpdateProductStockParams{
-			ID:            it.ProductID,
-			StockQuantity: it.Quantity,
-		})
-		if err != nil {
-			return nil, err
-		}
+	if err := restoreOrderStock(ctx, qtx, orderID); err != nil {
+		return nil, err
 	}
 
 	updated, err := qtx.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
 		ID:     orderID,
-		Status: "cancelled",
+		Status: OrderStatusCancelled,
 	})
 	if err != nil {
 		return nil, err
@@ -198,7 +170,7 @@ func cancelOrderLogic(ctx context.Context, qtx db.Querier, orderID int64, userID
 	return &updated, nil
 }
 
-func CancelOrderHandler(conn *sql.DB, queries *db.Queries) gin.HandlerFunc {
+func CancelOrderHandler(runner txn.Runner) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		orderIDParam := c.Param("id")
 		if orderIDParam == "" {
@@ -211,51 +183,20 @@ func CancelOrderHandler(conn *sql.DB, queries *db.Queries) gin.HandlerFunc {
 			return
 		}
 
-		raw, exists := c.Get("userID")
-		if !exists {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		var userID int64
-		switch v := raw.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		tx, err := conn.BeginTx(c.Request.Context(), nil)
+		principal, err := auth.PrincipalFrom(c)
 		if err != nil {
-			_ = c.Error(apperror.NewInternalError("BeginTx", err, apperror.InternalServerMessageCommon))
+			_ = c.Error(err)
 			return
 		}
 
-		qtx := queries.WithTx(tx)
-		updated, err := cancelOrderLogic(c.Request.Context(), qtx, orderID, userID)
+		var updated *db.UpdateOrderStatusRow
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			var err error
+			updated, err = cancelOrderLogic(c.Request.Context(), qtx, orderID, principal.UserID)
+			return err
+		})
 		if err != nil {
-			_ = tx.Rollback()
-
-			var ve *apperror.ValidationError
-			var ce *apperror.ConflictError
-			var ne *apperror.NotFoundError
-			var be *apperror.BusinessLogicError
-
-			if errors.As(err, &ve) || errors.As(err, &ne) || errors.As(err, &ce) || errors.As(err, &be) {
-				_ = c.Error(err)
-				return
-			}
-			_ = c.Error(apperror.NewInternalError("CancelOrder", err, apperror.InternalServerMessageCommon))
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			_ = c.Error(apperror.NewInternalError("Commit", err, apperror.InternalServerMessageCommon))
+			_ = c.Error(txError("CancelOrder", err))
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"order": updated})
@@ -273,28 +214,82 @@ type OrderWithItems struct {
 	Items []db.OrderItem         `json:"items"`
 }
 
-func getOrderLogic(ctx context.Context, qtx db.Querier, userID int64) ([]OrderWithItems, error) {
-	orders, err := qtx.ListOrdersByUser(ctx, userID)
+// 注文一覧の絞り込み条件。Cursor が nil なら先頭ページ
+type listOrdersFilter struct {
+	Status string
+	From   *time.Time
+	To     *time.Time
+	Cursor *orderCursor
+	Limit  int32
+}
+
+// 直前ページ末尾の注文の (created_at, id)
+type orderCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        int64     `json:"id"`
+}
+
+type orderPage struct {
+	Orders     []OrderWithItems
+	NextCursor *orderCursor
+}
+
+func getOrderLogic(ctx context.Context, qtx db.Querier, userID int64, filter listOrdersFilter) (*orderPage, error) {
+	params := db.ListOrdersByUserParams{
+		UserID: userID,
+		Status: sql.NullString{String: filter.Status, Valid: filter.Status != ""},
+		// 次ページの有無を判定するため1件多く取得する
+		PageLimit: filter.Limit + 1,
+	}
+	if filter.From != nil {
+		params.CreatedFrom = sql.NullTime{Time: *filter.From, Valid: true}
+	}
+	if filter.To != nil {
+		params.CreatedTo = sql.NullTime{Time: *filter.To, Valid: true}
+	}
+	if filter.Cursor != nil {
+		params.CursorCreatedAt = sql.NullTime{Time: filter.Cursor.CreatedAt, Valid: true}
+		params.CursorID = sql.NullInt64{Int64: filter.Cursor.ID, Valid: true}
+	}
+
+	orders, err := qtx.ListOrdersByUser(ctx, params)
 	if err != nil {
 		return nil, err
 	}
-	res := make([]OrderWithItems, 0, len(orders))
+
+	page := &orderPage{}
+	if len(orders) > int(filter.Limit) {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		page.NextCursor = &orderCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	page.Orders = make([]OrderWithItems, 0, len(orders))
+	if len(orders) == 0 {
+		return page, nil
+	}
+
+	// 明細は注文IDの配列でまとめて取得する
+	orderIDs := make([]int64, 0, len(orders))
 	for _, order := range orders {
-		items, err := qtx.ListOrderItemsByOrderID(ctx, order.ID)
-		if err != nil {
-			return nil, err
-		}
-		res = append(res, OrderWithItems{
+		orderIDs = append(orderIDs, order.ID)
+	}
+	items, err := qtx.ListOrderItemsByOrderIDs(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	itemsByOrder := make(map[int64][]db.OrderItem, len(orders))
+	for _, it := range items {
+		itemsByOrder[it.OrderID] = append(itemsByOrder[it.OrderID], it)
+	}
+
+	for _, order := range orders {
+		page.Orders = append(page.Orders, OrderWithItems{
 			Order: order,
-			Items: items,
+			Items: itemsByOrder[order.ID],
 		})
 	}
-	return res, nil
-}
-
-var validOrderStatuses = map[string]struct{}{
-	"pending":   {},
-	"cancelled": {},
+	return page, nil
 }
 
 func isValidOrderStatus(status string) bool {
@@ -307,22 +302,9 @@ func isValidOrderStatus(status string) bool {
 
 func GetOrdersHandler(queries db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		raw, exists := c.Get("userID")
-		if !exists {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
-			return
-		}
-
-		var userID int64
-		switch v := raw.(type) {
-		case int64:
-			userID = v
-		case int:
-			userID = int64(v)
-		case float64:
-			userID = int64(v)
-		default:
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
 
@@ -332,24 +314,62 @@ func GetOrdersHandler(queries db.Querier) gin.HandlerFunc {
 			return
 		}
 
-		orders, err := getOrderLogic(c.Request.Context(), queries, userID)
+		limit, err := parsePageLimit(c.Query("limit"))
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		from, err := parseTimeQuery("from", c.Query("from"))
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		to, err := parseTimeQuery("to", c.Query("to"))
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		if from != nil && to != nil && !from.Before(*to) {
+			_ = c.Error(apperror.NewValidationError("to", c.Query("to"), "range", apperror.ValidationMessageDateRange))
+			return
+		}
+
+		filter := listOrdersFilter{
+			Status: status,
+			From:   from,
+			To:     to,
+			Limit:  limit,
+		}
+		if raw := c.Query("cursor"); raw != "" {
+			var cur orderCursor
+			if err := decodeCursor(raw, &cur); err != nil {
+				_ = c.Error(err)
+				return
+			}
+			filter.Cursor = &cur
+		}
+
+		page, err := getOrderLogic(c.Request.Context(), queries, principal.UserID, filter)
 		if err != nil {
 			_ = c.Error(apperror.NewInternalError("GetOrders", err, apperror.InternalServerMessageCommon))
 			return
 		}
 
-		var filtered []OrderWithItems
-		if status == "" {
-			filtered = orders
-		} else {
-			for _, order := range orders {
-				if order.Order.Status == status {
-					filtered = append(filtered, order)
-				}
+		var nextCursor *string
+		if page.NextCursor != nil {
+			encoded, err := encodeCursor(page.NextCursor)
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("EncodeCursor", err, apperror.InternalServerMessageCommon))
+				return
 			}
+			nextCursor = &encoded
 		}
 
-		c.JSON(http.StatusOK, gin.H{"orders": filtered})
+		c.JSON(http.StatusOK, gin.H{
+			"orders":      page.Orders,
+			"next_cursor": nextCursor,
+		})
 
 		logging.LogEvent(c, logging.EventInput{
 			Event:  "orders_listed",
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/payment"
+	"sol_coffeesys/backend/pkg/txn"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusPreparing = "preparing"
+	OrderStatusReady     = "ready"
+	OrderStatusCompleted = "completed"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// 注文ステータスの遷移表。キーが現在のステータス、値が遷移可能な次のステータス。
+// cancelled / refunded は終端状態。
+// pending→paid は決済の成功(payOrderLogic)でのみ遷移し、ステータス更新では変えられない。
+// →refunded は決済サービスでの返金(refundOrderLogic)を通す。
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusPreparing, OrderStatusRefunded},
+	OrderStatusPreparing: {OrderStatusReady, OrderStatusRefunded},
+	OrderStatusReady:     {OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusCompleted: {OrderStatusRefunded},
+	OrderStatusCancelled: {},
+	OrderStatusRefunded:  {},
+}
+
+var validOrderStatuses = map[string]struct{}{
+	OrderStatusPending:   {},
+	OrderStatusPaid:      {},
+	OrderStatusPreparing: {},
+	OrderStatusReady:     {},
+	OrderStatusCompleted: {},
+	OrderStatusCancelled: {},
+	OrderStatusRefunded:  {},
+}
+
+func canTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 在庫を引き当て済みの注文を取り消すときは在庫を戻す
+func restoreOrderStock(ctx context.Context, qtx db.Querier, orderID int64) error {
+	items, err := qtx.ListOrderItemsByOrderID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	// 注文作成と同じく商品ID昇順で更新し、ロック順序を揃える
+	slices.SortFunc(items, func(a, b db.OrderItem) int {
+		return cmp.Compare(a.ProductID, b.ProductID)
+	})
+	for _, it := range items {
+		_, err := qtx.UpdateProductStock(ctx, db.UpdateProductStockParams{
+			ID:            it.ProductID,
+			StockQuantity: it.Quantity,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateOrderStatusLogic(ctx context.Context, qtx db.Querier, orderID int64, next string) (*db.UpdateOrderStatusRow, error) {
+	if _, ok := validOrderStatuses[next]; !ok {
+		return nil, apperror.NewValidationError("status", next, "", apperror.ValidationMessageStatus)
+	}
+
+	ord, err := qtx.GetOrderByIDForUpdate(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperror.NewNotFoundError("order", orderID, "")
+		}
+		return nil, err
+	}
+
+	// 返金は決済サービスを呼ぶため refundOrderLogic で扱う
+	if next == OrderStatusRefunded || !canTransitionOrder(ord.Status, next) {
+		return nil, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageOrderStatus)
+	}
+
+	if next == OrderStatusCancelled {
+		if err := ensureNoPaymentInFlight(ctx, qtx, orderID); err != nil {
+			return nil, err
+		}
+		if err := restoreOrderStock(ctx, qtx, orderID); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := qtx.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
+		ID:     orderID,
+		Status: next,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// authorizeRefund は返金できるのをログイン中の管理者に限る。
+// 注文の状態更新はスタッフや POS 連携の API キーにも許可しているが、返金は売上を戻すため任せない
+func authorizeRefund(p auth.Principal) error {
+	if p.APIKeyID != 0 {
+		return apperror.NewAccountForbiddenError(apperror.ForbiddenReasonAPIKeyScope, apperror.ForbiddenMessageAPIKeyScope)
+	}
+	if p.Role != auth.RoleAdmin {
+		return apperror.NewForbiddenError(auth.RoleAdmin, p.Role, apperror.ForbiddenMessageAdmin)
+	}
+	return nil
+}
+
+type updateOrderStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// ＋＋注文ステータス更新機能（管理者）＋＋
+func UpdateOrderStatusHandler(runner txn.Runner, provider payment.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError("order", nil, "", apperror.ValidationMessageOrder))
+			return
+		}
+
+		var req updateOrderStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var updated *db.UpdateOrderStatusRow
+		if req.Status == OrderStatusRefunded {
+			if err := authorizeRefund(principal); err != nil {
+				_ = c.Error(err)
+				return
+			}
+			updated, err = refundOrderLogic(c.Request.Context(), runner, provider, orderID)
+		} else {
+			err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+				var err error
+				updated, err = updateOrderStatusLogic(c.Request.Context(), qtx, orderID, req.Status)
+				return err
+			})
+		}
+		if err != nil {
+			_ = c.Error(txError("UpdateOrderStatus", err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order": updated})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "order_status_updated",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra: []slog.Attr{
+				slog.Int64("order_id", orderID),
+				slog.String("order_status", updated.Status),
+			},
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/payment"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: OrderStatusPending, to: OrderStatusPaid, want: false},
+		{from: OrderStatusPending, to: OrderStatusCancelled, want: true},
+		{from: OrderStatusPending, to: OrderStatusPreparing, want: false},
+		{from: OrderStatusPaid, to: OrderStatusPreparing, want: true},
+		{from: OrderStatusPaid, to: OrderStatusCancelled, want: false},
+		{from: OrderStatusPaid, to: OrderStatusRefunded, want: true},
+		{from: OrderStatusPreparing, to: OrderStatusReady, want: true},
+		{from: OrderStatusReady, to: OrderStatusCompleted, want: true},
+		{from: OrderStatusReady, to: OrderStatusPaid, want: false},
+		{from: OrderStatusCompleted, to: OrderStatusRefunded, want: true},
+		{from: OrderStatusCancelled, to: OrderStatusPending, want: false},
+		{from: OrderStatusRefunded, to: OrderStatusCompleted, want: false},
+		{from: "unknown", to: OrderStatusPaid, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.want, canTransitionOrder(tt.from, tt.to))
+		})
+	}
+}
+
+func TestUpdateOrderStatusLogic(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		orderID     int64
+		next        string
+		setupMock   func(*testutil.MockDB)
+		expectedErr string
+		checkErr    func(*testing.T, error)
+	}{
+		{
+			name:    "U1: paid -> preparing",
+			orderID: 1,
+			next:    OrderStatusPreparing,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(1)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 1, UserID: 1, Total: 1500, Status: OrderStatusPaid, CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 1, Status: OrderStatusPreparing}).Return(
+					db.UpdateOrderStatusRow{ID: 1, UserID: 1, Total: 1500, Status: OrderStatusPreparing, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+		},
+		{
+			name:    "U2: pending -> cancelled は在庫を戻す",
+			orderID: 2,
+			next:    OrderStatusCancelled,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(2)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 2, UserID: 1, Total: 1500, Status: OrderStatusPending, CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(2)).Return(db.Payment{}, sql.ErrNoRows)
+				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
+					[]db.OrderItem{{ID: 1, OrderID: 2, ProductID: 100, Quantity: 2, UnitPrice: 750, CreatedAt: now, UpdatedAt: now}}, nil)
+				m.On("UpdateProductStock", mock.Anything, db.UpdateProductStockParams{ID: 100, StockQuantity: 2}).Return(
+					db.UpdateProductStockRow{ID: 100, StockQuantity: 12}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 2, Status: OrderStatusCancelled}).Return(
+					db.UpdateOrderStatusRow{ID: 2, UserID: 1, Total: 1500, Status: OrderStatusCancelled, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+		},
+		{
+			name:    "U3: 不正な遷移 ready -> paid",
+			orderID: 3,
+			next:    OrderStatusPaid,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(3)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 3, UserID: 1, Total: 1500, Status: OrderStatusReady, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			checkErr: func(t *testing.T, err error) {
+				var be *apperror.BusinessLogicError
+				assert.True(t, errors.As(err, &be))
+				assert.Equal(t, apperror.BusinessLogicMessageOrderStatus, be.Message)
+			},
+		},
+		{
+			name:    "U3-2: 返金はステータス更新では行わない",
+			orderID: 7,
+			next:    OrderStatusRefunded,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(7)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 7, UserID: 1, Total: 1500, Status: OrderStatusPaid, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			expectedErr: apperror.BusinessLogicMessageOrderStatus,
+		},
+		{
+			name:    "U3-3: 決済なしに pending -> paid にはできない",
+			orderID: 8,
+			next:    OrderStatusPaid,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(8)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 8, UserID: 1, Total: 1500, Status: OrderStatusPending, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			expectedErr: apperror.BusinessLogicMessageOrderStatus,
+		},
+		{
+			name:    "U4: 未知のステータス",
+			orderID: 4,
+			next:    "shipped",
+			checkErr: func(t *testing.T, err error) {
+				var ve *apperror.ValidationError
+				assert.True(t, errors.As(err, &ve))
+				assert.Equal(t, "status", ve.Field)
+				assert.Equal(t, apperror.ValidationMessageStatus, ve.Message)
+			},
+		},
+		{
+			name:    "U5: 注文なし",
+			orderID: 5,
+			next:    OrderStatusPreparing,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(5)).Return(
+					db.GetOrderByIDForUpdateRow{}, sql.ErrNoRows)
+			},
+			checkErr: func(t *testing.T, err error) {
+				var ne *apperror.NotFoundError
+				assert.True(t, errors.As(err, &ne))
+				assert.Equal(t, "order", ne.Resource)
+			},
+		},
+		{
+			name:    "U6: DBエラー UpdateOrderStatus",
+			orderID: 6,
+			next:    OrderStatusReady,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(6)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 6, UserID: 1, Total: 1500, Status: OrderStatusPreparing, CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 6, Status: OrderStatusReady}).Return(
+					db.UpdateOrderStatusRow{}, errors.New("db error"))
+			},
+			expectedErr: "db error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			result, err := updateOrderStatusLogic(context.Background(), mockDB, tt.orderID, tt.next)
+			switch {
+			case tt.checkErr != nil:
+				assert.Error(t, err)
+				tt.checkErr(t, err)
+			case tt.expectedErr != "":
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			default:
+				assert.NoError(t, err)
+				if assert.NotNil(t, result) {
+					assert.Equal(t, tt.next, result.Status)
+				}
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+// 返金はログイン中の管理者だけができる。スタッフと API キーは決済サービスを呼ぶ前に 403
+func TestUpdateOrderStatusHandler_RefundRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+	}{
+		{name: "スタッフ", principal: auth.Principal{UserID: 2, Role: auth.RoleStaff}},
+		{name: "スタッフのAPIキー", principal: auth.Principal{UserID: 2, Role: auth.RoleStaff, APIKeyID: 5}},
+		{name: "管理者のAPIキー", principal: auth.Principal{UserID: 1, Role: auth.RoleAdmin, APIKeyID: 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.PATCH("/api/admin/orders/:id/status", func(c *gin.Context) {
+				auth.SetPrincipal(c, tt.principal)
+				UpdateOrderStatusHandler(testutil.TxRunner{Querier: mockDB}, payment.FakeProvider{})(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/admin/orders/1/status", bytes.NewBufferString(`{"status":"refunded"}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
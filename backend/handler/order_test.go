@@ -7,10 +7,13 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler/testutil"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/payment"
+	"sol_coffeesys/backend/pkg/txn"
 	"testing"
 	"time"
 
@@ -527,6 +530,37 @@ func TestCreateOrderLogic(t *testing.T) {
 	}
 }
 
+func TestCreateOrderLogic_LocksProductsInIDOrder(t *testing.T) {
+	now := time.Now()
+	mockDB := new(testutil.MockDB)
+	mockDB.On("GetOrCreateCartForUser", mock.Anything, int64(1)).Return(db.Cart{ID: 10, UserID: 1}, nil)
+	// カートの並びは商品ID降順
+	mockDB.On("ListCartItemsByUser", mock.Anything, int64(1)).Return(
+		[]db.ListCartItemsByUserRow{
+			{ID: 3, CartID: 10, ProductID: 300, Quantity: 1, Price: 500, ProductName: "C", ProductPrice: 500, CreatedAt: now, UpdatedAt: now},
+			{ID: 2, CartID: 10, ProductID: 200, Quantity: 1, Price: 500, ProductName: "B", ProductPrice: 500, CreatedAt: now, UpdatedAt: now},
+			{ID: 1, CartID: 10, ProductID: 100, Quantity: 1, Price: 500, ProductName: "A", ProductPrice: 500, CreatedAt: now, UpdatedAt: now},
+		}, nil)
+
+	var locked, updated []int64
+	mockDB.On("GetProductForUpdate", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		locked = append(locked, args.Get(1).(int64))
+	}).Return(db.Product{StockQuantity: 10}, nil)
+	mockDB.On("CreateOrder", mock.Anything, mock.Anything).Return(db.CreateOrderRow{ID: 1, UserID: 1, Total: 1500, Status: OrderStatusPending}, nil)
+	mockDB.On("CreateOrderItem", mock.Anything, mock.Anything).Return(db.OrderItem{}, nil)
+	mockDB.On("UpdateProductStock", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		updated = append(updated, args.Get(1).(db.UpdateProductStockParams).ID)
+	}).Return(db.UpdateProductStockRow{}, nil)
+	mockDB.On("ClearCartByUser", mock.Anything, int64(1)).Return(nil)
+
+	_, err := createOrderLogic(context.Background(), mockDB, 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 200, 300}, locked)
+	assert.Equal(t, []int64{100, 200, 300}, updated)
+	mockDB.AssertExpectations(t)
+}
+
 func TestCancelOrderLogic(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -546,6 +580,7 @@ func TestCancelOrderLogic(t *testing.T) {
 					db.GetOrderByIDForUpdateRow{
 						ID: 1, UserID: 1, Total: 1500, Status: "pending", CreatedAt: now, UpdatedAt: now,
 					}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(1)).Return(db.Payment{}, sql.ErrNoRows)
 				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
 					[]db.OrderItem{
 						{
@@ -567,6 +602,7 @@ func TestCancelOrderLogic(t *testing.T) {
 				now := time.Now()
 				m.On("GetOrderByIDForUpdate", mock.Anything, int64(2)).Return(
 					db.GetOrderByIDForUpdateRow{ID: 2, UserID: 2, Total: 3000, Status: "pending", CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(2)).Return(db.Payment{}, sql.ErrNoRows)
 				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
 					[]db.OrderItem{
 						{ID: 1, OrderID: 2, ProductID: 101, Quantity: 1, UnitPrice: 1000, CreatedAt: now, UpdatedAt: now},
@@ -629,6 +665,7 @@ func TestCancelOrderLogic(t *testing.T) {
 				now := time.Now()
 				m.On("GetOrderByIDForUpdate", mock.Anything, int64(20)).Return(
 					db.GetOrderByIDForUpdateRow{ID: 20, UserID: 5, Total: 800, Status: "pending", CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(20)).Return(db.Payment{}, sql.ErrNoRows)
 				m.On("ListOrderItemsByOrderID", mock.Anything, int64(20)).Return(
 					[]db.OrderItem{
 						{ID: 1, OrderID: 20, ProductID: 200, Quantity: 1, UnitPrice: 800, CreatedAt: now, UpdatedAt: now},
@@ -646,6 +683,7 @@ func TestCancelOrderLogic(t *testing.T) {
 				now := time.Now()
 				m.On("GetOrderByIDForUpdate", mock.Anything, int64(21)).Return(
 					db.GetOrderByIDForUpdateRow{ID: 21, UserID: 6, Total: 1200, Status: "pending", CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(21)).Return(db.Payment{}, sql.ErrNoRows)
 				m.On("ListOrderItemsByOrderID", mock.Anything, int64(21)).Return(
 					[]db.OrderItem{
 						{ID: 1, OrderID: 21, ProductID: 201, Quantity: 1, UnitPrice: 1200, CreatedAt: now, UpdatedAt: now},
@@ -657,6 +695,23 @@ func TestCancelOrderLogic(t *testing.T) {
 			},
 			expectedErr: "update status error",
 		},
+		{
+			name:    "U8: 結果待ちの決済があるとキャンセルできない",
+			orderID: 22,
+			userID:  6,
+			setupMock: func(m *testutil.MockDB) {
+				now := time.Now()
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(22)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 22, UserID: 6, Total: 1200, Status: "pending", CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(22)).Return(
+					db.Payment{ID: 220, OrderID: 22, Amount: 1200, Status: payment.StatusPending, Attempt: 1}, nil)
+			},
+			checkErr: func(t *testing.T, err error) {
+				var be *apperror.BusinessLogicError
+				assert.True(t, errors.As(err, &be))
+				assert.Equal(t, apperror.BusinessLogicMessagePaying, be.Message)
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -699,7 +754,7 @@ func TestGetOrderLogic(t *testing.T) {
 			expectedItems:  1,
 			setupMock: func(m *testutil.MockDB) {
 				now := time.Now()
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
+				m.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 21}).Return(
 					[]db.ListOrdersByUserRow{
 						{
 							ID:        1,
@@ -710,7 +765,7 @@ func TestGetOrderLogic(t *testing.T) {
 							UpdatedAt: now,
 						},
 					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{1}).Return(
 					[]db.OrderItem{
 						{
 							ID:        1,
@@ -731,7 +786,7 @@ func TestGetOrderLogic(t *testing.T) {
 			expectedOrders: 0,
 			expectedItems:  0,
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
+				m.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 21}).Return(
 					[]db.ListOrdersByUserRow{}, nil)
 			},
 		},
@@ -742,7 +797,7 @@ func TestGetOrderLogic(t *testing.T) {
 			expectedOrders: 0,
 			expectedItems:  0,
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
+				m.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 21}).Return(
 					[]db.ListOrdersByUserRow{}, errors.New("db error"))
 			},
 		},
@@ -754,7 +809,7 @@ func TestGetOrderLogic(t *testing.T) {
 			expectedItems:  0,
 			setupMock: func(m *testutil.MockDB) {
 				now := time.Now()
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
+				m.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 21}).Return(
 					[]db.ListOrdersByUserRow{
 						{
 							ID:        1,
@@ -765,7 +820,7 @@ func TestGetOrderLogic(t *testing.T) {
 							UpdatedAt: now,
 						},
 					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{1}).Return(
 					[]db.OrderItem{}, errors.New("db error"))
 			},
 		},
@@ -781,14 +836,16 @@ func TestGetOrderLogic(t *testing.T) {
 			}
 
 			ctx := context.Background()
-			owi, err := getOrderLogic(ctx, mockDB, tt.userID)
+			page, err := getOrderLogic(ctx, mockDB, tt.userID, listOrdersFilter{Limit: 20})
 
 			if tt.expectedErr != "" {
 				assert.Error(t, err, tt.name)
 				assert.Contains(t, err.Error(), tt.expectedErr)
 			} else {
 				assert.NoError(t, err, tt.name)
+				owi := page.Orders
 				assert.Len(t, owi, tt.expectedOrders)
+				assert.Nil(t, page.NextCursor)
 				if tt.expectedOrders > 0 {
 					assert.Equal(t, int64(1), owi[0].Order.ID)
 					assert.Len(t, owi[0].Items, tt.expectedItems)
@@ -801,19 +858,116 @@ func TestGetOrderLogic(t *testing.T) {
 	}
 }
 
+func TestGetOrderLogic_GroupsItemsByOrder(t *testing.T) {
+	now := time.Now()
+	mockDB := new(testutil.MockDB)
+	mockDB.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 21}).Return(
+		[]db.ListOrdersByUserRow{
+			{ID: 3, UserID: 1, Total: 2250, Status: "pending", CreatedAt: now, UpdatedAt: now},
+			{ID: 2, UserID: 1, Total: 500, Status: "pending", CreatedAt: now, UpdatedAt: now},
+			{ID: 1, UserID: 1, Total: 1500, Status: "pending", CreatedAt: now, UpdatedAt: now},
+		}, nil)
+	// 明細は order_id, id 順で返る
+	mockDB.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{3, 2, 1}).Return(
+		[]db.OrderItem{
+			{ID: 11, OrderID: 1, ProductID: 100, Quantity: 2, UnitPrice: 750, CreatedAt: now, UpdatedAt: now},
+			{ID: 31, OrderID: 3, ProductID: 100, Quantity: 1, UnitPrice: 750, CreatedAt: now, UpdatedAt: now},
+			{ID: 32, OrderID: 3, ProductID: 200, Quantity: 1, UnitPrice: 1500, CreatedAt: now, UpdatedAt: now},
+		}, nil)
+
+	page, err := getOrderLogic(context.Background(), mockDB, 1, listOrdersFilter{Limit: 20})
+
+	assert.NoError(t, err)
+	owi := page.Orders
+	if assert.Len(t, owi, 3) {
+		// 注文の並び順は ListOrdersByUser のまま
+		assert.Equal(t, int64(3), owi[0].Order.ID)
+		assert.Equal(t, int64(2), owi[1].Order.ID)
+		assert.Equal(t, int64(1), owi[2].Order.ID)
+
+		assert.Len(t, owi[0].Items, 2)
+		assert.Equal(t, int64(31), owi[0].Items[0].ID)
+		assert.Equal(t, int64(32), owi[0].Items[1].ID)
+		assert.Empty(t, owi[1].Items)
+		assert.Len(t, owi[2].Items, 1)
+		assert.Equal(t, int64(11), owi[2].Items[0].ID)
+	}
+	mockDB.AssertNumberOfCalls(t, "ListOrderItemsByOrderIDs", 1)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetOrderLogic_Pagination(t *testing.T) {
+	now := time.Now()
+	cursorAt := now.Add(-time.Hour)
+	from := now.Add(-24 * time.Hour)
+	to := now
+
+	mockDB := new(testutil.MockDB)
+	mockDB.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{
+		UserID:          1,
+		Status:          sql.NullString{String: "paid", Valid: true},
+		CreatedFrom:     sql.NullTime{Time: from, Valid: true},
+		CreatedTo:       sql.NullTime{Time: to, Valid: true},
+		CursorCreatedAt: sql.NullTime{Time: cursorAt, Valid: true},
+		CursorID:        sql.NullInt64{Int64: 10, Valid: true},
+		PageLimit:       3,
+	}).Return(
+		[]db.ListOrdersByUserRow{
+			{ID: 9, UserID: 1, Total: 500, Status: "paid", CreatedAt: cursorAt.Add(-time.Minute), UpdatedAt: now},
+			{ID: 8, UserID: 1, Total: 500, Status: "paid", CreatedAt: cursorAt.Add(-2 * time.Minute), UpdatedAt: now},
+			{ID: 7, UserID: 1, Total: 500, Status: "paid", CreatedAt: cursorAt.Add(-3 * time.Minute), UpdatedAt: now},
+		}, nil)
+	// limit+1件目の注文の明細は取得しない
+	mockDB.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{9, 8}).Return([]db.OrderItem{}, nil)
+
+	page, err := getOrderLogic(context.Background(), mockDB, 1, listOrdersFilter{
+		Status: "paid",
+		From:   &from,
+		To:     &to,
+		Cursor: &orderCursor{CreatedAt: cursorAt, ID: 10},
+		Limit:  2,
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Orders, 2)
+	if assert.NotNil(t, page.NextCursor) {
+		assert.Equal(t, int64(8), page.NextCursor.ID)
+		assert.True(t, page.NextCursor.CreatedAt.Equal(cursorAt.Add(-2*time.Minute)))
+	}
+	mockDB.AssertExpectations(t)
+}
+
 func TestGetOrdersHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	now := time.Now()
 
+	order := func(id int64, total int64, status string) db.ListOrdersByUserRow {
+		return db.ListOrdersByUserRow{ID: id, UserID: 1, Total: total, Status: status, CreatedAt: now, UpdatedAt: now}
+	}
+	item := func(id, orderID, productID int64, qty int32, price int64) db.OrderItem {
+		return db.OrderItem{ID: id, OrderID: orderID, ProductID: productID, Quantity: qty, UnitPrice: price, CreatedAt: now, UpdatedAt: now}
+	}
+	firstPage := db.ListOrdersByUserParams{UserID: 1, PageLimit: defaultPageLimit + 1}
+	withStatus := func(status string) db.ListOrdersByUserParams {
+		p := firstPage
+		p.Status = sql.NullString{String: status, Valid: true}
+		return p
+	}
+
+	cursorAt := now.Add(-time.Hour).UTC()
+	validCursor, err := encodeCursor(orderCursor{CreatedAt: cursorAt, ID: 5})
+	assert.NoError(t, err)
+
 	tests := []struct {
 		name                        string
 		query                       string
-		userID                      any
+		userID                      int64
 		setupMock                   func(*testutil.MockDB)
 		expectedStatus              int
 		expectedCount               int
 		expectedErrMsg              string
 		expectedProductNameSnapshot string
+		expectedNextCursor          bool
 	}{
 		{
 			name:           "U1: 注文確認成功 フィルタなし",
@@ -822,103 +976,23 @@ func TestGetOrdersHandler(t *testing.T) {
 			query:          "",
 			userID:         int64(1),
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				m.On("ListOrdersByUser", mock.Anything, firstPage).Return(
+					[]db.ListOrdersByUserRow{order(1, 1500, "pending"), order(2, 3000, "pending")}, nil)
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{1, 2}).Return(
+					[]db.OrderItem{item(11, 1, 100, 2, 750), item(21, 2, 200, 3, 1000)}, nil)
 			},
 		},
 		{
-			name:           "U2: 注文確認成功 フィルタ=pending",
+			name:           "U2: 注文確認成功 フィルタ=pending はSQLで絞り込む",
 			expectedStatus: http.StatusOK,
 			expectedCount:  1,
 			query:          "?status=pending",
 			userID:         int64(1),
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "cancelled",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				m.On("ListOrdersByUser", mock.Anything, withStatus("pending")).Return(
+					[]db.ListOrdersByUserRow{order(1, 1500, "pending")}, nil)
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{1}).Return(
+					[]db.OrderItem{item(11, 1, 100, 2, 750)}, nil)
 			},
 		},
 		{
@@ -928,51 +1002,10 @@ func TestGetOrdersHandler(t *testing.T) {
 			query:          "?status=cancelled",
 			userID:         int64(1),
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "cancelled",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				m.On("ListOrdersByUser", mock.Anything, withStatus("cancelled")).Return(
+					[]db.ListOrdersByUserRow{order(2, 3000, "cancelled")}, nil)
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{2}).Return(
+					[]db.OrderItem{item(21, 2, 200, 3, 1000)}, nil)
 			},
 		},
 		{
@@ -982,51 +1015,8 @@ func TestGetOrdersHandler(t *testing.T) {
 			query:          "?status=cancelled",
 			userID:         int64(1),
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				m.On("ListOrdersByUser", mock.Anything, withStatus("cancelled")).Return(
+					[]db.ListOrdersByUserRow{}, nil)
 			},
 		},
 		{
@@ -1043,153 +1033,79 @@ func TestGetOrdersHandler(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			expectedErrMsg: "認証が必要です",
 			query:          "",
-			userID:         nil,
 			setupMock:      nil,
 		},
 		{
-			name:           "U7: userIDの型がintでも通る",
-			expectedStatus: http.StatusOK,
-			expectedCount:  2,
-			query:          "",
-			userID:         int(1),
+			name:                        "U10: 明細にproduct_name_snapshotが含まれる",
+			expectedStatus:              http.StatusOK,
+			expectedCount:               1,
+			userID:                      int64(1),
+			expectedProductNameSnapshot: "House Blend",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				it := item(11, 1, 100, 2, 750)
+				it.ProductNameSnapshot = "House Blend"
+				m.On("ListOrdersByUser", mock.Anything, firstPage).Return(
+					[]db.ListOrdersByUserRow{order(1, 1500, "pending")}, nil)
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{1}).Return(
+					[]db.OrderItem{it}, nil)
+			},
+		},
+		{
+			name:               "U11: limitを超える注文があればnext_cursorを返す",
+			expectedStatus:     http.StatusOK,
+			expectedCount:      1,
+			expectedNextCursor: true,
+			query:              "?limit=1",
+			userID:             int64(1),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ListOrdersByUser", mock.Anything, db.ListOrdersByUserParams{UserID: 1, PageLimit: 2}).Return(
+					[]db.ListOrdersByUserRow{order(2, 3000, "pending"), order(1, 1500, "pending")}, nil)
+				m.On("ListOrderItemsByOrderIDs", mock.Anything, []int64{2}).Return(
+					[]db.OrderItem{item(21, 2, 200, 3, 1000)}, nil)
 			},
 		},
 		{
-			name:           "U8: userIDの型がfloatでも通る",
+			name:           "U12: cursorと期間をSQLに渡す",
 			expectedStatus: http.StatusOK,
-			expectedCount:  2,
-			query:          "",
-			userID:         float64(1),
+			expectedCount:  0,
+			query:          "?cursor=" + validCursor + "&from=2026-01-01T00:00:00Z&to=2026-02-01T00:00:00Z",
+			userID:         int64(1),
 			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-						{
-							ID:        2,
-							UserID:    1,
-							Total:     3000,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:        11,
-							OrderID:   1,
-							ProductID: 100,
-							Quantity:  2,
-							UnitPrice: 750,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(2)).Return(
-					[]db.OrderItem{
-						{
-							ID:        21,
-							OrderID:   2,
-							ProductID: 200,
-							Quantity:  3,
-							UnitPrice: 1000,
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
+				m.On("ListOrdersByUser", mock.Anything, mock.MatchedBy(func(arg db.ListOrdersByUserParams) bool {
+					return arg.CursorCreatedAt.Valid && arg.CursorCreatedAt.Time.Equal(cursorAt) &&
+						arg.CursorID == sql.NullInt64{Int64: 5, Valid: true} &&
+						arg.CreatedFrom.Valid && arg.CreatedFrom.Time.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) &&
+						arg.CreatedTo.Valid && arg.CreatedTo.Time.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+				})).Return([]db.ListOrdersByUserRow{}, nil)
 			},
 		},
 		{
-			name:           "U9: userIDの型が不正",
-			expectedStatus: http.StatusUnauthorized,
-			query:          "",
-			userID:         "not-a-number",
-			expectedErrMsg: "認証が必要です",
-			setupMock:      nil,
+			name:           "U13: limitが範囲外",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageLimit,
+			query:          "?limit=101",
+			userID:         int64(1),
 		},
 		{
-			name:                        "U10: 明細にproduct_name_snapshotが含まれる",
-			expectedStatus:              http.StatusOK,
-			expectedCount:               1,
-			userID:                      int64(1),
-			expectedProductNameSnapshot: "House Blend",
-			setupMock: func(m *testutil.MockDB) {
-				m.On("ListOrdersByUser", mock.Anything, int64(1)).Return(
-					[]db.ListOrdersByUserRow{
-						{
-							ID:        1,
-							UserID:    1,
-							Total:     1500,
-							Status:    "pending",
-							CreatedAt: now,
-							UpdatedAt: now,
-						},
-					}, nil)
-				m.On("ListOrderItemsByOrderID", mock.Anything, int64(1)).Return(
-					[]db.OrderItem{
-						{
-							ID:                  11,
-							OrderID:             1,
-							ProductID:           100,
-							Quantity:            2,
-							UnitPrice:           750,
-							ProductNameSnapshot: "House Blend",
-							CreatedAt:           now,
-							UpdatedAt:           now,
-						},
-					}, nil)
-			},
+			name:           "U14: cursorが不正",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCursor,
+			query:          "?cursor=@@@",
+			userID:         int64(1),
+		},
+		{
+			name:           "U15: fromがRFC3339でない",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageDate,
+			query:          "?from=2026-01-01",
+			userID:         int64(1),
+		},
+		{
+			name:           "U16: fromがto以降",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageDateRange,
+			query:          "?from=2026-02-01T00:00:00Z&to=2026-01-01T00:00:00Z",
+			userID:         int64(1),
 		},
 	}
 
@@ -1203,8 +1119,8 @@ func TestGetOrdersHandler(t *testing.T) {
 			router := gin.New()
 			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
 			router.GET("/api/orders", func(c *gin.Context) {
-				if tt.userID != nil {
-					c.Set("userID", tt.userID)
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
 				}
 				GetOrdersHandler(mockDB)(c)
 			})
@@ -1217,11 +1133,13 @@ func TestGetOrdersHandler(t *testing.T) {
 
 			if tt.expectedStatus == http.StatusOK {
 				var body struct {
-					Orders []OrderWithItems `json:"orders"`
+					Orders     []OrderWithItems `json:"orders"`
+					NextCursor *string          `json:"next_cursor"`
 				}
 				err := json.Unmarshal(w.Body.Bytes(), &body)
 				assert.NoError(t, err)
 				assert.Len(t, body.Orders, tt.expectedCount)
+				assert.Equal(t, tt.expectedNextCursor, body.NextCursor != nil)
 
 				if tt.query == "?status=pending" && len(body.Orders) > 0 {
 					for _, order := range body.Orders {
@@ -1236,6 +1154,12 @@ func TestGetOrdersHandler(t *testing.T) {
 				if tt.expectedProductNameSnapshot != "" && len(body.Orders) > 0 && len(body.Orders[0].Items) > 0 {
 					assert.Equal(t, tt.expectedProductNameSnapshot, body.Orders[0].Items[0].ProductNameSnapshot)
 				}
+				if tt.expectedNextCursor {
+					// 返されたcursorは最後の注文を指す
+					var cur orderCursor
+					assert.NoError(t, decodeCursor(*body.NextCursor, &cur))
+					assert.Equal(t, body.Orders[len(body.Orders)-1].Order.ID, cur.ID)
+				}
 			} else {
 				var body struct {
 					Error string `json:"error"`
@@ -1249,3 +1173,97 @@ func TestGetOrdersHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateOrderHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		userID         int64
+		txErr          error
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name:   "U1: 注文作成成功",
+			userID: int64(1),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrCreateCartForUser", mock.Anything, int64(1)).Return(db.Cart{ID: 10, UserID: 1}, nil)
+				m.On("ListCartItemsByUser", mock.Anything, int64(1)).Return(
+					[]db.ListCartItemsByUserRow{{ID: 1, CartID: 10, ProductID: 100, Quantity: 2, Price: 1500, ProductName: "Coffee", ProductPrice: 750}}, nil)
+				m.On("GetProductForUpdate", mock.Anything, int64(100)).Return(db.Product{ID: 100, StockQuantity: 5}, nil)
+				m.On("CreateOrder", mock.Anything, db.CreateOrderParams{UserID: 1, Total: 1500, Status: OrderStatusPending}).Return(
+					db.CreateOrderRow{ID: 1, UserID: 1, Total: 1500, Status: OrderStatusPending, CreatedAt: now, UpdatedAt: now}, nil)
+				m.On("CreateOrderItem", mock.Anything, mock.Anything).Return(db.OrderItem{}, nil)
+				m.On("UpdateProductStock", mock.Anything, db.UpdateProductStockParams{ID: 100, StockQuantity: -2}).Return(db.UpdateProductStockRow{}, nil)
+				m.On("ClearCartByUser", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:   "U2: 在庫不足は409",
+			userID: int64(1),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrCreateCartForUser", mock.Anything, int64(1)).Return(db.Cart{ID: 10, UserID: 1}, nil)
+				m.On("ListCartItemsByUser", mock.Anything, int64(1)).Return(
+					[]db.ListCartItemsByUserRow{{ID: 1, CartID: 10, ProductID: 100, Quantity: 2, Price: 1500}}, nil)
+				m.On("GetProductForUpdate", mock.Anything, int64(100)).Return(db.Product{ID: 100, StockQuantity: 1}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedErrMsg: apperror.ConflictMessageQty,
+		},
+		{
+			name:           "U3: 再試行しきれない競合は409",
+			userID:         int64(1),
+			txErr:          errors.Join(txn.ErrRetryExhausted, errors.New("deadlock detected")),
+			expectedStatus: http.StatusConflict,
+			expectedErrMsg: apperror.ConflictMessageBusy,
+		},
+		{
+			name:           "U4: 想定外のDBエラーは500",
+			userID:         int64(1),
+			txErr:          errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+		{
+			name:           "U5: 未認証は401",
+			expectedStatus: http.StatusUnauthorized,
+			expectedErrMsg: apperror.UnauthorizedMessageAuth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/orders", func(c *gin.Context) {
+				if tt.userID != 0 {
+					auth.SetPrincipal(c, auth.Principal{UserID: tt.userID})
+				}
+				CreateOrderHandler(testutil.TxRunner{Querier: mockDB, Err: tt.txErr})(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedErrMsg != "" {
+				var body struct {
+					Error string `json:"error"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body.Error)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sol_coffeesys/backend/pkg/apperror"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parsePageLimit は limit クエリを 1〜maxPageLimit の範囲で解釈する。未指定なら既定値。
+func parsePageLimit(raw string) (int32, error) {
+	if raw == "" {
+		return defaultPageLimit, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxPageLimit {
+		return 0, apperror.NewValidationError("limit", raw, "range", "")
+	}
+	return int32(n), nil
+}
+
+// parseTimeQuery は RFC3339 形式の日時クエリを解釈する。未指定なら nil。
+func parseTimeQuery(field, raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, apperror.NewValidationError(field, raw, "rfc3339", "")
+	}
+	return &t, nil
+}
+
+// cursor はクライアントにとって不透明な文字列として扱い、中身は base64url の JSON にする
+func encodeCursor(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(raw string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return apperror.NewValidationError("cursor", raw, "format", "")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return apperror.NewValidationError("cursor", raw, "format", "")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/mail"
+	"sol_coffeesys/backend/pkg/txn"
+	"sol_coffeesys/backend/pkg/validation"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const passwordResetTokenTTL = 30 * time.Minute
+
+// ＋＋パスワード再設定機能＋＋
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// DB にはトークンそのものではなくハッシュを保存する
+func hashResetToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// issuePasswordResetToken は再設定用トークンを発行して保存し、生のトークンを返す。
+// 未登録のメールアドレスの場合は空文字を返す(エラーにはしない)。
+func issuePasswordResetToken(ctx context.Context, q db.Querier, email string) (db.User, string, error) {
+	user, err := q.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.User{}, "", nil
+		}
+		return db.User{}, "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return db.User{}, "", err
+	}
+	rawToken := hex.EncodeToString(raw)
+
+	// 再発行すると以前のトークンは上書きされて使えなくなる
+	if _, err := q.SetResetToken(ctx, db.SetResetTokenParams{
+		ResetToken:          sql.NullString{String: hashResetToken(rawToken), Valid: true},
+		ResetTokenExpiresAt: sql.NullTime{Time: time.Now().Add(passwordResetTokenTTL), Valid: true},
+		ID:                  user.ID,
+	}); err != nil {
+		return db.User{}, "", err
+	}
+	return user, rawToken, nil
+}
+
+func passwordResetMessage(to, resetURL, rawToken string) mail.Message {
+	link := resetURL + "?token=" + url.QueryEscape(rawToken)
+	return mail.Message{
+		To:      to,
+		Subject: "【sol coffee】パスワード再設定のご案内",
+		Body: strings.Join([]string{
+			"パスワード再設定のリクエストを受け付けました。",
+			"以下のリンクから" + passwordResetTokenTTL.String() + "以内に新しいパスワードを設定してください。",
+			"",
+			link,
+			"",
+			"心当たりがない場合はこのメールを破棄してください。",
+		}, "\n"),
+	}
+}
+
+// ForgotPasswordHandler はメールアドレスの登録有無にかかわらず同じレスポンスを返す。
+// resetURL はフロントエンドの再設定画面の URL で、?token= を付けてメールに記載する。
+func ForgotPasswordHandler(q db.Querier, sender mail.Sender, resetURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+		if err := validation.ValidateEmail(req.Email); err != nil {
+			_ = c.Error(apperror.NewValidationError("email", nil, "", ""))
+			return
+		}
+
+		user, rawToken, err := issuePasswordResetToken(c.Request.Context(), q, req.Email)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("IssuePasswordResetToken", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		if rawToken != "" {
+			// 送信失敗をレスポンスに出すと登録有無が分かるため、ログにだけ残す
+			if err := sender.Send(c.Request.Context(), passwordResetMessage(user.Email, resetURL, rawToken)); err != nil {
+				slog.ErrorContext(c.Request.Context(), "password reset mail failed",
+					"request_id", c.GetString(logging.CtxKeyRequestID), "user_id", user.ID, "error", err)
+			}
+			c.Set("userID", user.ID)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "メールアドレスが登録されている場合、パスワード再設定用のメールを送信しました"})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_password_reset_requested",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.Bool("user_found", rawToken != "")},
+		})
+	}
+}
+
+// resetPasswordLogic はトークンを消費してパスワードを更新し、既存のリフレッシュトークンを全て失効させる
+func resetPasswordLogic(ctx context.Context, qtx db.Querier, rawToken, passwordHash string) (int64, error) {
+	userID, err := qtx.ResetPasswordByToken(ctx, db.ResetPasswordByTokenParams{
+		PasswordHash: passwordHash,
+		ResetToken:   hashResetToken(rawToken),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, apperror.NewValidationError("token", nil, "invalid", "")
+		}
+		return 0, err
+	}
+
+	if err := qtx.RevokeAllRefreshTokensByUser(ctx, userID); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func ResetPasswordHandler(runner txn.Runner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+			return
+		}
+		if err := validation.ValidatePassword(req.Password); err != nil {
+			_ = c.Error(apperror.NewValidationError("password", nil, "", ""))
+			return
+		}
+
+		hashed, err := HashPassword(req.Password)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var userID int64
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			var err error
+			userID, err = resetPasswordLogic(c.Request.Context(), qtx, req.Token, hashed)
+			return err
+		})
+		if err != nil {
+			_ = c.Error(txError("ResetPassword", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "パスワードを再設定しました"})
+
+		c.Set("userID", userID)
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_password_reset_succeeded",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeMailSender struct {
+	sent []mail.Message
+	err  error
+}
+
+func (s *fakeMailSender) Send(ctx context.Context, msg mail.Message) error {
+	s.sent = append(s.sent, msg)
+	return s.err
+}
+
+func TestForgotPasswordHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const resetURL = "http://localhost:3000/password/reset"
+
+	tests := []struct {
+		name           string
+		body           string
+		mailErr        error
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+		expectedSent   int
+	}{
+		{
+			name: "U1: 登録済みのメールアドレスにはトークンを発行して送信",
+			body: `{"email":"alice@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(db.User{ID: 1, Email: "alice@example.com"}, nil)
+				m.On("SetResetToken", mock.Anything, mock.MatchedBy(func(arg db.SetResetTokenParams) bool {
+					return arg.ID == 1 && arg.ResetToken.Valid && len(arg.ResetToken.String) == 64 &&
+						arg.ResetTokenExpiresAt.Valid && arg.ResetTokenExpiresAt.Time.After(time.Now())
+				})).Return(db.User{ID: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedSent:   1,
+		},
+		{
+			// パスワードの変更・2段階認証の解除ができない会員は、ここからパスワードを設定する
+			name: "U1-2: パスワードを持たない会員(外部 IdP で作成)にも送信",
+			body: `{"email":"alice@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(db.User{ID: 3, Email: "alice@example.com", PasswordHash: ""}, nil)
+				m.On("SetResetToken", mock.Anything, mock.MatchedBy(func(arg db.SetResetTokenParams) bool {
+					return arg.ID == 3 && arg.ResetToken.Valid
+				})).Return(db.User{ID: 3}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedSent:   1,
+		},
+		{
+			name: "U2: 未登録のメールアドレスでも同じレスポンス",
+			body: `{"email":"nobody@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "nobody@example.com").Return(db.User{}, sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "U3: メール送信失敗でもレスポンスは変えない",
+			body:    `{"email":"alice@example.com"}`,
+			mailErr: errors.New("smtp down"),
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(db.User{ID: 1, Email: "alice@example.com"}, nil)
+				m.On("SetResetToken", mock.Anything, mock.Anything).Return(db.User{ID: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedSent:   1,
+		},
+		{
+			name:           "U4: メールアドレスの形式不正",
+			body:           `{"email":"invalid"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageEmail,
+		},
+		{
+			name:           "U5: リクエスト形式不正",
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+		{
+			name: "U6: DBエラー",
+			body: `{"email":"alice@example.com"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(db.User{}, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			sender := &fakeMailSender{err: tt.mailErr}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/password/forgot", ForgotPasswordHandler(mockDB, sender, resetURL))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/password/forgot", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			} else {
+				assert.NotEmpty(t, body["message"])
+			}
+
+			assert.Len(t, sender.sent, tt.expectedSent)
+			if tt.expectedSent > 0 {
+				msg := sender.sent[0]
+				assert.Equal(t, "alice@example.com", msg.To)
+				// メールに載るのは生のトークンで、DB に保存したハッシュと対応する
+				saved := mockDB.Calls[1].Arguments.Get(1).(db.SetResetTokenParams).ResetToken.String
+				rawToken := extractResetToken(t, msg.Body, resetURL)
+				assert.Equal(t, saved, hashResetToken(rawToken))
+				assert.NotContains(t, msg.Body, saved)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func extractResetToken(t *testing.T, body, resetURL string) string {
+	t.Helper()
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, resetURL+"?") {
+			u, err := url.Parse(line)
+			assert.NoError(t, err)
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("reset link not found in body: %q", body)
+	return ""
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	original := BcryptGenerateFromPassword
+	t.Cleanup(func() { BcryptGenerateFromPassword = original })
+	BcryptGenerateFromPassword = func(password []byte, cost int) ([]byte, error) {
+		return []byte("hashed:" + string(password)), nil
+	}
+
+	rawToken := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedErrMsg string
+	}{
+		{
+			name: "U1: 有効なトークンで再設定しリフレッシュトークンを全て失効",
+			body: `{"token":"` + rawToken + `","password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ResetPasswordByToken", mock.Anything, db.ResetPasswordByTokenParams{
+					PasswordHash: "hashed:newpassword1",
+					ResetToken:   hashResetToken(rawToken),
+				}).Return(int64(1), nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "U2: 無効・期限切れ・使用済みのトークン",
+			body: `{"token":"` + rawToken + `","password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ResetPasswordByToken", mock.Anything, mock.Anything).Return(int64(0), sql.ErrNoRows)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageResetToken,
+		},
+		{
+			name:           "U3: パスワードが短すぎる",
+			body:           `{"token":"` + rawToken + `","password":"short"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessagePassword,
+		},
+		{
+			name:           "U4: トークンなし",
+			body:           `{"password":"newpassword1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageRequest,
+		},
+		{
+			name: "U5: 失効処理のDBエラー",
+			body: `{"token":"` + rawToken + `","password":"newpassword1"}`,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ResetPasswordByToken", mock.Anything, mock.Anything).Return(int64(1), nil)
+				m.On("RevokeAllRefreshTokensByUser", mock.Anything, int64(1)).Return(errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedErrMsg: apperror.InternalServerMessageCommon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.POST("/api/password/reset", ResetPasswordHandler(testutil.TxRunner{Querier: mockDB}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/password/reset", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tt.expectedErrMsg != "" {
+				assert.Equal(t, tt.expectedErrMsg, body["error"])
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
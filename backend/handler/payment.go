@@ -0,0 +1,393 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/payment"
+	"sol_coffeesys/backend/pkg/txn"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentResponse struct {
+	ID                    int64   `json:"id"`
+	OrderID               int64   `json:"order_id"`
+	Amount                int64   `json:"amount"`
+	Status                string  `json:"status"`
+	PaymentMethod         *string `json:"payment_method"`
+	ExternalTransactionID *string `json:"external_transaction_id"`
+	CreatedAt             string  `json:"created_at"`
+	UpdatedAt             string  `json:"updated_at"`
+}
+
+func toPaymentResponse(p db.Payment) PaymentResponse {
+	var method *string
+	if p.PaymentMethod.Valid {
+		method = &p.PaymentMethod.String
+	}
+	var txID *string
+	if p.ExternalTransactionID.Valid {
+		txID = &p.ExternalTransactionID.String
+	}
+	return PaymentResponse{
+		ID:                    p.ID,
+		OrderID:               p.OrderID,
+		Amount:                p.Amount,
+		Status:                p.Status,
+		PaymentMethod:         method,
+		ExternalTransactionID: txID,
+		CreatedAt:             p.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:             p.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+type payOrderRequest struct {
+	PaymentMethod string `json:"payment_method"`
+}
+
+// 決済が拒否された場合は Order が nil、Payment.Status が failed になる
+type payOrderResult struct {
+	Order   *db.UpdateOrderStatusRow
+	Payment db.Payment
+}
+
+// paymentIdempotencyKey は決済サービスへの冪等キー。同じ試行の問い合わせ直しでは同じキーになる
+func paymentIdempotencyKey(pay db.Payment) string {
+	return fmt.Sprintf("payment-%d-%d", pay.ID, pay.Attempt)
+}
+
+// payOrderLogic は外部の決済サービスを呼ぶ間に注文の行ロックと DB 接続を持ち続けないよう、3段階で決済する。
+//  1. 短いトランザクションで pending の payments 行を用意して commit する
+//  2. トランザクションの外で、payments の id から作った冪等キーを付けて課金する
+//  3. 2つ目の短いトランザクションで結果を記録する
+//
+// 課金の結果が分からない(通信障害など)ときは pending のまま残し、再試行では同じキーで問い合わせ直す。
+// これにより、DB 側のロールバックや再試行で二重に課金したり、課金したのに記録が無いということが起きない
+func payOrderLogic(ctx context.Context, runner txn.Runner, provider payment.PaymentProvider, orderID int64, userID int64, method string) (*payOrderResult, error) {
+	var pay db.Payment
+	err := runner.RunInTx(ctx, func(qtx db.Querier) error {
+		var err error
+		pay, err = beginPaymentAttempt(ctx, qtx, orderID, userID, method)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	charge, chargeErr := provider.Charge(ctx, payment.ChargeRequest{
+		OrderID:        orderID,
+		Amount:         pay.Amount,
+		Method:         pay.PaymentMethod.String,
+		IdempotencyKey: paymentIdempotencyKey(pay),
+	})
+	if chargeErr != nil && !errors.Is(chargeErr, payment.ErrDeclined) {
+		return nil, chargeErr
+	}
+
+	var result *payOrderResult
+	err = runner.RunInTx(ctx, func(qtx db.Querier) error {
+		var err error
+		result, err = recordPaymentResult(ctx, qtx, pay, charge, chargeErr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// beginPaymentAttempt は課金する payments 行を pending にして返す。
+// pending の注文に pending の payments 行がある間は、注文の取り消しを受け付けない(cancelOrderLogic)
+func beginPaymentAttempt(ctx context.Context, qtx db.Querier, orderID int64, userID int64, method string) (db.Payment, error) {
+	ord, err := qtx.GetOrderByIDForUpdate(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Payment{}, apperror.NewNotFoundError("order", orderID, "")
+		}
+		return db.Payment{}, err
+	}
+	// 所有権チェック
+	if ord.UserID != userID {
+		return db.Payment{}, apperror.NewNotFoundError("order", orderID, "")
+	}
+
+	if ord.Status != OrderStatusPending {
+		return db.Payment{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessagePayment)
+	}
+
+	paymentMethod := sql.NullString{String: method, Valid: method != ""}
+
+	pay, err := qtx.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return db.Payment{}, err
+		}
+		return qtx.CreatePayment(ctx, db.CreatePaymentParams{
+			OrderID:       orderID,
+			Amount:        ord.Total,
+			Status:        payment.StatusPending,
+			PaymentMethod: paymentMethod,
+		})
+	}
+
+	switch pay.Status {
+	case payment.StatusFailed:
+		// 決済失敗後の再試行は既存の payments 行を使い回し、新しい試行として冪等キーを変える
+		return qtx.RetryPayment(ctx, db.RetryPaymentParams{
+			ID:            pay.ID,
+			PaymentMethod: paymentMethod,
+		})
+	case payment.StatusPending:
+		// 結果の分からない前回の試行。同じキー・同じ支払い方法で問い合わせ直す
+		return pay, nil
+	default:
+		return db.Payment{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessagePayment)
+	}
+}
+
+// ensureNoPaymentInFlight は結果待ちの決済がある注文の取り消しを断る。
+// 課金済みかもしれない注文を取り消すと、記録の無い課金が残ってしまう
+func ensureNoPaymentInFlight(ctx context.Context, qtx db.Querier, orderID int64) error {
+	pay, err := qtx.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if pay.Status == payment.StatusPending {
+		return apperror.NewBusinessLogicError(apperror.BusinessLogicMessagePaying)
+	}
+	return nil
+}
+
+// recordPaymentResult は課金の結果を payments と注文に記録する。chargeErr は nil か payment.ErrDeclined
+func recordPaymentResult(ctx context.Context, qtx db.Querier, pay db.Payment, charge payment.ChargeResult, chargeErr error) (*payOrderResult, error) {
+	ord, err := qtx.GetOrderByIDForUpdate(ctx, pay.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := qtx.GetPaymentByOrderID(ctx, pay.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	// 同じ冪等キーで並行したリクエストが先に結果を記録した
+	if current.Attempt != pay.Attempt || current.Status != payment.StatusPending {
+		if current.Attempt == pay.Attempt && current.Status == payment.StatusFailed {
+			return &payOrderResult{Payment: current}, nil
+		}
+		return nil, apperror.NewBusinessLogicError(apperror.BusinessLogicMessagePayment)
+	}
+
+	if chargeErr != nil {
+		failed, err := qtx.UpdatePaymentStatus(ctx, db.UpdatePaymentStatusParams{
+			ID:            pay.ID,
+			Status:        payment.StatusFailed,
+			PaymentMethod: pay.PaymentMethod,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &payOrderResult{Payment: failed}, nil
+	}
+
+	// pending の payments 行がある間は取り消せないので、ここで注文は pending のはず
+	if ord.Status != OrderStatusPending {
+		return nil, fmt.Errorf("order %d is %s while payment %d is pending", ord.ID, ord.Status, pay.ID)
+	}
+
+	completed, err := qtx.UpdatePaymentStatus(ctx, db.UpdatePaymentStatusParams{
+		ID:                    pay.ID,
+		Status:                payment.StatusCompleted,
+		PaymentMethod:         pay.PaymentMethod,
+		ExternalTransactionID: sql.NullString{String: charge.TransactionID, Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := qtx.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
+		ID:     pay.OrderID,
+		Status: OrderStatusPaid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &payOrderResult{Order: &updated, Payment: completed}, nil
+}
+
+// refundOrderLogic は注文の決済を決済サービスで返金してから注文を refunded にする。
+// payOrderLogic と同じく、決済サービスを呼ぶ間はトランザクションを持たない。
+// 返金の結果が分からないときは payments を refunding のまま残し、再試行では同じキーで依頼し直す
+func refundOrderLogic(ctx context.Context, runner txn.Runner, provider payment.PaymentProvider, orderID int64) (*db.UpdateOrderStatusRow, error) {
+	var pay db.Payment
+	err := runner.RunInTx(ctx, func(qtx db.Querier) error {
+		var err error
+		pay, err = beginRefund(ctx, qtx, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = provider.Refund(ctx, payment.RefundRequest{
+		TransactionID:  pay.ExternalTransactionID.String,
+		Amount:         pay.Amount,
+		IdempotencyKey: fmt.Sprintf("refund-%d", pay.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *db.UpdateOrderStatusRow
+	err = runner.RunInTx(ctx, func(qtx db.Querier) error {
+		var err error
+		updated, err = recordRefund(ctx, qtx, pay)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// beginRefund は返金する payments 行を refunding にして返す。決済が完了していない注文は返金できない
+func beginRefund(ctx context.Context, qtx db.Querier, orderID int64) (db.Payment, error) {
+	ord, err := qtx.GetOrderByIDForUpdate(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Payment{}, apperror.NewNotFoundError("order", orderID, "")
+		}
+		return db.Payment{}, err
+	}
+	if !canTransitionOrder(ord.Status, OrderStatusRefunded) {
+		return db.Payment{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageOrderStatus)
+	}
+
+	pay, err := qtx.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Payment{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageRefund)
+		}
+		return db.Payment{}, err
+	}
+
+	switch pay.Status {
+	case payment.StatusCompleted:
+		return qtx.UpdatePaymentStatus(ctx, db.UpdatePaymentStatusParams{
+			ID:                    pay.ID,
+			Status:                payment.StatusRefunding,
+			PaymentMethod:         pay.PaymentMethod,
+			ExternalTransactionID: pay.ExternalTransactionID,
+		})
+	case payment.StatusRefunding:
+		// 結果の分からない前回の返金を同じキーで依頼し直す
+		return pay, nil
+	default:
+		return db.Payment{}, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageRefund)
+	}
+}
+
+// recordRefund は返金の完了を payments と注文に記録する
+func recordRefund(ctx context.Context, qtx db.Querier, pay db.Payment) (*db.UpdateOrderStatusRow, error) {
+	ord, err := qtx.GetOrderByIDForUpdate(ctx, pay.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := qtx.GetPaymentByOrderID(ctx, pay.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	// 並行したリクエストが先に返金を記録した
+	if current.Status != payment.StatusRefunding || !canTransitionOrder(ord.Status, OrderStatusRefunded) {
+		return nil, apperror.NewBusinessLogicError(apperror.BusinessLogicMessageOrderStatus)
+	}
+
+	if _, err := qtx.UpdatePaymentStatus(ctx, db.UpdatePaymentStatusParams{
+		ID:                    current.ID,
+		Status:                payment.StatusRefunded,
+		PaymentMethod:         current.PaymentMethod,
+		ExternalTransactionID: current.ExternalTransactionID,
+	}); err != nil {
+		return nil, err
+	}
+
+	updated, err := qtx.UpdateOrderStatus(ctx, db.UpdateOrderStatusParams{
+		ID:     pay.OrderID,
+		Status: OrderStatusRefunded,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func PayOrderHandler(runner txn.Runner, provider payment.PaymentProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderIDParam := c.Param("id")
+		if orderIDParam == "" {
+			_ = c.Error(apperror.NewValidationError("order", nil, "", apperror.ValidationMessageEssentialOrder))
+			return
+		}
+		orderID, err := strconv.ParseInt(orderIDParam, 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError("order", nil, "", apperror.ValidationMessageOrder))
+			return
+		}
+
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		var req payOrderRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				_ = c.Error(apperror.NewValidationError("request", nil, "bind", apperror.ValidationMessageRequest))
+				return
+			}
+		}
+
+		// 決済失敗も payments に記録するため、拒否はエラーにせず result で返す
+		result, err := payOrderLogic(c.Request.Context(), runner, provider, orderID, principal.UserID, req.PaymentMethod)
+		if err != nil {
+			_ = c.Error(txError("PayOrder", err))
+			return
+		}
+
+		if result.Order == nil {
+			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageDeclined))
+
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "order_payment_failed",
+				Status: http.StatusBadRequest,
+				Level:  slog.LevelWarn,
+				Extra:  []slog.Attr{slog.Int64("order_id", orderID)},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"order":   result.Order,
+			"payment": toPaymentResponse(result.Payment),
+		})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "order_paid",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/payment"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type stubPaymentProvider struct {
+	result payment.ChargeResult
+	err    error
+	called int
+	last   payment.ChargeRequest
+
+	refundErr    error
+	refundCalled int
+	lastRefund   payment.RefundRequest
+}
+
+func (s *stubPaymentProvider) Charge(ctx context.Context, req payment.ChargeRequest) (payment.ChargeResult, error) {
+	s.called++
+	s.last = req
+	return s.result, s.err
+}
+
+func (s *stubPaymentProvider) Refund(ctx context.Context, req payment.RefundRequest) error {
+	s.refundCalled++
+	s.lastRefund = req
+	return s.refundErr
+}
+
+func TestPayOrderLogic(t *testing.T) {
+	now := time.Now()
+	card := sql.NullString{String: "card", Valid: true}
+	pendingOrder := func(id int64, total int64) db.GetOrderByIDForUpdateRow {
+		return db.GetOrderByIDForUpdateRow{ID: id, UserID: 1, Total: total, Status: "pending", CreatedAt: now, UpdatedAt: now}
+	}
+
+	tests := []struct {
+		name          string
+		orderID       int64
+		userID        int64
+		provider      *stubPaymentProvider
+		setupMock     func(*testutil.MockDB)
+		wantPaid      bool
+		wantCharged   int
+		wantKey       string
+		expectedErr   string
+		checkErr      func(*testing.T, error)
+		wantPayStatus string
+	}{
+		{
+			name:     "U1: 初回決済成功で注文がpaidになる",
+			orderID:  1,
+			userID:   1,
+			provider: &stubPaymentProvider{result: payment.ChargeResult{TransactionID: "tx_1"}},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(1)).Return(pendingOrder(1, 1500), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(1)).Return(db.Payment{}, sql.ErrNoRows).Once()
+				m.On("CreatePayment", mock.Anything, db.CreatePaymentParams{
+					OrderID: 1, Amount: 1500, Status: payment.StatusPending, PaymentMethod: card,
+				}).Return(db.Payment{ID: 10, OrderID: 1, Amount: 1500, Status: payment.StatusPending, PaymentMethod: card, Attempt: 1}, nil)
+				// 課金後の2つ目のトランザクションで読み直す
+				m.On("GetPaymentByOrderID", mock.Anything, int64(1)).Return(
+					db.Payment{ID: 10, OrderID: 1, Amount: 1500, Status: payment.StatusPending, PaymentMethod: card, Attempt: 1}, nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, db.UpdatePaymentStatusParams{
+					ID: 10, Status: payment.StatusCompleted, PaymentMethod: card,
+					ExternalTransactionID: sql.NullString{String: "tx_1", Valid: true},
+				}).Return(db.Payment{ID: 10, OrderID: 1, Amount: 1500, Status: payment.StatusCompleted}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 1, Status: "paid"}).Return(
+					db.UpdateOrderStatusRow{ID: 1, UserID: 1, Total: 1500, Status: "paid", CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			wantPaid:      true,
+			wantCharged:   1,
+			wantKey:       "payment-10-1",
+			wantPayStatus: payment.StatusCompleted,
+		},
+		{
+			name:     "U2: 決済失敗の再試行は既存のpayments行を使い、冪等キーを変える",
+			orderID:  2,
+			userID:   1,
+			provider: &stubPaymentProvider{result: payment.ChargeResult{TransactionID: "tx_2"}},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(2)).Return(pendingOrder(2, 800), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(2)).Return(
+					db.Payment{ID: 20, OrderID: 2, Amount: 800, Status: payment.StatusFailed, Attempt: 1}, nil).Once()
+				m.On("RetryPayment", mock.Anything, db.RetryPaymentParams{ID: 20, PaymentMethod: card}).Return(
+					db.Payment{ID: 20, OrderID: 2, Amount: 800, Status: payment.StatusPending, PaymentMethod: card, Attempt: 2}, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(2)).Return(
+					db.Payment{ID: 20, OrderID: 2, Amount: 800, Status: payment.StatusPending, PaymentMethod: card, Attempt: 2}, nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, mock.MatchedBy(func(arg db.UpdatePaymentStatusParams) bool {
+					return arg.ID == 20 && arg.Status == payment.StatusCompleted
+				})).Return(db.Payment{ID: 20, OrderID: 2, Amount: 800, Status: payment.StatusCompleted}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 2, Status: "paid"}).Return(
+					db.UpdateOrderStatusRow{ID: 2, UserID: 1, Total: 800, Status: "paid", CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			wantPaid:      true,
+			wantCharged:   1,
+			wantKey:       "payment-20-2",
+			wantPayStatus: payment.StatusCompleted,
+		},
+		{
+			name:     "U2-2: 結果の分からない試行は同じ冪等キーで問い合わせ直す",
+			orderID:  9,
+			userID:   1,
+			provider: &stubPaymentProvider{result: payment.ChargeResult{TransactionID: "tx_9"}},
+			setupMock: func(m *testutil.MockDB) {
+				pending := db.Payment{ID: 90, OrderID: 9, Amount: 700, Status: payment.StatusPending, PaymentMethod: card, Attempt: 3}
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(9)).Return(pendingOrder(9, 700), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(9)).Return(pending, nil).Twice()
+				m.On("UpdatePaymentStatus", mock.Anything, mock.MatchedBy(func(arg db.UpdatePaymentStatusParams) bool {
+					return arg.ID == 90 && arg.Status == payment.StatusCompleted
+				})).Return(db.Payment{ID: 90, OrderID: 9, Amount: 700, Status: payment.StatusCompleted}, nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 9, Status: "paid"}).Return(
+					db.UpdateOrderStatusRow{ID: 9, UserID: 1, Total: 700, Status: "paid", CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			wantPaid:      true,
+			wantCharged:   1,
+			wantKey:       "payment-90-3",
+			wantPayStatus: payment.StatusCompleted,
+		},
+		{
+			name:     "U3: 決済拒否はfailedを記録し注文はpendingのまま",
+			orderID:  3,
+			userID:   1,
+			provider: &stubPaymentProvider{err: payment.ErrDeclined},
+			setupMock: func(m *testutil.MockDB) {
+				pending := db.Payment{ID: 30, OrderID: 3, Amount: 500, Status: payment.StatusPending, PaymentMethod: card, Attempt: 1}
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(3)).Return(pendingOrder(3, 500), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(3)).Return(db.Payment{}, sql.ErrNoRows).Once()
+				m.On("CreatePayment", mock.Anything, mock.Anything).Return(pending, nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(3)).Return(pending, nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, db.UpdatePaymentStatusParams{
+					ID: 30, Status: payment.StatusFailed, PaymentMethod: card,
+				}).Return(db.Payment{ID: 30, OrderID: 3, Amount: 500, Status: payment.StatusFailed}, nil)
+			},
+			wantPaid:      false,
+			wantCharged:   1,
+			wantKey:       "payment-30-1",
+			wantPayStatus: payment.StatusFailed,
+		},
+		{
+			name:     "U4: 注文なし",
+			orderID:  4,
+			userID:   1,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(4)).Return(
+					db.GetOrderByIDForUpdateRow{}, sql.ErrNoRows)
+			},
+			checkErr: func(t *testing.T, err error) {
+				var ne *apperror.NotFoundError
+				assert.True(t, errors.As(err, &ne))
+				assert.Equal(t, "order", ne.Resource)
+			},
+		},
+		{
+			name:     "U5: 他ユーザーの注文",
+			orderID:  5,
+			userID:   2,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(5)).Return(pendingOrder(5, 500), nil)
+			},
+			checkErr: func(t *testing.T, err error) {
+				var ne *apperror.NotFoundError
+				assert.True(t, errors.As(err, &ne))
+			},
+		},
+		{
+			name:     "U6: pending以外は支払い不可",
+			orderID:  6,
+			userID:   1,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(6)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 6, UserID: 1, Total: 500, Status: "cancelled", CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			expectedErr: apperror.BusinessLogicMessagePayment,
+		},
+		{
+			name:     "U7: 決済サービス障害はpendingを残してエラー",
+			orderID:  7,
+			userID:   1,
+			provider: &stubPaymentProvider{err: errors.New("provider unavailable")},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(7)).Return(pendingOrder(7, 500), nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(7)).Return(db.Payment{}, sql.ErrNoRows).Once()
+				m.On("CreatePayment", mock.Anything, mock.Anything).Return(
+					db.Payment{ID: 70, OrderID: 7, Amount: 500, Status: payment.StatusPending, Attempt: 1}, nil)
+			},
+			wantCharged: 1,
+			wantKey:     "payment-70-1",
+			expectedErr: "provider unavailable",
+		},
+		{
+			name:     "U8: DBエラー GetPaymentByOrderID",
+			orderID:  8,
+			userID:   1,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(8)).Return(pendingOrder(8, 500), nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(8)).Return(db.Payment{}, errors.New("db error"))
+			},
+			expectedErr: "db error",
+		},
+		{
+			name:     "U9: 決済済みのpayments行があれば課金しない",
+			orderID:  11,
+			userID:   1,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(11)).Return(pendingOrder(11, 500), nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(11)).Return(
+					db.Payment{ID: 110, OrderID: 11, Amount: 500, Status: payment.StatusCompleted, Attempt: 1}, nil)
+			},
+			expectedErr: apperror.BusinessLogicMessagePayment,
+		},
+		{
+			name:     "U10: 並行したリクエストが先に決済済みを記録していたら二重に記録しない",
+			orderID:  12,
+			userID:   1,
+			provider: &stubPaymentProvider{result: payment.ChargeResult{TransactionID: "tx_12"}},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(12)).Return(pendingOrder(12, 500), nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(12)).Return(
+					db.Payment{ID: 120, OrderID: 12, Amount: 500, Status: payment.StatusPending, PaymentMethod: card, Attempt: 1}, nil).Once()
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(12)).Return(
+					db.GetOrderByIDForUpdateRow{ID: 12, UserID: 1, Total: 500, Status: "paid", CreatedAt: now, UpdatedAt: now}, nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(12)).Return(
+					db.Payment{ID: 120, OrderID: 12, Amount: 500, Status: payment.StatusCompleted, PaymentMethod: card, Attempt: 1}, nil).Once()
+			},
+			wantCharged: 1,
+			wantKey:     "payment-120-1",
+			expectedErr: apperror.BusinessLogicMessagePayment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			runner := testutil.TxRunner{Querier: mockDB}
+			result, err := payOrderLogic(context.Background(), runner, tt.provider, tt.orderID, tt.userID, "card")
+			switch {
+			case tt.checkErr != nil:
+				assert.Error(t, err)
+				tt.checkErr(t, err)
+			case tt.expectedErr != "":
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			default:
+				assert.NoError(t, err)
+				if assert.NotNil(t, result) {
+					assert.Equal(t, tt.wantPaid, result.Order != nil)
+					assert.Equal(t, tt.wantPayStatus, result.Payment.Status)
+				}
+			}
+			assert.Equal(t, tt.wantCharged, tt.provider.called)
+			assert.Equal(t, tt.wantKey, tt.provider.last.IdempotencyKey)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRefundOrderLogic(t *testing.T) {
+	now := time.Now()
+	card := sql.NullString{String: "card", Valid: true}
+	txID := sql.NullString{String: "tx_1", Valid: true}
+	order := func(id int64, status string) db.GetOrderByIDForUpdateRow {
+		return db.GetOrderByIDForUpdateRow{ID: id, UserID: 1, Total: 1500, Status: status, CreatedAt: now, UpdatedAt: now}
+	}
+	paid := func(id int64, status string) db.Payment {
+		return db.Payment{ID: id * 10, OrderID: id, Amount: 1500, Status: status, PaymentMethod: card, ExternalTransactionID: txID, Attempt: 1}
+	}
+
+	tests := []struct {
+		name         string
+		orderID      int64
+		provider     *stubPaymentProvider
+		setupMock    func(*testutil.MockDB)
+		wantRefunded int
+		expectedErr  string
+	}{
+		{
+			name:     "U1: 決済済みの注文を決済サービスで返金してからrefundedにする",
+			orderID:  1,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(1)).Return(order(1, OrderStatusPreparing), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(1)).Return(paid(1, payment.StatusCompleted), nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, db.UpdatePaymentStatusParams{
+					ID: 10, Status: payment.StatusRefunding, PaymentMethod: card, ExternalTransactionID: txID,
+				}).Return(paid(1, payment.StatusRefunding), nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(1)).Return(paid(1, payment.StatusRefunding), nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, db.UpdatePaymentStatusParams{
+					ID: 10, Status: payment.StatusRefunded, PaymentMethod: card, ExternalTransactionID: txID,
+				}).Return(paid(1, payment.StatusRefunded), nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 1, Status: OrderStatusRefunded}).Return(
+					db.UpdateOrderStatusRow{ID: 1, UserID: 1, Total: 1500, Status: OrderStatusRefunded, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			wantRefunded: 1,
+		},
+		{
+			name:     "U2: 決済の無い注文は返金できない",
+			orderID:  2,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(2)).Return(order(2, OrderStatusPaid), nil)
+				m.On("GetPaymentByOrderID", mock.Anything, int64(2)).Return(db.Payment{}, sql.ErrNoRows)
+			},
+			expectedErr: apperror.BusinessLogicMessageRefund,
+		},
+		{
+			name:     "U3: pendingの注文は返金できない",
+			orderID:  3,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(3)).Return(order(3, OrderStatusPending), nil)
+			},
+			expectedErr: apperror.BusinessLogicMessageOrderStatus,
+		},
+		{
+			name:     "U4: 返金の失敗はrefundingのまま残してエラー",
+			orderID:  4,
+			provider: &stubPaymentProvider{refundErr: errors.New("provider unavailable")},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(4)).Return(order(4, OrderStatusPaid), nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(4)).Return(paid(4, payment.StatusCompleted), nil).Once()
+				m.On("UpdatePaymentStatus", mock.Anything, mock.MatchedBy(func(arg db.UpdatePaymentStatusParams) bool {
+					return arg.ID == 40 && arg.Status == payment.StatusRefunding
+				})).Return(paid(4, payment.StatusRefunding), nil)
+			},
+			wantRefunded: 1,
+			expectedErr:  "provider unavailable",
+		},
+		{
+			name:     "U5: refundingの決済は同じ冪等キーで返金を依頼し直す",
+			orderID:  5,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(5)).Return(order(5, OrderStatusReady), nil).Twice()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(5)).Return(paid(5, payment.StatusRefunding), nil).Twice()
+				m.On("UpdatePaymentStatus", mock.Anything, mock.MatchedBy(func(arg db.UpdatePaymentStatusParams) bool {
+					return arg.ID == 50 && arg.Status == payment.StatusRefunded
+				})).Return(paid(5, payment.StatusRefunded), nil)
+				m.On("UpdateOrderStatus", mock.Anything, db.UpdateOrderStatusParams{ID: 5, Status: OrderStatusRefunded}).Return(
+					db.UpdateOrderStatusRow{ID: 5, UserID: 1, Total: 1500, Status: OrderStatusRefunded, CreatedAt: now, UpdatedAt: now}, nil)
+			},
+			wantRefunded: 1,
+		},
+		{
+			name:     "U6: 並行したリクエストが先に返金を記録していたら二重に記録しない",
+			orderID:  6,
+			provider: &stubPaymentProvider{},
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(6)).Return(order(6, OrderStatusPaid), nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(6)).Return(paid(6, payment.StatusRefunding), nil).Once()
+				m.On("GetOrderByIDForUpdate", mock.Anything, int64(6)).Return(order(6, OrderStatusRefunded), nil).Once()
+				m.On("GetPaymentByOrderID", mock.Anything, int64(6)).Return(paid(6, payment.StatusRefunded), nil).Once()
+			},
+			wantRefunded: 1,
+			expectedErr:  apperror.BusinessLogicMessageOrderStatus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			runner := testutil.TxRunner{Querier: mockDB}
+			result, err := refundOrderLogic(context.Background(), runner, tt.provider, tt.orderID)
+			if tt.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				if assert.NotNil(t, result) {
+					assert.Equal(t, OrderStatusRefunded, result.Status)
+				}
+			}
+			assert.Equal(t, tt.wantRefunded, tt.provider.refundCalled)
+			if tt.wantRefunded > 0 {
+				assert.Equal(t, "tx_1", tt.provider.lastRefund.TransactionID)
+				assert.Equal(t, fmt.Sprintf("refund-%d", tt.orderID*10), tt.provider.lastRefund.IdempotencyKey)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
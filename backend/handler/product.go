@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log/slog"
@@ -9,7 +10,9 @@ import (
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
@@ -42,39 +45,314 @@ type CreateProductHandlerRequest struct {
 
 type UpdateProductHandlerRequest = CreateProductHandlerRequest
 
+func toProductResponse(p db.Product) ProductResponse {
+	var desc *string
+	if p.Description.Valid {
+		desc = &p.Description.String
+	}
+	var img *string
+	if p.ImageUrl.Valid {
+		img = &p.ImageUrl.String
+	}
+	return ProductResponse{
+		ID:            p.ID,
+		Name:          p.Name,
+		Price:         p.Price,
+		IsAvailable:   p.IsAvailable,
+		CategoryID:    p.CategoryID,
+		Sku:           p.Sku,
+		Description:   desc,
+		ImageUrl:      img,
+		StockQuantity: p.StockQuantity,
+		CreatedAt:     p.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:     p.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+const (
+	ProductSortID        = "id"
+	ProductSortPriceAsc  = "price_asc"
+	ProductSortPriceDesc = "price_desc"
+	ProductSortName      = "name"
+	ProductSortNewest    = "newest"
+)
+
+var validProductSorts = map[string]struct{}{
+	ProductSortID:        {},
+	ProductSortPriceAsc:  {},
+	ProductSortPriceDesc: {},
+	ProductSortName:      {},
+	ProductSortNewest:    {},
+}
+
+const productKeywordMaxLength = 100
+
+// 商品一覧の絞り込み条件。nil のポインタは条件なし
+type listProductsFilter struct {
+	CategoryID  *int64
+	MinPrice    *int32
+	MaxPrice    *int32
+	IsAvailable *bool
+	InStock     bool
+	Keyword     string
+	Sort        string
+	Cursor      *productCursor
+	Limit       int32
+}
+
+// 直前ページ末尾の商品の並び替えキー。sort が変わると使えない
+type productCursor struct {
+	Sort      string    `json:"sort"`
+	ID        int64     `json:"id"`
+	Price     int32     `json:"price"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type productPage struct {
+	Products   []db.Product
+	NextCursor *productCursor
+	// 件数の集計は重いので最初のページ(cursor なし)でだけ返す
+	Total *int64
+}
+
+// LIKE のワイルドカードを文字として扱う
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func listProductsLogic(ctx context.Context, q db.Querier, filter listProductsFilter) (*productPage, error) {
+	count := db.CountProductsParams{InStock: filter.InStock}
+	if filter.CategoryID != nil {
+		count.CategoryID = sql.NullInt64{Int64: *filter.CategoryID, Valid: true}
+	}
+	if filter.MinPrice != nil {
+		count.MinPrice = sql.NullInt32{Int32: *filter.MinPrice, Valid: true}
+	}
+	if filter.MaxPrice != nil {
+		count.MaxPrice = sql.NullInt32{Int32: *filter.MaxPrice, Valid: true}
+	}
+	if filter.IsAvailable != nil {
+		count.IsAvailable = sql.NullBool{Bool: *filter.IsAvailable, Valid: true}
+	}
+	if filter.Keyword != "" {
+		count.Keyword = sql.NullString{String: escapeLike(filter.Keyword), Valid: true}
+	}
+
+	// 次ページの有無を判定するため1件多く取得する
+	products, err := searchProducts(ctx, q, count, filter.Sort, filter.Cursor, filter.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &productPage{Products: products}
+	if filter.Cursor == nil {
+		total, err := q.CountProducts(ctx, count)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	if len(products) > int(filter.Limit) {
+		page.Products = products[:filter.Limit]
+		last := page.Products[len(page.Products)-1]
+		page.NextCursor = &productCursor{
+			Sort:      filter.Sort,
+			ID:        last.ID,
+			Price:     last.Price,
+			Name:      last.Name,
+			CreatedAt: last.CreatedAt,
+		}
+	}
+	return page, nil
+}
+
+// searchProducts は並び順ごとのクエリを呼び分ける。
+// (並び替えキー, id) の複合インデックスを使えるよう、並び順ごとにクエリを分けている
+func searchProducts(ctx context.Context, q db.Querier, cond db.CountProductsParams, sort string, cur *productCursor, limit int32) ([]db.Product, error) {
+	var key productCursor
+	if cur != nil {
+		key = *cur
+	}
+	cursorID := sql.NullInt64{Int64: key.ID, Valid: cur != nil}
+
+	switch sort {
+	case ProductSortPriceAsc:
+		return q.SearchProductsByPriceAsc(ctx, db.SearchProductsByPriceAscParams{
+			CategoryID:  cond.CategoryID,
+			MinPrice:    cond.MinPrice,
+			MaxPrice:    cond.MaxPrice,
+			IsAvailable: cond.IsAvailable,
+			InStock:     cond.InStock,
+			Keyword:     cond.Keyword,
+			CursorID:    cursorID,
+			CursorPrice: sql.NullInt32{Int32: key.Price, Valid: cur != nil},
+			PageLimit:   limit,
+		})
+	case ProductSortPriceDesc:
+		return q.SearchProductsByPriceDesc(ctx, db.SearchProductsByPriceDescParams{
+			CategoryID:  cond.CategoryID,
+			MinPrice:    cond.MinPrice,
+			MaxPrice:    cond.MaxPrice,
+			IsAvailable: cond.IsAvailable,
+			InStock:     cond.InStock,
+			Keyword:     cond.Keyword,
+			CursorID:    cursorID,
+			CursorPrice: sql.NullInt32{Int32: key.Price, Valid: cur != nil},
+			PageLimit:   limit,
+		})
+	case ProductSortName:
+		return q.SearchProductsByName(ctx, db.SearchProductsByNameParams{
+			CategoryID:  cond.CategoryID,
+			MinPrice:    cond.MinPrice,
+			MaxPrice:    cond.MaxPrice,
+			IsAvailable: cond.IsAvailable,
+			InStock:     cond.InStock,
+			Keyword:     cond.Keyword,
+			CursorID:    cursorID,
+			CursorName:  sql.NullString{String: key.Name, Valid: cur != nil},
+			PageLimit:   limit,
+		})
+	case ProductSortNewest:
+		return q.SearchProductsByNewest(ctx, db.SearchProductsByNewestParams{
+			CategoryID:      cond.CategoryID,
+			MinPrice:        cond.MinPrice,
+			MaxPrice:        cond.MaxPrice,
+			IsAvailable:     cond.IsAvailable,
+			InStock:         cond.InStock,
+			Keyword:         cond.Keyword,
+			CursorID:        cursorID,
+			CursorCreatedAt: sql.NullTime{Time: key.CreatedAt, Valid: cur != nil},
+			PageLimit:       limit,
+		})
+	default:
+		return q.SearchProductsByID(ctx, db.SearchProductsByIDParams{
+			CategoryID:  cond.CategoryID,
+			MinPrice:    cond.MinPrice,
+			MaxPrice:    cond.MaxPrice,
+			IsAvailable: cond.IsAvailable,
+			InStock:     cond.InStock,
+			Keyword:     cond.Keyword,
+			CursorID:    cursorID,
+			PageLimit:   limit,
+		})
+	}
+}
+
+func parseListProductsFilter(c *gin.Context) (listProductsFilter, error) {
+	filter := listProductsFilter{Sort: ProductSortID}
+
+	limit, err := parsePageLimit(c.Query("limit"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = limit
+
+	if raw := c.Query("category_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return filter, apperror.NewValidationError("category_id", raw, "", "")
+		}
+		filter.CategoryID = &id
+	}
+
+	for _, p := range []struct {
+		field string
+		dst   **int32
+	}{
+		{"min_price", &filter.MinPrice},
+		{"max_price", &filter.MaxPrice},
+	} {
+		raw := c.Query(p.field)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || v < 0 {
+			return filter, apperror.NewValidationError(p.field, raw, "", "")
+		}
+		price := int32(v)
+		*p.dst = &price
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return filter, apperror.NewValidationError("max_price", *filter.MaxPrice, "range", "")
+	}
+
+	if raw := c.Query("is_available"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, apperror.NewValidationError("is_available", raw, "", "")
+		}
+		filter.IsAvailable = &v
+	}
+	if raw := c.Query("in_stock"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, apperror.NewValidationError("in_stock", raw, "", "")
+		}
+		filter.InStock = v
+	}
+
+	filter.Keyword = strings.TrimSpace(c.Query("q"))
+	if utf8.RuneCountInString(filter.Keyword) > productKeywordMaxLength {
+		return filter, apperror.NewValidationError("q", nil, "max_length", "")
+	}
+
+	if raw := c.Query("sort"); raw != "" {
+		if _, ok := validProductSorts[raw]; !ok {
+			return filter, apperror.NewValidationError("sort", raw, "", "")
+		}
+		filter.Sort = raw
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		var cur productCursor
+		if err := decodeCursor(raw, &cur); err != nil {
+			return filter, err
+		}
+		if cur.Sort != filter.Sort {
+			return filter, apperror.NewValidationError("cursor", raw, "sort", "")
+		}
+		filter.Cursor = &cur
+	}
+
+	return filter, nil
+}
+
 // ＋＋商品一覧取得機能＋＋
 func ListProductsHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		products, err := q.ListProducts(c.Request.Context())
+		filter, err := parseListProductsFilter(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		page, err := listProductsLogic(c.Request.Context(), q, filter)
 		if err != nil {
 			_ = c.Error(apperror.NewInternalError("ListProducts", err, apperror.InternalServerMessageCommon))
 			return
 		}
-		resp := make([]ProductResponse, 0, len(products))
-		for _, p := range products {
-			var desc *string
-			if p.Description.Valid {
-				desc = &p.Description.String
-			}
-			var img *string
-			if p.ImageUrl.Valid {
-				img = &p.ImageUrl.String
+
+		var nextCursor *string
+		if page.NextCursor != nil {
+			encoded, err := encodeCursor(page.NextCursor)
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("EncodeCursor", err, apperror.InternalServerMessageCommon))
+				return
 			}
-			resp = append(resp, ProductResponse{
-				ID:            p.ID,
-				Name:          p.Name,
-				Price:         p.Price,
-				IsAvailable:   p.IsAvailable,
-				CategoryID:    p.CategoryID,
-				Sku:           p.Sku,
-				Description:   desc,
-				ImageUrl:      img,
-				StockQuantity: p.StockQuantity,
-				CreatedAt:     p.CreatedAt.Format(time.RFC3339),
-				UpdatedAt:     p.UpdatedAt.Format(time.RFC3339),
-			})
-		}
-		c.JSON(http.StatusOK, gin.H{"products": resp})
+			nextCursor = &encoded
+		}
+
+		resp := make([]ProductResponse, 0, len(page.Products))
+		for _, p := range page.Products {
+			resp = append(resp, toProductResponse(p))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"products":    resp,
+			"next_cursor": nextCursor,
+			"total":       page.Total,
+		})
 
 		logging.LogEvent(c, logging.EventInput{
 			Event:  "products_listed",
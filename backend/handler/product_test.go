@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sol_coffeesys/backend/auth"
@@ -106,7 +107,8 @@ func TestProductCRUD_HappyPath(t *testing.T) {
 
 	mockDB.On("CreateProduct", mock.Anything, mock.Anything).Return(sample, nil)
 	mockDB.On("GetProduct", mock.Anything, int64(1)).Return(sample, nil)
-	mockDB.On("ListProducts", mock.Anything).Return([]db.Product{sample}, nil)
+	mockDB.On("SearchProductsByID", mock.Anything, mock.Anything).Return([]db.Product{sample}, nil)
+	mockDB.On("CountProducts", mock.Anything, mock.Anything).Return(int64(1), nil)
 	mockDB.On("UpdateProduct", mock.Anything, mock.Anything).Return(sample, nil)
 	mockDB.On("DeleteProduct", mock.Anything, int64(1)).Return(nil)
 
@@ -542,6 +544,7 @@ func TestUpdateProduct_SkuConflict_409(t *testing.T) {
 
 func TestProductAuth_AdminOnly_Routes(t *testing.T) {
 	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
 
 	mockDB := new(testutil.MockDB)
 
@@ -560,9 +563,9 @@ func TestProductAuth_AdminOnly_Routes(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	}
 	// 2)非管理者トークン=>403
-	mockDB.On("GetUserForUpdate", mock.Anything, int64(2)).Return(db.User{ID: 2, Role: "user"}, nil)
+	mockDB.On("GetUserByID", mock.Anything, int64(2)).Return(db.User{ID: 2, Role: "user"}, nil)
 	{
-		token, err := auth.DefaultTokenGenerator{}.GenerateToken(int64(2))
+		token, err := auth.DefaultTokenGenerator{}.GenerateToken(int64(2), "member", "")
 		assert.NoError(t, err)
 		body := map[string]interface{}{"name": "X", "price": 100, "sku": "A2", "category_id": 1}
 		b, _ := json.Marshal(body)
@@ -575,7 +578,7 @@ func TestProductAuth_AdminOnly_Routes(t *testing.T) {
 	}
 
 	// 3)管理者トークン=>201
-	mockDB.On("GetUserForUpdate", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: "admin"}, nil)
+	mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Role: "admin"}, nil)
 	{
 		now := time.Now()
 		mockDB.On("GetCategory", mock.Anything, int64(1)).Return(db.Category{ID: 1, Name: "テストカテゴリ"}, nil)
@@ -591,7 +594,7 @@ func TestProductAuth_AdminOnly_Routes(t *testing.T) {
 			UpdatedAt:     now,
 		}, nil)
 
-		token, err := auth.DefaultTokenGenerator{}.GenerateToken(int64(1))
+		token, err := auth.DefaultTokenGenerator{}.GenerateToken(int64(1), "admin", "")
 		assert.NoError(t, err)
 		body := map[string]interface{}{
 			"name":           "AdminCreated",
@@ -611,3 +614,239 @@ func TestProductAuth_AdminOnly_Routes(t *testing.T) {
 		mockDB.AssertExpectations(t)
 	}
 }
+
+func TestListProductsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	product := func(id int64, name string, price int32) db.Product {
+		return db.Product{ID: id, Name: name, Price: price, IsAvailable: true, CategoryID: 1, Sku: fmt.Sprintf("SKU-%d", id), StockQuantity: 10, CreatedAt: now, UpdatedAt: now}
+	}
+	noFilter := db.CountProductsParams{}
+
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		expectedCount  int
+		expectedTotal  int64
+		expectedErrMsg string
+		wantNextCursor bool
+	}{
+		{
+			name:  "U1: パラメータなしはid順の先頭ページ",
+			query: "",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchProductsByID", mock.Anything, db.SearchProductsByIDParams{PageLimit: 21}).Return(
+					[]db.Product{product(1, "Coffee", 500), product(2, "Tea", 400)}, nil)
+				m.On("CountProducts", mock.Anything, noFilter).Return(int64(2), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedTotal:  2,
+		},
+		{
+			name:  "U2: 絞り込み条件をSQLに渡す",
+			query: "?category_id=3&min_price=100&max_price=900&is_available=true&in_stock=true&q=blend&sort=price_desc&limit=5",
+			setupMock: func(m *testutil.MockDB) {
+				filter := db.CountProductsParams{
+					CategoryID:  sql.NullInt64{Int64: 3, Valid: true},
+					MinPrice:    sql.NullInt32{Int32: 100, Valid: true},
+					MaxPrice:    sql.NullInt32{Int32: 900, Valid: true},
+					IsAvailable: sql.NullBool{Bool: true, Valid: true},
+					InStock:     true,
+					Keyword:     sql.NullString{String: "blend", Valid: true},
+				}
+				m.On("SearchProductsByPriceDesc", mock.Anything, db.SearchProductsByPriceDescParams{
+					CategoryID:  filter.CategoryID,
+					MinPrice:    filter.MinPrice,
+					MaxPrice:    filter.MaxPrice,
+					IsAvailable: filter.IsAvailable,
+					InStock:     true,
+					Keyword:     filter.Keyword,
+					PageLimit:   6,
+				}).Return([]db.Product{product(1, "House Blend", 800)}, nil)
+				m.On("CountProducts", mock.Anything, filter).Return(int64(1), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			expectedTotal:  1,
+		},
+		{
+			name:  "U3: 検索語のワイルドカードはエスケープする",
+			query: "?q=100%25_off",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchProductsByID", mock.Anything, mock.MatchedBy(func(arg db.SearchProductsByIDParams) bool {
+					return arg.Keyword.String == `100\%\_off`
+				})).Return([]db.Product{}, nil)
+				m.On("CountProducts", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+			expectedTotal:  0,
+		},
+		{
+			name:  "U4: limitを超える商品があればnext_cursorを返す",
+			query: "?limit=2",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchProductsByID", mock.Anything, db.SearchProductsByIDParams{PageLimit: 3}).Return(
+					[]db.Product{product(1, "A", 100), product(2, "B", 200), product(3, "C", 300)}, nil)
+				m.On("CountProducts", mock.Anything, noFilter).Return(int64(5), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedTotal:  5,
+			wantNextCursor: true,
+		},
+		{
+			name:  "U4-2: 名前順は名前順のクエリで引く",
+			query: "?sort=name",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchProductsByName", mock.Anything, db.SearchProductsByNameParams{PageLimit: 21}).Return(
+					[]db.Product{product(2, "A", 500), product(1, "B", 400)}, nil)
+				m.On("CountProducts", mock.Anything, noFilter).Return(int64(2), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+			expectedTotal:  2,
+		},
+		{
+			name:  "U4-3: 新しい順は作成日時順のクエリで引く",
+			query: "?sort=newest",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("SearchProductsByNewest", mock.Anything, db.SearchProductsByNewestParams{PageLimit: 21}).Return(
+					[]db.Product{product(2, "A", 500)}, nil)
+				m.On("CountProducts", mock.Anything, noFilter).Return(int64(1), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+			expectedTotal:  1,
+		},
+		{
+			name:           "U5: 不正な並び順",
+			query:          "?sort=popular",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageSort,
+		},
+		{
+			name:           "U6: 価格の下限が上限より大きい",
+			query:          "?min_price=500&max_price=100",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessagePriceRange,
+		},
+		{
+			name:           "U7: is_availableが真偽値でない",
+			query:          "?is_available=yes",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageBool,
+		},
+		{
+			name:           "U8: category_idが不正",
+			query:          "?category_id=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCategoryID,
+		},
+		{
+			name:           "U9: 検索語が長すぎる",
+			query:          "?q=" + strings.Repeat("a", 101),
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageKeyword,
+		},
+		{
+			name:           "U10: cursorが不正",
+			query:          "?cursor=@@@",
+			expectedStatus: http.StatusBadRequest,
+			expectedErrMsg: apperror.ValidationMessageCursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			router.GET("/api/products", handler.ListProductsHandler(mockDB))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/products"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				var body struct {
+					Products   []handler.ProductResponse `json:"products"`
+					NextCursor *string                   `json:"next_cursor"`
+					Total      int64                     `json:"total"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Len(t, body.Products, tt.expectedCount)
+				assert.Equal(t, tt.expectedTotal, body.Total)
+				assert.Equal(t, tt.wantNextCursor, body.NextCursor != nil)
+			} else {
+				var body struct {
+					Error string `json:"error"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedErrMsg, body.Error)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListProductsHandler_CursorFollowsSort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	mockDB := new(testutil.MockDB)
+	mockDB.On("SearchProductsByPriceAsc", mock.Anything, db.SearchProductsByPriceAscParams{PageLimit: 2}).Return(
+		[]db.Product{
+			{ID: 7, Name: "A", Price: 300, CreatedAt: now, UpdatedAt: now},
+			{ID: 3, Name: "B", Price: 400, CreatedAt: now, UpdatedAt: now},
+		}, nil).Once()
+	mockDB.On("SearchProductsByPriceAsc", mock.Anything, mock.MatchedBy(func(arg db.SearchProductsByPriceAscParams) bool {
+		return arg.CursorID == sql.NullInt64{Int64: 7, Valid: true} &&
+			arg.CursorPrice == sql.NullInt32{Int32: 300, Valid: true}
+	})).Return([]db.Product{{ID: 3, Name: "B", Price: 400, CreatedAt: now, UpdatedAt: now}}, nil).Once()
+	// 件数は最初のページでだけ数える
+	mockDB.On("CountProducts", mock.Anything, mock.Anything).Return(int64(2), nil).Once()
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/api/products", handler.ListProductsHandler(mockDB))
+
+	get := func(query string) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, "/api/products"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]any
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, first := get("?sort=price_asc&limit=1")
+	assert.Equal(t, http.StatusOK, code)
+	cursor, ok := first["next_cursor"].(string)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	assert.Equal(t, float64(2), first["total"])
+
+	code, second := get("?sort=price_asc&limit=1&cursor=" + cursor)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, second["next_cursor"])
+	assert.Nil(t, second["total"])
+
+	// 並び順を変えて同じcursorは使えない
+	code, mismatch := get("?sort=name&limit=1&cursor=" + cursor)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, apperror.ValidationMessageCursor, mismatch["error"])
+
+	mockDB.AssertExpectations(t)
+}
@@ -11,12 +11,21 @@ import (
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/txn"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RefreshTokenHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.HandlerFunc {
+// errRefreshTokenReused は rotate しようとしたトークンが既に失効していたことを表す
+var errRefreshTokenReused = errors.New("refresh token already revoked")
+
+// refreshRevokeReasonRotated は ConsumeRefreshToken が rotate で失効させたトークンに付ける失効理由
+const refreshRevokeReasonRotated = "rotated"
+
+// RefreshTokenHandler は提示されたリフレッシュトークンを失効させ、同じファミリーの新しいトークンを発行する。
+// 失効と発行は1つのトランザクションで行い、同じトークンを並行して使われた場合も片方だけを成功させる
+func RefreshTokenHandler(q db.Querier, runner txn.Runner, tokenGenerator auth.TokenGenerator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cookie, err := c.Request.Cookie("refresh_token")
 		if err != nil {
@@ -37,8 +46,13 @@ func RefreshTokenHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.H
 			return
 		}
 
-		if rt.RevokedAt.Valid || rt.ExpiresAt.Before(time.Now()) {
-			_ = c.Error(apperror.NewUnauthorizedError("refresh_token_revoked_alredy", apperror.UnauthorizedMessageAuth))
+		if rt.RevokedAt.Valid {
+			refreshTokenRevoked(c, q, rt)
+			return
+		}
+
+		if rt.ExpiresAt.Before(time.Now()) {
+			_ = c.Error(apperror.NewUnauthorizedError("refresh_token_expired", apperror.UnauthorizedMessageAuth))
 			c.Abort()
 			return
 		}
@@ -53,66 +67,56 @@ func RefreshTokenHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.H
 			c.Abort()
 			return
 		}
-
-		newRefresh, _, expiresAt, err := GenerateRefreshToken(c.Request.Context(), q, user.ID)
-		if err != nil {
-			_ = c.Error(apperror.NewInternalError("GenerateRefreshToken", err, apperror.InternalServerMessageRefresh))
+		if err := auth.CheckUserStatus(user.Status); err != nil {
+			_ = c.Error(err)
 			c.Abort()
 			return
 		}
 
-		if err := RevokeRefreshByRaw(c.Request.Context(), q, cookie.Value); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				// 存在しないトークンは無視
-			} else {
-				_ = c.Error(apperror.NewInternalError("RevokeRefreshByRaw", err, apperror.InternalServerMessageCommon))
-				c.Abort()
+		var newRefresh string
+		var expiresAt time.Time
+		err = runner.RunInTx(c.Request.Context(), func(qtx db.Querier) error {
+			// 先に失効させる。並行する rotate は行ロックで待たされ、0 件になる
+			consumed, err := qtx.ConsumeRefreshToken(c.Request.Context(), hash)
+			if err != nil {
+				return err
+			}
+			if consumed == 0 {
+				return errRefreshTokenReused
+			}
+			newRefresh, _, expiresAt, err = GenerateRefreshToken(c.Request.Context(), qtx, user.ID, rt.FamilyID, sessionMetaFrom(c))
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, errRefreshTokenReused) {
+				// 読んでから失効させるまでの間に失効した。rotate かログアウト等かを引き直して判断する
+				latest, err := q.GetRefreshTokenByHash(c.Request.Context(), hash)
+				if err != nil {
+					_ = c.Error(apperror.NewInternalError("GetRefreshTokenByHash", err, apperror.InternalServerMessageCommon))
+					c.Abort()
+					return
+				}
+				refreshTokenRevoked(c, q, latest)
 				return
 			}
+			_ = c.Error(apperror.NewInternalError("RotateRefreshToken", err, apperror.InternalServerMessageRefresh))
+			c.Abort()
+			return
 		}
 
-		accessToken, err := tokenGenerator.GenerateToken(user.ID)
+		accessToken, err := tokenGenerator.GenerateToken(user.ID, user.Role, rt.FamilyID)
 		if err != nil {
 			_ = c.Error(apperror.NewInternalError("GenerateToken", err, apperror.InternalServerMessageGenToken))
 			c.Abort()
 			return
 		}
 
-		accessCookie := &http.Cookie{
-			Name:     "access_token",
-			Value:    accessToken,
-			HttpOnly: true,
-			Path:     "/",
-			Expires:  time.Now().Add(15 * time.Minute),
-			MaxAge:   15 * 60,
-			SameSite: http.SameSiteLaxMode,
-		}
-		refreshCookie := &http.Cookie{
-			Name:     "refresh_token",
-			Value:    newRefresh,
-			HttpOnly: true,
-			Path:     "/api/refresh",
-			Expires:  expiresAt,
-			MaxAge:   14 * 24 * 60 * 60,
-			SameSite: http.SameSiteStrictMode,
-		}
-		if gin.Mode() == gin.ReleaseMode {
-			accessCookie.Secure = true
-			refreshCookie.Secure = true
-		}
-
-		http.SetCookie(c.Writer, accessCookie)
-		http.SetCookie(c.Writer, refreshCookie)
+		setAuthCookies(c, accessToken, newRefresh, expiresAt)
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "トークンを更新しました",
 			"token":   accessToken,
-			"user": gin.H{
-				"id":    user.ID,
-				"name":  user.Name,
-				"email": user.Email,
-				"role":  user.Role,
-			},
+			"user":    toUserResponse(user),
 		})
 
 		c.Set("userID", user.ID)
@@ -125,6 +129,40 @@ func RefreshTokenHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.H
 	}
 }
 
+// refreshTokenRevoked は失効済みトークンが提示されたときの応答を返す。
+// rotate で失効したトークンの再提示だけを漏洩したトークンの再利用とみなし、ファミリーごと失効させる。
+// ログアウトやセッションの失効で無効になったトークンは、単に 401 を返す
+func refreshTokenRevoked(c *gin.Context, q db.Querier, rt db.RefreshToken) {
+	if rt.RevokeReason.Valid && rt.RevokeReason.String == refreshRevokeReasonRotated {
+		refreshTokenReused(c, q, rt)
+		return
+	}
+	_ = c.Error(apperror.NewUnauthorizedError("refresh_token_revoked", apperror.UnauthorizedMessageAuth))
+	c.Abort()
+}
+
+// refreshTokenReused は rotate 済みトークンの再提示を漏洩したトークンの再利用とみなし、同じファミリーを全て失効させる。
+// 失効はロールバックされないよう、rotate のトランザクションの外で行う
+func refreshTokenReused(c *gin.Context, q db.Querier, rt db.RefreshToken) {
+	if err := q.RevokeRefreshTokenFamily(c.Request.Context(), rt.FamilyID); err != nil {
+		_ = c.Error(apperror.NewInternalError("RevokeRefreshTokenFamily", err, apperror.InternalServerMessageCommon))
+		c.Abort()
+		return
+	}
+	_ = c.Error(apperror.NewUnauthorizedError("refresh_token_reused", apperror.UnauthorizedMessageAuth))
+	c.Abort()
+
+	logging.LogEvent(c, logging.EventInput{
+		Event:  "auth_refresh_token_reuse_detected",
+		Status: http.StatusUnauthorized,
+		Level:  slog.LevelWarn,
+		Extra: []slog.Attr{
+			slog.Int64("target_user_id", rt.UserID),
+			slog.String("family_id", rt.FamilyID),
+		},
+	})
+}
+
 func RevokeRefreshHandler(q db.Querier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cookie, err := c.Request.Cookie("refresh_token")
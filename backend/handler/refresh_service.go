@@ -7,9 +7,40 @@ import (
 	"encoding/hex"
 	"sol_coffeesys/backend/db"
 	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
 )
 
-func GenerateRefreshToken(ctx context.Context, q db.Querier, userID int64) (rawToken string, tokenHash string, expiresAt time.Time, err error) {
+const userAgentMaxLength = 255
+
+// SessionMeta はセッション一覧で端末を見分けるための、トークン発行時のリクエスト情報
+type SessionMeta struct {
+	UserAgent string
+	IPAddress string
+}
+
+func sessionMetaFrom(c *gin.Context) SessionMeta {
+	ua := c.Request.UserAgent()
+	if utf8.RuneCountInString(ua) > userAgentMaxLength {
+		ua = string([]rune(ua)[:userAgentMaxLength])
+	}
+	return SessionMeta{UserAgent: ua, IPAddress: c.ClientIP()}
+}
+
+// newSessionID はログイン1回分のセッション(リフレッシュトークンのファミリー)IDを払い出す。
+// アクセストークンの sid にも同じ値を入れ、操作中のセッションを識別する
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken は sessionID のファミリーにリフレッシュトークンを発行する。
+// ログイン時は新しいセッションID、rotate 時は元のトークンのファミリーを渡す
+func GenerateRefreshToken(ctx context.Context, q db.Querier, userID int64, sessionID string, meta SessionMeta) (rawToken string, tokenHash string, expiresAt time.Time, err error) {
 	raw := make([]byte, 32)
 	if _, err := rand.Read(raw); err != nil {
 		return "", "", time.Time{}, err
@@ -23,6 +54,9 @@ func GenerateRefreshToken(ctx context.Context, q db.Querier, userID int64) (rawT
 		UserID:    userID,
 		TokenHash: tokenHash,
 		ExpiresAt: expiresAt,
+		FamilyID:  sessionID,
+		UserAgent: meta.UserAgent,
+		IpAddress: meta.IPAddress,
 	}); err != nil {
 		return "", "", time.Time{}, err
 	}
@@ -25,13 +25,17 @@ func TestGenerateRefreshToken_Success(t *testing.T) {
 			captured = args.Get(1).(db.CreateRefreshTokenParams)
 		}).Return(
 		db.RefreshToken{ID: 1, UserID: 1}, nil)
-	raw, hash, expiresAt, err := handler.GenerateRefreshToken(context.Background(), mockDB, 1)
+	meta := handler.SessionMeta{UserAgent: "Mozilla/5.0", IPAddress: "192.0.2.1"}
+	raw, hash, expiresAt, err := handler.GenerateRefreshToken(context.Background(), mockDB, 1, "family-1", meta)
 	assert.NoError(t, err)
 	assert.Equal(t, 64, len(raw))
 
 	sum := sha256.Sum256([]byte(raw))
 	assert.Equal(t, hash, hex.EncodeToString(sum[:]))
 	assert.Equal(t, hash, captured.TokenHash)
+	assert.Equal(t, "family-1", captured.FamilyID)
+	assert.Equal(t, "Mozilla/5.0", captured.UserAgent)
+	assert.Equal(t, "192.0.2.1", captured.IpAddress)
 	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), expiresAt, 5*time.Second)
 
 	mockDB.AssertExpectations(t)
@@ -40,7 +44,7 @@ func TestGenerateRefreshToken_Success(t *testing.T) {
 func TestGenerateRefreshToken_DBError(t *testing.T) {
 	mockDB := new(testutil.MockDB)
 	mockDB.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(db.RefreshToken{}, errors.New("db error"))
-	_, _, _, err := handler.GenerateRefreshToken(context.Background(), mockDB, 1)
+	_, _, _, err := handler.GenerateRefreshToken(context.Background(), mockDB, 1, "family-1", handler.SessionMeta{})
 	assert.Error(t, err)
 	mockDB.AssertExpectations(t)
 }
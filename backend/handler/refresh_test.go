@@ -7,6 +7,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
 	"sol_coffeesys/backend/handler/testutil"
@@ -39,6 +40,7 @@ func TestRefreshTokenHandler_Success(t *testing.T) {
 			UserID:    1,
 			TokenHash: oldHash,
 			ExpiresAt: time.Now().Add(1 * time.Hour),
+			FamilyID:  "family-1",
 		}, nil)
 
 	mockDB.On("GetUserByID", mock.Anything, int64(1)).Return(
@@ -49,8 +51,9 @@ func TestRefreshTokenHandler_Success(t *testing.T) {
 			Role:  "member",
 		}, nil)
 
-	mockTG.On("GenerateToken", int64(1)).Return("new_access_token", nil)
+	mockTG.On("GenerateToken", int64(1), "member", "family-1").Return("new_access_token", nil)
 
+	mockDB.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(int64(1), nil)
 	var captured db.CreateRefreshTokenParams
 	mockDB.On("CreateRefreshToken", mock.Anything, mock.Anything).
 		Run(func(args mock.Arguments) {
@@ -58,9 +61,7 @@ func TestRefreshTokenHandler_Success(t *testing.T) {
 		}).
 		Return(db.RefreshToken{ID: 2, UserID: 1}, nil)
 
-	mockDB.On("RevokeRefreshTokenByHash", mock.Anything, oldHash).Return(nil)
-
-	router.POST("/api/refresh", handler.RefreshTokenHandler(mockDB, mockTG))
+	router.POST("/api/refresh", handler.RefreshTokenHandler(mockDB, testutil.TxRunner{Querier: mockDB}, mockTG))
 
 	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
 	req.AddCookie(&http.Cookie{
@@ -102,6 +103,8 @@ func TestRefreshTokenHandler_Success(t *testing.T) {
 	sum2 := sha256.Sum256([]byte(refreshCookie.Value))
 	gotHash := hex.EncodeToString(sum2[:])
 	assert.Equal(t, gotHash, captured.TokenHash)
+	// rotate 後も同じファミリーに属する
+	assert.Equal(t, "family-1", captured.FamilyID)
 	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), captured.ExpiresAt, 5*time.Second)
 
 	mockDB.AssertExpectations(t)
@@ -155,7 +158,28 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 			cookie: strings.Repeat("a", 64),
 		},
 		{
-			name:           "token失効済み",
+			name:           "rotate済みtokenの再提示(再利用検知でファミリーごと失効)",
+			expectedStatus: http.StatusUnauthorized,
+			setupMock: func(m *testutil.MockDB) {
+				oldRefresh := strings.Repeat("a", 64)
+				sum := sha256.Sum256([]byte(oldRefresh))
+				oldHash := hex.EncodeToString(sum[:])
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(db.RefreshToken{
+					ID:           1,
+					UserID:       1,
+					TokenHash:    oldHash,
+					ExpiresAt:    time.Now().Add(1 * time.Hour),
+					RevokedAt:    sql.NullTime{Valid: true, Time: time.Now().Add(-1 * time.Hour)},
+					RevokeReason: sql.NullString{Valid: true, String: "rotated"},
+					FamilyID:     "family-1",
+				}, nil)
+				m.On("RevokeRefreshTokenFamily", mock.Anything, "family-1").Return(nil)
+			},
+			cookie: strings.Repeat("a", 64),
+		},
+		{
+			// ログアウト等で失効したトークンは再利用とみなさず、他の端末のセッションを巻き込まない
+			name:           "ログアウトで失効したtoken(ファミリーは失効させない)",
 			expectedStatus: http.StatusUnauthorized,
 			setupMock: func(m *testutil.MockDB) {
 				oldRefresh := strings.Repeat("a", 64)
@@ -167,10 +191,31 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 					TokenHash: oldHash,
 					ExpiresAt: time.Now().Add(1 * time.Hour),
 					RevokedAt: sql.NullTime{Valid: true, Time: time.Now().Add(-1 * time.Hour)},
+					FamilyID:  "family-1",
 				}, nil)
 			},
 			cookie: strings.Repeat("a", 64),
 		},
+		{
+			name:           "DBエラー ファミリー失効",
+			expectedStatus: http.StatusInternalServerError,
+			setupMock: func(m *testutil.MockDB) {
+				oldRefresh := strings.Repeat("a", 64)
+				sum := sha256.Sum256([]byte(oldRefresh))
+				oldHash := hex.EncodeToString(sum[:])
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(db.RefreshToken{
+					ID:           1,
+					UserID:       1,
+					TokenHash:    oldHash,
+					ExpiresAt:    time.Now().Add(1 * time.Hour),
+					RevokedAt:    sql.NullTime{Valid: true, Time: time.Now().Add(-1 * time.Hour)},
+					RevokeReason: sql.NullString{Valid: true, String: "rotated"},
+					FamilyID:     "family-1",
+				}, nil)
+				m.On("RevokeRefreshTokenFamily", mock.Anything, "family-1").Return(errors.New("db error"))
+			},
+			cookie: strings.Repeat("a", 64),
+		},
 		{
 			name:           "DBエラー Get",
 			expectedStatus: http.StatusInternalServerError,
@@ -192,12 +237,27 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 					db.RefreshToken{ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour)}, nil)
 				m.On("GetUserByID", mock.Anything, int64(1)).Return(
 					db.User{ID: 1, Email: "test@eample.com", Name: "test", Role: "member"}, nil)
+				m.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(int64(1), nil)
 				m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(
 					db.RefreshToken{}, errors.New("db create error"))
 			},
 			cookie:         strings.Repeat("a", 64),
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name:           "利用停止中のアカウント",
+			expectedStatus: http.StatusForbidden,
+			setupMock: func(m *testutil.MockDB) {
+				oldRefresh := strings.Repeat("a", 64)
+				sum := sha256.Sum256([]byte(oldRefresh))
+				oldHash := hex.EncodeToString(sum[:])
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(
+					db.RefreshToken{ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour)}, nil)
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(
+					db.User{ID: 1, Email: "x", Name: "x", Role: "member", Status: auth.UserStatusSuspended}, nil)
+			},
+			cookie: strings.Repeat("a", 64),
+		},
 		{
 			name: "DBエラー Revoke",
 			setupMock: func(m *testutil.MockDB) {
@@ -208,14 +268,54 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 					db.RefreshToken{ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour)}, nil)
 				m.On("GetUserByID", mock.Anything, int64(1)).Return(
 					db.User{ID: 1, Email: "test@eample.com", Name: "test", Role: "member"}, nil)
-				m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(
-					db.RefreshToken{ID: 2, UserID: 1}, nil)
-				m.On("RevokeRefreshTokenByHash", mock.Anything, oldHash).Return(
-					errors.New("db revoke error"))
+				m.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(
+					int64(0), errors.New("db revoke error"))
 			},
 			cookie:         strings.Repeat("a", 64),
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name:           "並行するrotateに先を越された(再利用検知でファミリーごと失効)",
+			expectedStatus: http.StatusUnauthorized,
+			setupMock: func(m *testutil.MockDB) {
+				oldRefresh := strings.Repeat("a", 64)
+				sum := sha256.Sum256([]byte(oldRefresh))
+				oldHash := hex.EncodeToString(sum[:])
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(
+					db.RefreshToken{ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour), FamilyID: "family-1"}, nil).Once()
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(
+					db.User{ID: 1, Email: "test@eample.com", Name: "test", Role: "member"}, nil)
+				m.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(int64(0), nil)
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(
+					db.RefreshToken{
+						ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour), FamilyID: "family-1",
+						RevokedAt:    sql.NullTime{Valid: true, Time: time.Now()},
+						RevokeReason: sql.NullString{Valid: true, String: "rotated"},
+					}, nil).Once()
+				m.On("RevokeRefreshTokenFamily", mock.Anything, "family-1").Return(nil)
+			},
+			cookie: strings.Repeat("a", 64),
+		},
+		{
+			name:           "rotate中にログアウトで失効した(ファミリーは失効させない)",
+			expectedStatus: http.StatusUnauthorized,
+			setupMock: func(m *testutil.MockDB) {
+				oldRefresh := strings.Repeat("a", 64)
+				sum := sha256.Sum256([]byte(oldRefresh))
+				oldHash := hex.EncodeToString(sum[:])
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(
+					db.RefreshToken{ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour), FamilyID: "family-1"}, nil).Once()
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(
+					db.User{ID: 1, Email: "test@eample.com", Name: "test", Role: "member"}, nil)
+				m.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(int64(0), nil)
+				m.On("GetRefreshTokenByHash", mock.Anything, oldHash).Return(
+					db.RefreshToken{
+						ID: 1, UserID: 1, TokenHash: oldHash, ExpiresAt: time.Now().Add(1 * time.Hour), FamilyID: "family-1",
+						RevokedAt: sql.NullTime{Valid: true, Time: time.Now()},
+					}, nil).Once()
+			},
+			cookie: strings.Repeat("a", 64),
+		},
 		{
 			name:           "token生成失敗",
 			expectedStatus: http.StatusInternalServerError,
@@ -231,13 +331,13 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 						ExpiresAt: time.Now().Add(1 * time.Hour),
 					}, nil)
 				m.On("GetUserByID", mock.Anything, int64(1)).Return(db.User{ID: 1, Email: "x", Name: "x", Role: "member"}, nil)
+				m.On("ConsumeRefreshToken", mock.Anything, oldHash).Return(int64(1), nil)
 				m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(
 					db.RefreshToken{ID: 2, UserID: 1}, nil)
-				m.On("RevokeRefreshTokenByHash", mock.Anything, oldHash).Return(nil)
 			},
 
 			setupTG: func(tg *MockTokenGenerator) {
-				tg.On("GenerateToken", int64(1)).Return("", errors.New("token gen failed"))
+				tg.On("GenerateToken", int64(1), "member", "").Return("", errors.New("token gen failed"))
 			},
 			cookie: strings.Repeat("a", 64),
 		},
@@ -257,7 +357,7 @@ func TestRefreshTokenHandler_Errros(t *testing.T) {
 				tt.setupTG(mockTG)
 			}
 
-			router.POST("/api/refresh", handler.RefreshTokenHandler(mockDB, mockTG))
+			router.POST("/api/refresh", handler.RefreshTokenHandler(mockDB, testutil.TxRunner{Querier: mockDB}, mockTG))
 
 			req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
 			if tt.cookie != "" {
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionResponse はログイン中の端末1件分。トークンやファミリーIDは返さない
+type SessionResponse struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func toSessionResponse(s db.ListActiveSessionsByUserRow, currentSessionID string) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		UserAgent:  s.UserAgent,
+		IPAddress:  s.IpAddress,
+		SignedInAt: s.SignedInAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+		Current:    currentSessionID != "" && s.FamilyID == currentSessionID,
+	}
+}
+
+// ＋＋セッション一覧取得機能＋＋
+func ListSessionsHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		sessions, err := q.ListActiveSessionsByUser(c.Request.Context(), principal.UserID)
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("ListActiveSessionsByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		currentSessionID := principal.SessionID
+		resp := make([]SessionResponse, 0, len(sessions))
+		for _, s := range sessions {
+			resp = append(resp, toSessionResponse(s, currentSessionID))
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": resp})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_sessions_listed",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
+
+// ＋＋セッション終了機能＋＋
+// 指定したセッションのリフレッシュトークンを失効させる。
+// 発行済みのアクセストークンは有効期限(15分)まで使えるが、以降は更新できない
+func RevokeSessionHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			_ = c.Error(apperror.NewValidationError("id", nil, "", ""))
+			return
+		}
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		revoked, err := q.RevokeSessionByUser(c.Request.Context(), db.RevokeSessionByUserParams{
+			ID:     id,
+			UserID: principal.UserID,
+		})
+		if err != nil {
+			_ = c.Error(apperror.NewInternalError("RevokeSessionByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+		// 他人のセッション・終了済みのセッションは区別せず 404
+		if revoked == 0 {
+			_ = c.Error(apperror.NewNotFoundError("session", id, ""))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_session_revoked",
+			Status: http.StatusNoContent,
+			Level:  slog.LevelInfo,
+			Extra:  []slog.Attr{slog.Int64("session_id", id)},
+		})
+	}
+}
+
+// ＋＋他の端末からログアウト機能＋＋
+// 操作中のセッションはアクセストークンの sid で識別する
+func RevokeOtherSessionsHandler(q db.Querier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		// sid を持たない古いトークンでは自分のセッションまで失効させてしまうため、リフレッシュを促す
+		sessionID := principal.SessionID
+		if sessionID == "" {
+			_ = c.Error(apperror.NewUnauthorizedError("session_id_missing", apperror.UnauthorizedMessageAuth))
+			return
+		}
+
+		if err := q.RevokeOtherSessionsByUser(c.Request.Context(), db.RevokeOtherSessionsByUserParams{
+			UserID:   principal.UserID,
+			FamilyID: sessionID,
+		}); err != nil {
+			_ = c.Error(apperror.NewInternalError("RevokeOtherSessionsByUser", err, apperror.InternalServerMessageCommon))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "他の端末からログアウトしました"})
+
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_other_sessions_revoked",
+			Status: http.StatusOK,
+			Level:  slog.LevelInfo,
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestListSessionsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		sessionID      string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+		checkResponse  func(*testing.T, *httptest.ResponseRecorder)
+	}{
+		{
+			name:      "正常系：操作中のセッションに current が付く",
+			sessionID: "family-1",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ListActiveSessionsByUser", mock.Anything, int64(1)).Return([]db.ListActiveSessionsByUserRow{
+					{ID: 12, FamilyID: "family-2", UserAgent: "iPad", IpAddress: "192.0.2.2", SignedInAt: now.Add(-48 * time.Hour), LastUsedAt: now, ExpiresAt: now.Add(14 * 24 * time.Hour)},
+					{ID: 10, FamilyID: "family-1", UserAgent: "Mozilla/5.0", IpAddress: "192.0.2.1", SignedInAt: now.Add(-time.Hour), LastUsedAt: now.Add(-time.Minute), ExpiresAt: now.Add(14 * 24 * time.Hour)},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var resp struct {
+					Sessions []map[string]any `json:"sessions"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				if assert.Len(t, resp.Sessions, 2) {
+					assert.Equal(t, float64(12), resp.Sessions[0]["id"])
+					assert.Equal(t, "iPad", resp.Sessions[0]["user_agent"])
+					assert.Equal(t, false, resp.Sessions[0]["current"])
+					assert.Equal(t, true, resp.Sessions[1]["current"])
+				}
+				assert.NotContains(t, w.Body.String(), "family")
+			},
+		},
+		{
+			name: "正常系：sid の無いトークンでは current が付かない",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ListActiveSessionsByUser", mock.Anything, int64(1)).Return([]db.ListActiveSessionsByUserRow{
+					{ID: 10, FamilyID: "family-1"},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Contains(t, w.Body.String(), `"current":false`)
+			},
+		},
+		{
+			name: "正常系：セッションが無ければ空配列",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ListActiveSessionsByUser", mock.Anything, int64(1)).Return(nil, nil)
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.JSONEq(t, `{"sessions":[]}`, w.Body.String())
+			},
+		},
+		{
+			name: "異常系：DBエラー",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("ListActiveSessionsByUser", mock.Anything, int64(1)).Return(nil, errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			router.GET("/api/me/sessions", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1, SessionID: tt.sessionID})
+				ListSessionsHandler(mockDB)(c)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/me/sessions", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, w)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeSessionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		sessionID      string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+	}{
+		{
+			name:      "正常系：自分のセッションを終了できる",
+			sessionID: "12",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("RevokeSessionByUser", mock.Anything, db.RevokeSessionByUserParams{ID: 12, UserID: 1}).
+					Return(int64(1), nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:      "異常系：他人または終了済みのセッションは404",
+			sessionID: "99",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("RevokeSessionByUser", mock.Anything, db.RevokeSessionByUserParams{ID: 99, UserID: 1}).
+					Return(int64(0), nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "異常系：IDが数値でない",
+			sessionID:      "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "異常系：DBエラー",
+			sessionID: "12",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("RevokeSessionByUser", mock.Anything, db.RevokeSessionByUserParams{ID: 12, UserID: 1}).
+					Return(int64(0), errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			router.DELETE("/api/me/sessions/:id", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1})
+				RevokeSessionHandler(mockDB)(c)
+			})
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/me/sessions/"+tt.sessionID, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeOtherSessionsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		sessionID      string
+		setupMock      func(*testutil.MockDB)
+		expectedStatus int
+	}{
+		{
+			name:      "正常系：操作中以外のセッションを失効させる",
+			sessionID: "family-1",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("RevokeOtherSessionsByUser", mock.Anything, db.RevokeOtherSessionsByUserParams{UserID: 1, FamilyID: "family-1"}).
+					Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "異常系：sid の無いトークンは401(自分のセッションまで消さない)",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "異常系：DBエラー",
+			sessionID: "family-1",
+			setupMock: func(m *testutil.MockDB) {
+				m.On("RevokeOtherSessionsByUser", mock.Anything, mock.Anything).Return(errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+			router.POST("/api/me/sessions/revoke-others", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: 1, SessionID: tt.sessionID})
+				RevokeOtherSessionsHandler(mockDB)(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/me/sessions/revoke-others", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
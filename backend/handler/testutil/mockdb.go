@@ -3,6 +3,7 @@ package testutil
 import (
 	"context"
 	"sol_coffeesys/backend/db"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -66,6 +67,51 @@ func (m *MockDB) ListProducts(ctx context.Context) ([]db.Product, error) {
 	return args.Get(0).([]db.Product), args.Error(1)
 }
 
+func (m *MockDB) SearchProductsByID(ctx context.Context, arg db.SearchProductsByIDParams) ([]db.Product, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.Product), args.Error(1)
+}
+
+func (m *MockDB) SearchProductsByPriceAsc(ctx context.Context, arg db.SearchProductsByPriceAscParams) ([]db.Product, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.Product), args.Error(1)
+}
+
+func (m *MockDB) SearchProductsByPriceDesc(ctx context.Context, arg db.SearchProductsByPriceDescParams) ([]db.Product, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.Product), args.Error(1)
+}
+
+func (m *MockDB) SearchProductsByName(ctx context.Context, arg db.SearchProductsByNameParams) ([]db.Product, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.Product), args.Error(1)
+}
+
+func (m *MockDB) SearchProductsByNewest(ctx context.Context, arg db.SearchProductsByNewestParams) ([]db.Product, error) {
+	args := m.Called(ctx, arg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.Product), args.Error(1)
+}
+
+func (m *MockDB) CountProducts(ctx context.Context, arg db.CountProductsParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockDB) UpdateProduct(ctx context.Context, arg db.UpdateProductParams) (db.Product, error) {
 	args := m.Called(ctx, arg)
 	return args.Get(0).(db.Product), args.Error(1)
@@ -81,6 +127,11 @@ func (m *MockDB) GetUserByID(ctx context.Context, id int64) (db.User, error) {
 	return args.Get(0).(db.User), args.Error(1)
 }
 
+func (m *MockDB) MarkUserEmailVerified(ctx context.Context, id int64) (db.User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(db.User), args.Error(1)
+}
+
 func (m *MockDB) UpdateUserRole(ctx context.Context, arg db.UpdateUserRoleParams) (db.User, error) {
 	args := m.Called(ctx, arg)
 	return args.Get(0).(db.User), args.Error(1)
@@ -91,6 +142,46 @@ func (m *MockDB) SetResetToken(ctx context.Context, arg db.SetResetTokenParams)
 	return args.Get(0).(db.User), args.Error(1)
 }
 
+func (m *MockDB) ResetPasswordByToken(ctx context.Context, arg db.ResetPasswordByTokenParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.User), args.Error(1)
+}
+
+func (m *MockDB) UpdateUserStatus(ctx context.Context, arg db.UpdateUserStatusParams) (db.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.User), args.Error(1)
+}
+
+func (m *MockDB) UpdateUserLastLogin(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockDB) SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.SearchUsersRow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]db.SearchUsersRow), args.Error(1)
+}
+
+func (m *MockDB) CountUsers(ctx context.Context, arg db.CountUsersParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) CountActiveRefreshTokensByUser(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) UpdateUserPassword(ctx context.Context, arg db.UpdateUserPasswordParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
 func (m *MockDB) ListCartItemsByUser(ctx context.Context, userID int64) ([]db.ListCartItemsByUserRow, error) {
 	args := m.Called(ctx, userID)
 	if args.Get(0) == nil {
@@ -159,6 +250,11 @@ func (m *MockDB) CreateOrderItem(ctx context.Context, arg db.CreateOrderItemPara
 	return args.Get(0).(db.OrderItem), args.Error(1)
 }
 
+func (m *MockDB) GetOrderCountByUser(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *MockDB) GetOrderByIDForUpdate(ctx context.Context, id int64) (db.GetOrderByIDForUpdateRow, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(db.GetOrderByIDForUpdateRow), args.Error(1)
@@ -172,13 +268,21 @@ func (m *MockDB) ListOrderItemsByOrderID(ctx context.Context, orderID int64) ([]
 	return args.Get(0).([]db.OrderItem), args.Error(1)
 }
 
+func (m *MockDB) ListOrderItemsByOrderIDs(ctx context.Context, orderIds []int64) ([]db.OrderItem, error) {
+	args := m.Called(ctx, orderIds)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.OrderItem), args.Error(1)
+}
+
 func (m *MockDB) UpdateOrderStatus(ctx context.Context, arg db.UpdateOrderStatusParams) (db.UpdateOrderStatusRow, error) {
 	args := m.Called(ctx, arg)
 	return args.Get(0).(db.UpdateOrderStatusRow), args.Error(1)
 }
 
-func (m *MockDB) ListOrdersByUser(ctx context.Context, userID int64) ([]db.ListOrdersByUserRow, error) {
-	args := m.Called(ctx, userID)
+func (m *MockDB) ListOrdersByUser(ctx context.Context, arg db.ListOrdersByUserParams) ([]db.ListOrdersByUserRow, error) {
+	args := m.Called(ctx, arg)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -205,3 +309,211 @@ func (m *MockDB) RevokeRefreshTokenByHash(ctx context.Context, tokenHash string)
 	args := m.Called(ctx, tokenHash)
 	return args.Error(0)
 }
+
+func (m *MockDB) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	args := m.Called(ctx, familyID)
+	return args.Error(0)
+}
+
+func (m *MockDB) ListActiveSessionsByUser(ctx context.Context, userID int64) ([]db.ListActiveSessionsByUserRow, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]db.ListActiveSessionsByUserRow), args.Error(1)
+}
+
+func (m *MockDB) RevokeSessionByUser(ctx context.Context, arg db.RevokeSessionByUserParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) RevokeOtherSessionsByUser(ctx context.Context, arg db.RevokeOtherSessionsByUserParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) RevokeAllRefreshTokensByUser(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockDB) CreatePayment(ctx context.Context, arg db.CreatePaymentParams) (db.Payment, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.Payment), args.Error(1)
+}
+
+func (m *MockDB) GetPaymentByOrderID(ctx context.Context, orderID int64) (db.Payment, error) {
+	args := m.Called(ctx, orderID)
+	return args.Get(0).(db.Payment), args.Error(1)
+}
+
+func (m *MockDB) UpdatePaymentStatus(ctx context.Context, arg db.UpdatePaymentStatusParams) (db.Payment, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.Payment), args.Error(1)
+}
+
+func (m *MockDB) CreateIdempotencyKey(ctx context.Context, arg db.CreateIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.IdempotencyKey), args.Error(1)
+}
+
+func (m *MockDB) GetIdempotencyKey(ctx context.Context, arg db.GetIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.IdempotencyKey), args.Error(1)
+}
+
+func (m *MockDB) SaveIdempotencyResponse(ctx context.Context, arg db.SaveIdempotencyResponseParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteIdempotencyKey(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetLoginThrottle(ctx context.Context, throttleKey string) (db.LoginThrottle, error) {
+	args := m.Called(ctx, throttleKey)
+	return args.Get(0).(db.LoginThrottle), args.Error(1)
+}
+
+func (m *MockDB) RecordLoginFailure(ctx context.Context, arg db.RecordLoginFailureParams) (int32, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int32), args.Error(1)
+}
+
+func (m *MockDB) LockLoginThrottle(ctx context.Context, arg db.LockLoginThrottleParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) ResetLoginThrottle(ctx context.Context, throttleKey string) error {
+	args := m.Called(ctx, throttleKey)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetUserTOTP(ctx context.Context, userID int64) (db.UserTotp, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(db.UserTotp), args.Error(1)
+}
+
+func (m *MockDB) UpsertPendingUserTOTP(ctx context.Context, arg db.UpsertPendingUserTOTPParams) (db.UserTotp, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.UserTotp), args.Error(1)
+}
+
+func (m *MockDB) EnableUserTOTP(ctx context.Context, arg db.EnableUserTOTPParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) ConsumeTOTPStep(ctx context.Context, arg db.ConsumeTOTPStepParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) DeleteUserTOTP(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockDB) CreateRecoveryCode(ctx context.Context, arg db.CreateRecoveryCodeParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteRecoveryCodesByUser(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockDB) ConsumeRecoveryCode(ctx context.Context, arg db.ConsumeRecoveryCodeParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.ApiKey), args.Error(1)
+}
+
+func (m *MockDB) ListAPIKeysByUser(ctx context.Context, userID int64) ([]db.ApiKey, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]db.ApiKey), args.Error(1)
+}
+
+func (m *MockDB) RevokeAPIKeyByUser(ctx context.Context, arg db.RevokeAPIKeyByUserParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (db.GetActiveAPIKeyByHashRow, error) {
+	args := m.Called(ctx, keyHash)
+	return args.Get(0).(db.GetActiveAPIKeyByHashRow), args.Error(1)
+}
+
+func (m *MockDB) TouchAPIKeyLastUsed(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetUserIdentity(ctx context.Context, arg db.GetUserIdentityParams) (db.UserIdentity, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.UserIdentity), args.Error(1)
+}
+
+func (m *MockDB) CreateUserIdentity(ctx context.Context, arg db.CreateUserIdentityParams) (db.UserIdentity, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.UserIdentity), args.Error(1)
+}
+
+func (m *MockDB) TouchUserIdentityLogin(ctx context.Context, arg db.TouchUserIdentityLoginParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) RevokeAllAPIKeysByUser(ctx context.Context, userID int64) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteStaleLoginThrottles(ctx context.Context, staleBefore time.Time) (int64, error) {
+	args := m.Called(ctx, staleBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) ConsumeRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) RetryPayment(ctx context.Context, arg db.RetryPaymentParams) (db.Payment, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(db.Payment), args.Error(1)
+}
+
+func (m *MockDB) SaveUserSuspension(ctx context.Context, arg db.SaveUserSuspensionParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+func (m *MockDB) DeleteUserSuspension(ctx context.Context, userID int64) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (m *MockDB) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDB) ListUsersByEmailFold(ctx context.Context, email string) ([]db.User, error) {
+	args := m.Called(ctx, email)
+	return args.Get(0).([]db.User), args.Error(1)
+}
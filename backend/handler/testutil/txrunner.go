@@ -0,0 +1,22 @@
+package testutil
+
+import (
+	"context"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/txn"
+)
+
+// TxRunner は txn.Runner のテスト用実装。
+// トランザクションを張らずに Querier(通常は MockDB)をそのまま fn に渡す。
+// Err を設定すると fn を呼ばずにそのエラーを返す。
+type TxRunner struct {
+	Querier db.Querier
+	Err     error
+}
+
+func (r TxRunner) RunInTx(ctx context.Context, fn func(q db.Querier) error, opts ...txn.Option) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return fn(r.Querier)
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/txn"
+)
+
+// 注文作成はロック競合が起きやすいため、デッドロック時に全体をやり直す回数
+const orderTxMaxAttempts = 3
+
+// logTxRetry は再試行のたびに警告ログを出す OnRetry フックを返す
+func logTxRetry(op string) func(ctx context.Context, attempt int, err error) {
+	return func(ctx context.Context, attempt int, err error) {
+		slog.WarnContext(ctx, "transaction retry", "op", op, "attempt", attempt, "error", err)
+	}
+}
+
+// txError は RunInTx から返ったエラーをレスポンス用のエラーに変換する。
+// 業務エラーはそのまま返し、再試行しきれなかった競合は 409、それ以外は op 名付きの 500 にする。
+func txError(op string, err error) error {
+	var ve *apperror.ValidationError
+	var ce *apperror.ConflictError
+	var ne *apperror.NotFoundError
+	var be *apperror.BusinessLogicError
+
+	if errors.As(err, &ve) || errors.As(err, &ne) || errors.As(err, &ce) || errors.As(err, &be) {
+		return err
+	}
+	if errors.Is(err, txn.ErrRetryExhausted) {
+		return apperror.NewConflictError("tx", "", apperror.ConflictMessageBusy)
+	}
+	return apperror.NewInternalError(op, err, apperror.InternalServerMessageCommon)
+}
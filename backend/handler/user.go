@@ -9,10 +9,12 @@ import (
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/redaction"
 	"sol_coffeesys/backend/pkg/validation"
 
 	"strconv"
-	"time"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
@@ -36,7 +38,9 @@ func HashPassword(password string) (string, error) {
 	return string(hashed), nil
 }
 
-func RegisterUserHandler(q db.Querier) gin.HandlerFunc {
+// RegisterUserHandler は unverified 状態で会員を作成し、確認メールを送る。
+// メールの送信に失敗しても登録は成功とし、利用者には再送 API から送り直してもらう
+func RegisterUserHandler(q db.Querier, verifier *EmailVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var req RegisterRequest
@@ -74,6 +78,7 @@ func RegisterUserHandler(q db.Querier) gin.HandlerFunc {
 			Email:        req.Email,
 			PasswordHash: string(hashed),
 			Role:         "member",
+			Status:       auth.UserStatusUnverified,
 		})
 		if err != nil {
 			var pqErr *pq.Error
@@ -87,8 +92,13 @@ func RegisterUserHandler(q db.Querier) gin.HandlerFunc {
 			return
 		}
 
+		if err := verifier.send(c.Request.Context(), user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "verification mail failed",
+				"request_id", c.GetString(logging.CtxKeyRequestID), "user_id", user.ID, "error", err)
+		}
+
 		// 登録成功		migrate -path db/migrations -database "postgres://user:password@db:5432/coffeesys_db?sslmode=disable" up
-		c.JSON(http.StatusCreated, user)
+		c.JSON(http.StatusCreated, toUserResponse(user))
 
 		c.Set("userID", user.ID)
 		logging.LogEvent(c, logging.EventInput{
@@ -105,7 +115,17 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-func LoginUserHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.HandlerFunc {
+// dummyPasswordHash は存在しないメールアドレスでも bcrypt の比較を行い、
+// 応答時間からアカウントの有無を推測されないようにするためのハッシュ
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hashed
+})
+
+func LoginUserHandler(q db.Querier, tokenGenerator auth.TokenGenerator, guard *loginguard.Guard) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req LoginRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -124,67 +144,89 @@ func LoginUserHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.Hand
 			return
 		}
 
-		user, err := q.GetUserByEmail(c.Request.Context(), req.Email)
+		ctx := c.Request.Context()
+		ip := c.ClientIP()
+
+		// ロック中はパスワードを照合しない(正しいパスワードでも弾く)
+		retryAfter, err := guard.Check(ctx, ip, req.Email)
 		if err != nil {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageEmailOrPassword))
+			_ = c.Error(apperror.NewInternalError("LoginGuardCheck", err, apperror.InternalServerMessageCommon))
 			return
 		}
-		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-		if err != nil {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageEmailOrPassword))
+		if retryAfter > 0 {
+			_ = c.Error(apperror.NewTooManyRequestsError("login_locked", retryAfter, apperror.TooManyRequestsMessageLogin))
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "auth_login_throttled",
+				Status: http.StatusTooManyRequests,
+				Level:  slog.LevelWarn,
+				Extra:  []slog.Attr{slog.String("email", redaction.MaskEmail(req.Email))},
+			})
 			return
 		}
 
-		token, err := tokenGenerator.GenerateToken(user.ID)
-		// token, err := auth.GenerateToken(int32(user.ID))
+		user, err := q.GetUserByEmail(ctx, req.Email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			_ = c.Error(apperror.NewInternalError("GetUserByEmail", err, apperror.InternalServerMessageCommon))
+			return
+		}
 		if err != nil {
-			_ = c.Error(apperror.NewInternalError("GenerateToken", err, apperror.InternalServerMessageGenToken))
+			// 存在しないユーザーでも同じだけ bcrypt を回す
+			_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+			loginFailed(c, guard, ip, req.Email, "user_not_found")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			loginFailed(c, guard, ip, req.Email, "password_mismatch")
+			return
+		}
+		// パスワードが一致した場合のみ状態を返す(停止中かどうかを第三者に知られないため)
+		if err := auth.CheckUserStatus(user.Status); err != nil {
+			_ = c.Error(err)
 			return
 		}
 
-		refreshToken, _, expiresAt, err := GenerateRefreshToken(c.Request.Context(), q, user.ID)
+		// TOTP を有効にしているユーザーには Cookie を渡さず、確認コードの入力を求める。
+		// 失敗回数は2段階目を通過するまで(LoginMFAHandler で)リセットしない。
+		// ここでリセットすると、パスワードを知る攻撃者がログインし直すたびに確認コードを試し放題になる
+		totpEnabled, err := hasTOTPEnabled(ctx, q, user.ID)
 		if err != nil {
-			_ = c.Error(apperror.NewInternalError("GenerateRefreshToken", err, apperror.InternalServerMessageRefresh))
+			_ = c.Error(apperror.NewInternalError("GetUserTOTP", err, apperror.InternalServerMessageCommon))
 			return
 		}
-
-		//  Cookieをセット
-		accessCookie := &http.Cookie{
-			Name:     "access_token",
-			Value:    token,
-			HttpOnly: true,
-			Path:     "/",
-			Expires:  time.Now().Add(15 * time.Minute),
-			MaxAge:   15 * 60,
-			SameSite: http.SameSiteLaxMode,
+		if !totpEnabled {
+			if err := guard.RecordSuccess(ctx, req.Email); err != nil {
+				_ = c.Error(apperror.NewInternalError("LoginGuardReset", err, apperror.InternalServerMessageCommon))
+				return
+			}
 		}
-
-		refreshCookie := &http.Cookie{
-			Name:     "refresh_token",
-			Value:    refreshToken,
-			HttpOnly: true,
-			Path:     "/api/refresh",
-			Expires:  expiresAt,
-			MaxAge:   60 * 60 * 24 * 14,
-			SameSite: http.SameSiteStrictMode,
+		if totpEnabled {
+			mfaToken, err := auth.GenerateMFAChallenge(user.ID)
+			if err != nil {
+				_ = c.Error(apperror.NewInternalError("GenerateMFAChallenge", err, apperror.InternalServerMessageGenToken))
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"message":      "確認コードを入力してください",
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			})
+
+			c.Set("userID", user.ID)
+			logging.LogEvent(c, logging.EventInput{
+				Event:  "auth_login_mfa_required",
+				Status: http.StatusOK,
+				Level:  slog.LevelInfo,
+			})
+			return
 		}
 
-		if gin.Mode() == gin.ReleaseMode {
-			accessCookie.Secure = true
-			refreshCookie.Secure = true
+		if !startSession(c, q, tokenGenerator, user) {
+			return
 		}
 
-		http.SetCookie(c.Writer, accessCookie)
-		http.SetCookie(c.Writer, refreshCookie)
-
 		c.JSON(http.StatusOK, gin.H{
 			"message": "ログイン成功",
-			"user": gin.H{
-				"id":    user.ID,
-				"name":  user.Name,
-				"email": user.Email,
-				"role":  user.Role,
-			},
+			"user":    toUserResponse(user),
 		})
 
 		c.Set("userID", user.ID)
@@ -197,6 +239,71 @@ func LoginUserHandler(q db.Querier, tokenGenerator auth.TokenGenerator) gin.Hand
 	}
 }
 
+// startSession はアクセストークンとリフレッシュトークンを発行して Cookie に載せ、最終ログイン日時を更新する。
+// 失敗したときはエラーを c に積んで false を返す
+func startSession(c *gin.Context, q db.Querier, tokenGenerator auth.TokenGenerator, user db.User) bool {
+	sessionID, err := newSessionID()
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("NewSessionID", err, apperror.InternalServerMessageRefresh))
+		return false
+	}
+
+	token, err := tokenGenerator.GenerateToken(user.ID, user.Role, sessionID)
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("GenerateToken", err, apperror.InternalServerMessageGenToken))
+		return false
+	}
+
+	refreshToken, _, expiresAt, err := GenerateRefreshToken(c.Request.Context(), q, user.ID, sessionID, sessionMetaFrom(c))
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("GenerateRefreshToken", err, apperror.InternalServerMessageRefresh))
+		return false
+	}
+
+	if err := q.UpdateUserLastLogin(c.Request.Context(), user.ID); err != nil {
+		_ = c.Error(apperror.NewInternalError("UpdateUserLastLogin", err, apperror.InternalServerMessageCommon))
+		return false
+	}
+
+	//  Cookieをセット
+	setAuthCookies(c, token, refreshToken, expiresAt)
+	return true
+}
+
+// loginFailed は失敗を数え、上限に達したら 429、そうでなければ 401 を返す。
+// どちらの理由で失敗したかはログにだけ残す
+func loginFailed(c *gin.Context, guard *loginguard.Guard, ip, email, reason string) {
+	retryAfter, err := guard.RecordFailure(c.Request.Context(), ip, email)
+	if err != nil {
+		_ = c.Error(apperror.NewInternalError("LoginGuardRecordFailure", err, apperror.InternalServerMessageCommon))
+		return
+	}
+	if retryAfter > 0 {
+		_ = c.Error(apperror.NewTooManyRequestsError("login_locked", retryAfter, apperror.TooManyRequestsMessageLogin))
+		logging.LogEvent(c, logging.EventInput{
+			Event:  "auth_login_locked",
+			Status: http.StatusTooManyRequests,
+			Level:  slog.LevelWarn,
+			Extra: []slog.Attr{
+				slog.String("email", redaction.MaskEmail(email)),
+				slog.String("reason", reason),
+				slog.Duration("lockout", retryAfter),
+			},
+		})
+		return
+	}
+	_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageEmailOrPassword))
+	logging.LogEvent(c, logging.EventInput{
+		Event:  "auth_login_failed",
+		Status: http.StatusUnauthorized,
+		Level:  slog.LevelWarn,
+		Extra: []slog.Attr{
+			slog.String("email", redaction.MaskEmail(email)),
+			slog.String("reason", reason),
+		},
+	})
+}
+
 // ＋＋権限変更機能＋＋
 type SetUserRoleRequest struct {
 	Role string `json:"role" binding:"required"`
@@ -210,14 +317,13 @@ func SetUserRoleHandler(q db.Querier) gin.HandlerFunc {
 			_ = c.Error(apperror.NewValidationError("id", nil, "", ""))
 			return
 		}
-		raw, exists := c.Get("userID")
-		if !exists {
-			_ = c.Error(apperror.NewUnauthorizedError("", apperror.UnauthorizedMessageAuth))
+		principal, err := auth.PrincipalFrom(c)
+		if err != nil {
+			_ = c.Error(err)
 			return
 		}
-		adminID := raw.(int64)
 
-		if adminID == userID {
+		if principal.UserID == userID {
 			_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageRole))
 			return
 		}
@@ -245,13 +351,16 @@ func SetUserRoleHandler(q db.Querier) gin.HandlerFunc {
 			_ = c.Error(apperror.NewInternalError("UpdateUserRole", err, apperror.InternalServerMessageCommon))
 			return
 		}
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, adminUserFromUser(user))
 
-		c.Set("userID", userID)
 		logging.LogEvent(c, logging.EventInput{
 			Event:  "auth_setrole_succeeded",
 			Status: http.StatusOK,
 			Level:  slog.LevelInfo,
+			Extra: []slog.Attr{
+				slog.Int64("target_user_id", userID),
+				slog.String("role", user.Role),
+			},
 		})
 	}
 }
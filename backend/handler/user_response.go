@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"database/sql"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"time"
+)
+
+// db.User には password_hash / reset_token が含まれるため、レスポンスには必ずこのファイルの型を使う
+
+// UserResponse は本人・一般向けのユーザー表現
+type UserResponse struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	// HasPassword が false の会員(外部 IdP で作成した会員など)は、パスワード再設定から設定するまで
+	// パスワードの変更・2段階認証の解除ができない
+	HasPassword bool `json:"has_password"`
+}
+
+func toUserResponse(u db.User) UserResponse {
+	return UserResponse{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.Status != auth.UserStatusUnverified,
+		HasPassword:   u.PasswordHash != "",
+	}
+}
+
+// 管理画面向けのユーザー表現。password_hash / reset_token は含めない
+type AdminUserResponse struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Email       string  `json:"email"`
+	Role        string  `json:"role"`
+	Status      string  `json:"status"`
+	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at"`
+	LastLoginAt *string `json:"last_login_at"`
+}
+
+type AdminUserDetailResponse struct {
+	AdminUserResponse
+	OrderCount         int64 `json:"order_count"`
+	ActiveSessionCount int64 `json:"active_session_count"`
+}
+
+func toAdminUserResponse(id int64, name, email, role, status string, createdAt, updatedAt time.Time, lastLoginAt sql.NullTime) AdminUserResponse {
+	var lastLogin *string
+	if lastLoginAt.Valid {
+		v := lastLoginAt.Time.Format(time.RFC3339)
+		lastLogin = &v
+	}
+	return AdminUserResponse{
+		ID:          id,
+		Name:        name,
+		Email:       email,
+		Role:        role,
+		Status:      status,
+		CreatedAt:   createdAt.Format(time.RFC3339),
+		UpdatedAt:   updatedAt.Format(time.RFC3339),
+		LastLoginAt: lastLogin,
+	}
+}
+
+func adminUserFromUser(u db.User) AdminUserResponse {
+	return toAdminUserResponse(u.ID, u.Name, u.Email, u.Role, u.Status, u.CreatedAt, u.UpdatedAt, u.LastLoginAt)
+}
+
+func adminUserFromRow(r db.SearchUsersRow) AdminUserResponse {
+	return toAdminUserResponse(r.ID, r.Name, r.Email, r.Role, r.Status, r.CreatedAt, r.UpdatedAt, r.LastLoginAt)
+}
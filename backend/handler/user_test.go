@@ -7,14 +7,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
 	testutil "sol_coffeesys/backend/handler/testutil"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/emailverify"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/mail"
 	"strings"
 	"testing"
 	"time"
@@ -24,6 +29,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,13 +37,14 @@ type MockTokenGenerator struct {
 	mock.Mock
 }
 
-func (m *MockTokenGenerator) GenerateToken(userID int64) (string, error) {
-	args := m.Called(userID)
+func (m *MockTokenGenerator) GenerateToken(userID int64, role, sessionID string) (string, error) {
+	args := m.Called(userID, role, sessionID)
 	return args.String(0), args.Error(1)
 }
 
 func TestLoginUserHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
 
 	tests := []struct {
 		name           string
@@ -71,6 +78,7 @@ func TestLoginUserHandler(t *testing.T) {
 						ID:     1,
 						UserID: 1,
 					}, nil)
+				m.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(nil)
 			},
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				var response map[string]interface{}
@@ -109,7 +117,7 @@ func TestLoginUserHandler(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			setupMock: func(m *testutil.MockDB) {
 				m.On("GetUserByEmail", mock.Anything, "notfound@example.com").
-					Return(db.User{}, errors.New("user not found"))
+					Return(db.User{}, sql.ErrNoRows)
 			},
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				var response map[string]interface{}
@@ -118,6 +126,18 @@ func TestLoginUserHandler(t *testing.T) {
 				assert.Contains(t, response["error"], "メールアドレスまたはパスワードが正しくありません")
 			},
 		},
+		{
+			name: "異常系：ユーザー取得時のDBエラー",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			expectedStatus: http.StatusInternalServerError,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{}, errors.New("db error"))
+			},
+		},
 		{
 			name: "異常系：パスワード相違",
 			requestBody: map[string]interface{}{
@@ -144,6 +164,141 @@ func TestLoginUserHandler(t *testing.T) {
 				assert.Contains(t, response["error"], "メールアドレスまたはパスワードが正しくありません")
 			},
 		},
+		{
+			name: "異常系：利用停止中のアカウント",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			expectedStatus: http.StatusForbidden,
+			setupMock: func(m *testutil.MockDB) {
+				passwordHash, err := handler.HashPassword("password123")
+				if err != nil {
+					t.Fatalf("パスワードのハッシュ化に失敗しました: %v", err)
+				}
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{
+						ID:           1,
+						Email:        "test@example.com",
+						PasswordHash: passwordHash,
+						Status:       auth.UserStatusSuspended,
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var response map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &response)
+				assert.NoError(t, err)
+				assert.Equal(t, apperror.ForbiddenMessageSuspended, response["error"])
+				assert.Empty(t, w.Result().Cookies())
+			},
+		},
+		{
+			name: "異常系：停止中でもパスワード相違なら401",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "wrongpassword",
+			},
+			expectedStatus: http.StatusUnauthorized,
+			setupMock: func(m *testutil.MockDB) {
+				passwordHash, err := handler.HashPassword("password123")
+				if err != nil {
+					t.Fatalf("パスワードのハッシュ化に失敗しました: %v", err)
+				}
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{
+						ID:           1,
+						Email:        "test@example.com",
+						PasswordHash: passwordHash,
+						Status:       auth.UserStatusSuspended,
+					}, nil)
+			},
+		},
+		{
+			name: "異常系：最終ログイン日時の更新に失敗",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			expectedStatus: http.StatusInternalServerError,
+			setupMock: func(m *testutil.MockDB) {
+				passwordHash, err := handler.HashPassword("password123")
+				if err != nil {
+					t.Fatalf("パスワードのハッシュ化に失敗しました: %v", err)
+				}
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{
+						ID:           1,
+						Email:        "test@example.com",
+						PasswordHash: passwordHash,
+					}, nil)
+				m.On("CreateRefreshToken", mock.Anything, mock.Anything).
+					Return(db.RefreshToken{ID: 1, UserID: 1}, nil)
+				m.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(errors.New("db error"))
+			},
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Empty(t, w.Result().Cookies())
+			},
+		},
+		{
+			name: "正常系：TOTP有効ならCookieを発行せずmfa_tokenを返す",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			expectedStatus: http.StatusOK,
+			setupMock: func(m *testutil.MockDB) {
+				passwordHash, err := handler.HashPassword("password123")
+				if err != nil {
+					t.Fatalf("パスワードのハッシュ化に失敗しました: %v", err)
+				}
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{
+						ID:           1,
+						Email:        "test@example.com",
+						PasswordHash: passwordHash,
+						Role:         auth.RoleAdmin,
+					}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).
+					Return(db.UserTotp{UserID: 1, EnabledAt: sql.NullTime{Time: time.Now(), Valid: true}}, nil)
+			},
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var response map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &response)
+				assert.NoError(t, err)
+				assert.Equal(t, true, response["mfa_required"])
+				assert.NotEmpty(t, response["mfa_token"])
+				assert.NotContains(t, response, "user")
+				assert.Empty(t, w.Result().Cookies())
+
+				userID, err := auth.ValidateMFAChallenge(response["mfa_token"].(string))
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), userID)
+			},
+		},
+		{
+			name: "異常系：TOTP取得時のDBエラー",
+			requestBody: map[string]interface{}{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			expectedStatus: http.StatusInternalServerError,
+			setupMock: func(m *testutil.MockDB) {
+				passwordHash, err := handler.HashPassword("password123")
+				if err != nil {
+					t.Fatalf("パスワードのハッシュ化に失敗しました: %v", err)
+				}
+				m.On("GetUserByEmail", mock.Anything, "test@example.com").
+					Return(db.User{
+						ID:           1,
+						Email:        "test@example.com",
+						PasswordHash: passwordHash,
+					}, nil)
+				m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, errors.New("db error"))
+			},
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Empty(t, w.Result().Cookies())
+			},
+		},
 		{
 			name:           "異常系：JSON形式エラー",
 			expectedStatus: http.StatusBadRequest,
@@ -175,7 +330,7 @@ func TestLoginUserHandler(t *testing.T) {
 					}, nil)
 			},
 			setupTokenMock: func(tg *MockTokenGenerator) {
-				tg.On("GenerateToken", int64(1)).
+				tg.On("GenerateToken", int64(1), mock.Anything, mock.Anything).
 					Return("", errors.New("トークンの生成に失敗しました")) // モック設定を追加
 			},
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
@@ -228,16 +383,19 @@ func TestLoginUserHandler(t *testing.T) {
 			if tt.setupMock != nil {
 				tt.setupMock(mockDB)
 			}
+			// 個別に設定しなければ TOTP は未登録
+			mockDB.On("GetUserTOTP", mock.Anything, mock.Anything).Return(db.UserTotp{}, sql.ErrNoRows).Maybe()
 
 			if tt.setupTokenMock != nil {
 				tt.setupTokenMock(mockTokenGenerator)
 			} else {
 				// デフォルトの動作を設定
-				mockTokenGenerator.On("GenerateToken", mock.Anything).
+				mockTokenGenerator.On("GenerateToken", mock.Anything, mock.Anything, mock.Anything).
 					Return("default_token", nil)
 			}
 
-			router.POST("/api/login", handler.LoginUserHandler(mockDB, mockTokenGenerator))
+			guard := loginguard.New(loginguard.NewMemoryStore(nil), loginguard.DefaultOptions())
+			router.POST("/api/login", handler.LoginUserHandler(mockDB, mockTokenGenerator, guard))
 
 			var body []byte
 			if tt.name == "異常系：JSON形式エラー" {
@@ -281,7 +439,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 			},
 			setupMock: func(m *testutil.MockDB) {
 
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("UpdateUserRole", mock.Anything, db.UpdateUserRoleParams{
 					Role: "admin",
@@ -307,6 +465,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 			checkResponse: func(t *testing.T, resp map[string]interface{}) {
 				assert.Equal(t, float64(2), resp["id"])
 				assert.Equal(t, "admin", resp["role"])
+				assert.NotContains(t, resp, "password_hash")
 			},
 		},
 		{
@@ -316,7 +475,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 				"role": "member",
 			},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("UpdateUserRole", mock.Anything, db.UpdateUserRoleParams{
 					Role: "member",
@@ -351,7 +510,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 				"role": "member",
 			},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 			},
 			setupAuth: func() (func(string) (*jwt.Token, error), func()) {
@@ -376,7 +535,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 				"role": "user",
 			},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 			},
 			setupAuth: func() (func(string) (*jwt.Token, error), func()) {
@@ -401,7 +560,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 				"role": "admin",
 			},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("UpdateUserRole", mock.Anything, db.UpdateUserRoleParams{
 					Role: "admin",
@@ -430,7 +589,7 @@ func TestSetUserRoleHandler(t *testing.T) {
 				"role": "",
 			},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 			},
 			setupAuth: func() (func(string) (*jwt.Token, error), func()) {
@@ -509,13 +668,15 @@ func TestRegisterUserHandler(t *testing.T) {
 				m.On("CreateUser", mock.Anything, mock.MatchedBy(func(params db.CreateUserParams) bool {
 					return params.Name == "Test User" &&
 						params.Email == "test@example.com" &&
-						params.Role == "member"
+						params.Role == "member" &&
+						params.Status == auth.UserStatusUnverified
 				})).Return(db.User{
 					ID:           1,
 					Name:         "Test User",
 					Email:        "test@example.com",
 					PasswordHash: hashedPassword,
 					Role:         "member",
+					Status:       auth.UserStatusUnverified,
 				}, nil)
 			},
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
@@ -524,6 +685,8 @@ func TestRegisterUserHandler(t *testing.T) {
 				assert.NoError(t, err)
 				assert.Equal(t, "Test User", user.Name)
 				assert.Equal(t, "test@example.com", user.Email)
+				assert.NotContains(t, w.Body.String(), "password_hash")
+				assert.Contains(t, w.Body.String(), `"email_verified":false`)
 			},
 		},
 		{
@@ -679,7 +842,13 @@ func TestRegisterUserHandler(t *testing.T) {
 				mockDB.On("CreateUser", mock.Anything, mock.Anything).Return(db.User{}, nil)
 			}
 
-			router.POST("/api/register", handler.RegisterUserHandler(mockDB))
+			outbox := t.TempDir()
+			verifier := &handler.EmailVerifier{
+				Signer:    emailverify.NewSigner([]byte("test-secret"), time.Hour),
+				Sender:    mail.FileSender{Dir: outbox},
+				VerifyURL: "http://localhost:3000/verify-email",
+			}
+			router.POST("/api/register", handler.RegisterUserHandler(mockDB, verifier))
 
 			var body []byte
 			if tt.name == "異常系：JSON形式エラー" {
@@ -699,6 +868,15 @@ func TestRegisterUserHandler(t *testing.T) {
 			if tt.checkResponse != nil {
 				tt.checkResponse(t, w)
 			}
+
+			// 登録に成功したときだけ確認メールが送られる
+			sent, err := os.ReadDir(outbox)
+			require.NoError(t, err)
+			if w.Code == http.StatusCreated {
+				assert.Len(t, sent, 1)
+			} else {
+				assert.Empty(t, sent)
+			}
 		})
 	}
 }
@@ -726,6 +904,116 @@ func TestHashPassword(t *testing.T) {
 	})
 }
 
+func TestLoginUserHandler_Lockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := db.User{ID: 1, Email: "test@example.com", PasswordHash: string(passwordHash), Role: "member"}
+
+	newRouter := func(m *testutil.MockDB, guard *loginguard.Guard) *gin.Engine {
+		router := gin.New()
+		router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+		tg := new(MockTokenGenerator)
+		tg.On("GenerateToken", mock.Anything, mock.Anything, mock.Anything).Return("default_token", nil)
+		router.POST("/api/login", handler.LoginUserHandler(m, tg, guard))
+		return router
+	}
+	login := func(router *gin.Engine, email, password, ip string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":54321"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	opts := loginguard.Options{
+		Account: loginguard.Policy{MaxFailures: 3, Window: 15 * time.Minute, Lockout: 15 * time.Minute},
+		IP:      loginguard.Policy{MaxFailures: 5, Window: 15 * time.Minute, Lockout: 30 * time.Minute},
+	}
+
+	t.Run("アカウント単位の上限で429とRetry-After、ロック中は正しいパスワードでも429", func(t *testing.T) {
+		m := new(testutil.MockDB)
+		m.On("GetUserByEmail", mock.Anything, "test@example.com").Return(user, nil)
+		router := newRouter(m, loginguard.New(loginguard.NewMemoryStore(nil), opts))
+
+		for i := 0; i < 2; i++ {
+			w := login(router, "test@example.com", "wrongpassword", "192.0.2.1")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		w := login(router, "test@example.com", "wrongpassword", "192.0.2.1")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "900", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), apperror.TooManyRequestsMessageLogin)
+
+		// 別の IP から正しいパスワードでも弾く。パスワードは照合しない
+		m.Calls = nil
+		w = login(router, "Test@Example.com", "password123", "198.51.100.1")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		m.AssertNotCalled(t, "GetUserByEmail", mock.Anything, mock.Anything)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("存在しないメールアドレスも失敗として数える", func(t *testing.T) {
+		m := new(testutil.MockDB)
+		m.On("GetUserByEmail", mock.Anything, mock.Anything).Return(db.User{}, sql.ErrNoRows)
+		router := newRouter(m, loginguard.New(loginguard.NewMemoryStore(nil), opts))
+
+		for i := 0; i < 2; i++ {
+			w := login(router, "ghost@example.com", "password123", "192.0.2.1")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		w := login(router, "ghost@example.com", "password123", "192.0.2.1")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("IP単位の上限で別アカウントも429", func(t *testing.T) {
+		m := new(testutil.MockDB)
+		m.On("GetUserByEmail", mock.Anything, mock.Anything).Return(db.User{}, sql.ErrNoRows)
+		router := newRouter(m, loginguard.New(loginguard.NewMemoryStore(nil), opts))
+
+		for i := 0; i < 4; i++ {
+			w := login(router, fmt.Sprintf("user%d@example.com", i), "password123", "192.0.2.1")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		w := login(router, "user4@example.com", "password123", "192.0.2.1")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1800", w.Header().Get("Retry-After"))
+
+		w = login(router, "user5@example.com", "password123", "198.51.100.1")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("成功するとアカウント単位の失敗回数が戻る", func(t *testing.T) {
+		m := new(testutil.MockDB)
+		m.On("GetUserByEmail", mock.Anything, "test@example.com").Return(user, nil)
+		m.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(db.RefreshToken{ID: 1, UserID: 1}, nil)
+		m.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+		m.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(nil)
+		router := newRouter(m, loginguard.New(loginguard.NewMemoryStore(nil), opts))
+
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login(router, "test@example.com", "wrongpassword", "192.0.2.1").Code)
+		}
+		assert.Equal(t, http.StatusOK, login(router, "test@example.com", "password123", "192.0.2.1").Code)
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login(router, "test@example.com", "wrongpassword", "192.0.2.1").Code)
+		}
+	})
+
+	t.Run("カウンタの保存に失敗したら500", func(t *testing.T) {
+		m := new(testutil.MockDB)
+		m.On("GetLoginThrottle", mock.Anything, mock.Anything).Return(db.LoginThrottle{}, errors.New("db error"))
+		router := newRouter(m, loginguard.New(loginguard.NewPostgresStore(m), opts))
+
+		w := login(router, "test@example.com", "password123", "192.0.2.1")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		m.AssertNotCalled(t, "GetUserByEmail", mock.Anything, mock.Anything)
+	})
+}
+
 func TestLoginUserHandler_SetsCookies(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
@@ -758,9 +1046,17 @@ func TestLoginUserHandler_SetsCookies(t *testing.T) {
 			UserID: 1,
 		}, nil)
 
-	mockTokenGenerator.On("GenerateToken", mock.Anything).Return("default_token", nil)
+	mockDB.On("GetUserTOTP", mock.Anything, int64(1)).Return(db.UserTotp{}, sql.ErrNoRows)
+	mockDB.On("UpdateUserLastLogin", mock.Anything, int64(1)).Return(nil)
+
+	var sessionID string
+	mockTokenGenerator.On("GenerateToken", mock.Anything, mock.Anything, mock.Anything).Run(
+		func(args mock.Arguments) {
+			sessionID = args.String(2)
+		}).Return("default_token", nil)
 
-	router.POST("/api/login", handler.LoginUserHandler(mockDB, mockTokenGenerator))
+	guard := loginguard.New(loginguard.NewMemoryStore(nil), loginguard.DefaultOptions())
+	router.POST("/api/login", handler.LoginUserHandler(mockDB, mockTokenGenerator, guard))
 
 	body, _ := json.Marshal(map[string]string{
 		"email":    "test@example.com",
@@ -768,6 +1064,8 @@ func TestLoginUserHandler_SetsCookies(t *testing.T) {
 	})
 	req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CafeTablet/1.0")
+	req.RemoteAddr = "192.0.2.10:54321"
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -806,6 +1104,12 @@ func TestLoginUserHandler_SetsCookies(t *testing.T) {
 
 	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), captured.ExpiresAt, 5*time.Second)
 
+	// アクセストークンの sid とリフレッシュトークンのファミリーが一致し、端末情報が保存される
+	assert.NotEmpty(t, sessionID)
+	assert.Equal(t, sessionID, captured.FamilyID)
+	assert.Equal(t, "CafeTablet/1.0", captured.UserAgent)
+	assert.Equal(t, "192.0.2.10", captured.IpAddress)
+
 	mockDB.AssertExpectations(t)
 	mockTokenGenerator.AssertExpectations(t)
 }
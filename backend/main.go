@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"log/slog"
 	"os"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
@@ -19,7 +20,16 @@ import (
 func main() {
 	slog.SetDefault(middleware.NewJSONLogger(os.Stdout, slog.LevelInfo))
 
-	//1. DB接続
+	//1. JWT鍵の読み込み(署名鍵が無ければ起動しない)
+	keys, err := auth.LoadKeySetFromEnv()
+	if err != nil {
+		slog.Error("startup failed", "phase", "init", "reason", "failed to load jwt keys", "error", err)
+		os.Exit(1)
+	}
+	auth.SetKeySet(keys)
+	slog.Info("jwt keys loaded", "signing_kid", keys.SigningKeyID())
+
+	//2. DB接続
 	connStr := os.Getenv("DATABASE_URL")
 	if connStr == "" {
 		slog.Error("startup failed", "phase", "init", "reason", "DATABASE_URL is not set")
@@ -36,14 +46,19 @@ func main() {
 	}
 	defer conn.Close()
 
-	//2. sqlクエリ初期化
+	//3. sqlクエリ初期化
 	queries := db.New(conn)
 
-	//3. Ginルーター初期化
+	//4. Ginルーター初期化
 	r := gin.New()
 	r.Use(gin.Recovery())
+	// IP 単位の制限を X-Forwarded-For の書き換えで回避されないよう、信用するプロキシを絞る
+	if err := middleware.ConfigureTrustedProxies(r); err != nil {
+		slog.Error("startup failed", "phase", "init", "reason", "invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
 
-	// 4. ミドルウェア設定
+	// 5. ミドルウェア設定
 	// duration_ms
 	r.Use(middleware.RequestStartedAtMiddleware())
 	// request_id生成
@@ -53,17 +68,18 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "X-CSRF-Token"},
+		ExposeHeaders:    []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 		AllowCredentials: true,
 	}))
 
 	// エラーハンドラ
 	r.Use(middleware.ErrorHandler(apperror.ToHTTP))
 
-	//5. ルーティング設定
+	//6. ルーティング設定
 	routes.SetupRoutes(r, conn, queries)
 
-	//6. サーバー起動
+	//7. サーバー起動
 	slog.Info("Server starting on :8080")
 	if err := r.Run(":8080"); err != nil {
 		slog.Error("failed to run server", "error", err)
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// CSRFCookieName は GET /api/csrf が発行するトークンを入れる Cookie
+	CSRFCookieName = "csrf_token"
+	// CSRFHeaderName は状態を変えるリクエストでトークンを送り返すヘッダ
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+type CSRFOptions struct {
+	// Exempt は検証しないルート(c.FullPath() と比較)。
+	// アクセストークンの Cookie を使わない login / register / refresh などを並べる
+	Exempt []string
+}
+
+// CSRF は Cookie のトークンとヘッダのトークンが一致するかを確かめる(ダブルサブミット)。
+// アクセストークンを Cookie で送っている状態を変えるリクエストだけを検証し、
+// Bearer ヘッダで認証するクライアント(ブラウザが自動で付けない)と GET などは素通しする
+func CSRF(opts CSRFOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isSafeMethod(c.Request.Method) ||
+			slices.Contains(opts.Exempt, c.FullPath()) ||
+			!auth.AuthenticatesByCookie(c.Request) {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader(CSRFHeaderName)
+		cookie, err := c.Request.Cookie(CSRFCookieName)
+		if header == "" || err != nil || cookie.Value == "" {
+			_ = c.Error(apperror.NewCSRFForbiddenError(apperror.ForbiddenReasonCSRFMissing))
+			c.Abort()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+			_ = c.Error(apperror.NewCSRFForbiddenError(apperror.ForbiddenReasonCSRFMismatch))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
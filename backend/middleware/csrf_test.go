@@ -0,0 +1,133 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCSRFRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	r.Use(middleware.CSRF(middleware.CSRFOptions{Exempt: []string{"/api/login"}}))
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) }
+	r.GET("/api/cart", ok)
+	r.POST("/api/cart/items", ok)
+	r.POST("/api/login", ok)
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		setup      func(req *http.Request)
+		wantStatus int
+	}{
+		{
+			name:   "Cookie認証でトークン一致なら通す",
+			method: http.MethodPost,
+			path:   "/api/cart/items",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+				req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: "csrf-abc"})
+				req.Header.Set(middleware.CSRFHeaderName, "csrf-abc")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Cookie認証でヘッダが無ければ403",
+			method: http.MethodPost,
+			path:   "/api/cart/items",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+				req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: "csrf-abc"})
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Cookie認証でCSRF Cookieが無ければ403",
+			method: http.MethodPost,
+			path:   "/api/cart/items",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+				req.Header.Set(middleware.CSRFHeaderName, "csrf-abc")
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Cookie認証でトークン不一致なら403",
+			method: http.MethodPost,
+			path:   "/api/cart/items",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+				req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: "csrf-abc"})
+				req.Header.Set(middleware.CSRFHeaderName, "csrf-xyz")
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Bearerヘッダがあれば Cookie があっても検証しない",
+			method: http.MethodPost,
+			path:   "/api/cart/items",
+			setup: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer jwt")
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "未認証(Cookieなし)は検証しない",
+			method:     http.MethodPost,
+			path:       "/api/cart/items",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "GETは検証しない",
+			method: http.MethodGet,
+			path:   "/api/cart",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt"})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "除外したルートは検証しない",
+			method: http.MethodPost,
+			path:   "/api/login",
+			setup: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "stale-jwt"})
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	r := setupCSRFRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.setup != nil {
+				tt.setup(req)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusForbidden {
+				var body map[string]any
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, apperror.ForbiddenMessageCSRF, body["error"])
+			}
+		})
+	}
+}
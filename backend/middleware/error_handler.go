@@ -8,6 +8,7 @@ import (
 	"sol_coffeesys/backend/pkg/apperror"
 	"sol_coffeesys/backend/pkg/logging"
 	"sol_coffeesys/backend/pkg/redaction"
+	"strconv"
 	"strings"
 	"time"
 
@@ -48,6 +49,9 @@ func ErrorHandler(toHTTP func(error) (int, string)) gin.HandlerFunc {
 		if c.Writer.Written() {
 			return
 		}
+		if retryAfter := retryAfterOf(err); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+		}
 		c.JSON(status, gin.H{"error": msg})
 
 	}
@@ -69,6 +73,16 @@ func logError(c *gin.Context, err error, status int, msg string, elapsed time.Du
 		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
 	}
 
+	var forbiddenErr *apperror.ForbiddenError
+	if errors.As(err, &forbiddenErr) {
+		attrs = append(attrs, slog.String("reason", forbiddenErr.Reason))
+	}
+
+	var tooManyErr *apperror.TooManyRequestsError
+	if errors.As(err, &tooManyErr) {
+		attrs = append(attrs, slog.String("reason", tooManyErr.Reason))
+	}
+
 	// internal用メッセージ
 	var internErr *apperror.InternalError
 	if errors.As(err, &internErr) {
@@ -78,7 +92,7 @@ func logError(c *gin.Context, err error, status int, msg string, elapsed time.Du
 		}
 	}
 
-	if raw, ok := c.Get("userID"); ok {
+	if raw, ok := c.Get(logging.CtxKeyUserID); ok {
 		if uid, ok := raw.(int64); ok {
 			attrs = append(attrs, slog.Int64("user_id", uid))
 		}
@@ -96,7 +110,7 @@ func logLevelForError(err error) slog.Level {
 	switch {
 	case isValidationError(err), isNotFoundError(err), isConflictError(err), isBusinessLogicError(err):
 		return slog.LevelInfo
-	case isUnauthorizedError(err), isForbiddenError(err):
+	case isUnauthorizedError(err), isForbiddenError(err), isTooManyRequestsError(err):
 		return slog.LevelWarn
 	default:
 		return slog.LevelError
@@ -115,6 +129,8 @@ func errorTypeName(err error) string {
 		return "UnauthorizedError"
 	case isForbiddenError(err):
 		return "ForbiddenError"
+	case isTooManyRequestsError(err):
+		return "TooManyRequestsError"
 	case isBusinessLogicError(err):
 		return "BusinessLogicError"
 	case isInternalError(err):
@@ -149,6 +165,29 @@ func isForbiddenError(err error) bool {
 	return errors.As(err, &target)
 }
 
+func isTooManyRequestsError(err error) bool {
+	var target *apperror.TooManyRequestsError
+	return errors.As(err, &target)
+}
+
+// retryAfterOf は 429 系のエラーが持つ待ち時間を返す。該当しなければ 0
+func retryAfterOf(err error) time.Duration {
+	var tooMany *apperror.TooManyRequestsError
+	if errors.As(err, &tooMany) {
+		return tooMany.RetryAfter
+	}
+	return 0
+}
+
+// retryAfterSeconds は Retry-After 用に秒へ切り上げる(0秒にはしない)
+func retryAfterSeconds(d time.Duration) int {
+	sec := int((d + time.Second - 1) / time.Second)
+	if sec < 1 {
+		return 1
+	}
+	return sec
+}
+
 func isBusinessLogicError(err error) bool {
 	var target *apperror.BusinessLogicError
 	return errors.As(err, &target)
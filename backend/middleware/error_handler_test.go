@@ -12,6 +12,7 @@ import (
 	"sol_coffeesys/backend/pkg/apperror"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,6 +26,7 @@ func TestErrorHandler(t *testing.T) {
 		handler    gin.HandlerFunc
 		wantStatus int
 		wantBody   map[string]any
+		wantHeader map[string]string
 	}{
 		{
 			name: "ValidationErrorは400を返す",
@@ -46,6 +48,28 @@ func TestErrorHandler(t *testing.T) {
 				"error": apperror.UnauthorizedMessageAuth,
 			},
 		},
+		{
+			name: "TooManyRequestsErrorは429とRetry-Afterを返す(秒に切り上げ)",
+			handler: func(c *gin.Context) {
+				_ = c.Error(apperror.NewTooManyRequestsError("login_locked", 90*time.Second+time.Millisecond, apperror.TooManyRequestsMessageLogin))
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantBody: map[string]any{
+				"error": apperror.TooManyRequestsMessageLogin,
+			},
+			wantHeader: map[string]string{"Retry-After": "91"},
+		},
+		{
+			name: "TooManyRequestsErrorのMessageが空なら共通の文言",
+			handler: func(c *gin.Context) {
+				_ = c.Error(apperror.NewTooManyRequestsError("rate_limit:auth", 500*time.Millisecond, ""))
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantBody: map[string]any{
+				"error": apperror.TooManyRequestsMessageGeneric,
+			},
+			wantHeader: map[string]string{"Retry-After": "1"},
+		},
 		{
 			name: "エラーなしは下流レスポンス維持",
 			handler: func(c *gin.Context) {
@@ -93,6 +117,12 @@ func TestErrorHandler(t *testing.T) {
 			if !reflect.DeepEqual(got, tt.wantBody) {
 				t.Fatalf("body mismatch: got=%v want=%v", got, tt.wantBody)
 			}
+
+			for k, v := range tt.wantHeader {
+				if w.Header().Get(k) != v {
+					t.Fatalf("header %s mismatch: got=%q want=%q", k, w.Header().Get(k), v)
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/logging"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	headerKeyIdempotencyKey   = "Idempotency-Key"
+	headerKeyIdempotentReplay = "Idempotent-Replayed"
+	idempotencyKeyMaxLength   = 255
+	idempotencyKeyTTL         = 24 * time.Hour
+)
+
+type IdempotencyOptions struct {
+	// ClaimTimeout を過ぎてもレスポンスが保存されていないキーは、処理中にプロセスが落ちたものとみなして
+	// 同じキーの再試行で登録し直す。0 なら期限まで処理中のまま
+	ClaimTimeout time.Duration
+	// PruneInterval ごとに、期限切れのキーをキーの登録時に消す。0 なら消さない
+	PruneInterval time.Duration
+	// MaxBodyBytes を超えるボディは読み込まずに 400 にする。フィンガープリントのためにボディを全て読むので上限を設ける
+	MaxBodyBytes int64
+	// Now はテスト用。nil なら time.Now
+	Now func() time.Time
+}
+
+func DefaultIdempotencyOptions() IdempotencyOptions {
+	return IdempotencyOptions{
+		ClaimTimeout:  5 * time.Minute,
+		PruneInterval: 10 * time.Minute,
+		MaxBodyBytes:  1 << 20,
+	}
+}
+
+// Idempotency は Idempotency-Key ヘッダ付きのリクエストを一度だけ実行する。
+// 同じキーで再送された場合はハンドラを実行せず、保存済みのレスポンスを返す。
+// 認証ミドルウェアの後ろに置き、キーはユーザー単位で管理する。
+// 期限切れのキーの掃除は戻り値ごとに間隔を数えるので、複数のルートでは同じ戻り値を使う
+func Idempotency(q db.Querier, opts IdempotencyOptions) gin.HandlerFunc {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	pruner := &idempotencyPruner{q: q, interval: opts.PruneInterval}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(headerKeyIdempotencyKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			_ = c.Error(apperror.NewValidationError("idempotency_key", nil, "max_length", ""))
+			c.Abort()
+			return
+		}
+
+		userID := auth.MustPrincipal(c).UserID
+
+		var body []byte
+		if c.Request.Body != nil {
+			r := c.Request.Body
+			if opts.MaxBodyBytes > 0 {
+				r = http.MaxBytesReader(c.Writer, r, opts.MaxBodyBytes)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					_ = c.Error(apperror.NewValidationError("request", nil, "max_bytes", apperror.ValidationMessageRequestTooLarge))
+				} else {
+					_ = c.Error(apperror.NewValidationError("request", nil, "read", apperror.ValidationMessageRequest))
+				}
+				c.Abort()
+				return
+			}
+			body = b
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		now := opts.Now()
+		params := db.CreateIdempotencyKeyParams{
+			UserID:         userID,
+			IdempotencyKey: key,
+			RequestMethod:  c.Request.Method,
+			RequestPath:    c.Request.URL.Path,
+			RequestHash:    requestFingerprint(c.Request.Method, c.Request.URL.Path, body),
+			ExpiresAt:      now.Add(idempotencyKeyTTL),
+		}
+
+		record, claimed, err := claimIdempotencyKey(c.Request.Context(), q, params, now, opts.ClaimTimeout)
+		if err != nil {
+			var ce *apperror.ConflictError
+			if errors.As(err, &ce) {
+				_ = c.Error(err)
+			} else {
+				_ = c.Error(apperror.NewInternalError("ClaimIdempotencyKey", err, apperror.InternalServerMessageCommon))
+			}
+			c.Abort()
+			return
+		}
+		if !claimed {
+			replayIdempotentResponse(c, record, params.RequestHash)
+			return
+		}
+		pruner.pruneIfDue(c.Request.Context(), now)
+
+		w := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		// エラー応答は保存せず、キーを解放して再試行できるようにする
+		ctx := context.WithoutCancel(c.Request.Context())
+		if len(c.Errors) > 0 || !w.Written() || w.Status() >= http.StatusBadRequest {
+			if err := q.DeleteIdempotencyKey(ctx, record.ID); err != nil {
+				slog.WarnContext(ctx, "idempotency key release failed", "request_id", c.GetString(logging.CtxKeyRequestID), "error", err)
+			}
+			return
+		}
+
+		if err := q.SaveIdempotencyResponse(ctx, db.SaveIdempotencyResponseParams{
+			ID:             record.ID,
+			ResponseStatus: sql.NullInt32{Int32: int32(w.Status()), Valid: true},
+			ResponseBody:   w.body.Bytes(),
+		}); err != nil {
+			slog.WarnContext(ctx, "idempotency response save failed", "request_id", c.GetString(logging.CtxKeyRequestID), "error", err)
+		}
+	}
+}
+
+// claimIdempotencyKey はキーを新規登録できれば claimed=true を返す。
+// 既に登録済みの場合は既存レコードを返す。期限切れのレコードと、claimTimeout を過ぎても
+// レスポンスが保存されていないレコードは削除して登録し直す。
+func claimIdempotencyKey(ctx context.Context, q db.Querier, params db.CreateIdempotencyKeyParams, now time.Time, claimTimeout time.Duration) (db.IdempotencyKey, bool, error) {
+	record, err := q.CreateIdempotencyKey(ctx, params)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return db.IdempotencyKey{}, false, err
+	}
+
+	existing, err := q.GetIdempotencyKey(ctx, db.GetIdempotencyKeyParams{
+		UserID:         params.UserID,
+		IdempotencyKey: params.IdempotencyKey,
+	})
+	if err != nil {
+		return db.IdempotencyKey{}, false, err
+	}
+	expired := !existing.ExpiresAt.After(now)
+	abandoned := !existing.ResponseStatus.Valid && claimTimeout > 0 && !existing.CreatedAt.Add(claimTimeout).After(now)
+	if !expired && !abandoned {
+		return existing, false, nil
+	}
+
+	if err := q.DeleteIdempotencyKey(ctx, existing.ID); err != nil {
+		return db.IdempotencyKey{}, false, err
+	}
+	record, err = q.CreateIdempotencyKey(ctx, params)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// 削除直後に別リクエストが登録した
+			return db.IdempotencyKey{}, false, apperror.NewConflictError("idempotency_key", params.IdempotencyKey, apperror.ConflictMessageIdempotencyInProgress)
+		}
+		return db.IdempotencyKey{}, false, err
+	}
+	return record, true, nil
+}
+
+// idempotencyPruner は期限切れのキーを interval ごとに消す。
+// 再送されなかったキーは行が残り続けるため、消さないとテーブルが際限なく大きくなる
+type idempotencyPruner struct {
+	q        db.Querier
+	interval time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func (p *idempotencyPruner) pruneIfDue(ctx context.Context, now time.Time) {
+	if p.interval <= 0 {
+		return
+	}
+	p.mu.Lock()
+	if now.Sub(p.lastPruned) < p.interval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastPruned = now
+	p.mu.Unlock()
+
+	// 掃除に失敗してもリクエストは続ける。次の間隔で再試行する
+	if _, err := p.q.DeleteExpiredIdempotencyKeys(ctx, now); err != nil {
+		slog.WarnContext(ctx, "idempotency key prune failed", "error", err)
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, record db.IdempotencyKey, fingerprint string) {
+	if record.RequestHash != fingerprint {
+		_ = c.Error(apperror.NewConflictError("idempotency_key", record.IdempotencyKey, apperror.ConflictMessageIdempotencyMismatch))
+		c.Abort()
+		return
+	}
+	if !record.ResponseStatus.Valid {
+		_ = c.Error(apperror.NewConflictError("idempotency_key", record.IdempotencyKey, apperror.ConflictMessageIdempotencyInProgress))
+		c.Abort()
+		return
+	}
+
+	c.Header(headerKeyIdempotentReplay, "true")
+	c.Data(int(record.ResponseStatus.Int32), "application/json; charset=utf-8", record.ResponseBody)
+	c.Abort()
+
+	logging.LogEvent(c, logging.EventInput{
+		Event:  "idempotent_request_replayed",
+		Status: int(record.ResponseStatus.Int32),
+		Level:  slog.LevelInfo,
+	})
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,300 @@
+package middleware_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler/testutil"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// setupIdempotencyRouter は期限切れのキーの掃除を止めた既定の設定で組み立てる
+func setupIdempotencyRouter(q db.Querier, handler gin.HandlerFunc) *gin.Engine {
+	opts := middleware.DefaultIdempotencyOptions()
+	opts.PruneInterval = 0
+	return setupIdempotencyRouterWith(q, opts, handler)
+}
+
+func setupIdempotencyRouterWith(q db.Querier, opts middleware.IdempotencyOptions, handler gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	r.POST("/api/orders", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: 1})
+		c.Next()
+	}, middleware.Idempotency(q, opts), handler)
+	return r
+}
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	created := func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"order": gin.H{"id": 1}})
+	}
+	failed := func(c *gin.Context) {
+		_ = c.Error(apperror.NewBusinessLogicError(apperror.BusinessLogicMessageGeneric))
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		body         string
+		handler      gin.HandlerFunc
+		setupMock    func(*testutil.MockDB)
+		wantStatus   int
+		wantBody     string
+		wantReplayed bool
+		wantCalled   bool
+	}{
+		{
+			name:       "キーなしはそのまま実行",
+			body:       `{}`,
+			handler:    created,
+			wantStatus: http.StatusCreated,
+			wantCalled: true,
+		},
+		{
+			name:    "初回リクエストはレスポンスを保存する",
+			key:     "key-1",
+			body:    `{}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.MatchedBy(func(arg db.CreateIdempotencyKeyParams) bool {
+					return arg.UserID == 1 && arg.IdempotencyKey == "key-1" && arg.RequestPath == "/api/orders"
+				})).Return(db.IdempotencyKey{ID: 10}, nil)
+				m.On("SaveIdempotencyResponse", mock.Anything, mock.MatchedBy(func(arg db.SaveIdempotencyResponseParams) bool {
+					return arg.ID == 10 && arg.ResponseStatus.Int32 == http.StatusCreated &&
+						strings.Contains(string(arg.ResponseBody), `"order"`)
+				})).Return(nil)
+			},
+			wantStatus: http.StatusCreated,
+			wantCalled: true,
+		},
+		{
+			name:    "同じキーの再送は保存済みレスポンスを返す",
+			key:     "key-2",
+			body:    `{}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows).Once()
+				m.On("GetIdempotencyKey", mock.Anything, db.GetIdempotencyKeyParams{UserID: 1, IdempotencyKey: "key-2"}).Return(db.IdempotencyKey{
+					ID:             20,
+					IdempotencyKey: "key-2",
+					RequestHash:    fingerprintOf(t, "key-2", `{}`),
+					ResponseStatus: sql.NullInt32{Int32: http.StatusCreated, Valid: true},
+					ResponseBody:   []byte(`{"order":{"id":99}}`),
+					ExpiresAt:      time.Now().Add(time.Hour),
+				}, nil)
+			},
+			wantStatus:   http.StatusCreated,
+			wantBody:     `{"order":{"id":99}}`,
+			wantReplayed: true,
+		},
+		{
+			name:    "同じキーで内容が異なる場合は409",
+			key:     "key-3",
+			body:    `{"other":true}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows)
+				m.On("GetIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{
+					ID:             30,
+					IdempotencyKey: "key-3",
+					RequestHash:    "different",
+					ResponseStatus: sql.NullInt32{Int32: http.StatusCreated, Valid: true},
+					ExpiresAt:      time.Now().Add(time.Hour),
+				}, nil)
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   apperror.ConflictMessageIdempotencyMismatch,
+		},
+		{
+			name:    "処理中のキーは409",
+			key:     "key-4",
+			body:    `{}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows)
+				m.On("GetIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{
+					ID:             40,
+					IdempotencyKey: "key-4",
+					RequestHash:    fingerprintOf(t, "key-4", `{}`),
+					ExpiresAt:      time.Now().Add(time.Hour),
+					CreatedAt:      time.Now().Add(-time.Minute),
+				}, nil)
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   apperror.ConflictMessageIdempotencyInProgress,
+		},
+		{
+			name:    "処理中のまま時間が経ったキーは登録し直す",
+			key:     "key-4",
+			body:    `{}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows).Once()
+				m.On("GetIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{
+					ID:             41,
+					IdempotencyKey: "key-4",
+					ExpiresAt:      time.Now().Add(time.Hour),
+					CreatedAt:      time.Now().Add(-10 * time.Minute),
+				}, nil)
+				m.On("DeleteIdempotencyKey", mock.Anything, int64(41)).Return(nil)
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{ID: 42}, nil).Once()
+				m.On("SaveIdempotencyResponse", mock.Anything, mock.MatchedBy(func(arg db.SaveIdempotencyResponseParams) bool {
+					return arg.ID == 42
+				})).Return(nil)
+			},
+			wantStatus: http.StatusCreated,
+			wantCalled: true,
+		},
+		{
+			name:    "エラー応答はキーを解放する",
+			key:     "key-5",
+			body:    `{}`,
+			handler: failed,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{ID: 50}, nil)
+				m.On("DeleteIdempotencyKey", mock.Anything, int64(50)).Return(nil)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCalled: true,
+		},
+		{
+			name:    "期限切れのキーは登録し直す",
+			key:     "key-6",
+			body:    `{}`,
+			handler: created,
+			setupMock: func(m *testutil.MockDB) {
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{}, sql.ErrNoRows).Once()
+				m.On("GetIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{
+					ID:        60,
+					ExpiresAt: time.Now().Add(-time.Minute),
+				}, nil)
+				m.On("DeleteIdempotencyKey", mock.Anything, int64(60)).Return(nil)
+				m.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{ID: 61}, nil).Once()
+				m.On("SaveIdempotencyResponse", mock.Anything, mock.MatchedBy(func(arg db.SaveIdempotencyResponseParams) bool {
+					return arg.ID == 61
+				})).Return(nil)
+			},
+			wantStatus: http.StatusCreated,
+			wantCalled: true,
+		},
+		{
+			name:       "大きすぎるボディはキーを登録せず400",
+			key:        "key-7",
+			body:       `{"note":"` + strings.Repeat("a", 1<<20) + `"}`,
+			handler:    created,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   apperror.ValidationMessageRequestTooLarge,
+		},
+		{
+			name:       "長すぎるキーは400",
+			key:        strings.Repeat("k", 256),
+			body:       `{}`,
+			handler:    created,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   apperror.ValidationMessageIdempotencyKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(testutil.MockDB)
+			if tt.setupMock != nil {
+				tt.setupMock(mockDB)
+			}
+
+			called := false
+			r := setupIdempotencyRouter(mockDB, func(c *gin.Context) {
+				called = true
+				tt.handler(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Contains(t, w.Body.String(), tt.wantBody)
+			}
+			assert.Equal(t, tt.wantReplayed, w.Header().Get("Idempotent-Replayed") == "true")
+			assert.Equal(t, tt.wantCalled, called)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+// 初回リクエストで保存されるフィンガープリントを取得する
+func fingerprintOf(t *testing.T, key, body string) string {
+	t.Helper()
+
+	var hash string
+	mockDB := new(testutil.MockDB)
+	mockDB.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		hash = args.Get(1).(db.CreateIdempotencyKeyParams).RequestHash
+	}).Return(db.IdempotencyKey{ID: 1}, nil)
+	mockDB.On("SaveIdempotencyResponse", mock.Anything, mock.Anything).Return(nil)
+
+	r := setupIdempotencyRouter(mockDB, func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	return hash
+}
+
+// 期限切れのキーは PruneInterval ごとに、キーの登録時にまとめて消す
+func TestIdempotency_PrunesExpiredKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	opts := middleware.DefaultIdempotencyOptions()
+	opts.PruneInterval = 10 * time.Minute
+	opts.Now = func() time.Time { return now }
+
+	mockDB := new(testutil.MockDB)
+	mockDB.On("CreateIdempotencyKey", mock.Anything, mock.Anything).Return(db.IdempotencyKey{ID: 1}, nil)
+	mockDB.On("SaveIdempotencyResponse", mock.Anything, mock.Anything).Return(nil)
+	mockDB.On("DeleteExpiredIdempotencyKeys", mock.Anything, mock.Anything).Return(int64(3), nil)
+
+	r := setupIdempotencyRouterWith(mockDB, opts, func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+	send := func(key string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	send("key-1")
+	mockDB.AssertNumberOfCalls(t, "DeleteExpiredIdempotencyKeys", 1)
+	mockDB.AssertCalled(t, "DeleteExpiredIdempotencyKeys", mock.Anything, now)
+
+	// 間隔が空くまでは消さない
+	now = now.Add(5 * time.Minute)
+	send("key-2")
+	mockDB.AssertNumberOfCalls(t, "DeleteExpiredIdempotencyKeys", 1)
+
+	now = now.Add(5 * time.Minute)
+	send("key-3")
+	mockDB.AssertNumberOfCalls(t, "DeleteExpiredIdempotencyKeys", 2)
+}
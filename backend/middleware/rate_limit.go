@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"math"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/pkg/apperror"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	headerKeyRateLimitLimit     = "RateLimit-Limit"
+	headerKeyRateLimitRemaining = "RateLimit-Remaining"
+	headerKeyRateLimitReset     = "RateLimit-Reset"
+	headerKeyRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// RateLimitKeyFunc はリクエストをどの単位で数えるかを決める
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitPolicy はトークンバケットの設定。
+// 最大 Limit 回まで連続で受け付け、Window をかけて Limit 個のトークンが一定の速さで補充される
+type RateLimitPolicy struct {
+	// Name はログと RateLimit-Policy ヘッダに出す名前
+	Name   string
+	Limit  int
+	Window time.Duration
+	// Key が nil なら接続元 IP 単位で数える
+	Key RateLimitKeyFunc
+	// Now はテスト用。nil なら time.Now
+	Now func() time.Time
+}
+
+// RateLimitByIP は接続元 IP 単位で数える
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitByPrincipal は認証済みユーザー単位で数える。auth.Require の後ろに置く。
+// Principal が無ければ IP 単位に戻す
+func RateLimitByPrincipal(c *gin.Context) string {
+	if p, err := auth.PrincipalFrom(c); err == nil {
+		return "user:" + strconv.FormatInt(p.UserID, 10)
+	}
+	return RateLimitByIP(c)
+}
+
+// RateLimit はポリシーごとにトークンバケットでリクエスト数を制限する。
+// 同じ戻り値を複数のルートに付けるとバケットを共有する(ルートグループ単位の制限)。
+// バケットはプロセス内に置くため、複数インスタンスでは制限がインスタンスごとになる。
+// 全レスポンスに RateLimit-* ヘッダを付け、上限を超えたら TooManyRequestsError(429)を ErrorHandler に渡す
+func RateLimit(policy RateLimitPolicy) gin.HandlerFunc {
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		panic("middleware: RateLimit requires positive Limit and Window")
+	}
+	if policy.Key == nil {
+		policy.Key = RateLimitByIP
+	}
+	if policy.Now == nil {
+		policy.Now = time.Now
+	}
+	limiter := newTokenBucketLimiter(policy.Limit, policy.Window)
+	policyHeader := strconv.Itoa(policy.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(policy.Window.Seconds())))
+
+	return func(c *gin.Context) {
+		res := limiter.take(policy.Key(c), policy.Now())
+
+		c.Header(headerKeyRateLimitLimit, strconv.Itoa(policy.Limit))
+		c.Header(headerKeyRateLimitRemaining, strconv.Itoa(res.remaining))
+		c.Header(headerKeyRateLimitReset, strconv.Itoa(ceilSeconds(res.reset)))
+		c.Header(headerKeyRateLimitPolicy, policyHeader)
+
+		if !res.allowed {
+			_ = c.Error(apperror.NewTooManyRequestsError("rate_limit:"+policy.Name, res.retryAfter, apperror.TooManyRequestsMessageGeneric))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // バケットが満杯に戻るまで
+	retryAfter time.Duration // 次のトークンが補充されるまで(拒否時のみ)
+}
+
+type tokenBucketLimiter struct {
+	mu        sync.Mutex
+	capacity  float64
+	perSecond float64
+	window    time.Duration
+	buckets   map[string]*tokenBucket
+	sweptAt   time.Time
+}
+
+func newTokenBucketLimiter(limit int, window time.Duration) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		capacity:  float64(limit),
+		perSecond: float64(limit) / window.Seconds(),
+		window:    window,
+		buckets:   map[string]*tokenBucket{},
+	}
+}
+
+func (l *tokenBucketLimiter) take(key string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.capacity, updatedAt: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.capacity, b.tokens+elapsed*l.perSecond)
+		b.updatedAt = now
+	}
+
+	res := rateLimitResult{allowed: b.tokens >= 1}
+	if res.allowed {
+		b.tokens--
+	} else {
+		res.retryAfter = l.durationFor(1 - b.tokens)
+	}
+	res.remaining = int(math.Floor(b.tokens))
+	res.reset = l.durationFor(l.capacity - b.tokens)
+	return res
+}
+
+// sweep は Window 以上使われていない(満杯に戻っている)バケットを Window ごとに捨てる
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < l.window {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweptAt = now
+}
+
+func (l *tokenBucketLimiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.perSecond * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,143 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rateLimitClock struct{ t time.Time }
+
+func (c *rateLimitClock) Now() time.Time { return c.t }
+
+func setupRateLimitRouter(policy middleware.RateLimitPolicy, pre ...gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	r.Use(pre...)
+	// 2つのルートに同じ RateLimit を付ける
+	limit := middleware.RateLimit(policy)
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) }
+	r.GET("/api/a", limit, ok)
+	r.GET("/api/b", limit, ok)
+	return r
+}
+
+func doRateLimitRequest(r *gin.Engine, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = ip + ":54321"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_ByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clock := &rateLimitClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := setupRateLimitRouter(middleware.RateLimitPolicy{Name: "auth", Limit: 3, Window: time.Minute, Now: clock.Now})
+
+	for i, wantRemaining := range []string{"2", "1", "0"} {
+		w := doRateLimitRequest(r, "/api/a", "192.0.2.1")
+		assert.Equal(t, http.StatusOK, w.Code, "request %d", i+1)
+		assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, wantRemaining, w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "3;w=60", w.Header().Get("RateLimit-Policy"))
+	}
+
+	// 同じポリシーを付けたルートはバケットを共有する
+	w := doRateLimitRequest(r, "/api/b", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	// 3回/60秒なので次のトークンは20秒後
+	assert.Equal(t, "20", w.Header().Get("Retry-After"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, apperror.TooManyRequestsMessageGeneric, body["error"])
+
+	// 別の IP は影響を受けない
+	w = doRateLimitRequest(r, "/api/a", "198.51.100.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 補充されたぶんだけ再び通る
+	clock.t = clock.t.Add(20 * time.Second)
+	w = doRateLimitRequest(r, "/api/a", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRateLimitRequest(r, "/api/a", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Window が過ぎれば満杯に戻る
+	clock.t = clock.t.Add(time.Minute)
+	w = doRateLimitRequest(r, "/api/a", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestRateLimit_ByIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("TRUSTED_PROXIES", "")
+	clock := &rateLimitClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := setupRateLimitRouter(middleware.RateLimitPolicy{Name: "auth", Limit: 2, Window: time.Minute, Now: clock.Now})
+	require.NoError(t, middleware.ConfigureTrustedProxies(r))
+
+	// X-Forwarded-For を毎回変えても接続元 IP で数える
+	codes := make([]int, 0, 3)
+	for _, forwarded := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/a", nil)
+		req.RemoteAddr = "192.0.2.1:54321"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestRateLimit_ByPrincipal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clock := &rateLimitClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	setUser := func(c *gin.Context) {
+		if c.GetHeader("X-Test-User") == "2" {
+			auth.SetPrincipal(c, auth.Principal{UserID: 2})
+		} else {
+			auth.SetPrincipal(c, auth.Principal{UserID: 1})
+		}
+		c.Next()
+	}
+	r := setupRateLimitRouter(middleware.RateLimitPolicy{
+		Name:   "user",
+		Limit:  1,
+		Window: time.Minute,
+		Key:    middleware.RateLimitByPrincipal,
+		Now:    clock.Now,
+	}, setUser)
+
+	w := doRateLimitRequest(r, "/api/a", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 同じユーザーは IP が変わっても同じバケット
+	w = doRateLimitRequest(r, "/api/a", "198.51.100.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// 同じ IP でも別ユーザーは別のバケット
+	req := httptest.NewRequest(http.MethodGet, "/api/a", nil)
+	req.RemoteAddr = "192.0.2.1:54321"
+	req.Header.Set("X-Test-User", "2")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimit_InvalidPolicyPanics(t *testing.T) {
+	assert.Panics(t, func() {
+		middleware.RateLimit(middleware.RateLimitPolicy{Name: "zero", Limit: 0, Window: time.Minute})
+	})
+}
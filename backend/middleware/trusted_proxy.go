@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedProxiesFromEnv は TRUSTED_PROXIES(カンマ区切りの IP または CIDR)を読む。
+// 未設定なら空で、X-Forwarded-For・X-Real-IP を信用せず接続元アドレスを ClientIP とする。
+// ロードバランサーやリバースプロキシの後ろに置くときは、そのアドレスだけを指定すること
+func TrustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// ConfigureTrustedProxies は r が信用するプロキシを TRUSTED_PROXIES に絞る。
+// gin の既定は全てのプロキシを信用するため、そのままではクライアントが X-Forwarded-For を
+// 書き換えるだけで IP 単位のログイン制限・レート制限を回避できてしまう
+func ConfigureTrustedProxies(r *gin.Engine) error {
+	return r.SetTrustedProxies(TrustedProxiesFromEnv())
+}
@@ -0,0 +1,51 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/middleware"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func clientIPOf(t *testing.T, remoteAddr, forwardedFor string) string {
+	t.Helper()
+	r := gin.New()
+	require.NoError(t, middleware.ConfigureTrustedProxies(r))
+	r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	req.Header.Set("X-Real-IP", forwardedFor)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestConfigureTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("既定ではX-Forwarded-Forを信用しない", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "")
+		assert.Equal(t, "203.0.113.5", clientIPOf(t, "203.0.113.5:1234", "198.51.100.1"))
+	})
+
+	t.Run("信用するプロキシ経由ならX-Forwarded-Forを使う", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.0.1")
+		assert.Equal(t, "198.51.100.1", clientIPOf(t, "10.0.0.2:1234", "198.51.100.1"))
+	})
+
+	t.Run("信用しない接続元からのX-Forwarded-Forは無視する", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+		assert.Equal(t, "203.0.113.5", clientIPOf(t, "203.0.113.5:1234", "198.51.100.1"))
+	})
+
+	t.Run("不正な設定はエラー", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "not-an-ip")
+		assert.Error(t, middleware.ConfigureTrustedProxies(gin.New()))
+	})
+}
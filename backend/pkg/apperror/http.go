@@ -6,19 +6,33 @@ import (
 )
 
 var validationMessages = map[string]string{
-	"email":    ValidationMessageEmail,
-	"password": ValidationMessagePassword,
-	"name":     ValidationMessageName,
-	"sku":      ValidationMessageSku,
-	"id":       ValidationMessageID,
-	"order":    ValidationMessageOrder,
-	"status":   ValidationMessageStatus,
-	"price":    ValidationMessagePrice,
-	"request":  ValidationMessageRequest,
-	"role":     ValidationMessageRole,
-	"cart":     ValidationMessageCart,
-	"category": ValidationMessageCategory,
-	"qty":      ValidationMessageQty,
+	"email":            ValidationMessageEmail,
+	"password":         ValidationMessagePassword,
+	"name":             ValidationMessageName,
+	"sku":              ValidationMessageSku,
+	"id":               ValidationMessageID,
+	"order":            ValidationMessageOrder,
+	"status":           ValidationMessageStatus,
+	"price":            ValidationMessagePrice,
+	"request":          ValidationMessageRequest,
+	"role":             ValidationMessageRole,
+	"cart":             ValidationMessageCart,
+	"category":         ValidationMessageCategory,
+	"qty":              ValidationMessageQty,
+	"idempotency_key":  ValidationMessageIdempotencyKey,
+	"limit":            ValidationMessageLimit,
+	"cursor":           ValidationMessageCursor,
+	"from":             ValidationMessageDate,
+	"to":               ValidationMessageDate,
+	"category_id":      ValidationMessageCategoryID,
+	"min_price":        ValidationMessagePriceRange,
+	"max_price":        ValidationMessagePriceRange,
+	"is_available":     ValidationMessageBool,
+	"in_stock":         ValidationMessageBool,
+	"q":                ValidationMessageKeyword,
+	"sort":             ValidationMessageSort,
+	"token":            ValidationMessageResetToken,
+	"current_password": ValidationMessageCurrentPassword,
 }
 
 var conflictMessages = map[string]string{
@@ -33,6 +47,17 @@ var notFoundMessages = map[string]string{
 	"user":      NotFoundMessageUser,
 	"category":  NotFoundMessageCategory,
 	"order":     NotFoundMessageOrder,
+	"api_key":   NotFoundMessageAPIKey,
+	"oidc":      NotFoundMessageOIDC,
+}
+
+var forbiddenMessages = map[string]string{
+	ForbiddenReasonAccountSuspended: ForbiddenMessageSuspended,
+	ForbiddenReasonAccountDisabled:  ForbiddenMessageDisabled,
+	ForbiddenReasonAccountPending:   ForbiddenMessagePending,
+	ForbiddenReasonEmailUnverified:  ForbiddenMessageUnverified,
+	ForbiddenReasonMFARequired:      ForbiddenMessageMFARequired,
+	ForbiddenReasonAPIKeyScope:      ForbiddenMessageAPIKeyScope,
 }
 
 func ToHTTP(err error) (status int, message string) {
@@ -86,9 +111,20 @@ func ToHTTP(err error) (status int, message string) {
 		if fe.Message != "" {
 			return http.StatusForbidden, fe.Message
 		}
+		if m, ok := forbiddenMessages[fe.Reason]; ok {
+			return http.StatusForbidden, m
+		}
 		return http.StatusForbidden, ForbiddenMessageGeneric
 	}
 
+	var te *TooManyRequestsError
+	if errors.As(err, &te) {
+		if te.Message != "" {
+			return http.StatusTooManyRequests, te.Message
+		}
+		return http.StatusTooManyRequests, TooManyRequestsMessageGeneric
+	}
+
 	var be *BusinessLogicError
 	if errors.As(err, &be) {
 		if be.Message != "" {
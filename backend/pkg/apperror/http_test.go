@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestToHTTP(t *testing.T) {
@@ -87,6 +88,24 @@ func TestToHTTP(t *testing.T) {
 			wantStatus: http.StatusForbidden,
 			wantMsg:    ForbiddenMessageGeneric,
 		},
+		{
+			name:       "Forbidden: reason map account_suspended",
+			err:        NewAccountForbiddenError(ForbiddenReasonAccountSuspended, ""),
+			wantStatus: http.StatusForbidden,
+			wantMsg:    ForbiddenMessageSuspended,
+		},
+		{
+			name:       "TooManyRequests: Message優先",
+			err:        NewTooManyRequestsError("login_locked", time.Minute, TooManyRequestsMessageLogin),
+			wantStatus: http.StatusTooManyRequests,
+			wantMsg:    TooManyRequestsMessageLogin,
+		},
+		{
+			name:       "TooManyRequests: Message空はfallback",
+			err:        NewTooManyRequestsError("", 0, ""),
+			wantStatus: http.StatusTooManyRequests,
+			wantMsg:    TooManyRequestsMessageGeneric,
+		},
 		{
 			name:       "BusinessLogic: Message空はfallback",
 			err:        NewBusinessLogicError(""),
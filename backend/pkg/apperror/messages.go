@@ -13,16 +13,48 @@ const (
 	ValidationMessageStatus          = "無効なステータスです"
 	ValidationMessagePrice           = "価格は正の整数である必要があります"
 	ValidationMessageRequest         = "リクエスト形式が正しくありません"
+	ValidationMessageRequestTooLarge = "リクエストが大きすぎます"
 	ValidationMessageRole            = "無効なロールです"
 	ValidationMessageCart            = "カートが空です"
 	ValidationMessageCategory        = "カテゴリ名は必須です"
 	ValidationMessageQty             = "在庫は1以上である必要があります"
 	ValidationMessageEssentialOrder  = "注文IDが必要です"
 	ValidationMessageConflictedEmail = "このメールアドレスは既に登録されています"
+	ValidationMessageIdempotencyKey  = "Idempotency-Keyが正しくありません"
+	ValidationMessageLimit           = "limitは1から100の整数で指定してください"
+	ValidationMessageCursor          = "cursorが正しくありません"
+	ValidationMessageDate            = "日時はRFC3339形式で指定してください"
+	ValidationMessageDateRange       = "期間の指定が正しくありません"
+	ValidationMessageCategoryID      = "カテゴリIDが正しくありません"
+	ValidationMessagePriceRange      = "価格の範囲が正しくありません"
+	ValidationMessageBool            = "trueまたはfalseで指定してください"
+	ValidationMessageKeyword         = "検索キーワードは100文字以内で指定してください"
+	ValidationMessageSort            = "無効な並び順です"
+	ValidationMessageResetToken      = "パスワード再設定用のトークンが無効か、有効期限が切れています"
+	ValidationMessageCurrentPassword = "現在のパスワードが正しくありません"
+	ValidationMessageVerifyToken     = "確認用のリンクが無効です"
+	ValidationMessageVerifyExpired   = "確認用のリンクの有効期限が切れています。確認メールを再送してください"
+	ValidationMessageMFACode         = "確認コードが正しくありません"
+	ValidationMessageAPIKeyScope     = "無効なスコープです"
+	ValidationMessageAPIKeyExpiry    = "有効期限は1から365日の間で指定してください"
 
 	// 400
-	BusinessLogicMessageGeneric = "この操作は実行できません"
-	BusinessLogicMessageRole    = "自分自身のロールは変更できません"
+	BusinessLogicMessageGeneric     = "この操作は実行できません"
+	BusinessLogicMessageRole        = "自分自身のロールは変更できません"
+	BusinessLogicMessagePayment     = "この注文は支払いできません"
+	BusinessLogicMessageDeclined    = "決済に失敗しました"
+	BusinessLogicMessageCancel      = "この注文はキャンセルできません"
+	BusinessLogicMessagePaying      = "決済の処理中のためキャンセルできません。支払いをもう一度お試しください"
+	BusinessLogicMessageOrderStatus = "この注文のステータスは変更できません"
+	BusinessLogicMessageRefund      = "この注文には返金できる決済がありません"
+	BusinessLogicMessageSuspendSelf = "自分自身のアカウントは停止できません"
+	BusinessLogicMessageUserStatus  = "このユーザーのステータスは変更できません"
+	BusinessLogicMessageVerified    = "メールアドレスは確認済みです"
+	BusinessLogicMessageMFAEnabled  = "2段階認証は既に有効です"
+	BusinessLogicMessageMFASetup    = "2段階認証の設定を開始してください"
+	BusinessLogicMessageMFADisabled = "2段階認証は有効になっていません"
+	BusinessLogicMessageAPIKeyAdmin = "管理者アカウントにはAPIキーを発行できません"
+	BusinessLogicMessageNoPassword  = "パスワードが設定されていません。パスワード再設定のメールから設定してください"
 
 	// 404
 	NotFoundMessageGeneric  = "リソースが見つかりません"
@@ -32,20 +64,41 @@ const (
 	NotFoundMessageUser     = "ユーザーが見つかりません"
 	NotFoundMessageCategory = "カテゴリが見つかりません"
 	NotFoundMessageOrder    = "注文が見つかりません"
+	NotFoundMessageAPIKey   = "APIキーが見つかりません"
+	NotFoundMessageOIDC     = "ログイン方法が見つかりません"
 
 	// 409
-	ConflictMessageGeneric = "競合が発生しました"
-	ConflictMessageQty     = "在庫不足です"
-	ConflictMessageSku     = "SKUが既に存在します"
+	ConflictMessageGeneric               = "競合が発生しました"
+	ConflictMessageQty                   = "在庫不足です"
+	ConflictMessageSku                   = "SKUが既に存在します"
+	ConflictMessageIdempotencyMismatch   = "同じIdempotency-Keyが異なるリクエストで使用されています"
+	ConflictMessageIdempotencyInProgress = "同じリクエストを処理中です"
+	ConflictMessageBusy                  = "処理が混み合っています。時間をおいて再度お試しください"
 
 	// 401
 	UnauthorizedMessageGeneric         = "認証エラーが発生しました"
 	UnauthorizedMessageAuth            = "認証が必要です"
 	UnauthorizedMessageEmailOrPassword = "メールアドレスまたはパスワードが正しくありません"
+	UnauthorizedMessageMFACode         = "確認コードが正しくありません"
+	UnauthorizedMessageMFAChallenge    = "確認の有効期限が切れました。もう一度ログインしてください"
+	UnauthorizedMessageAPIKey          = "APIキーが無効か、有効期限が切れています"
 
 	// 403
-	ForbiddenMessageGeneric = "権限エラーが発生しました"
-	ForbiddenMessageAdmin   = "管理者権限が必要です"
+	ForbiddenMessageGeneric     = "権限エラーが発生しました"
+	ForbiddenMessageAdmin       = "管理者権限が必要です"
+	ForbiddenMessageStaff       = "スタッフ権限が必要です"
+	ForbiddenMessageSuspended   = "このアカウントは利用停止中です"
+	ForbiddenMessageDisabled    = "このアカウントは無効化されています"
+	ForbiddenMessagePending     = "このアカウントはまだ有効化されていません"
+	ForbiddenMessageUnverified  = "メールアドレスの確認が完了していません。確認メールのリンクを開いてください"
+	ForbiddenMessageCSRF        = "リクエストの検証に失敗しました。ページを再読み込みしてから再度お試しください"
+	ForbiddenMessageMFARequired = "管理者アカウントは2段階認証の設定が必要です"
+	ForbiddenMessageAPIKeyScope = "このAPIキーにはこの操作の権限がありません"
+
+	// 429
+	TooManyRequestsMessageGeneric  = "リクエストが多すぎます。しばらくしてから再度お試しください"
+	TooManyRequestsMessageLogin    = "ログインの試行回数が上限に達しました。しばらくしてから再度お試しください"
+	TooManyRequestsMessagePassword = "パスワードの確認の試行回数が上限に達しました。しばらくしてから再度お試しください"
 
 	// 500
 	InternalServerMessageCommon   = "予期せぬエラーが発生しました"
@@ -3,6 +3,7 @@ package apperror
 import (
 	"reflect"
 	"sol_coffeesys/backend/pkg/redaction"
+	"time"
 )
 
 type ValidationError struct {
@@ -89,7 +90,21 @@ func (e *UnauthorizedError) Error() string {
 	return e.Message
 }
 
+// ForbiddenError の Reason。ロール不足かアカウント状態による拒否かを区別する
+const (
+	ForbiddenReasonRole             = "insufficient_role"
+	ForbiddenReasonAccountSuspended = "account_suspended"
+	ForbiddenReasonAccountDisabled  = "account_disabled"
+	ForbiddenReasonAccountPending   = "account_pending"
+	ForbiddenReasonEmailUnverified  = "email_unverified"
+	ForbiddenReasonMFARequired      = "mfa_enrollment_required"
+	ForbiddenReasonAPIKeyScope      = "api_key_scope"
+	ForbiddenReasonCSRFMissing      = "csrf_token_missing"
+	ForbiddenReasonCSRFMismatch     = "csrf_token_mismatch"
+)
+
 type ForbiddenError struct {
+	Reason       string
 	RequiredRole string
 	UserRole     string
 	Message      string
@@ -97,16 +112,51 @@ type ForbiddenError struct {
 
 func NewForbiddenError(requiredRole, userRole, message string) *ForbiddenError {
 	return &ForbiddenError{
+		Reason:       ForbiddenReasonRole,
 		RequiredRole: requiredRole,
 		UserRole:     userRole,
 		Message:      message,
 	}
 }
 
+func NewAccountForbiddenError(reason, message string) *ForbiddenError {
+	return &ForbiddenError{
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// NewCSRFForbiddenError は CSRF トークンの検証失敗。reason は ForbiddenReasonCSRF*
+func NewCSRFForbiddenError(reason string) *ForbiddenError {
+	return &ForbiddenError{
+		Reason:  reason,
+		Message: ForbiddenMessageCSRF,
+	}
+}
+
 func (e *ForbiddenError) Error() string {
 	return e.Message
 }
 
+// TooManyRequestsError は試行回数の上限による拒否。RetryAfter は Retry-After ヘッダに載せる
+type TooManyRequestsError struct {
+	Reason     string
+	RetryAfter time.Duration
+	Message    string
+}
+
+func NewTooManyRequestsError(reason string, retryAfter time.Duration, message string) *TooManyRequestsError {
+	return &TooManyRequestsError{
+		Reason:     reason,
+		RetryAfter: retryAfter,
+		Message:    message,
+	}
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return e.Message
+}
+
 type BusinessLogicError struct {
 	Message string
 }
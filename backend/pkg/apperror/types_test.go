@@ -314,6 +314,9 @@ func TestNewForbiddenError(t *testing.T) {
 			if err == nil {
 				t.Fatal("NewForbiddenError() returned")
 			}
+			if err.Reason != ForbiddenReasonRole {
+				t.Fatalf("Reason = %q, want %q", err.Reason, ForbiddenReasonRole)
+			}
 			if err.RequiredRole != tt.requiredRole {
 				t.Fatalf("RequiredRole = %q, want %q", err.RequiredRole, tt.requiredRole)
 			}
@@ -330,6 +333,50 @@ func TestNewForbiddenError(t *testing.T) {
 	}
 }
 
+func TestNewAccountForbiddenError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		reason    string
+		message   string
+		wantError string
+	}{
+		{
+			name:      "異常系: 利用停止中のアカウント",
+			reason:    ForbiddenReasonAccountSuspended,
+			message:   ForbiddenMessageSuspended,
+			wantError: ForbiddenMessageSuspended,
+		},
+		{
+			name:      "異常系: メッセージ未指定",
+			reason:    ForbiddenReasonAccountDisabled,
+			message:   "",
+			wantError: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := NewAccountForbiddenError(tt.reason, tt.message)
+			if err == nil {
+				t.Fatal("NewAccountForbiddenError() returned nil")
+			}
+			if err.Reason != tt.reason {
+				t.Fatalf("Reason = %q, want %q", err.Reason, tt.reason)
+			}
+			if err.RequiredRole != "" || err.UserRole != "" {
+				t.Fatalf("RequiredRole/UserRole = %q/%q, want empty", err.RequiredRole, err.UserRole)
+			}
+			if got := err.Error(); got != tt.wantError {
+				t.Fatalf("Error() = %q, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
+
 func TestNewBusinessLogicError(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,128 @@
+// Package emailverify はメールアドレス確認用の署名付きトークンを発行・検証する。
+// トークンは DB に保存せず、ユーザーID・メールアドレスのハッシュ・有効期限を HMAC-SHA256 で署名する。
+// メールアドレスを変更すると、変更前に発行したトークンは使えなくなる。
+package emailverify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTTL は確認メールのリンクの有効期限
+const DefaultTTL = 24 * time.Hour
+
+var (
+	ErrNoSecret     = errors.New("emailverify: secret is not configured")
+	ErrInvalidToken = errors.New("emailverify: invalid token")
+	ErrExpiredToken = errors.New("emailverify: token expired")
+)
+
+type Claims struct {
+	UserID    int64
+	ExpiresAt time.Time
+	emailHash string
+}
+
+// MatchesEmail はトークンが email に宛てて発行されたものかを返す
+func (c Claims) MatchesEmail(email string) bool {
+	return hmac.Equal([]byte(c.emailHash), []byte(hashEmail(email)))
+}
+
+type payload struct {
+	UserID    int64  `json:"uid"`
+	EmailHash string `json:"em"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// NewSignerFromEnv は EMAIL_VERIFICATION_SECRET で署名する。
+// 未設定なら JWT_SECRET から用途別の鍵を導出する(アクセストークンと同じ鍵をそのまま使わない)
+func NewSignerFromEnv() *Signer {
+	if secret := os.Getenv("EMAIL_VERIFICATION_SECRET"); secret != "" {
+		return NewSigner([]byte(secret), DefaultTTL)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("email-verification"))
+		return NewSigner(mac.Sum(nil), DefaultTTL)
+	}
+	return NewSigner(nil, DefaultTTL)
+}
+
+// WithClock はテスト用に現在時刻を差し替えた Signer を返す
+func (s *Signer) WithClock(now func() time.Time) *Signer {
+	c := *s
+	c.now = now
+	return &c
+}
+
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *Signer) Issue(userID int64, email string) (string, error) {
+	if len(s.secret) == 0 {
+		return "", ErrNoSecret
+	}
+	body, err := json.Marshal(payload{
+		UserID:    userID,
+		EmailHash: hashEmail(email),
+		ExpiresAt: s.now().Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+func (s *Signer) Verify(token string) (Claims, error) {
+	if len(s.secret) == 0 {
+		return Claims{}, ErrNoSecret
+	}
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return Claims{}, ErrInvalidToken
+	}
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil || p.UserID == 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	expiresAt := time.Unix(p.ExpiresAt, 0)
+	if !s.now().Before(expiresAt) {
+		return Claims{}, ErrExpiredToken
+	}
+	return Claims{UserID: p.UserID, ExpiresAt: expiresAt, emailHash: p.EmailHash}, nil
+}
+
+func (s *Signer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// リンクにメールアドレスそのものを載せないためハッシュにする。大文字小文字の違いは同じ宛先とみなす
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:16])
+}
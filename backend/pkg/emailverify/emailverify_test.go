@@ -0,0 +1,67 @@
+package emailverify
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	s := NewSigner([]byte("secret"), time.Hour).WithClock(clock)
+
+	token, err := s.Issue(42, "User@Example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, strings.ToLower(token), "example.com")
+
+	claims, err := s.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), claims.UserID)
+	assert.True(t, claims.MatchesEmail("user@example.com"))
+	assert.False(t, claims.MatchesEmail("other@example.com"))
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		token   string
+		wantErr error
+	}{
+		{name: "期限切れ", signer: s.WithClock(func() time.Time { return now.Add(time.Hour) }), token: token, wantErr: ErrExpiredToken},
+		{name: "別の鍵で署名", signer: NewSigner([]byte("other"), time.Hour).WithClock(clock), token: token, wantErr: ErrInvalidToken},
+		{name: "署名の改ざん", signer: s, token: token + "x", wantErr: ErrInvalidToken},
+		{name: "区切りが無い", signer: s, token: "garbage", wantErr: ErrInvalidToken},
+		{name: "鍵が未設定", signer: NewSigner(nil, time.Hour), token: token, wantErr: ErrNoSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.signer.Verify(tt.token)
+			assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+		})
+	}
+}
+
+func TestSigner_IssueWithoutSecret(t *testing.T) {
+	_, err := NewSigner(nil, time.Hour).Issue(1, "user@example.com")
+	assert.ErrorIs(t, err, ErrNoSecret)
+}
+
+func TestNewSignerFromEnv(t *testing.T) {
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "")
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	fromJWT := NewSignerFromEnv()
+	token, err := fromJWT.Issue(1, "user@example.com")
+	require.NoError(t, err)
+
+	// JWT_SECRET をそのまま鍵にしない
+	_, err = NewSigner([]byte("jwt-secret"), DefaultTTL).Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "dedicated")
+	_, err = NewSignerFromEnv().Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,167 @@
+// Package loginguard はログイン試行の失敗回数を IP 単位とアカウント単位で数え、
+// 上限を超えたキーを一定時間ロックしてブルートフォース攻撃を防ぐ。
+// カウンタは Store に置く。本番は複数インスタンスで共有できる PostgresStore、
+// ユニットテストでは MemoryStore を使う。
+package loginguard
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store は試行回数とロック状態の保存先
+type Store interface {
+	// LockedUntil はキーのロック期限を返す。ロックされていなければゼロ値
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// RecordFailure は失敗を 1 回数え、集計期間内の失敗回数を返す。
+	// windowStart より前に始まった集計期間は破棄して数え直す
+	RecordFailure(ctx context.Context, key string, windowStart time.Time) (int, error)
+	// Lock はキーを until までロックし、失敗回数を数え直す
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset はキーのカウンタとロックを消す
+	Reset(ctx context.Context, key string) error
+	// Prune は staleBefore 以降に失敗が無く、ロックも切れたキーを消す
+	Prune(ctx context.Context, staleBefore time.Time) error
+}
+
+// Policy は Window の間に MaxFailures 回失敗したら Lockout の間ロックするという規則
+type Policy struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+}
+
+type Options struct {
+	// Account はメールアドレス単位の規則。特定アカウントへのパスワード総当たりを防ぐ
+	Account Policy
+	// IP は接続元単位の規則。同じ IP から多数のアカウントを試す攻撃(パスワードスプレー)を防ぐ
+	IP Policy
+	// PruneInterval ごとに、集計期間とロックが終わったカウンタを失敗の記録時に消す。0 なら消さない
+	PruneInterval time.Duration
+	Now           func() time.Time
+}
+
+// DefaultOptions はアカウント単位 15 分で 5 回、IP 単位 15 分で 20 回失敗すると 15 分ロックする。
+// 期限の切れたカウンタは 5 分ごとに消す
+func DefaultOptions() Options {
+	return Options{
+		Account:       Policy{MaxFailures: 5, Window: 15 * time.Minute, Lockout: 15 * time.Minute},
+		IP:            Policy{MaxFailures: 20, Window: 15 * time.Minute, Lockout: 15 * time.Minute},
+		PruneInterval: 5 * time.Minute,
+		Now:           time.Now,
+	}
+}
+
+type Guard struct {
+	store Store
+	opts  Options
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func New(store Store, opts Options) *Guard {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Guard{store: store, opts: opts}
+}
+
+// Check は IP・アカウントのどちらかがロック中なら残り時間を返す。ロックされていなければ 0
+func (g *Guard) Check(ctx context.Context, ip, email string) (time.Duration, error) {
+	now := g.opts.Now()
+	var retryAfter time.Duration
+	for _, key := range g.keys(ip, email) {
+		until, err := g.store.LockedUntil(ctx, key.name)
+		if err != nil {
+			return 0, err
+		}
+		if d := until.Sub(now); d > retryAfter {
+			retryAfter = d
+		}
+	}
+	return retryAfter, nil
+}
+
+// RecordFailure は失敗を数え、上限に達したキーをロックする。
+// この失敗でロックされた場合はロックの残り時間を返す。ロックされなければ 0
+func (g *Guard) RecordFailure(ctx context.Context, ip, email string) (time.Duration, error) {
+	now := g.opts.Now()
+	var retryAfter time.Duration
+	for _, key := range g.keys(ip, email) {
+		failures, err := g.store.RecordFailure(ctx, key.name, now.Add(-key.policy.Window))
+		if err != nil {
+			return 0, err
+		}
+		if failures < key.policy.MaxFailures {
+			continue
+		}
+		if err := g.store.Lock(ctx, key.name, now.Add(key.policy.Lockout)); err != nil {
+			return 0, err
+		}
+		if key.policy.Lockout > retryAfter {
+			retryAfter = key.policy.Lockout
+		}
+	}
+	g.pruneIfDue(ctx, now)
+	return retryAfter, nil
+}
+
+// pruneIfDue は前回から PruneInterval 経っていれば期限切れのカウンタを消す。
+// 試されたキーごとに行が増えるため、消さないと任意のクライアントがテーブルを際限なく大きくできる
+func (g *Guard) pruneIfDue(ctx context.Context, now time.Time) {
+	if g.opts.PruneInterval <= 0 {
+		return
+	}
+	g.mu.Lock()
+	if now.Sub(g.lastPruned) < g.opts.PruneInterval {
+		g.mu.Unlock()
+		return
+	}
+	g.lastPruned = now
+	g.mu.Unlock()
+
+	// 掃除に失敗してもロックの判定には影響しない。記録だけ残して次の間隔で再試行する
+	if err := g.store.Prune(ctx, now.Add(-g.staleAfter())); err != nil {
+		slog.WarnContext(ctx, "login throttle prune failed", "error", err)
+	}
+}
+
+// staleAfter は最後の失敗からこれだけ経てば、どの規則でも集計期間が終わっている長さ
+func (g *Guard) staleAfter() time.Duration {
+	return max(g.opts.Account.Window, g.opts.IP.Window)
+}
+
+// RecordSuccess はアカウント単位のカウンタを消す。
+// IP 単位は消さない(正しいパスワードを 1 つ知っている攻撃者が IP のカウンタを戻せないようにする)
+func (g *Guard) RecordSuccess(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, AccountKey(email))
+}
+
+type guardKey struct {
+	name   string
+	policy Policy
+}
+
+func (g *Guard) keys(ip, email string) []guardKey {
+	keys := make([]guardKey, 0, 2)
+	if g.opts.IP.MaxFailures > 0 && ip != "" {
+		keys = append(keys, guardKey{name: IPKey(ip), policy: g.opts.IP})
+	}
+	if g.opts.Account.MaxFailures > 0 {
+		keys = append(keys, guardKey{name: AccountKey(email), policy: g.opts.Account})
+	}
+	return keys
+}
+
+// AccountKey はメールアドレスを正規化したカウンタのキー。大文字小文字違いで回数を分散させない
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,244 @@
+package loginguard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sol_coffeesys/backend/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGuard(clock *fakeClock) *Guard {
+	return New(NewMemoryStore(clock.Now), Options{
+		Account: Policy{MaxFailures: 3, Window: 10 * time.Minute, Lockout: 15 * time.Minute},
+		IP:      Policy{MaxFailures: 5, Window: 10 * time.Minute, Lockout: 30 * time.Minute},
+		Now:     clock.Now,
+	})
+}
+
+func TestGuard_AccountLockout(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g := newTestGuard(clock)
+
+	for i := 0; i < 2; i++ {
+		d, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+		require.NoError(t, err)
+		assert.Zero(t, d)
+	}
+
+	// 3回目でロックされる
+	d, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, d)
+
+	// 大文字小文字・前後の空白が違っても同じアカウントとして扱う
+	d, err = g.Check(ctx, "198.51.100.1", " User@Example.com ")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, d)
+
+	// 別アカウントは影響を受けない
+	d, err = g.Check(ctx, "198.51.100.1", "other@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	clock.Advance(15 * time.Minute)
+	d, err = g.Check(ctx, "192.0.2.1", "user@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	// ロック解除後は 1 回の失敗でロックされない
+	d, err = g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+}
+
+func TestGuard_IPLockout(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g := newTestGuard(clock)
+
+	// 同じ IP から別々のアカウントを試す(アカウント単位の上限には達しない)
+	var d time.Duration
+	for i, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		var err error
+		d, err = g.RecordFailure(ctx, "192.0.2.1", email)
+		require.NoError(t, err)
+		if i < 4 {
+			assert.Zero(t, d)
+		}
+	}
+	assert.Equal(t, 30*time.Minute, d)
+
+	d, err := g.Check(ctx, "192.0.2.1", "f@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, d)
+
+	d, err = g.Check(ctx, "198.51.100.1", "f@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+}
+
+func TestGuard_WindowExpires(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g := newTestGuard(clock)
+
+	for i := 0; i < 2; i++ {
+		_, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+		require.NoError(t, err)
+	}
+
+	// 集計期間を過ぎた失敗は数えない
+	clock.Advance(11 * time.Minute)
+	d, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+}
+
+func TestGuard_RecordSuccessResetsAccountOnly(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore(clock.Now)
+	g := New(store, Options{
+		Account: Policy{MaxFailures: 3, Window: 10 * time.Minute, Lockout: 15 * time.Minute},
+		IP:      Policy{MaxFailures: 3, Window: 10 * time.Minute, Lockout: 15 * time.Minute},
+		Now:     clock.Now,
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+		require.NoError(t, err)
+	}
+	require.NoError(t, g.RecordSuccess(ctx, "user@example.com"))
+
+	// IP のカウンタは残っているので次の失敗で IP がロックされる
+	d, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, d)
+
+	until, err := store.LockedUntil(ctx, AccountKey("user@example.com"))
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+}
+
+func TestGuard_PrunesStaleCounters(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore(clock.Now)
+	g := New(store, Options{
+		Account:       Policy{MaxFailures: 2, Window: 10 * time.Minute, Lockout: 30 * time.Minute},
+		IP:            Policy{MaxFailures: 5, Window: 10 * time.Minute, Lockout: 30 * time.Minute},
+		PruneInterval: 5 * time.Minute,
+		Now:           clock.Now,
+	})
+
+	// locked@ はロックされ、stale@ は 1 回失敗しただけ
+	for i := 0; i < 2; i++ {
+		_, err := g.RecordFailure(ctx, "192.0.2.1", "locked@example.com")
+		require.NoError(t, err)
+	}
+	_, err := g.RecordFailure(ctx, "192.0.2.2", "stale@example.com")
+	require.NoError(t, err)
+	require.Len(t, store.entries, 4)
+
+	// 集計期間が過ぎた後の失敗で、ロック中でないカウンタが消える
+	clock.Advance(11 * time.Minute)
+	_, err = g.RecordFailure(ctx, "192.0.2.3", "new@example.com")
+	require.NoError(t, err)
+
+	assert.Contains(t, store.entries, AccountKey("locked@example.com"))
+	assert.Contains(t, store.entries, AccountKey("new@example.com"))
+	assert.NotContains(t, store.entries, AccountKey("stale@example.com"))
+	assert.NotContains(t, store.entries, IPKey("192.0.2.2"))
+
+	d, err := g.Check(ctx, "192.0.2.1", "locked@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 19*time.Minute, d, "ロック中のカウンタは消さない")
+}
+
+func TestGuard_PruneErrorDoesNotFailRecord(t *testing.T) {
+	ctx := context.Background()
+	g := New(pruneErrStore{NewMemoryStore(nil)}, DefaultOptions())
+
+	_, err := g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	assert.NoError(t, err)
+}
+
+type pruneErrStore struct{ *MemoryStore }
+
+func (pruneErrStore) Prune(context.Context, time.Time) error { return errors.New("store error") }
+
+func TestGuard_StoreError(t *testing.T) {
+	ctx := context.Background()
+	g := New(errStore{}, DefaultOptions())
+
+	_, err := g.Check(ctx, "192.0.2.1", "user@example.com")
+	assert.Error(t, err)
+	_, err = g.RecordFailure(ctx, "192.0.2.1", "user@example.com")
+	assert.Error(t, err)
+}
+
+type errStore struct{}
+
+func (errStore) LockedUntil(context.Context, string) (time.Time, error) {
+	return time.Time{}, errors.New("store error")
+}
+func (errStore) RecordFailure(context.Context, string, time.Time) (int, error) {
+	return 0, errors.New("store error")
+}
+func (errStore) Lock(context.Context, string, time.Time) error { return errors.New("store error") }
+func (errStore) Reset(context.Context, string) error           { return errors.New("store error") }
+func (errStore) Prune(context.Context, time.Time) error        { return errors.New("store error") }
+
+type fakeThrottleQuerier struct {
+	db.Querier
+	row    db.LoginThrottle
+	getErr error
+}
+
+func (f *fakeThrottleQuerier) GetLoginThrottle(context.Context, string) (db.LoginThrottle, error) {
+	return f.row, f.getErr
+}
+
+func TestPostgresStore_LockedUntil(t *testing.T) {
+	ctx := context.Background()
+	until := time.Date(2025, 1, 1, 0, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		q       *fakeThrottleQuerier
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "行が無ければゼロ値", q: &fakeThrottleQuerier{getErr: sql.ErrNoRows}},
+		{name: "ロックされていなければゼロ値", q: &fakeThrottleQuerier{row: db.LoginThrottle{Failures: 2}}},
+		{
+			name: "ロック期限を返す",
+			q:    &fakeThrottleQuerier{row: db.LoginThrottle{LockedUntil: sql.NullTime{Time: until, Valid: true}}},
+			want: until,
+		},
+		{name: "DBエラーはそのまま返す", q: &fakeThrottleQuerier{getErr: errors.New("db error")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPostgresStore(tt.q).LockedUntil(ctx, "account:user@example.com")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got))
+		})
+	}
+}
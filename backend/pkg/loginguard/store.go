@@ -0,0 +1,138 @@
+package loginguard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sol_coffeesys/backend/db"
+	"sync"
+	"time"
+)
+
+// PostgresStore は login_throttles テーブルにカウンタを置く。複数インスタンスでも回数を共有できる
+type PostgresStore struct {
+	q db.Querier
+}
+
+func NewPostgresStore(q db.Querier) *PostgresStore {
+	return &PostgresStore{q: q}
+}
+
+func (s *PostgresStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	row, err := s.q.GetLoginThrottle(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	if !row.LockedUntil.Valid {
+		return time.Time{}, nil
+	}
+	return row.LockedUntil.Time, nil
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	failures, err := s.q.RecordLoginFailure(ctx, db.RecordLoginFailureParams{
+		ThrottleKey: key,
+		WindowStart: windowStart,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(failures), nil
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.q.LockLoginThrottle(ctx, db.LockLoginThrottleParams{
+		ThrottleKey: key,
+		LockedUntil: sql.NullTime{Time: until, Valid: true},
+	})
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.q.ResetLoginThrottle(ctx, key)
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, staleBefore time.Time) error {
+	_, err := s.q.DeleteStaleLoginThrottles(ctx, staleBefore)
+	return err
+}
+
+// MemoryStore はプロセス内にカウンタを置く。テストや単一インスタンスでの動作確認用
+type MemoryStore struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	failures      int
+	windowStarted time.Time
+	lockedUntil   time.Time
+}
+
+func NewMemoryStore(now func() time.Time) *MemoryStore {
+	if now == nil {
+		now = time.Now
+	}
+	return &MemoryStore{now: now, entries: map[string]*memoryEntry{}}
+}
+
+func (s *MemoryStore) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *MemoryStore) RecordFailure(_ context.Context, key string, windowStart time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{windowStarted: s.now()}
+		s.entries[key] = e
+	}
+	if e.windowStarted.Before(windowStart) {
+		e.failures = 0
+		e.windowStarted = s.now()
+	}
+	e.failures++
+	return e.failures, nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	e.failures = 0
+	e.windowStarted = s.now()
+	e.lockedUntil = until
+	return nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(_ context.Context, staleBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, e := range s.entries {
+		if e.windowStarted.Before(staleBefore) && !e.lockedUntil.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
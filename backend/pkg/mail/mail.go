@@ -0,0 +1,89 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sol_coffeesys/backend/pkg/redaction"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender はメール送信の抽象。本番の送信サービスはこのインターフェースを実装して差し替える。
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSenderFromEnv は SMTP_HOST が設定されていれば SMTPSender、
+// MAIL_OUTBOX_DIR が設定されていれば FileSender、どちらもなければ LogSender を返す。
+func NewSenderFromEnv() Sender {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return SMTPSender{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	}
+	if dir := os.Getenv("MAIL_OUTBOX_DIR"); dir != "" {
+		return FileSender{Dir: dir}
+	}
+	return LogSender{}
+}
+
+// LogSender は送信内容をログに出すだけのローカル実装。
+// 本文にはリセット用 URL などの秘密情報が含まれるため、開発環境以外では使わない。
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "mail sent",
+		"to", redaction.MaskEmail(msg.To),
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
+
+// FileSender は Dir 配下に 1 通 1 ファイルでメールを書き出すローカル実装。
+type FileSender struct {
+	Dir string
+}
+
+func (s FileSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405.000000000"), sanitizeFileName(msg.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+	return os.WriteFile(filepath.Join(s.Dir, name), []byte(content), 0o600)
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == '@':
+			return '_'
+		default:
+			return -1
+		}
+	}, s)
+}
@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSenderSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	s := FileSender{Dir: dir}
+
+	err := s.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "パスワード再設定",
+		Body:    "https://example.com/reset?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("files = %d, want 1", len(entries))
+	}
+	if !strings.HasSuffix(entries[0].Name(), "_alice_example.com.eml") {
+		t.Errorf("file name = %q", entries[0].Name())
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	for _, want := range []string{"To: alice@example.com", "Subject: パスワード再設定", "token=abc"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("content does not contain %q", want)
+		}
+	}
+}
+
+func TestFileSenderSend_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dir := t.TempDir()
+	if err := (FileSender{Dir: dir}).Send(ctx, Message{To: "a@example.com"}); err == nil {
+		t.Fatal("Send() error = nil, want context error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("files = %d, want 0", len(entries))
+	}
+}
+
+func TestNewSenderFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_OUTBOX_DIR", "")
+	if _, ok := NewSenderFromEnv().(LogSender); !ok {
+		t.Error("MAIL_OUTBOX_DIR 未設定なら LogSender")
+	}
+
+	t.Setenv("MAIL_OUTBOX_DIR", "/tmp/outbox")
+	s, ok := NewSenderFromEnv().(FileSender)
+	if !ok || s.Dir != "/tmp/outbox" {
+		t.Errorf("NewSenderFromEnv() = %#v, want FileSender{/tmp/outbox}", s)
+	}
+
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "")
+	t.Setenv("MAIL_FROM", "no-reply@example.com")
+	smtpSender, ok := NewSenderFromEnv().(SMTPSender)
+	if !ok || smtpSender.Host != "smtp.example.com" || smtpSender.Port != "587" || smtpSender.From != "no-reply@example.com" {
+		t.Errorf("NewSenderFromEnv() = %#v, want SMTPSender{smtp.example.com:587}", smtpSender)
+	}
+}
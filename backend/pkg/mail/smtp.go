@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader はヘッダに改行が含まれる(ヘッダインジェクション)ことを表す
+var ErrInvalidHeader = errors.New("mail: header contains line break")
+
+// SMTPSender は SMTP サーバー経由で送信する本番用の実装。
+// Username が空なら認証しない(ローカルの MailHog など)。
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+
+	// sendMail はテストで差し替える。nil なら smtp.SendMail
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	raw, err := s.build(msg)
+	if err != nil {
+		return err
+	}
+
+	var a smtp.Auth
+	if s.Username != "" {
+		a = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	send := s.sendMail
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(net.JoinHostPort(s.Host, s.Port), a, s.From, []string{msg.To}, raw)
+}
+
+// build は本文を base64 にした text/plain の RFC 5322 メッセージを組み立てる
+func (s SMTPSender) build(msg Message) ([]byte, error) {
+	for _, v := range []string{s.From, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestSMTPSenderSend(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg []byte
+	var gotAuth smtp.Auth
+	s := SMTPSender{
+		Host:     "smtp.example.com",
+		Port:     "587",
+		Username: "user",
+		Password: "pass",
+		From:     "no-reply@example.com",
+		sendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			gotAddr, gotAuth, gotFrom, gotTo, gotMsg = addr, a, from, to, msg
+			return nil
+		},
+	}
+
+	body := "以下のリンクからメールアドレスを確認してください。\nhttps://example.com/verify?token=abc"
+	if err := s.Send(context.Background(), Message{To: "alice@example.com", Subject: "メールアドレスの確認", Body: body}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if gotAddr != "smtp.example.com:587" || gotFrom != "no-reply@example.com" || len(gotTo) != 1 || gotTo[0] != "alice@example.com" {
+		t.Errorf("addr=%q from=%q to=%v", gotAddr, gotFrom, gotTo)
+	}
+	if gotAuth == nil {
+		t.Error("Username があれば PLAIN 認証する")
+	}
+
+	header, encoded, ok := strings.Cut(string(gotMsg), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator: %q", gotMsg)
+	}
+	for _, want := range []string{"From: no-reply@example.com", "To: alice@example.com", "Subject: =?UTF-8?b?", "Content-Type: text/plain; charset=UTF-8"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header does not contain %q", want)
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\r\n", ""))
+	if err != nil {
+		t.Fatalf("body is not base64: %v", err)
+	}
+	if string(decoded) != body {
+		t.Errorf("body = %q, want %q", decoded, body)
+	}
+}
+
+func TestSMTPSenderSend_Errors(t *testing.T) {
+	called := false
+	s := SMTPSender{Host: "localhost", Port: "1025", From: "no-reply@example.com",
+		sendMail: func(string, smtp.Auth, string, []string, []byte) error {
+			called = true
+			return errors.New("connection refused")
+		},
+	}
+
+	if err := s.Send(context.Background(), Message{To: "a@example.com\r\nBcc: evil@example.com"}); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Send() error = %v, want ErrInvalidHeader", err)
+	}
+	if called {
+		t.Error("ヘッダに改行があれば送信しない")
+	}
+
+	if err := s.Send(context.Background(), Message{To: "a@example.com"}); err == nil {
+		t.Error("送信エラーをそのまま返す")
+	}
+}
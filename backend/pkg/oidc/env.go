@@ -0,0 +1,48 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultRedirectBase は OIDC_<NAME>_REDIRECT_URL を省略したときのコールバック URL の前半(開発用)
+const defaultRedirectBase = "http://localhost:8080/api/auth/oidc/"
+
+var providerNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ConfigsFromEnv は OIDC_PROVIDERS(カンマ区切り。例: google,line)に挙げた IdP の設定を読む。
+// IdP ごとに OIDC_<NAME>_ISSUER・OIDC_<NAME>_CLIENT_ID・OIDC_<NAME>_CLIENT_SECRET を必須とし、
+// OIDC_<NAME>_REDIRECT_URL・OIDC_<NAME>_SCOPES(空白区切り)は省略できる
+func ConfigsFromEnv() ([]Config, error) {
+	raw := strings.TrimSpace(os.Getenv("OIDC_PROVIDERS"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	var configs []Config
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !providerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("oidc: invalid provider name %q", name)
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("oidc: %sISSUER, %sCLIENT_ID and %sCLIENT_SECRET are required", prefix, prefix, prefix)
+		}
+		if cfg.RedirectURL == "" {
+			cfg.RedirectURL = defaultRedirectBase + name + "/callback"
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
@@ -0,0 +1,198 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/pkg/oidc"
+	"sol_coffeesys/backend/pkg/oidc/oidctest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/api/auth/oidc/mock/callback"
+
+func newProvider(idp *oidctest.Server) *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  redirectURL,
+	}, nil)
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.NewServer("client-1", "secret-1")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: true, Name: "利用者"})
+	p := newProvider(idp)
+	ctx := context.Background()
+
+	verifier, err := oidc.RandomToken()
+	require.NoError(t, err)
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", oidc.CodeChallenge(verifier))
+	require.NoError(t, err)
+
+	callback, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	// code_verifier が違えば交換できない(PKCE)。失敗した認可コードも使えなくなる
+	_, err = p.Exchange(ctx, code, "wrong-verifier")
+	require.Error(t, err)
+
+	callback, err = idp.Authorize(authURL)
+	require.NoError(t, err)
+	rawIDToken, err := p.Exchange(ctx, callback.Query().Get("code"), verifier)
+	require.NoError(t, err)
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, oidc.Claims{Subject: "sub-1", Email: "user@example.com", EmailVerified: true, Name: "利用者"}, claims)
+
+	_, err = p.Exchange(ctx, callback.Query().Get("code"), verifier)
+	assert.Error(t, err, "認可コードは1回限り")
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	idp := oidctest.NewServer("client-1", "secret-1")
+	defer idp.Close()
+	other := oidctest.NewServer("client-1", "secret-1")
+	defer other.Close()
+
+	user := oidctest.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		nonce   string
+		wantErr error
+	}{
+		{
+			name:  "正常系",
+			token: func() string { return idp.SignIDToken(idp.Claims(user, "n")) },
+			nonce: "n",
+		},
+		{
+			name: "正常系：email_verified が文字列",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				c["email_verified"] = "true"
+				return idp.SignIDToken(c)
+			},
+			nonce: "n",
+		},
+		{
+			name:    "異常系：nonce が違う",
+			token:   func() string { return idp.SignIDToken(idp.Claims(user, "other")) },
+			nonce:   "n",
+			wantErr: oidc.ErrNonceMismatch,
+		},
+		{
+			name: "異常系：aud が別のクライアント",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				c["aud"] = "client-2"
+				return idp.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：aud が複数で azp が自分でない",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				c["aud"] = []string{"client-1", "client-2"}
+				c["azp"] = "client-2"
+				return idp.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：iss が違う",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				c["iss"] = other.Issuer()
+				return idp.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：有効期限切れ",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+				return idp.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：exp が無い",
+			token: func() string {
+				c := idp.Claims(user, "n")
+				delete(c, "exp")
+				return idp.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：別の IdP の鍵で署名",
+			token: func() string {
+				c := other.Claims(user, "n")
+				c["iss"] = idp.Issuer()
+				return other.SignIDToken(c)
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name: "異常系：client_secret で署名した HS256 の改ざん",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.Claims(user, "n"))
+				signed, _ := token.SignedString([]byte("not-the-secret"))
+				return signed
+			},
+			nonce:   "n",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := newProvider(idp).VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "err = %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "sub-1", claims.Subject)
+			assert.True(t, claims.EmailVerified)
+		})
+	}
+}
+
+func TestProvider_DiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewServer("client-1", "secret-1")
+	defer idp.Close()
+	// 別の Issuer を名乗るディスカバリ文書を返すサーバー
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, idp.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer proxy.Close()
+
+	p := oidc.NewProvider(oidc.Config{Name: "mock", Issuer: proxy.URL, ClientID: "client-1", RedirectURL: redirectURL}, nil)
+	_, err := p.AuthCodeURL(context.Background(), "s", "n", "c")
+	assert.ErrorContains(t, err, "does not match")
+}
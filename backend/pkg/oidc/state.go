@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultStateTTL はログイン開始から IdP で認証を終えて戻ってくるまでの猶予
+const DefaultStateTTL = 10 * time.Minute
+
+var (
+	ErrNoSecret     = errors.New("oidc: state secret is not configured")
+	ErrInvalidState = errors.New("oidc: invalid state")
+	ErrExpiredState = errors.New("oidc: state expired")
+)
+
+// LoginState はログイン開始時に発行し、コールバックで照合する値。
+// DB に保存せず、署名して利用者のブラウザの Cookie に預ける
+type LoginState struct {
+	Provider     string `json:"p"`
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	ExpiresAt    int64  `json:"exp"`
+}
+
+type StateSealer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewStateSealer(secret []byte, ttl time.Duration) *StateSealer {
+	return &StateSealer{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// NewStateSealerFromEnv は OIDC_STATE_SECRET で署名する。
+// 未設定なら JWT_SECRET から用途別の鍵を導出する(アクセストークンと同じ鍵をそのまま使わない)
+func NewStateSealerFromEnv() *StateSealer {
+	if secret := os.Getenv("OIDC_STATE_SECRET"); secret != "" {
+		return NewStateSealer([]byte(secret), DefaultStateTTL)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("oidc-state"))
+		return NewStateSealer(mac.Sum(nil), DefaultStateTTL)
+	}
+	return NewStateSealer(nil, DefaultStateTTL)
+}
+
+// WithClock はテスト用に現在時刻を差し替えた StateSealer を返す
+func (s *StateSealer) WithClock(now func() time.Time) *StateSealer {
+	c := *s
+	c.now = now
+	return &c
+}
+
+func (s *StateSealer) TTL() time.Duration {
+	return s.ttl
+}
+
+// Seal は有効期限を付けて署名した文字列を返す。code_verifier を含むので Cookie は HttpOnly にすること
+func (s *StateSealer) Seal(st LoginState) (string, error) {
+	if len(s.secret) == 0 {
+		return "", ErrNoSecret
+	}
+	st.ExpiresAt = s.now().Add(s.ttl).Unix()
+	body, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+func (s *StateSealer) Open(sealed string) (LoginState, error) {
+	if len(s.secret) == 0 {
+		return LoginState{}, ErrNoSecret
+	}
+	encoded, sig, ok := strings.Cut(sealed, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return LoginState{}, ErrInvalidState
+	}
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return LoginState{}, ErrInvalidState
+	}
+	var st LoginState
+	if err := json.Unmarshal(body, &st); err != nil || st.State == "" || st.Nonce == "" || st.CodeVerifier == "" {
+		return LoginState{}, ErrInvalidState
+	}
+	if !s.now().Before(time.Unix(st.ExpiresAt, 0)) {
+		return LoginState{}, ErrExpiredState
+	}
+	return st, nil
+}
+
+func (s *StateSealer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateSealer(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	s := NewStateSealer([]byte("secret"), 10*time.Minute).WithClock(clock)
+
+	in := LoginState{Provider: "google", State: "st", Nonce: "no", CodeVerifier: "ve"}
+	sealed, err := s.Seal(in)
+	require.NoError(t, err)
+
+	out, err := s.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "google", out.Provider)
+	assert.Equal(t, "ve", out.CodeVerifier)
+
+	t.Run("改ざん", func(t *testing.T) {
+		encoded, sig, _ := strings.Cut(sealed, ".")
+		_, err := s.Open(encoded + "x." + sig)
+		assert.True(t, errors.Is(err, ErrInvalidState))
+	})
+	t.Run("別の鍵", func(t *testing.T) {
+		_, err := NewStateSealer([]byte("other"), 10*time.Minute).WithClock(clock).Open(sealed)
+		assert.True(t, errors.Is(err, ErrInvalidState))
+	})
+	t.Run("期限切れ", func(t *testing.T) {
+		later := s.WithClock(func() time.Time { return now.Add(10 * time.Minute) })
+		_, err := later.Open(sealed)
+		assert.True(t, errors.Is(err, ErrExpiredState))
+	})
+	t.Run("空", func(t *testing.T) {
+		_, err := s.Open("")
+		assert.True(t, errors.Is(err, ErrInvalidState))
+	})
+	t.Run("鍵が未設定", func(t *testing.T) {
+		_, err := NewStateSealer(nil, time.Minute).Seal(in)
+		assert.True(t, errors.Is(err, ErrNoSecret))
+	})
+}
+
+func TestConfigsFromEnv(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "google, line")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "gid")
+	t.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "gsecret")
+	t.Setenv("OIDC_LINE_ISSUER", "https://access.line.me")
+	t.Setenv("OIDC_LINE_CLIENT_ID", "lid")
+	t.Setenv("OIDC_LINE_CLIENT_SECRET", "lsecret")
+	t.Setenv("OIDC_LINE_REDIRECT_URL", "https://api.example.com/api/auth/oidc/line/callback")
+	t.Setenv("OIDC_LINE_SCOPES", "openid profile")
+
+	configs, err := ConfigsFromEnv()
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "google", configs[0].Name)
+	assert.Equal(t, "http://localhost:8080/api/auth/oidc/google/callback", configs[0].RedirectURL)
+	assert.Equal(t, []string{"openid", "profile"}, configs[1].Scopes)
+
+	t.Setenv("OIDC_LINE_CLIENT_SECRET", "")
+	_, err = ConfigsFromEnv()
+	assert.ErrorContains(t, err, "OIDC_LINE_")
+
+	t.Setenv("OIDC_PROVIDERS", "")
+	configs, err = ConfigsFromEnv()
+	assert.NoError(t, err)
+	assert.Empty(t, configs)
+}
@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	// StatusRefunding は決済サービスに返金を依頼し、結果を待っている状態
+	StatusRefunding = "refunding"
+	StatusRefunded  = "refunded"
+)
+
+// ErrDeclined は決済サービスが支払いを拒否したことを表す。
+// それ以外のエラーは通信障害などの想定外エラーとして扱う。
+var ErrDeclined = errors.New("payment declined")
+
+type ChargeRequest struct {
+	OrderID int64
+	Amount  int64
+	Method  string
+	// IdempotencyKey が同じ請求は決済サービス側で1回として扱われ、2回目以降は最初の結果が返る。
+	// 通信障害などで結果が分からなかった請求を二重に課金せずに問い合わせ直すために使う
+	IdempotencyKey string
+}
+
+type ChargeResult struct {
+	TransactionID string
+}
+
+// RefundRequest は課金済みの TransactionID の全額を返金する。
+// IdempotencyKey の扱いは ChargeRequest と同じ
+type RefundRequest struct {
+	TransactionID  string
+	Amount         int64
+	IdempotencyKey string
+}
+
+type PaymentProvider interface {
+	Charge(ctx context.Context, req ChargeRequest) (ChargeResult, error)
+	Refund(ctx context.Context, req RefundRequest) error
+}
+
+// FakeDeclineMethod を指定すると FakeProvider は必ず決済を拒否する。
+const FakeDeclineMethod = "fake_decline"
+
+// FakeProvider は外部決済サービスの代わりに使うローカル実装。
+// IdempotencyKey が同じ請求には同じ TransactionID を返す。
+type FakeProvider struct{}
+
+func (FakeProvider) Charge(ctx context.Context, req ChargeRequest) (ChargeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ChargeResult{}, err
+	}
+	if req.Method == FakeDeclineMethod || req.Amount <= 0 {
+		return ChargeResult{}, ErrDeclined
+	}
+
+	if req.IdempotencyKey != "" {
+		sum := sha256.Sum256([]byte(req.IdempotencyKey))
+		return ChargeResult{TransactionID: "fake_" + hex.EncodeToString(sum[:12])}, nil
+	}
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return ChargeResult{}, err
+	}
+	return ChargeResult{TransactionID: "fake_" + hex.EncodeToString(raw)}, nil
+}
+
+func (FakeProvider) Refund(ctx context.Context, req RefundRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if req.TransactionID == "" {
+		return errors.New("payment: refund requires a transaction id")
+	}
+	return nil
+}
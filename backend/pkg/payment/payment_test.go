@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFakeProviderCharge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		req     ChargeRequest
+		wantErr error
+	}{
+		{
+			name: "正常系: 決済成功",
+			req:  ChargeRequest{OrderID: 1, Amount: 1500, Method: "card"},
+		},
+		{
+			name:    "異常系: 拒否用メソッドは ErrDeclined",
+			req:     ChargeRequest{OrderID: 1, Amount: 1500, Method: FakeDeclineMethod},
+			wantErr: ErrDeclined,
+		},
+		{
+			name:    "異常系: 金額0は ErrDeclined",
+			req:     ChargeRequest{OrderID: 1, Amount: 0, Method: "card"},
+			wantErr: ErrDeclined,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := FakeProvider{}.Charge(context.Background(), tt.req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(res.TransactionID, "fake_") {
+				t.Fatalf("TransactionID = %q, want prefix fake_", res.TransactionID)
+			}
+		})
+	}
+}
+
+func TestFakeProviderChargeIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req := ChargeRequest{OrderID: 1, Amount: 1500, Method: "card", IdempotencyKey: "payment-10-1"}
+
+	first, err := FakeProvider{}.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := FakeProvider{}.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.TransactionID != again.TransactionID {
+		t.Fatalf("same key returned %q and %q", first.TransactionID, again.TransactionID)
+	}
+
+	req.IdempotencyKey = "payment-10-2"
+	other, err := FakeProvider{}.Charge(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other.TransactionID == first.TransactionID {
+		t.Fatalf("different keys returned the same TransactionID %q", other.TransactionID)
+	}
+}
+
+func TestFakeProviderRefund(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	if err := (FakeProvider{}).Refund(ctx, RefundRequest{TransactionID: "fake_1", Amount: 1500, IdempotencyKey: "refund-10"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (FakeProvider{}).Refund(ctx, RefundRequest{Amount: 1500}); err == nil {
+		t.Fatal("refund without transaction id succeeded")
+	}
+}
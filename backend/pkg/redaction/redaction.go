@@ -13,7 +13,7 @@ func RedactAttr(_ []string, attr slog.Attr) slog.Attr {
 	}
 
 	switch strings.ToLower(attr.Key) {
-	case "password", "token", "access_token", "refresh_token", "authorization":
+	case "password", "token", "access_token", "refresh_token", "authorization", "api_key":
 		return slog.String(attr.Key, Redacted)
 	case "email":
 		return slog.String(attr.Key, MaskEmail(attr.Value.String()))
@@ -38,6 +38,12 @@ func TestRedactAttr(t *testing.T) {
 			in:   "refresh-token-value",
 			want: "[REDACTED]",
 		},
+		{
+			name: "api_keyはREDACTEDに置換される",
+			key:  "api_key",
+			in:   "sol_0123456789abcdef",
+			want: "[REDACTED]",
+		},
 		{
 			name: "authorizationはREDACTEDに置換される",
 			key:  "authorization",
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 Appendix B の SHA1 のテストベクタ(8桁の下6桁)
+func TestCodeAt_RFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(secret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "unix=%d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	current := Step(now)
+
+	prev, err := CodeAt(secret, current-1)
+	require.NoError(t, err)
+	step, ok, err := Validate(secret, prev, now, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, current-1, step)
+
+	// 許容範囲外のステップは通さない
+	old, err := CodeAt(secret, current-2)
+	require.NoError(t, err)
+	_, ok, err = Validate(secret, old, now, 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = Validate(secret, "12345", now, 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = Validate("not base32!", "123456", now, 1)
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("sol coffee", "admin@example.com", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/sol coffee:admin@example.com", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "sol coffee", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
@@ -0,0 +1,157 @@
+// Package txn は handler から使うトランザクションヘルパーを提供する。
+// BeginTx / WithTx / Rollback / Commit をまとめ、分離レベルの指定と
+// デッドロック・直列化失敗時の再試行をオプションで選べるようにする。
+package txn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"sol_coffeesys/backend/db"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const defaultBackoff = 20 * time.Millisecond
+
+// ErrRetryExhausted は再試行しても競合が解消しなかったことを表す。
+// 最後に発生した DB エラーと errors.Join で結合して返す。
+var ErrRetryExhausted = errors.New("transaction retry exhausted")
+
+// Runner はトランザクション内で fn を実行する。
+// handler はこのインターフェースに依存させ、ユニットテストでは testutil.TxRunner に差し替える。
+type Runner interface {
+	RunInTx(ctx context.Context, fn func(q db.Querier) error, opts ...Option) error
+}
+
+type options struct {
+	txOpts      sql.TxOptions
+	maxAttempts int
+	backoff     time.Duration
+	onRetry     func(ctx context.Context, attempt int, err error)
+}
+
+type Option func(*options)
+
+// WithIsolation はトランザクションの分離レベルを指定する。既定は DB の設定(READ COMMITTED)。
+func WithIsolation(level sql.IsolationLevel) Option {
+	return func(o *options) {
+		o.txOpts.Isolation = level
+	}
+}
+
+// ReadOnly は読み取り専用トランザクションにする。
+func ReadOnly() Option {
+	return func(o *options) {
+		o.txOpts.ReadOnly = true
+	}
+}
+
+// WithRetry はデッドロック(40P01)・直列化失敗(40001)時に最大 maxAttempts 回まで全体をやり直す。
+// 既定は 1 回(再試行しない)。
+func WithRetry(maxAttempts int) Option {
+	return func(o *options) {
+		o.maxAttempts = max(maxAttempts, 1)
+	}
+}
+
+// WithBackoff は再試行間隔の基準値を指定する。実際の待ち時間は試行ごとに倍になり、ジッタが加わる。
+func WithBackoff(base time.Duration) Option {
+	return func(o *options) {
+		o.backoff = base
+	}
+}
+
+// OnRetry は再試行の直前に呼ばれるフックを登録する。attempt は次に行う試行の番号(2 始まり)。
+func OnRetry(hook func(ctx context.Context, attempt int, err error)) Option {
+	return func(o *options) {
+		o.onRetry = hook
+	}
+}
+
+// SQLRunner は *sql.DB を使う Runner の実装。
+type SQLRunner struct {
+	conn    *sql.DB
+	queries *db.Queries
+}
+
+func New(conn *sql.DB) *SQLRunner {
+	return &SQLRunner{conn: conn, queries: db.New(conn)}
+}
+
+// RunInTx は conn 上でトランザクションを開始して fn を実行する。
+// fn がエラーを返した場合はロールバックし、そのエラーをそのまま返す。
+func RunInTx(ctx context.Context, conn *sql.DB, fn func(q db.Querier) error, opts ...Option) error {
+	return New(conn).RunInTx(ctx, fn, opts...)
+}
+
+func (r *SQLRunner) RunInTx(ctx context.Context, fn func(q db.Querier) error, opts ...Option) error {
+	o := options{maxAttempts: 1, backoff: defaultBackoff}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= o.maxAttempts; attempt++ {
+		if attempt > 1 {
+			if o.onRetry != nil {
+				o.onRetry(ctx, attempt, lastErr)
+			}
+			if err := sleepBackoff(ctx, o.backoff, attempt-1); err != nil {
+				return err
+			}
+		}
+
+		lastErr = r.runOnce(ctx, fn, &o.txOpts)
+		if lastErr == nil || !IsRetryable(lastErr) {
+			return lastErr
+		}
+	}
+	if o.maxAttempts == 1 {
+		return lastErr
+	}
+	return errors.Join(ErrRetryExhausted, lastErr)
+}
+
+func (r *SQLRunner) runOnce(ctx context.Context, fn func(q db.Querier) error, txOpts *sql.TxOptions) error {
+	tx, err := r.conn.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	if err := fn(r.queries.WithTx(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsRetryable はデッドロック(40P01)・直列化失敗(40001)かどうかを判定する
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40P01" || pqErr.Code == "40001"
+}
+
+// sleepBackoff は base * 2^(n-1) にジッタを加えた時間だけ待つ
+func sleepBackoff(ctx context.Context, base time.Duration, n int) error {
+	if base <= 0 {
+		return ctx.Err()
+	}
+	d := base << (n - 1)
+	d += rand.N(d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
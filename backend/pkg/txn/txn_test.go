@@ -0,0 +1,204 @@
+package txn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sol_coffeesys/backend/db"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "デッドロック", err: &pq.Error{Code: "40P01"}, want: true},
+		{name: "直列化失敗", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "ラップされたデッドロック", err: errors.Join(errors.New("wrap"), &pq.Error{Code: "40P01"}), want: true},
+		{name: "一意制約違反", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "pq 以外のエラー", err: errors.New("db error"), want: false},
+		{name: "nil", err: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestRunInTx(t *testing.T) {
+	deadlock := &pq.Error{Code: "40P01"}
+
+	tests := []struct {
+		name        string
+		opts        []Option
+		setupMock   func(sqlmock.Sqlmock)
+		results     []error
+		wantCalls   int
+		wantRetries []int
+		checkErr    func(*testing.T, error)
+	}{
+		{
+			name: "成功時は1回でコミット",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectCommit()
+			},
+			results:   []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name: "fn のエラーはロールバックしてそのまま返す",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectRollback()
+			},
+			results:   []error{sql.ErrNoRows},
+			wantCalls: 1,
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, sql.ErrNoRows)
+				assert.NotErrorIs(t, err, ErrRetryExhausted)
+			},
+		},
+		{
+			name: "既定では再試行しない",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectRollback()
+			},
+			results:   []error{deadlock},
+			wantCalls: 1,
+			checkErr: func(t *testing.T, err error) {
+				assert.True(t, IsRetryable(err))
+				assert.NotErrorIs(t, err, ErrRetryExhausted)
+			},
+		},
+		{
+			name: "デッドロック後に再試行して成功",
+			opts: []Option{WithRetry(3), WithBackoff(0)},
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectRollback()
+				m.ExpectBegin()
+				m.ExpectCommit()
+			},
+			results:     []error{deadlock, nil},
+			wantCalls:   2,
+			wantRetries: []int{2},
+		},
+		{
+			name: "コミット時の直列化失敗も再試行する",
+			opts: []Option{WithRetry(3), WithBackoff(0)},
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectCommit().WillReturnError(&pq.Error{Code: "40001"})
+				m.ExpectBegin()
+				m.ExpectCommit()
+			},
+			results:     []error{nil, nil},
+			wantCalls:   2,
+			wantRetries: []int{2},
+		},
+		{
+			name: "再試行上限に達したら ErrRetryExhausted",
+			opts: []Option{WithRetry(3), WithBackoff(time.Millisecond)},
+			setupMock: func(m sqlmock.Sqlmock) {
+				for range 3 {
+					m.ExpectBegin()
+					m.ExpectRollback()
+				}
+			},
+			results:     []error{deadlock, deadlock, deadlock},
+			wantCalls:   3,
+			wantRetries: []int{2, 3},
+			checkErr: func(t *testing.T, err error) {
+				assert.ErrorIs(t, err, ErrRetryExhausted)
+				assert.True(t, IsRetryable(err))
+			},
+		},
+		{
+			name: "再試行対象外のエラーは再試行しない",
+			opts: []Option{WithRetry(3), WithBackoff(0)},
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectRollback()
+			},
+			results:   []error{&pq.Error{Code: "23505"}},
+			wantCalls: 1,
+			checkErr: func(t *testing.T, err error) {
+				assert.NotErrorIs(t, err, ErrRetryExhausted)
+			},
+		},
+		{
+			name: "BeginTx の失敗はそのまま返す",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin().WillReturnError(errors.New("begin failed"))
+			},
+			wantCalls: 0,
+			checkErr: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "begin failed")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, m, err := sqlmock.New()
+			require.NoError(t, err)
+			defer conn.Close()
+			tt.setupMock(m)
+
+			var retries []int
+			opts := append(tt.opts, OnRetry(func(ctx context.Context, attempt int, err error) {
+				assert.True(t, IsRetryable(err))
+				retries = append(retries, attempt)
+			}))
+
+			calls := 0
+			err = RunInTx(context.Background(), conn, func(q db.Querier) error {
+				assert.NotNil(t, q)
+				res := tt.results[calls]
+				calls++
+				return res
+			}, opts...)
+
+			if tt.checkErr != nil {
+				assert.Error(t, err)
+				tt.checkErr(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantRetries, retries)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRunInTx_ContextCancelled(t *testing.T) {
+	conn, m, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+	m.ExpectBegin()
+	m.ExpectRollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err = RunInTx(ctx, conn, func(q db.Querier) error {
+		calls++
+		cancel()
+		return &pq.Error{Code: "40P01"}
+	}, WithRetry(3))
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
@@ -75,7 +75,7 @@ func ValidateRole(role string) error {
 	if r == "" {
 		return ErrInvalidRole
 	}
-	if r != "admin" && r != "member" {
+	if r != "admin" && r != "member" && r != "staff" {
 		return ErrInvalidRole
 	}
 	return nil
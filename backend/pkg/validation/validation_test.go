@@ -132,6 +132,11 @@ func TestValidateRole(t *testing.T) {
 			role:    "member",
 			wantErr: nil,
 		},
+		{
+			name:    "正常系：staff",
+			role:    "staff",
+			wantErr: nil,
+		},
 		{
 			name:    "異常系：user(不正な値)",
 			role:    "user",
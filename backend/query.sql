@@ -10,6 +10,112 @@ SELECT
 FROM products
 ORDER BY id;
 
+-- name: SearchProductsByID :many
+-- 絞り込みをしたうえで id の昇順に keyset ページングする。cursor_id が NULL なら先頭ページ
+-- 並び順ごとにクエリを分け、(並び替えキー, id) の複合インデックスを使えるようにしている
+-- keyword は LIKE のワイルドカードをエスケープ済みであること
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR id > sqlc.narg(cursor_id)::bigint)
+ORDER BY id ASC
+LIMIT @page_limit;
+
+-- name: SearchProductsByPriceAsc :many
+-- SearchProductsByID と同じ条件で価格の安い順に並べる
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR (price, id) > (sqlc.narg(cursor_price)::integer, sqlc.narg(cursor_id)::bigint))
+ORDER BY price ASC, id ASC
+LIMIT @page_limit;
+
+-- name: SearchProductsByPriceDesc :many
+-- SearchProductsByID と同じ条件で価格の高い順に並べる
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR (price, id) < (sqlc.narg(cursor_price)::integer, sqlc.narg(cursor_id)::bigint))
+ORDER BY price DESC, id DESC
+LIMIT @page_limit;
+
+-- name: SearchProductsByName :many
+-- SearchProductsByID と同じ条件で名前順に並べる
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR (name, id) > (sqlc.narg(cursor_name)::text, sqlc.narg(cursor_id)::bigint))
+ORDER BY name ASC, id ASC
+LIMIT @page_limit;
+
+-- name: SearchProductsByNewest :many
+-- SearchProductsByID と同じ条件で新しい順に並べる
+SELECT
+    id, name, price, is_available, category_id, sku, description, image_url, stock_quantity, created_at, updated_at
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR (created_at, id) < (sqlc.narg(cursor_created_at)::timestamptz, sqlc.narg(cursor_id)::bigint))
+ORDER BY created_at DESC, id DESC
+LIMIT @page_limit;
+
+-- name: CountProducts :one
+-- SearchProductsBy* と同じ条件での総件数
+SELECT COUNT(*)
+FROM products
+WHERE (sqlc.narg(category_id)::bigint IS NULL OR category_id = sqlc.narg(category_id)::bigint)
+  AND (sqlc.narg(min_price)::integer IS NULL OR price >= sqlc.narg(min_price)::integer)
+  AND (sqlc.narg(max_price)::integer IS NULL OR price <= sqlc.narg(max_price)::integer)
+  AND (sqlc.narg(is_available)::boolean IS NULL OR is_available = sqlc.narg(is_available)::boolean)
+  AND (NOT @in_stock::boolean OR stock_quantity > 0)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || COALESCE(description, '') || ' ' || sku) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  );
+
 -- name: CreateProduct :one
 INSERT INTO products (
     name, price, is_available, category_id, sku, description, image_url, stock_quantity
@@ -39,9 +145,9 @@ WHERE id = $1;
 
 -- name: CreateUser :one
 INSERT INTO users (
-    name, email, password_hash, role
+    name, email, password_hash, role, status
 ) VALUES (
-    $1, $2, $3, $4
+    $1, $2, $3, $4, $5
 )
 RETURNING *;
 
@@ -49,6 +155,13 @@ RETURNING *;
 SELECT * FROM users 
 WHERE email = $1 LIMIT 1;
 
+-- name: ListUsersByEmailFold :many
+-- 大文字小文字を区別せずにメールアドレスで引く。登録時にアドレスを正規化していないので、
+-- 大文字小文字だけが違うアドレスの会員が複数見つかることがある
+SELECT * FROM users
+WHERE LOWER(email) = LOWER(@email)
+ORDER BY id;
+
 -- name: GetUserForUpdate :one
 SELECT * FROM users
 WHERE id = $1 LIMIT 1;
@@ -96,13 +209,82 @@ SET role = @role,
 WHERE id = @id
 RETURNING *;
 
+-- name: UpdateUserStatus :one
+UPDATE users
+SET status = @status,
+    updated_at = NOW()
+WHERE id = @id
+RETURNING *;
+
+-- name: UpdateUserLastLogin :exec
+UPDATE users
+SET last_login_at = NOW()
+WHERE id = $1;
+
+-- name: SearchUsers :many
+-- 管理画面用。password_hash / reset_token は取得しない
+-- keyword は LIKE のワイルドカードをエスケープ済みであること。cursor_id が NULL なら先頭ページ
+SELECT id, name, email, role, status, created_at, updated_at, last_login_at
+FROM users
+WHERE (sqlc.narg(role)::text IS NULL OR role = sqlc.narg(role)::text)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status)::text)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || email) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  )
+  AND (sqlc.narg(cursor_id)::bigint IS NULL OR id > sqlc.narg(cursor_id)::bigint)
+ORDER BY id
+LIMIT @page_limit;
+
+-- name: CountUsers :one
+-- SearchUsers と同じ条件での総件数
+SELECT COUNT(*)
+FROM users
+WHERE (sqlc.narg(role)::text IS NULL OR role = sqlc.narg(role)::text)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status)::text)
+  AND (
+    sqlc.narg(keyword)::text IS NULL
+    OR (name || ' ' || email) ILIKE '%' || sqlc.narg(keyword)::text || '%'
+  );
+
 -- name: SetResetToken :one
 UPDATE users
 SET reset_token = @reset_token,
+    reset_token_expires_at = @reset_token_expires_at,
+    updated_at = NOW()
+WHERE id = @id
+RETURNING *;
+
+-- name: UpdateUserProfile :one
+-- NULL の項目は変更しない。有効な会員がメールアドレスを変えたら、新しいアドレスを確認するまで unverified に戻す
+UPDATE users
+SET name = COALESCE(sqlc.narg(name), name),
+    email = COALESCE(sqlc.narg(email), email),
+    status = CASE
+        WHEN status = 'active' AND LOWER(sqlc.narg(email)) <> LOWER(email) THEN 'unverified'
+        ELSE status
+    END,
     updated_at = NOW()
 WHERE id = @id
 RETURNING *;
 
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET password_hash = @password_hash,
+    updated_at = NOW()
+WHERE id = @id;
+
+-- name: ResetPasswordByToken :one
+-- 有効期限内のトークンに限りパスワードを更新し、同時にトークンを消費する
+UPDATE users
+SET password_hash = @password_hash,
+    reset_token = NULL,
+    reset_token_expires_at = NULL,
+    updated_at = NOW()
+WHERE reset_token = @reset_token::text
+  AND reset_token_expires_at > NOW()
+RETURNING id;
+
 -- name: CreateCart :one
  INSERT INTO carts (user_id, created_at, updated_at)
  VALUES($1, NOW(), NOW())
@@ -240,11 +422,20 @@ INSERT INTO order_items (
 RETURNING id, order_id, product_id, quantity, unit_price, product_name_snapshot, created_at, updated_at;
 
 -- name: ListOrdersByUser :many
+-- (created_at, id) の降順で keyset ページングする。cursor_* が NULL なら先頭ページ
 SELECT
     id, user_id, total, status, created_at, updated_at
 FROM orders
-WHERE user_id = $1
-ORDER BY created_at DESC;
+WHERE user_id = @user_id
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status)::text)
+  AND (sqlc.narg(created_from)::timestamptz IS NULL OR created_at >= sqlc.narg(created_from)::timestamptz)
+  AND (sqlc.narg(created_to)::timestamptz IS NULL OR created_at < sqlc.narg(created_to)::timestamptz)
+  AND (
+    sqlc.narg(cursor_created_at)::timestamptz IS NULL
+    OR (created_at, id) < (sqlc.narg(cursor_created_at)::timestamptz, sqlc.narg(cursor_id)::bigint)
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT @page_limit;
 
 -- name: GetOrderByID :one
 SELECT
@@ -268,6 +459,14 @@ FROM order_items
 WHERE order_id = $1
 ORDER BY id;
 
+-- name: ListOrderItemsByOrderIDs :many
+-- 注文一覧の明細を1回で取得する (N+1回避)
+SELECT
+    id, order_id, product_id, quantity, unit_price, product_name_snapshot, created_at, updated_at
+FROM order_items
+WHERE order_id = ANY(@order_ids::bigint[])
+ORDER BY order_id, id;
+
 -- name: UpdateOrderStatus :one
 UPDATE orders
 SET
@@ -282,12 +481,12 @@ FROM orders
 WHERE user_id = $1;
 
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (user_id, token_hash, expires_at, revoked_at, created_at, updated_at)
-VALUES ($1, $2, $3, NULL, NOW(), NOW())
-RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at;
+INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, user_agent, ip_address, revoked_at, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, NULL, NOW(), NOW())
+RETURNING id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason;
 
 -- name: GetRefreshTokenByHash :one
-SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, updated_at, family_id, user_agent, ip_address, revoke_reason
 FROM refresh_tokens
 WHERE token_hash = $1
 LIMIT 1;
@@ -300,10 +499,336 @@ SET
 WHERE token_hash = $1
 AND revoked_at IS NULL;
 
+-- name: ConsumeRefreshToken :execrows
+-- rotate で提示されたトークンを失効させ、失効理由を 'rotated' にする。同じトークンが並行して使われたら後の方は 0 件になる
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    revoke_reason = 'rotated',
+    updated_at = NOW()
+WHERE token_hash = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+-- 再利用を検知したファミリーの有効なトークンを全て失効させる
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: ListActiveSessionsByUser :many
+-- 有効なリフレッシュトークン1件を1セッションとして返す。
+-- rotate のたびに行が作られるため、created_at は最終利用日時、ファミリー最古の created_at がログイン日時になる
+SELECT
+    rt.id,
+    rt.family_id,
+    rt.user_agent,
+    rt.ip_address,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id)::timestamptz AS signed_in_at,
+    rt.created_at AS last_used_at,
+    rt.expires_at
+FROM refresh_tokens rt
+WHERE rt.user_id = $1
+AND rt.revoked_at IS NULL
+AND rt.expires_at > NOW()
+ORDER BY rt.created_at DESC, rt.id DESC;
+
+-- name: RevokeSessionByUser :execrows
+-- 指定したトークンが属するファミリーを失効させる。他人のトークンなら0件
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = (
+    SELECT s.family_id FROM refresh_tokens s
+    WHERE s.id = $1 AND s.user_id = $2
+)
+AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessionsByUser :exec
+-- 操作中のセッション(ファミリー)以外を全て失効させる
+UPDATE refresh_tokens
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND family_id <> $2
+AND revoked_at IS NULL;
+
+-- name: CountActiveRefreshTokensByUser :one
+SELECT COUNT(*)
+FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW();
+
 -- name: RevokeAllRefreshTokensByUser :exec
 UPDATE refresh_tokens
 SET 
     revoked_at = NOW(),
     updated_at = NOW()
 WHERE user_id = $1
-AND revoked_at IS NULL;
\ No newline at end of file
+AND revoked_at IS NULL;
+
+-- name: CreatePayment :one
+INSERT INTO payments (
+    order_id, amount, status, payment_method, created_at, updated_at
+) VALUES (
+    $1, $2, $3, $4, NOW(), NOW()
+)
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt;
+
+-- name: GetPaymentByOrderID :one
+SELECT
+    id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt
+FROM payments
+WHERE order_id = $1
+LIMIT 1;
+
+-- name: UpdatePaymentStatus :one
+UPDATE payments
+SET
+    status = $2,
+    payment_method = $3,
+    external_transaction_id = $4,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt;
+
+-- name: RetryPayment :one
+-- 失敗した決済をやり直す。決済サービスへの冪等キーを変えるため attempt を進める
+UPDATE payments
+SET
+    status = 'pending',
+    attempt = attempt + 1,
+    payment_method = $2,
+    external_transaction_id = NULL,
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'failed'
+RETURNING id, order_id, amount, status, payment_method, external_transaction_id, created_at, updated_at, attempt;
+
+-- name: CreateIdempotencyKey :one
+-- 既にキーが存在する場合は行を返さない (sql.ErrNoRows)
+INSERT INTO idempotency_keys (
+    user_id, idempotency_key, request_method, request_path, request_hash, expires_at, created_at, updated_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, NOW(), NOW()
+)
+ON CONFLICT (user_id, idempotency_key) DO NOTHING
+RETURNING id, user_id, idempotency_key, request_method, request_path, request_hash, response_status, response_body, expires_at, created_at, updated_at;
+
+-- name: GetIdempotencyKey :one
+SELECT id, user_id, idempotency_key, request_method, request_path, request_hash, response_status, response_body, expires_at, created_at, updated_at
+FROM idempotency_keys
+WHERE user_id = $1
+AND idempotency_key = $2
+LIMIT 1;
+
+-- name: SaveIdempotencyResponse :exec
+UPDATE idempotency_keys
+SET
+    response_status = $2,
+    response_body = $3,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE id = $1;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+-- 期限切れのキーをまとめて消す。期限内に再送されなかったキーはここでしか消えない
+DELETE FROM idempotency_keys
+WHERE expires_at < @now;
+
+-- name: GetLoginThrottle :one
+SELECT throttle_key, failures, window_started_at, locked_until, updated_at
+FROM login_throttles
+WHERE throttle_key = $1;
+
+-- name: RecordLoginFailure :one
+-- window_start より前に始まった集計期間は破棄し、1 から数え直す
+INSERT INTO login_throttles (throttle_key, failures, window_started_at, updated_at)
+VALUES (@throttle_key, 1, NOW(), NOW())
+ON CONFLICT (throttle_key) DO UPDATE
+SET
+    failures = CASE WHEN login_throttles.window_started_at < @window_start THEN 1 ELSE login_throttles.failures + 1 END,
+    window_started_at = CASE WHEN login_throttles.window_started_at < @window_start THEN NOW() ELSE login_throttles.window_started_at END,
+    updated_at = NOW()
+RETURNING failures;
+
+-- name: LockLoginThrottle :exec
+-- ロック解除後は失敗回数を数え直す
+UPDATE login_throttles
+SET
+    failures = 0,
+    window_started_at = NOW(),
+    locked_until = $2,
+    updated_at = NOW()
+WHERE throttle_key = $1;
+
+-- name: ResetLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE throttle_key = $1;
+
+-- name: DeleteStaleLoginThrottles :execrows
+-- 失敗のたびに updated_at が進むので、stale_before より古い行は集計期間が終わっている。ロック中の行は残す
+DELETE FROM login_throttles
+WHERE updated_at < @stale_before
+  AND (locked_until IS NULL OR locked_until < NOW());
+
+-- name: MarkUserEmailVerified :one
+-- 確認待ちのときだけ有効化する。停止中などのアカウントは確認リンクで復帰させない
+UPDATE users
+SET status = 'active',
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'unverified'
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT user_id, secret, enabled_at, last_used_step, created_at, updated_at
+FROM user_totp
+WHERE user_id = $1;
+
+-- name: UpsertPendingUserTOTP :one
+-- 有効化済みのシークレットは上書きしない(その場合は 0 件で sql.ErrNoRows になる)
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET
+    secret = EXCLUDED.secret,
+    last_used_step = 0,
+    updated_at = NOW()
+WHERE user_totp.enabled_at IS NULL
+RETURNING user_id, secret, enabled_at, last_used_step, created_at, updated_at;
+
+-- name: EnableUserTOTP :execrows
+UPDATE user_totp
+SET
+    enabled_at = NOW(),
+    last_used_step = $2,
+    updated_at = NOW()
+WHERE user_id = $1
+AND enabled_at IS NULL;
+
+-- name: ConsumeTOTPStep :execrows
+-- 受け付けたタイムステップより古い(同じ)コードは 0 件になり、再利用を防ぐ
+UPDATE user_totp
+SET
+    last_used_step = $2,
+    updated_at = NOW()
+WHERE user_id = $1
+AND enabled_at IS NOT NULL
+AND last_used_step < $2;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO user_recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- name: DeleteRecoveryCodesByUser :exec
+DELETE FROM user_recovery_codes
+WHERE user_id = $1;
+
+-- name: ConsumeRecoveryCode :execrows
+UPDATE user_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*)
+FROM user_recovery_codes
+WHERE user_id = $1
+AND used_at IS NULL;
+
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at, updated_at;
+
+-- name: ListAPIKeysByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at, updated_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC;
+
+-- name: RevokeAPIKeyByUser :execrows
+-- 他人のキー・失効済みのキーは0件
+UPDATE api_keys
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: GetActiveAPIKeyByHash :one
+-- 失効済み・期限切れのキーは sql.ErrNoRows。持ち主のロールとアカウント状態も合わせて返す
+SELECT
+    k.id,
+    k.user_id,
+    k.scopes,
+    u.role,
+    u.status
+FROM api_keys k
+JOIN users u ON u.id = k.user_id
+WHERE k.key_hash = $1
+AND k.revoked_at IS NULL
+AND (k.expires_at IS NULL OR k.expires_at > NOW());
+
+-- name: TouchAPIKeyLastUsed :exec
+-- 毎リクエストの書き込みを避けるため、最終利用日時は1分単位でしか更新しない
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- name: GetUserIdentity :one
+SELECT id, user_id, provider, subject, email, created_at, last_login_at
+FROM user_identities
+WHERE provider = $1
+AND subject = $2;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, NOW())
+RETURNING id, user_id, provider, subject, email, created_at, last_login_at;
+
+-- name: TouchUserIdentityLogin :exec
+-- IdP 側でメールアドレスが変わっていれば控えも更新する
+UPDATE user_identities
+SET
+    email = $2,
+    last_login_at = NOW()
+WHERE id = $1;
+
+-- name: RevokeAllAPIKeysByUser :exec
+UPDATE api_keys
+SET
+    revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: SaveUserSuspension :exec
+-- 停止する直前の状態を残す。再開で DeleteUserSuspension が返す
+INSERT INTO user_suspensions (user_id, previous_status, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET
+    previous_status = EXCLUDED.previous_status,
+    created_at = NOW();
+
+-- name: DeleteUserSuspension :one
+DELETE FROM user_suspensions
+WHERE user_id = $1
+RETURNING previous_status;
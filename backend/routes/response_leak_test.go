@@ -0,0 +1,226 @@
+package routes_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/routes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	leakPassword   = "password123"
+	leakResetToken = "leak-reset-token"
+)
+
+// レスポンスに出てはいけない JSON のキー
+var sensitiveKeys = []string{"password_hash", "reset_token", "reset_token_expires_at", "token_hash", "key_hash"}
+
+// leakQuerier はユーザーを返すクエリに機密項目を埋めた db.User を返す。
+// 実装していないクエリは panic し、Recovery で 500 になる
+type leakQuerier struct {
+	db.Querier
+	user db.User
+}
+
+// userWithID は ID だけ差し替えたユーザーを返す。ログイン中の管理者以外は一般会員として返す
+func (q *leakQuerier) userWithID(id int64) db.User {
+	u := q.user
+	if id != u.ID {
+		u.Role = auth.RoleMember
+	}
+	u.ID = id
+	return u
+}
+
+func (q *leakQuerier) GetUserForUpdate(ctx context.Context, id int64) (db.User, error) {
+	return q.userWithID(id), nil
+}
+func (q *leakQuerier) GetUserByID(ctx context.Context, id int64) (db.User, error) {
+	return q.userWithID(id), nil
+}
+func (q *leakQuerier) GetUserByEmail(ctx context.Context, email string) (db.User, error) {
+	return q.user, nil
+}
+func (q *leakQuerier) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error) {
+	return q.userWithID(2), nil
+}
+func (q *leakQuerier) UpdateUserRole(ctx context.Context, arg db.UpdateUserRoleParams) (db.User, error) {
+	return q.userWithID(arg.ID), nil
+}
+func (q *leakQuerier) UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error) {
+	return q.userWithID(arg.ID), nil
+}
+func (q *leakQuerier) UpdateUserLastLogin(ctx context.Context, id int64) error {
+	return nil
+}
+func (q *leakQuerier) SetResetToken(ctx context.Context, arg db.SetResetTokenParams) (db.User, error) {
+	return q.userWithID(arg.ID), nil
+}
+func (q *leakQuerier) SearchUsers(ctx context.Context, arg db.SearchUsersParams) ([]db.SearchUsersRow, error) {
+	u := q.user
+	return []db.SearchUsersRow{{ID: u.ID, Name: u.Name, Email: u.Email, Role: u.Role, Status: u.Status, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt}}, nil
+}
+func (q *leakQuerier) CountUsers(ctx context.Context, arg db.CountUsersParams) (int64, error) {
+	return 1, nil
+}
+func (q *leakQuerier) GetOrderCountByUser(ctx context.Context, userID int64) (int64, error) {
+	return 0, nil
+}
+func (q *leakQuerier) CountActiveRefreshTokensByUser(ctx context.Context, userID int64) (int64, error) {
+	return 1, nil
+}
+func (q *leakQuerier) CreateRefreshToken(ctx context.Context, arg db.CreateRefreshTokenParams) (db.RefreshToken, error) {
+	return db.RefreshToken{ID: 1, UserID: arg.UserID, TokenHash: arg.TokenHash, ExpiresAt: arg.ExpiresAt}, nil
+}
+func (q *leakQuerier) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (db.RefreshToken, error) {
+	return db.RefreshToken{ID: 1, UserID: q.user.ID, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+func (q *leakQuerier) RevokeRefreshTokenByHash(ctx context.Context, tokenHash string) error {
+	return nil
+}
+func (q *leakQuerier) GetLoginThrottle(ctx context.Context, throttleKey string) (db.LoginThrottle, error) {
+	return db.LoginThrottle{}, sql.ErrNoRows
+}
+func (q *leakQuerier) GetUserTOTP(ctx context.Context, userID int64) (db.UserTotp, error) {
+	return db.UserTotp{}, sql.ErrNoRows
+}
+func (q *leakQuerier) ResetLoginThrottle(ctx context.Context, throttleKey string) error {
+	return nil
+}
+func (q *leakQuerier) CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error) {
+	return db.ApiKey{ID: 1, UserID: arg.UserID, Name: arg.Name, Prefix: arg.Prefix, KeyHash: arg.KeyHash, Scopes: arg.Scopes, ExpiresAt: arg.ExpiresAt}, nil
+}
+func (q *leakQuerier) ListAPIKeysByUser(ctx context.Context, userID int64) ([]db.ApiKey, error) {
+	return []db.ApiKey{{ID: 1, UserID: userID, Name: "pos", Prefix: "sol_abcd1234", KeyHash: "leak-key-hash"}}, nil
+}
+
+// 全ルートに機密項目入りのユーザーを返す DB を繋ぎ、どのレスポンスにも機密項目が出ないことを確認する
+func TestAllRoutes_DoNotLeakSensitiveUserFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	// ログインのレスポンスも検査するため TOTP は未登録とし、管理者ルートの TOTP 必須ポリシーを外す
+	t.Setenv("ADMIN_MFA_REQUIRED", "false")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(leakPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+	now := time.Now()
+	q := &leakQuerier{user: db.User{
+		ID:                  1,
+		Name:                "管理者",
+		Email:               "admin@example.com",
+		PasswordHash:        string(hash),
+		Role:                "admin",
+		Status:              auth.UserStatusActive,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+		ResetToken:          sql.NullString{String: leakResetToken, Valid: true},
+		ResetTokenExpiresAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+	}}
+
+	// トランザクションを使うルートは BeginTx が失敗して 500 になる(期待を積んだ refresh だけは成功させる)
+	conn, sqlMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r := gin.New()
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	r.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	routes.SetupRoutes(r, conn, q)
+
+	accessToken, err := auth.DefaultTokenGenerator{}.GenerateToken(1, "admin", "")
+	require.NoError(t, err)
+	body, err := json.Marshal(map[string]any{
+		"name":             "新しい名前",
+		"email":            "new@example.com",
+		"password":         leakPassword,
+		"role":             "member",
+		"current_password": leakPassword,
+		"new_password":     "newpassword1",
+		"token":            leakResetToken,
+		"status":           "paid",
+		"product_id":       1,
+		"quantity":         1,
+		"scopes":           []string{auth.ScopeOrdersRead},
+	})
+	require.NoError(t, err)
+
+	// 機密項目を返しうるルートは実際に成功していること(テストが空振りしていないこと)
+	mustSucceed := map[string]bool{
+		"POST /api/register":        false,
+		"POST /api/login":           false,
+		"POST /api/refresh":         false,
+		"GET /api/me":               false,
+		"PATCH /api/me":             false,
+		"PATCH /api/users/:id/role": false,
+		"GET /api/admin/users":      false,
+		"GET /api/admin/users/:id":  false,
+		"POST /api/password/forgot": false,
+		"GET /api/me/api-keys":      false,
+		// 管理者アカウントにはキーを発行しないので、発行時のレスポンスは一般会員への発行で確認する
+		"POST /api/admin/users/:id/api-keys": false,
+	}
+
+	for _, route := range r.Routes() {
+		key := route.Method + " " + route.Path
+		t.Run(key, func(t *testing.T) {
+			path := strings.ReplaceAll(route.Path, ":id", "2")
+			req := httptest.NewRequest(route.Method, path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			req.Header.Set("Idempotency-Key", "leak-test-key")
+			req.AddCookie(&http.Cookie{Name: "refresh_token", Value: strings.Repeat("a", 64)})
+			if key == "POST /api/refresh" {
+				expectRefreshRotation(sqlMock, q.user.ID)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if _, ok := mustSucceed[key]; ok && w.Code < http.StatusBadRequest {
+				mustSucceed[key] = true
+			}
+
+			resp := w.Body.String()
+			for _, k := range sensitiveKeys {
+				assert.NotContains(t, resp, `"`+k+`"`, "status=%d", w.Code)
+			}
+			assert.NotContains(t, resp, q.user.PasswordHash)
+			assert.NotContains(t, resp, leakResetToken)
+			assert.NotContains(t, resp, "leak-key-hash")
+		})
+	}
+
+	for key, ok := range mustSucceed {
+		assert.True(t, ok, "%s did not succeed", key)
+	}
+}
+
+// expectRefreshRotation は refresh の rotate(旧トークンの失効と新トークンの発行)のトランザクションを成功させる
+func expectRefreshRotation(m sqlmock.Sqlmock, userID int64) {
+	now := time.Now()
+	m.ExpectBegin()
+	m.ExpectExec("UPDATE refresh_tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("INSERT INTO refresh_tokens").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "token_hash", "expires_at", "revoked_at", "created_at", "updated_at", "family_id", "user_agent", "ip_address", "revoke_reason"}).
+			AddRow(int64(2), userID, "new-hash", now.Add(time.Hour), nil, now, now, "", "", "", nil),
+	)
+	m.ExpectCommit()
+}
@@ -2,46 +2,195 @@ package routes
 
 import (
 	"database/sql"
+	"log/slog"
+	"os"
 	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/emailverify"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/mail"
+	"sol_coffeesys/backend/pkg/oidc"
+	"sol_coffeesys/backend/pkg/payment"
+	"sol_coffeesys/backend/pkg/txn"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, conn *sql.DB, queries *db.Queries) {
+const (
+	defaultPasswordResetURL = "http://localhost:3000/password/reset"
+	defaultEmailVerifyURL   = "http://localhost:3000/verify-email"
+	defaultOIDCSuccessURL   = "http://localhost:3000/"
+	defaultOIDCLoginURL     = "http://localhost:3000/login"
+)
+
+func SetupRoutes(r *gin.Engine, conn *sql.DB, queries db.Querier) {
+	r.GET("/.well-known/jwks.json", handler.JWKSHandler())
+
 	api := r.Group("/api")
+	// Cookie 認証の状態を変えるリクエストは X-CSRF-Token を要求する。
+	// アクセストークンの Cookie を使わない認証系のルートは除外する
+	api.Use(middleware.CSRF(middleware.CSRFOptions{Exempt: []string{
+		"/api/register",
+		"/api/login",
+		"/api/login/mfa",
+		"/api/password/forgot",
+		"/api/password/reset",
+		"/api/verify-email",
+		"/api/refresh",
+		"/api/refresh/revoke",
+		"/api/logout",
+	}}))
 	tokenGenerator := auth.DefaultTokenGenerator{}
+	paymentProvider := payment.FakeProvider{}
+	txRunner := txn.New(conn)
+	mailSender := mail.NewSenderFromEnv()
+	passwordResetURL := os.Getenv("PASSWORD_RESET_URL")
+	if passwordResetURL == "" {
+		passwordResetURL = defaultPasswordResetURL
+	}
+	emailVerifyURL := os.Getenv("EMAIL_VERIFY_URL")
+	if emailVerifyURL == "" {
+		emailVerifyURL = defaultEmailVerifyURL
+	}
+	emailVerifier := &handler.EmailVerifier{
+		Signer:    emailverify.NewSignerFromEnv(),
+		Sender:    mailSender,
+		VerifyURL: emailVerifyURL,
+	}
+	oidcLogin := &handler.OIDCLogin{
+		Providers:  map[string]*oidc.Provider{},
+		Sealer:     oidc.NewStateSealerFromEnv(),
+		SuccessURL: os.Getenv("OIDC_SUCCESS_URL"),
+		LoginURL:   os.Getenv("OIDC_LOGIN_URL"),
+	}
+	if oidcLogin.SuccessURL == "" {
+		oidcLogin.SuccessURL = defaultOIDCSuccessURL
+	}
+	if oidcLogin.LoginURL == "" {
+		oidcLogin.LoginURL = defaultOIDCLoginURL
+	}
+	// 設定に誤りがあっても起動は止めず、外部 IdP でのログインだけを無効にする
+	oidcConfigs, err := oidc.ConfigsFromEnv()
+	if err != nil {
+		slog.Error("oidc login disabled", "reason", "invalid configuration", "error", err)
+	}
+	for _, cfg := range oidcConfigs {
+		oidcLogin.Providers[cfg.Name] = oidc.NewProvider(cfg, nil)
+	}
+	// メールアドレス未確認の会員に注文を許すか。ALLOW_UNVERIFIED_ORDERS=false で確認済みの会員だけに絞る。
+	// フロントエンドに確認リンクの受け口と再送の画面ができるまでは、既定で未確認の会員にも許す
+	verifiedForOrders := func(c *gin.Context) { c.Next() }
+	if os.Getenv("ALLOW_UNVERIFIED_ORDERS") == "false" {
+		verifiedForOrders = auth.RequireVerifiedEmail(queries)
+	}
+	loginGuard := loginguard.New(loginguard.NewPostgresStore(queries), loginguard.DefaultOptions())
+	// 認証まわりは総当たり・大量登録を防ぐため IP 単位で厳しく絞る
+	authLimit := middleware.RateLimit(middleware.RateLimitPolicy{Name: "auth", Limit: 10, Window: time.Minute})
+	// トークンの更新は複数タブから並行して走るので、ログイン等の枠を食い潰さないよう別のバケットにする
+	refreshLimit := middleware.RateLimit(middleware.RateLimitPolicy{Name: "refresh", Limit: 60, Window: time.Minute})
+	// 商品・カテゴリの閲覧は一覧画面の再読み込みを妨げない程度に緩く
+	catalogLimit := middleware.RateLimit(middleware.RateLimitPolicy{Name: "catalog", Limit: 120, Window: time.Minute})
+	// 注文・決済の作成はユーザー単位
+	orderLimit := middleware.RateLimit(middleware.RateLimitPolicy{Name: "order", Limit: 20, Window: time.Minute, Key: middleware.RateLimitByPrincipal})
+	// 確認メールの再送はメール爆撃に使われないようユーザー単位で少なく
+	verifyResendLimit := middleware.RateLimit(middleware.RateLimitPolicy{Name: "verify_email_resend", Limit: 3, Window: time.Hour, Key: middleware.RateLimitByPrincipal})
+	// Authorization: ApiKey で呼べるルートは受け付けるスコープを宣言する。
+	// 宣言の無いルート(キー管理・パスワード変更など)はアクセストークンでしか呼べない
+	// ADMIN_MFA_REQUIRED=true で管理者ルートを TOTP を有効化した管理者だけに許す。
+	// フロントエンドに TOTP の登録とログイン時のコード入力の画面ができるまでは既定で求めない
+	adminMFARequired := os.Getenv("ADMIN_MFA_REQUIRED") == "true"
+	// 管理者アカウントには API キーを発行しないので、管理者ルートはスコープを宣言しない
+	adminOnly := auth.Require(auth.RequireOptions{
+		Queries:    queries,
+		Roles:      []string{auth.RoleAdmin},
+		RequireMFA: adminMFARequired,
+	})
+	authWith := func(scopes ...string) gin.HandlerFunc {
+		return auth.Require(auth.RequireOptions{Queries: queries, Scopes: scopes})
+	}
+	// 注文の状態更新は店舗スタッフ(バリスタ)にも許可する。POS 連携から API キーでも呼べる。
+	// 返金もここを通るので、管理者には他の管理者ルートと同じく TOTP を求める(返金できるのは管理者だけで、ハンドラで確かめる)
+	staffOrAdmin := auth.Require(auth.RequireOptions{
+		Queries:    queries,
+		Roles:      []string{auth.RoleAdmin, auth.RoleStaff},
+		RequireMFA: adminMFARequired,
+		Scopes:     []string{auth.ScopeOrdersWrite},
+	})
+	// 注文と支払いで同じものを使い、期限切れのキーの掃除の間隔を共有する
+	idempotency := middleware.Idempotency(queries, middleware.DefaultIdempotencyOptions())
+	// 注文一覧は POS 連携などから頻繁に呼ばれるので、発行から1分以内のトークンは DB で状態を確認し直さない
+	ordersRead := auth.Require(auth.RequireOptions{
+		Queries:    queries,
+		Recheck:    auth.RecheckStale,
+		StaleAfter: time.Minute,
+		Scopes:     []string{auth.ScopeOrdersRead},
+	})
+	// 注文はカートから作るため、カートの操作も orders:write で許す
+	ordersWrite := authWith(auth.ScopeOrdersWrite)
 	{
-		api.POST("/register", handler.RegisterUserHandler(queries))
-		api.POST("/login", handler.LoginUserHandler(queries, tokenGenerator))
+		api.GET("/csrf", handler.CSRFTokenHandler())
+
+		api.POST("/register", authLimit, handler.RegisterUserHandler(queries, emailVerifier))
+		api.POST("/login", authLimit, handler.LoginUserHandler(queries, tokenGenerator, loginGuard))
+		api.POST("/login/mfa", authLimit, handler.LoginMFAHandler(queries, tokenGenerator, loginGuard))
+		api.POST("/password/forgot", authLimit, handler.ForgotPasswordHandler(queries, mailSender, passwordResetURL))
+		api.POST("/password/reset", authLimit, handler.ResetPasswordHandler(txRunner))
+		api.POST("/verify-email", authLimit, handler.VerifyEmailHandler(queries, emailVerifier))
+		api.POST("/verify-email/resend", auth.RequireAuth(queries), verifyResendLimit, handler.ResendVerificationEmailHandler(queries, emailVerifier))
+		api.GET("/auth/oidc/:provider/login", authLimit, handler.OIDCLoginHandler(oidcLogin))
+		api.GET("/auth/oidc/:provider/callback", authLimit, handler.OIDCCallbackHandler(oidcLogin, queries, txRunner, tokenGenerator))
+
+		api.POST("/categories", adminOnly, handler.CreateCategoryHandler(queries))
+		api.PUT("/categories/:id", adminOnly, handler.UpdateCategoryHandler(queries))
+		api.DELETE("/categories/:id", adminOnly, handler.DeleteCategoryHandler(queries))
+		api.GET("/categories", catalogLimit, handler.GetCategoriesHandler(queries))
 
-		api.POST("/categories", auth.AdminOnly(queries), handler.CreateCategoryHandler(queries))
-		api.PUT("/categories/:id", auth.AdminOnly(queries), handler.UpdateCategoryHandler(queries))
-		api.DELETE("/categories/:id", auth.AdminOnly(queries), handler.DeleteCategoryHandler(queries))
-		api.GET("/categories", handler.GetCategoriesHandler(queries))
+		api.GET("/products", catalogLimit, handler.ListProductsHandler(queries))
+		api.GET("/products/:id", catalogLimit, handler.GetProductHandler(queries))
+		api.POST("/products", adminOnly, handler.CreateProductHandler(queries))
+		api.PUT("/products/:id", adminOnly, handler.UpdateProductHandler(queries))
+		api.DELETE("/products/:id", adminOnly, handler.DeleteProductHandler(queries))
 
-		api.GET("/products", handler.ListProductsHandler(queries))
-		api.GET("/products/:id", handler.GetProductHandler(queries))
-		api.POST("/products", auth.AdminOnly(queries), handler.CreateProductHandler(queries))
-		api.PUT("/products/:id", auth.AdminOnly(queries), handler.UpdateProductHandler(queries))
-		api.DELETE("/products/:id", auth.AdminOnly(queries), handler.DeleteProductHandler(queries))
+		api.PATCH("/users/:id/role", adminOnly, handler.SetUserRoleHandler(queries))
 
-		api.PATCH("/users/:id/role", auth.AdminOnly(queries), handler.SetUserRoleHandler(queries))
+		api.GET("/admin/users", adminOnly, handler.ListUsersHandler(queries))
+		api.GET("/admin/users/:id", adminOnly, handler.GetUserDetailHandler(queries))
+		api.POST("/admin/users/:id/suspend", adminOnly, handler.SuspendUserHandler(txRunner))
+		api.POST("/admin/users/:id/reactivate", adminOnly, handler.ReactivateUserHandler(txRunner))
+		api.GET("/admin/users/:id/api-keys", adminOnly, handler.ListUserAPIKeysHandler(queries))
+		api.POST("/admin/users/:id/api-keys", adminOnly, handler.CreateUserAPIKeyHandler(queries))
+		api.DELETE("/admin/users/:id/api-keys/:keyId", adminOnly, handler.RevokeUserAPIKeyHandler(queries))
+		api.PATCH("/admin/orders/:id/status", staffOrAdmin, handler.UpdateOrderStatusHandler(txRunner, paymentProvider))
 
-		api.GET("/cart", auth.RequireAuth(queries), handler.GetCartHandler(queries))
-		api.POST("/cart/items", auth.RequireAuth(queries), handler.AddToCartHandler(queries))
-		api.PUT("/cart/items/:id", auth.RequireAuth(queries), handler.UpdateCartItemHandler(queries))
-		api.DELETE("/cart/items/:id", auth.RequireAuth(queries), handler.RemoveCartItemHandler(queries))
-		api.DELETE("/cart", auth.RequireAuth(queries), handler.ClearCartHandler(queries))
+		api.GET("/cart", ordersWrite, handler.GetCartHandler(queries))
+		api.POST("/cart/items", ordersWrite, handler.AddToCartHandler(queries))
+		api.PUT("/cart/items/:id", ordersWrite, handler.UpdateCartItemHandler(queries))
+		api.DELETE("/cart/items/:id", ordersWrite, handler.RemoveCartItemHandler(queries))
+		api.DELETE("/cart", ordersWrite, handler.ClearCartHandler(queries))
 
 		api.GET("/me", auth.RequireAuth(queries), handler.MeHandler(queries))
+		api.PATCH("/me", auth.RequireAuth(queries), handler.UpdateMeHandler(queries, emailVerifier))
+		api.POST("/me/password", auth.RequireAuth(queries), handler.ChangePasswordHandler(queries, txRunner, tokenGenerator, loginGuard))
+		api.GET("/me/sessions", auth.RequireAuth(queries), handler.ListSessionsHandler(queries))
+		api.DELETE("/me/sessions/:id", auth.RequireAuth(queries), handler.RevokeSessionHandler(queries))
+		api.POST("/me/sessions/revoke-others", auth.RequireAuth(queries), handler.RevokeOtherSessionsHandler(queries))
+		api.GET("/me/mfa", auth.RequireAuth(queries), handler.GetMFAStatusHandler(queries))
+		api.POST("/me/mfa/totp/setup", auth.RequireAuth(queries), handler.SetupTOTPHandler(queries))
+		api.POST("/me/mfa/totp/enable", auth.RequireAuth(queries), handler.EnableTOTPHandler(queries, txRunner))
+		api.POST("/me/mfa/totp/disable", auth.RequireAuth(queries), authLimit, handler.DisableTOTPHandler(queries, txRunner))
+		api.GET("/me/api-keys", auth.RequireAuth(queries), handler.ListMyAPIKeysHandler(queries))
+		api.POST("/me/api-keys", auth.RequireAuth(queries), handler.CreateMyAPIKeyHandler(queries))
+		api.DELETE("/me/api-keys/:id", auth.RequireAuth(queries), handler.RevokeMyAPIKeyHandler(queries))
 
-		api.GET("/orders", auth.RequireAuth(queries), handler.GetOrdersHandler(queries))
-		api.POST("/orders", auth.RequireAuth(queries), handler.CreateOrderHandler(conn, queries))
-		api.POST("/orders/:id/cancel", auth.RequireAuth(queries), handler.CancelOrderHandler(conn, queries))
+		api.GET("/orders", ordersRead, handler.GetOrdersHandler(queries))
+		api.POST("/orders", ordersWrite, verifiedForOrders, orderLimit, idempotency, handler.CreateOrderHandler(txRunner))
+		api.POST("/orders/:id/cancel", ordersWrite, handler.CancelOrderHandler(txRunner))
+		api.POST("/orders/:id/pay", ordersWrite, verifiedForOrders, orderLimit, idempotency, handler.PayOrderHandler(txRunner, paymentProvider))
 
-		api.POST("/refresh", handler.RefreshTokenHandler(queries, tokenGenerator))
+		api.POST("/refresh", refreshLimit, handler.RefreshTokenHandler(queries, txRunner, tokenGenerator))
 		api.POST("/logout", handler.LogoutHandler(queries))
 		api.POST("/refresh/revoke", handler.RevokeRefreshHandler(queries))
 	}
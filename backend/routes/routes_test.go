@@ -12,6 +12,7 @@ import (
 	testutil "sol_coffeesys/backend/handler/testutil"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/routes"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -39,7 +40,7 @@ func TestCategories_AdminOnlyMiddleware(t *testing.T) {
 		{
 			name: "非管理者->403",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "user"}, nil)
 			},
 			setAuthHeader:  true,
@@ -48,7 +49,7 @@ func TestCategories_AdminOnlyMiddleware(t *testing.T) {
 		{
 			name: "管理者->ハンドラ実行201",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("CreateCategory", mock.Anything, mock.Anything).
 					Return(db.Category{ID: 1, Name: "テスト"}, nil)
@@ -111,7 +112,7 @@ func TestCartRoutes_AuthMiddlewareAndDeleteIdempotency(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			setAuthHeader:  true,
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).Return(
+				m.On("GetUserByID", mock.Anything, int64(1)).Return(
 					db.User{
 						ID:   1,
 						Role: "member",
@@ -152,3 +153,117 @@ func TestCartRoutes_AuthMiddlewareAndDeleteIdempotency(t *testing.T) {
 		})
 	}
 }
+
+// Cookie 認証の状態を変えるリクエストは SetupRoutes 全体で CSRF トークンを要求する
+func TestSetupRoutes_CSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	mockDB := new(testutil.MockDB)
+	routes.SetupRoutes(router, nil, mockDB)
+
+	accessToken, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	assert.NoError(t, err)
+
+	// トークン無しの Cookie 認証は DB に触れる前に 403
+	req := httptest.NewRequest(http.MethodDelete, "/api/cart", nil)
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: accessToken})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockDB.AssertNotCalled(t, "GetUserByID", mock.Anything, mock.Anything)
+
+	// GET /api/csrf で受け取ったトークンを送れば通る
+	req = httptest.NewRequest(http.MethodGet, "/api/csrf", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	csrfCookies := w.Result().Cookies()
+
+	mockDB.On("GetUserByID", mock.Anything, int64(1)).
+		Return(db.User{ID: 1, Role: auth.RoleMember, Status: auth.UserStatusActive}, nil)
+	mockDB.On("ClearCartByUser", mock.Anything, int64(1)).Return(nil)
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/cart", nil)
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: accessToken})
+	for _, c := range csrfCookies {
+		req.AddCookie(c)
+	}
+	req.Header.Set(middleware.CSRFHeaderName, body["csrf_token"])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockDB.AssertExpectations(t)
+
+	// 除外したログインは古い Cookie が残っていても検証しない(空のボディなので 400)
+	req = httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: accessToken})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// API キーはルートが宣言したスコープでだけ通り、CSRF の対象にもならない
+func TestSetupRoutes_APIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_MFA_REQUIRED", "false")
+
+	raw, _, hash, err := auth.GenerateAPIKey()
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	mockDB := new(testutil.MockDB)
+	routes.SetupRoutes(router, nil, mockDB)
+
+	mockDB.On("GetActiveAPIKeyByHash", mock.Anything, hash).Return(db.GetActiveAPIKeyByHashRow{
+		ID: 4, UserID: 7, Scopes: []string{auth.ScopeOrdersWrite}, Role: auth.RoleStaff, Status: auth.UserStatusActive,
+	}, nil)
+	mockDB.On("TouchAPIKeyLastUsed", mock.Anything, int64(4)).Return(nil)
+
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "ApiKey "+raw)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// orders:write のキーは X-CSRF-Token 無しでもハンドラーまで届く(注文IDの検証で 400)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/api/orders/abc/cancel", ""))
+	// 持っていないスコープのルート
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/orders", ""))
+	// スコープを宣言していないルート(キー管理・商品やユーザーの管理)は API キーでは呼べない
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/me/api-keys", `{}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/products", `{}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/admin/users", ""))
+	mockDB.AssertExpectations(t)
+}
+
+// 注文一覧は発行直後のトークンなら DB でユーザーを引き直さない
+func TestSetupRoutes_OrdersReadSkipsRecheckForFreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	mockDB := new(testutil.MockDB)
+	routes.SetupRoutes(router, nil, mockDB)
+
+	accessToken, err := auth.DefaultTokenGenerator{}.GenerateToken(1, auth.RoleMember, "")
+	assert.NoError(t, err)
+
+	// 不正な status はハンドラーで 400 になる(認証は通っている)
+	req := httptest.NewRequest(http.MethodGet, "/api/orders?status=unknown", nil)
+	req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: accessToken})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "GetUserByID", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,167 @@
+//go:build integration
+
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/txn"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuspendAndReactivateUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 停止対象(リフレッシュトークン2件付き)
+	userID := seedPasswordResetUser(t)
+
+	var adminID int64
+	err := testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash, role)
+		VALUES ('管理者', 'admin@example.com', 'hash', 'admin')
+		RETURNING id
+	`).Scan(&adminID)
+	if err != nil {
+		t.Fatalf("admin insert failed:%v", err)
+	}
+
+	runner := txn.New(testDB)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.Use(func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: adminID})
+		c.Next()
+	})
+	router.POST("/api/admin/users/:id/suspend", handler.SuspendUserHandler(runner))
+	router.POST("/api/admin/users/:id/reactivate", handler.ReactivateUserHandler(runner))
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	idStr := strconv.FormatInt(userID, 10)
+
+	// I1: 停止すると status が suspended になり、リフレッシュトークンが全て失効する
+	w := post("/api/admin/users/" + idStr + "/suspend")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var status string
+	err = testDB.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, "suspended", status)
+
+	var activeRefresh int
+	err = testDB.QueryRow(`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&activeRefresh)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, activeRefresh)
+
+	// I2: 再開すると active に戻る
+	w = post("/api/admin/users/" + idStr + "/reactivate")
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = testDB.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, "active", status)
+
+	// I2-2: メールアドレス未確認のまま停止されたユーザーは、再開しても unverified のまま
+	_, err = testDB.Exec(`UPDATE users SET status = 'unverified' WHERE id = $1`, userID)
+	assert.NoError(t, err)
+	w = post("/api/admin/users/" + idStr + "/suspend")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = post("/api/admin/users/" + idStr + "/reactivate")
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = testDB.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, "unverified", status)
+
+	// I3: CHECK 制約により未定義の状態は保存できない
+	_, err = testDB.Exec(`UPDATE users SET status = 'banned' WHERE id = $1`, userID)
+	assert.Error(t, err)
+}
+
+func TestListUsersAndDetail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := seedPasswordResetUser(t)
+	_, err := testDB.Exec(`
+		INSERT INTO users(name, email, password_hash, role, status) VALUES
+		('管理者', 'admin@example.com', 'hash', 'admin', 'active'),
+		('停止ユーザー', 'suspended_user@example.com', 'hash', 'member', 'suspended')
+	`)
+	if err != nil {
+		t.Fatalf("users insert failed:%v", err)
+	}
+	_, err = testDB.Exec(`UPDATE users SET last_login_at = NOW() WHERE id = $1`, userID)
+	assert.NoError(t, err)
+
+	queries := db.New(testDB)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/api/admin/users", handler.ListUsersHandler(queries))
+	router.GET("/api/admin/users/:id", handler.GetUserDetailHandler(queries))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// I1: ステータスとキーワードで絞り込める。"_" はワイルドカードとして扱わない
+	w := get("/api/admin/users?status=suspended&q=" + url.QueryEscape("_user"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Users []map[string]any `json:"users"`
+		Total *int64           `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.NotNil(t, list.Total) {
+		assert.Equal(t, int64(1), *list.Total)
+	}
+	if assert.Len(t, list.Users, 1) {
+		assert.Equal(t, "suspended_user@example.com", list.Users[0]["email"])
+	}
+
+	// I2: limit を超える分は next_cursor で取得する
+	w = get("/api/admin/users?limit=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Users      []map[string]any `json:"users"`
+		NextCursor *string          `json:"next_cursor"`
+		Total      *int64           `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Users, 2)
+	assert.NotNil(t, page.Total)
+	if assert.NotNil(t, page.NextCursor) {
+		w = get("/api/admin/users?limit=2&cursor=" + *page.NextCursor)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Len(t, page.Users, 1)
+		assert.Nil(t, page.NextCursor)
+		// 件数は最初のページでだけ返す
+		assert.Nil(t, page.Total)
+	}
+
+	// I3: 詳細は有効なセッション数と最終ログイン日時を返し、機密項目は含まない
+	w = get("/api/admin/users/" + strconv.FormatInt(userID, 10))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var detail struct {
+		User map[string]any `json:"user"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Equal(t, float64(0), detail.User["order_count"])
+	assert.Equal(t, float64(2), detail.User["active_session_count"])
+	assert.NotNil(t, detail.User["last_login_at"])
+	assert.NotContains(t, w.Body.String(), "password_hash")
+	assert.NotContains(t, w.Body.String(), "reset_token")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
 	testutil "sol_coffeesys/backend/handler/testutil"
@@ -163,11 +164,26 @@ func TestCartFlow_TableTopCases(t *testing.T) {
 
 			// register handlers; optionally set auth into context depending on case
 			if tc.withAuth {
-				router.POST("/api/cart/items", func(c *gin.Context) { c.Set("userID", int64(42)); handler.AddToCartHandler(mockDB)(c) })
-				router.GET("/api/cart", func(c *gin.Context) { c.Set("userID", int64(42)); handler.GetCartHandler(mockDB)(c) })
-				router.PUT("/api/cart/items/:id", func(c *gin.Context) { c.Set("userID", int64(42)); handler.UpdateCartItemHandler(mockDB)(c) })
-				router.DELETE("/api/cart/items/:id", func(c *gin.Context) { c.Set("userID", int64(42)); handler.RemoveCartItemHandler(mockDB)(c) })
-				router.DELETE("/api/cart", func(c *gin.Context) { c.Set("userID", int64(42)); handler.ClearCartHandler(mockDB)(c) })
+				router.POST("/api/cart/items", func(c *gin.Context) {
+					auth.SetPrincipal(c, auth.Principal{UserID: 42})
+					handler.AddToCartHandler(mockDB)(c)
+				})
+				router.GET("/api/cart", func(c *gin.Context) {
+					auth.SetPrincipal(c, auth.Principal{UserID: 42})
+					handler.GetCartHandler(mockDB)(c)
+				})
+				router.PUT("/api/cart/items/:id", func(c *gin.Context) {
+					auth.SetPrincipal(c, auth.Principal{UserID: 42})
+					handler.UpdateCartItemHandler(mockDB)(c)
+				})
+				router.DELETE("/api/cart/items/:id", func(c *gin.Context) {
+					auth.SetPrincipal(c, auth.Principal{UserID: 42})
+					handler.RemoveCartItemHandler(mockDB)(c)
+				})
+				router.DELETE("/api/cart", func(c *gin.Context) {
+					auth.SetPrincipal(c, auth.Principal{UserID: 42})
+					handler.ClearCartHandler(mockDB)(c)
+				})
 			} else {
 				// register handlers without setting userID to simulate unauthorized
 				router.POST("/api/cart/items", func(c *gin.Context) { handler.AddToCartHandler(mockDB)(c) })
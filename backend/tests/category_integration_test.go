@@ -54,7 +54,7 @@ func TestCreateCategory_AdminAndNonAdmin(t *testing.T) {
 			body:   map[string]string{"name": "A"},
 			target: "/api/categories",
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("CreateCategory", mock.Anything, mock.Anything).
 					Return(db.Category{ID: 1, Name: "A"}, nil)
@@ -70,7 +70,7 @@ func TestCreateCategory_AdminAndNonAdmin(t *testing.T) {
 			target: "/api/categories",
 			body:   map[string]string{"name": "A"},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(2)).
+				m.On("GetUserByID", mock.Anything, int64(2)).
 					Return(db.User{ID: 2, Role: "member"}, nil)
 			},
 			validate: func(string) (*jwt.Token, error) {
@@ -84,7 +84,7 @@ func TestCreateCategory_AdminAndNonAdmin(t *testing.T) {
 			target: "/api/categories/1",
 			body:   map[string]string{"name": "Updated"},
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("UpdateCategory", mock.Anything, mock.Anything).
 					Return(db.Category{ID: 1, Name: "Updated"}, nil)
@@ -100,7 +100,7 @@ func TestCreateCategory_AdminAndNonAdmin(t *testing.T) {
 			target: "/api/categories/1",
 			body:   nil,
 			setupMock: func(m *testutil.MockDB) {
-				m.On("GetUserForUpdate", mock.Anything, int64(1)).
+				m.On("GetUserByID", mock.Anything, int64(1)).
 					Return(db.User{ID: 1, Role: "admin"}, nil)
 				m.On("DeleteCategory", mock.Anything, int64(1)).Return(nil)
 			},
@@ -0,0 +1,77 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottle_PostgresStore(t *testing.T) {
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+	ctx := context.Background()
+	store := loginguard.NewPostgresStore(db.New(testDB))
+	key := loginguard.AccountKey("throttle@example.com")
+
+	for want := 1; want <= 3; want++ {
+		got, err := store.RecordFailure(ctx, key, time.Now().Add(-15*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// 集計期間より前に始まったカウンタは 1 から数え直す
+	got, err := store.RecordFailure(ctx, key, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, got)
+
+	until := time.Now().Add(15 * time.Minute).Truncate(time.Microsecond)
+	require.NoError(t, store.Lock(ctx, key, until))
+	locked, err := store.LockedUntil(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, until.Equal(locked))
+
+	// ロック後は失敗回数が戻っている
+	got, err = store.RecordFailure(ctx, key, time.Now().Add(-15*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, got)
+
+	require.NoError(t, store.Reset(ctx, key))
+	locked, err = store.LockedUntil(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, locked.IsZero())
+}
+
+func TestLoginThrottle_PostgresStorePrune(t *testing.T) {
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+	ctx := context.Background()
+	store := loginguard.NewPostgresStore(db.New(testDB))
+	stale, locked, fresh := loginguard.IPKey("192.0.2.1"), loginguard.IPKey("192.0.2.2"), loginguard.IPKey("192.0.2.3")
+
+	for _, key := range []string{stale, locked, fresh} {
+		_, err := store.RecordFailure(ctx, key, time.Now().Add(-15*time.Minute))
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Lock(ctx, locked, time.Now().Add(time.Hour)))
+	_, err := testDB.ExecContext(ctx, `UPDATE login_throttles SET updated_at = NOW() - INTERVAL '1 hour' WHERE throttle_key IN ($1, $2)`, stale, locked)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Prune(ctx, time.Now().Add(-15*time.Minute)))
+
+	var keys []string
+	rows, err := testDB.QueryContext(ctx, `SELECT throttle_key FROM login_throttles ORDER BY throttle_key`)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		require.NoError(t, rows.Scan(&key))
+		keys = append(keys, key)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{locked, fresh}, keys)
+}
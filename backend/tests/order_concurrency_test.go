@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"sol_coffeesys/backend/db"
+	"slices"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/handler"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/txn"
 	"sync"
 	"testing"
 
@@ -105,7 +107,7 @@ func TestCreateOrderHandler_Concurrency(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			queries := db.New(testDB)
+			runner := txn.New(testDB)
 			productID, userIDs := seedConcurrentOrders(t, tt.userCnt, tt.cartQty, tt.productQty)
 
 			var (
@@ -123,8 +125,8 @@ func TestCreateOrderHandler_Concurrency(t *testing.T) {
 					router := gin.New()
 					router.Use(middleware.ErrorHandler(apperror.ToHTTP))
 					router.POST("/api/orders", func(c *gin.Context) {
-						c.Set("userID", userID)
-						handler.CreateOrderHandler(testDB, queries)(c)
+						auth.SetPrincipal(c, auth.Principal{UserID: userID})
+						handler.CreateOrderHandler(runner)(c)
 					})
 
 					req := httptest.NewRequest(http.MethodPost, "/api/orders", bytes.NewBufferString(`{}`))
@@ -169,3 +171,161 @@ func TestCreateOrderHandler_Concurrency(t *testing.T) {
 	}
 
 }
+
+// seedOverlappingCarts は productCnt 個の商品を作成し、各ユーザーのカートに全商品を入れる。
+// 奇数番目のユーザーは商品を逆順にカートへ入れ、ロック順序が食い違う状況を作る。
+func seedOverlappingCarts(t *testing.T, userCnt int, productCnt int, cartQty int64, productQty int64) ([]int64, []int64) {
+	t.Helper()
+
+	var categoryID int64
+	err := testDB.QueryRow(`
+		INSERT INTO categories (name)
+		VALUES('テストカテゴリ')
+		RETURNING id
+	`).Scan(&categoryID)
+	if err != nil {
+		t.Fatalf("category insert failed:%v", err)
+	}
+
+	var productIDs []int64
+	for i := range productCnt {
+		var productID int64
+		err := testDB.QueryRow(`
+			INSERT INTO products (name, price, category_id, sku, stock_quantity)
+			VALUES($1, 750, $2, $3, $4)
+			RETURNING id
+		`, fmt.Sprintf("テストコーヒー%d", i), categoryID, fmt.Sprintf("SKU_OVERLAP-%03d", i), productQty).Scan(&productID)
+		if err != nil {
+			t.Fatalf("product insert failed:%v", err)
+		}
+		productIDs = append(productIDs, productID)
+	}
+
+	var userIDs []int64
+	for i := range userCnt {
+		var userID int64
+		err := testDB.QueryRow(`
+			INSERT INTO users (name, email, password_hash)
+			VALUES ($1, $2, 'dummy_hash')
+			RETURNING id
+		`, fmt.Sprintf("同期テストユーザー%d", i), fmt.Sprintf("overlap%d@example.com", i)).Scan(&userID)
+		if err != nil {
+			t.Fatalf("users insert failed: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+
+		var cartID int64
+		err = testDB.QueryRow(`
+		INSERT INTO carts(user_id) VALUES ($1) RETURNING id
+	`, userID).Scan(&cartID)
+		if err != nil {
+			t.Fatalf("cart insert failed:%v", err)
+		}
+
+		order := slices.Clone(productIDs)
+		if i%2 == 1 {
+			slices.Reverse(order)
+		}
+		for _, productID := range order {
+			_, err = testDB.Exec(`
+			INSERT INTO cart_items(cart_id, product_id, quantity, price)
+			VALUES($1, $2, $3, $4)
+		`, cartID, productID, cartQty, 750*cartQty)
+			if err != nil {
+				t.Fatalf("cart_item insert failed:%v", err)
+			}
+		}
+	}
+
+	t.Cleanup(func() {
+		cleanupOrderRelatedTables(t)
+	})
+
+	return productIDs, userIDs
+}
+
+func TestCreateOrderHandler_OverlappingCarts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		userCnt    int
+		productCnt int
+		cartQty    int64
+		productQty int64
+	}{
+		{
+			name:       "在庫十分 ユーザー20 商品3 全員成功",
+			userCnt:    20,
+			productCnt: 3,
+			cartQty:    1,
+			productQty: 100,
+		},
+		{
+			name:       "在庫不足 ユーザー20 商品4 成功5 競合15",
+			userCnt:    20,
+			productCnt: 4,
+			cartQty:    2,
+			productQty: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := txn.New(testDB)
+			productIDs, userIDs := seedOverlappingCarts(t, tt.userCnt, tt.productCnt, tt.cartQty, tt.productQty)
+
+			var (
+				wg          sync.WaitGroup
+				mu          sync.Mutex
+				statusCodes []int
+			)
+			ready := make(chan struct{})
+			for _, uid := range userIDs {
+				wg.Add(1)
+				go func(userID int64) {
+					defer wg.Done()
+					<-ready
+
+					router := gin.New()
+					router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+					router.POST("/api/orders", func(c *gin.Context) {
+						auth.SetPrincipal(c, auth.Principal{UserID: userID})
+						handler.CreateOrderHandler(runner)(c)
+					})
+
+					req := httptest.NewRequest(http.MethodPost, "/api/orders", bytes.NewBufferString(`{}`))
+					req.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, req)
+
+					mu.Lock()
+					statusCodes = append(statusCodes, w.Code)
+					mu.Unlock()
+				}(uid)
+			}
+
+			close(ready)
+			wg.Wait()
+
+			successCnt := int64(0)
+			for _, code := range statusCodes {
+				switch code {
+				case http.StatusCreated:
+					successCnt++
+				case http.StatusConflict:
+				default:
+					// デッドロックが 500 として漏れていないこと
+					t.Fatalf("unexpected status code: %d", code)
+				}
+			}
+
+			expectedSuccessCnt := min(int64(tt.userCnt), tt.productQty/tt.cartQty)
+			assert.Len(t, statusCodes, tt.userCnt)
+			assert.Equal(t, expectedSuccessCnt, successCnt)
+			for _, productID := range productIDs {
+				assertProductStockByID(t, productID, int32(tt.productQty-expectedSuccessCnt*tt.cartQty))
+			}
+		})
+	}
+}
@@ -13,10 +13,12 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sol_coffeesys/backend/auth"
 	"sol_coffeesys/backend/db"
 	"sol_coffeesys/backend/handler"
 	"sol_coffeesys/backend/middleware"
 	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/txn"
 	"testing"
 	"time"
 
@@ -175,10 +177,10 @@ func TestCreateOrderHandler_HappyPath(t *testing.T) {
 
 	router := gin.New()
 	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
-	queries := db.New(testDB)
+	runner := txn.New(testDB)
 	router.POST("/api/orders", func(c *gin.Context) {
-		c.Set("userID", userID)
-		handler.CreateOrderHandler(testDB, queries)(c)
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.CreateOrderHandler(runner)(c)
 	})
 
 	req := httptest.NewRequest(http.MethodPost, "/api/orders", bytes.NewBufferString(`{}`))
@@ -284,7 +286,7 @@ func TestCreateOrderHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		setupDB        func(t *testing.T) int64 //DBを準備してuserIDを返す
-		rawUserID      interface{}
+		setAuth        bool
 		expectedStatus int
 		expectedErrMsg string
 		assertDB       func(t *testing.T, userID int64)
@@ -292,15 +294,7 @@ func TestCreateOrderHandler(t *testing.T) {
 		{
 			name:           "異常系：未認証",
 			setupDB:        nil,
-			rawUserID:      nil,
-			expectedStatus: http.StatusUnauthorized,
-			expectedErrMsg: "認証が必要です",
-			assertDB:       nil,
-		},
-		{
-			name:           "異常系：userIDが不正な型",
-			setupDB:        nil,
-			rawUserID:      "not-a-number",
+			setAuth:        false,
 			expectedStatus: http.StatusUnauthorized,
 			expectedErrMsg: "認証が必要です",
 			assertDB:       nil,
@@ -308,7 +302,7 @@ func TestCreateOrderHandler(t *testing.T) {
 		{
 			name:           "異常系：カートが空",
 			setupDB:        seedEmptyCart,
-			rawUserID:      int64(0),
+			setAuth:        true,
 			expectedStatus: http.StatusBadRequest,
 			expectedErrMsg: "カートが空です",
 			assertDB: func(t *testing.T, userID int64) {
@@ -318,7 +312,7 @@ func TestCreateOrderHandler(t *testing.T) {
 		{
 			name:           "異常系：在庫不足",
 			setupDB:        seedOutOfStock,
-			rawUserID:      int64(0),
+			setAuth:        true,
 			expectedStatus: http.StatusConflict,
 			expectedErrMsg: "在庫不足です",
 			assertDB: func(t *testing.T, userID int64) {
@@ -328,55 +322,24 @@ func TestCreateOrderHandler(t *testing.T) {
 				assertCartItemCountByUser(t, userID, 1)
 			},
 		},
-		{
-			name:           "正常系：userIDがintでも通る",
-			setupDB:        seedEmptyCart,
-			rawUserID:      int(0),
-			expectedStatus: http.StatusBadRequest,
-			expectedErrMsg: "カートが空です",
-			assertDB: func(t *testing.T, userID int64) {
-				assertOrderCountByUser(t, userID, 0)
-			},
-		},
-		{
-			name:           "正常系：userIDがfloatでも通る",
-			setupDB:        seedEmptyCart,
-			rawUserID:      float64(0),
-			expectedStatus: http.StatusBadRequest,
-			expectedErrMsg: "カートが空です",
-			assertDB: func(t *testing.T, userID int64) {
-				assertOrderCountByUser(t, userID, 0)
-			},
-		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var userID int64
-			rawUserID := tt.rawUserID
-
 			if tt.setupDB != nil {
 				userID = tt.setupDB(t)
-
-				switch tt.rawUserID.(type) {
-				case int:
-					rawUserID = int(userID)
-				case float64:
-					rawUserID = float64(userID)
-				case int64:
-					rawUserID = userID
-				}
 			}
 
 			router := gin.New()
 			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
-			queries := db.New(testDB)
+			runner := txn.New(testDB)
 
 			router.POST("/api/orders", func(c *gin.Context) {
-				if rawUserID != nil {
-					c.Set("userID", rawUserID)
+				if tt.setAuth {
+					auth.SetPrincipal(c, auth.Principal{UserID: userID})
 				}
-				handler.CreateOrderHandler(testDB, queries)(c)
+				handler.CreateOrderHandler(runner)(c)
 			})
 
 			req := httptest.NewRequest(http.MethodPost, "/api/orders", bytes.NewBufferString(`{}`))
@@ -463,11 +426,11 @@ func TestCancelOrderHandler_HappyPath(t *testing.T) {
 
 	router := gin.New()
 	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
-	queries := db.New(testDB)
+	runner := txn.New(testDB)
 
 	router.POST("/api/orders/:id/cancel", func(c *gin.Context) {
-		c.Set("userID", userID)
-		handler.CancelOrderHandler(testDB, queries)(c)
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.CancelOrderHandler(runner)(c)
 	})
 
 	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/orders/%d/cancel", orderID), bytes.NewBufferString(`{}`))
@@ -633,7 +596,7 @@ func seedCancelledOrderForCancel(t *testing.T) (userID int64, orderID int64, pro
 }
 
 type cancelCaseSeed struct {
-	rawUserID interface{}
+	userID    int64
 	orderID   int64
 	productID int64
 }
@@ -655,7 +618,7 @@ func TestCancelOrderHandler(t *testing.T) {
 			setupDB: func(t *testing.T) cancelCaseSeed {
 				requestUserID, orderID, productID := seedOthersOrderForCancel(t)
 				return cancelCaseSeed{
-					rawUserID: requestUserID,
+					userID:    requestUserID,
 					orderID:   orderID,
 					productID: productID,
 				}
@@ -677,7 +640,7 @@ func TestCancelOrderHandler(t *testing.T) {
 				userID, _, productID := seedCancelledOrderForCancel(t)
 				// 明示的にorderID-1を指定
 				return cancelCaseSeed{
-					rawUserID: userID,
+					userID:    userID,
 					orderID:   -1,
 					productID: productID,
 				}
@@ -695,7 +658,7 @@ func TestCancelOrderHandler(t *testing.T) {
 			setupDB: func(t *testing.T) cancelCaseSeed {
 				userID, orderID, productID := seedCancelledOrderForCancel(t)
 				return cancelCaseSeed{
-					rawUserID: userID,
+					userID:    userID,
 					orderID:   orderID,
 					productID: productID,
 				}
@@ -716,7 +679,7 @@ func TestCancelOrderHandler(t *testing.T) {
 			setupDB: func(t *testing.T) cancelCaseSeed {
 				userID, orderID, productID := seedOthersOrderForCancel(t)
 				return cancelCaseSeed{
-					rawUserID: userID,
+					userID:    userID,
 					orderID:   orderID,
 					productID: productID,
 				}
@@ -737,7 +700,7 @@ func TestCancelOrderHandler(t *testing.T) {
 			setupDB: func(t *testing.T) cancelCaseSeed {
 				userID, orderID, productID := seedOthersOrderForCancel(t)
 				return cancelCaseSeed{
-					rawUserID: userID,
+					userID:    userID,
 					orderID:   orderID,
 					productID: productID,
 				}
@@ -753,70 +716,6 @@ func TestCancelOrderHandler(t *testing.T) {
 				assertProductStockByID(t, seed.productID, 8)
 			},
 		},
-		{
-			name: "正常系：userIDがintでも通る",
-			setupDB: func(t *testing.T) cancelCaseSeed {
-				userID, orderID, productID := seedOthersOrderForCancel(t)
-				return cancelCaseSeed{
-					rawUserID: int(userID),
-					orderID:   orderID,
-					productID: productID,
-				}
-			},
-			pathBuilder: func(seed cancelCaseSeed) string {
-				return fmt.Sprintf("/api/orders/%d/cancel", seed.orderID)
-			},
-			setAuth:        true,
-			expectedStatus: http.StatusNotFound,
-			expectedErrMsg: "注文が見つかりません",
-			assertDB: func(t *testing.T, seed cancelCaseSeed) {
-				assertOrderStatus(t, seed.orderID, "pending")
-				assertProductStockByID(t, seed.productID, 8)
-			},
-		},
-		{
-			name: "正常系：userIDがfloatでも通る",
-			setupDB: func(t *testing.T) cancelCaseSeed {
-				userID, orderID, productID := seedOthersOrderForCancel(t)
-				return cancelCaseSeed{
-					rawUserID: float64(userID),
-					orderID:   orderID,
-					productID: productID,
-				}
-			},
-			pathBuilder: func(seed cancelCaseSeed) string {
-				return fmt.Sprintf("/api/orders/%d/cancel", seed.orderID)
-			},
-			setAuth:        true,
-			expectedStatus: http.StatusNotFound,
-			expectedErrMsg: "注文が見つかりません",
-			assertDB: func(t *testing.T, seed cancelCaseSeed) {
-				assertOrderStatus(t, seed.orderID, "pending")
-				assertProductStockByID(t, seed.productID, 8)
-			},
-		},
-		{
-			name: "異常系：userIDの型が不正",
-			setupDB: func(t *testing.T) cancelCaseSeed {
-				_, orderID, productID := seedOthersOrderForCancel(t)
-				// 不正な型のuserIDを注入
-				return cancelCaseSeed{
-					rawUserID: "not-a-number",
-					orderID:   orderID,
-					productID: productID,
-				}
-			},
-			pathBuilder: func(seed cancelCaseSeed) string {
-				return fmt.Sprintf("/api/orders/%d/cancel", seed.orderID)
-			},
-			setAuth:        true,
-			expectedStatus: http.StatusUnauthorized,
-			expectedErrMsg: "認証が必要です",
-			assertDB: func(t *testing.T, seed cancelCaseSeed) {
-				assertOrderStatus(t, seed.orderID, "pending")
-				assertProductStockByID(t, seed.productID, 8)
-			},
-		},
 	}
 
 	for _, tt := range tests {
@@ -828,13 +727,13 @@ func TestCancelOrderHandler(t *testing.T) {
 
 			router := gin.New()
 			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
-			queries := db.New(testDB)
+			runner := txn.New(testDB)
 
 			router.POST("/api/orders/:id/cancel", func(c *gin.Context) {
 				if tt.setAuth {
-					c.Set("userID", seed.rawUserID)
+					auth.SetPrincipal(c, auth.Principal{UserID: seed.userID})
 				}
-				handler.CancelOrderHandler(testDB, queries)(c)
+				handler.CancelOrderHandler(runner)(c)
 			})
 
 			req := httptest.NewRequest(http.MethodPost, tt.pathBuilder(seed), bytes.NewBufferString(`{}`))
@@ -855,3 +754,175 @@ func TestCancelOrderHandler(t *testing.T) {
 		})
 	}
 }
+
+// users, products, orders(3件、うち1件は明細なし)の設定
+func seedOrdersWithItems(t *testing.T) (userID int64, orderIDs []int64) {
+	t.Helper()
+
+	var categoryID, productID int64
+
+	err := testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash)
+		VALUES ('一覧ユーザー', 'list-orders@example.com', 'dummy_hash')
+		RETURNING id
+	`).Scan(&userID)
+	if err != nil {
+		t.Fatalf("user insert failed:%v", err)
+	}
+
+	err = testDB.QueryRow(`
+		INSERT INTO categories (name)
+		VALUES('テストカテゴリ')
+		RETURNING id
+	`).Scan(&categoryID)
+	if err != nil {
+		t.Fatalf("category insert failed:%v", err)
+	}
+
+	err = testDB.QueryRow(`
+		INSERT INTO products (name, price, category_id, sku, stock_quantity)
+		VALUES ('一覧商品', 750, $1, 'SKU_LIST_ORDERS_001', 10)
+		RETURNING id
+	`, categoryID).Scan(&productID)
+	if err != nil {
+		t.Fatalf("product insert failed:%v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		var orderID int64
+		err = testDB.QueryRow(`
+			INSERT INTO orders (user_id, total, status)
+			VALUES ($1, $2, 'pending')
+			RETURNING id
+		`, userID, 750*i).Scan(&orderID)
+		if err != nil {
+			t.Fatalf("order insert failed:%v", err)
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	// 1件目に1明細、3件目に2明細
+	for _, it := range []struct {
+		orderID int64
+		qty     int
+	}{
+		{orderIDs[0], 1},
+		{orderIDs[2], 1},
+		{orderIDs[2], 2},
+	} {
+		_, err = testDB.Exec(`
+			INSERT INTO order_items (order_id, product_id, quantity, unit_price, product_name_snapshot)
+			VALUES ($1, $2, $3, 750, '一覧商品')
+		`, it.orderID, productID, it.qty)
+		if err != nil {
+			t.Fatalf("order_item insert failed:%v", err)
+		}
+	}
+
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	return userID, orderIDs
+}
+
+func TestGetOrdersHandler_BatchedItems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID, orderIDs := seedOrdersWithItems(t)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	queries := db.New(testDB)
+
+	router.GET("/api/orders", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.GetOrdersHandler(queries)(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Orders []handler.OrderWithItems `json:"orders"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Orders, 3)
+
+	itemCount := map[int64]int{}
+	for _, o := range resp.Orders {
+		for _, it := range o.Items {
+			// 明細が別の注文に紛れ込んでいないこと
+			assert.Equal(t, o.Order.ID, it.OrderID)
+		}
+		itemCount[o.Order.ID] = len(o.Items)
+	}
+	assert.Equal(t, 1, itemCount[orderIDs[0]])
+	assert.Equal(t, 0, itemCount[orderIDs[1]])
+	assert.Equal(t, 2, itemCount[orderIDs[2]])
+}
+
+func TestGetOrdersHandler_Pagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID, orderIDs := seedOrdersWithItems(t)
+
+	// created_at が同じでも id で順序が決まることを確認する
+	_, err := testDB.Exec(`UPDATE orders SET created_at = '2026-01-15T00:00:00Z' WHERE user_id = $1`, userID)
+	if err != nil {
+		t.Fatalf("order update failed:%v", err)
+	}
+	_, err = testDB.Exec(`UPDATE orders SET status = 'cancelled' WHERE id = $1`, orderIDs[1])
+	if err != nil {
+		t.Fatalf("order update failed:%v", err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	queries := db.New(testDB)
+
+	router.GET("/api/orders", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.GetOrdersHandler(queries)(c)
+	})
+
+	type pageResp struct {
+		Orders     []handler.OrderWithItems `json:"orders"`
+		NextCursor *string                  `json:"next_cursor"`
+	}
+	get := func(query string) pageResp {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/orders"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp pageResp
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	ids := func(p pageResp) []int64 {
+		var res []int64
+		for _, o := range p.Orders {
+			res = append(res, o.Order.ID)
+		}
+		return res
+	}
+
+	first := get("?limit=2")
+	assert.Equal(t, []int64{orderIDs[2], orderIDs[1]}, ids(first))
+	if assert.NotNil(t, first.NextCursor) {
+		second := get("?limit=2&cursor=" + *first.NextCursor)
+		assert.Equal(t, []int64{orderIDs[0]}, ids(second))
+		assert.Nil(t, second.NextCursor)
+	}
+
+	cancelled := get("?status=cancelled")
+	assert.Equal(t, []int64{orderIDs[1]}, ids(cancelled))
+
+	inRange := get("?from=2026-01-15T00:00:00Z&to=2026-01-16T00:00:00Z")
+	assert.Len(t, inRange.Orders, 3)
+	outOfRange := get("?from=2026-01-16T00:00:00Z")
+	assert.Empty(t, outOfRange.Orders)
+}
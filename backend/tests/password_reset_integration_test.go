@@ -0,0 +1,174 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/mail"
+	"sol_coffeesys/backend/pkg/txn"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPasswordResetURL = "http://localhost:3000/password/reset"
+
+type outboxSender struct {
+	sent []mail.Message
+}
+
+func (s *outboxSender) Send(ctx context.Context, msg mail.Message) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// パスワード再設定用のユーザーと有効なリフレッシュトークン2件を作成する
+func seedPasswordResetUser(t *testing.T) int64 {
+	t.Helper()
+
+	var userID int64
+	err := testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash)
+		VALUES ('再設定ユーザー', 'reset@example.com', 'old_hash')
+		RETURNING id
+	`).Scan(&userID)
+	if err != nil {
+		t.Fatalf("user insert failed:%v", err)
+	}
+
+	for _, hash := range []string{"refresh_hash_1", "refresh_hash_2"} {
+		_, err := testDB.Exec(`
+			INSERT INTO refresh_tokens(user_id, token_hash, expires_at, family_id)
+			VALUES ($1, $2, NOW() + INTERVAL '14 days', $2)
+		`, userID, hash)
+		if err != nil {
+			t.Fatalf("refresh_token insert failed:%v", err)
+		}
+	}
+
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	return userID
+}
+
+func newPasswordResetRouter(sender mail.Sender) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/password/forgot", handler.ForgotPasswordHandler(db.New(testDB), sender, testPasswordResetURL))
+	router.POST("/api/password/reset", handler.ResetPasswordHandler(txn.New(testDB)))
+	return router
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func resetTokenFromMail(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if strings.HasPrefix(line, testPasswordResetURL+"?") {
+			u, err := url.Parse(line)
+			if err != nil {
+				t.Fatalf("parse reset link failed:%v", err)
+			}
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("reset link not found: %q", msg.Body)
+	return ""
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := seedPasswordResetUser(t)
+	sender := &outboxSender{}
+	router := newPasswordResetRouter(sender)
+
+	// I1: forgot でトークンのハッシュと有効期限が保存される
+	w := postJSON(router, "/api/password/forgot", `{"email":"reset@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if !assert.Len(t, sender.sent, 1) {
+		return
+	}
+	rawToken := resetTokenFromMail(t, sender.sent[0])
+
+	var savedHash string
+	var expiresAt time.Time
+	err := testDB.QueryRow(`SELECT reset_token, reset_token_expires_at FROM users WHERE id = $1`, userID).Scan(&savedHash, &expiresAt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, rawToken, savedHash)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), expiresAt, time.Minute)
+
+	// I2: reset でパスワードが更新され、トークンが消費され、リフレッシュトークンが全て失効する
+	w = postJSON(router, "/api/password/reset", `{"token":"`+rawToken+`","password":"newpassword1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var passwordHash string
+	var resetToken *string
+	err = testDB.QueryRow(`SELECT password_hash, reset_token FROM users WHERE id = $1`, userID).Scan(&passwordHash, &resetToken)
+	assert.NoError(t, err)
+	assert.Nil(t, resetToken)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("newpassword1")))
+
+	var activeRefresh int
+	err = testDB.QueryRow(`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&activeRefresh)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, activeRefresh)
+
+	// I3: 同じトークンは再利用できない
+	w = postJSON(router, "/api/password/reset", `{"token":"`+rawToken+`","password":"another123"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), apperror.ValidationMessageResetToken)
+}
+
+func TestPasswordReset_ExpiredToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := seedPasswordResetUser(t)
+	sender := &outboxSender{}
+	router := newPasswordResetRouter(sender)
+
+	w := postJSON(router, "/api/password/forgot", `{"email":"reset@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if !assert.Len(t, sender.sent, 1) {
+		return
+	}
+	rawToken := resetTokenFromMail(t, sender.sent[0])
+
+	_, err := testDB.Exec(`UPDATE users SET reset_token_expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, userID)
+	assert.NoError(t, err)
+
+	w = postJSON(router, "/api/password/reset", `{"token":"`+rawToken+`","password":"newpassword1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var passwordHash string
+	err = testDB.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash)
+	assert.NoError(t, err)
+	assert.Equal(t, "old_hash", passwordHash)
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	seedPasswordResetUser(t)
+	sender := &outboxSender{}
+	router := newPasswordResetRouter(sender)
+
+	w := postJSON(router, "/api/password/forgot", `{"email":"unknown@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, sender.sent)
+}
@@ -0,0 +1,181 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/payment"
+	"sol_coffeesys/backend/pkg/txn"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// users, order(pending, total=1500)の設定
+func seedPayOrder(t *testing.T) (userID int64, orderID int64) {
+	t.Helper()
+
+	err := testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash)
+		VALUES ('決済ユーザー', 'pay@example.com', 'dummy_hash')
+		RETURNING id
+	`).Scan(&userID)
+	if err != nil {
+		t.Fatalf("user insert failed:%v", err)
+	}
+
+	err = testDB.QueryRow(`
+		INSERT INTO orders (user_id, total, status)
+		VALUES ($1, 1500, 'pending')
+		RETURNING id
+	`, userID).Scan(&orderID)
+	if err != nil {
+		t.Fatalf("order insert failed:%v", err)
+	}
+
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	return userID, orderID
+}
+
+func TestPayOrderHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name              string
+		body              string
+		expectedStatus    int
+		wantOrderStatus   string
+		wantPaymentStatus string
+	}{
+		{
+			name:              "I1: 決済成功で注文がpaidになる",
+			body:              `{"payment_method":"card"}`,
+			expectedStatus:    http.StatusOK,
+			wantOrderStatus:   "paid",
+			wantPaymentStatus: payment.StatusCompleted,
+		},
+		{
+			name:              "I2: 決済拒否でもfailedが記録され注文はpendingのまま",
+			body:              fmt.Sprintf(`{"payment_method":"%s"}`, payment.FakeDeclineMethod),
+			expectedStatus:    http.StatusBadRequest,
+			wantOrderStatus:   "pending",
+			wantPaymentStatus: payment.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, orderID := seedPayOrder(t)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+			runner := txn.New(testDB)
+			router.POST("/api/orders/:id/pay", func(c *gin.Context) {
+				auth.SetPrincipal(c, auth.Principal{UserID: userID})
+				handler.PayOrderHandler(runner, payment.FakeProvider{})(c)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/orders/%d/pay", orderID), bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assertOrderStatus(t, orderID, tt.wantOrderStatus)
+			assertPaymentStatusByOrder(t, orderID, tt.wantPaymentStatus)
+		})
+	}
+}
+
+func TestPayOrderHandler_RetryAfterDecline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID, orderID := seedPayOrder(t)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	runner := txn.New(testDB)
+	router.POST("/api/orders/:id/pay", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.PayOrderHandler(runner, payment.FakeProvider{})(c)
+	})
+
+	pay := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/orders/%d/pay", orderID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, pay(fmt.Sprintf(`{"payment_method":"%s"}`, payment.FakeDeclineMethod)))
+	assert.Equal(t, http.StatusOK, pay(`{"payment_method":"card"}`))
+
+	assertOrderStatus(t, orderID, "paid")
+	assertPaymentStatusByOrder(t, orderID, payment.StatusCompleted)
+
+	// 再試行は同じ payments 行を使い、試行回数だけ進める
+	var rows, attempt int
+	err := testDB.QueryRow(`SELECT COUNT(*), MAX(attempt) FROM payments WHERE order_id = $1`, orderID).Scan(&rows, &attempt)
+	if err != nil {
+		t.Fatalf("payments select failed:%v", err)
+	}
+	assert.Equal(t, 1, rows)
+	assert.Equal(t, 2, attempt)
+}
+
+func TestUpdateOrderStatusHandler_Refund(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userID, orderID := seedPayOrder(t)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	runner := txn.New(testDB)
+	router.POST("/api/orders/:id/pay", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+		handler.PayOrderHandler(runner, payment.FakeProvider{})(c)
+	})
+	statusAs := func(role string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			auth.SetPrincipal(c, auth.Principal{UserID: userID, Role: role})
+			handler.UpdateOrderStatusHandler(runner, payment.FakeProvider{})(c)
+		}
+	}
+	router.PATCH("/api/admin/orders/:id/status", statusAs(auth.RoleAdmin))
+	router.PATCH("/api/staff/orders/:id/status", statusAs(auth.RoleStaff))
+
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	statusPath := fmt.Sprintf("/api/admin/orders/%d/status", orderID)
+
+	// 決済の無い注文は paid にも refunded にもできない
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPatch, statusPath, `{"status":"paid"}`))
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPatch, statusPath, `{"status":"refunded"}`))
+	assertOrderStatus(t, orderID, "pending")
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, fmt.Sprintf("/api/orders/%d/pay", orderID), `{"payment_method":"card"}`))
+
+	// スタッフは返金できない
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPatch, fmt.Sprintf("/api/staff/orders/%d/status", orderID), `{"status":"refunded"}`))
+	assertOrderStatus(t, orderID, "paid")
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPatch, statusPath, `{"status":"refunded"}`))
+
+	assertOrderStatus(t, orderID, "refunded")
+	assertPaymentStatusByOrder(t, orderID, payment.StatusRefunded)
+}
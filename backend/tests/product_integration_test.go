@@ -0,0 +1,184 @@
+//go:build integration
+
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// categories(2件), products(5件)の設定。戻り値は商品名→ID
+func seedProductCatalogue(t *testing.T) (coffeeID int64, productIDs map[string]int64) {
+	t.Helper()
+
+	var teaID int64
+	err := testDB.QueryRow(`INSERT INTO categories (name) VALUES ('コーヒー') RETURNING id`).Scan(&coffeeID)
+	if err != nil {
+		t.Fatalf("category insert failed:%v", err)
+	}
+	err = testDB.QueryRow(`INSERT INTO categories (name) VALUES ('紅茶') RETURNING id`).Scan(&teaID)
+	if err != nil {
+		t.Fatalf("category insert failed:%v", err)
+	}
+
+	productIDs = map[string]int64{}
+	for _, p := range []struct {
+		name        string
+		price       int
+		available   bool
+		categoryID  int64
+		sku         string
+		description string
+		stock       int
+		createdAt   string
+	}{
+		{"ハウスブレンド", 500, true, coffeeID, "SKU_SEARCH_001", "定番の深煎り", 10, "2026-01-01T00:00:00Z"},
+		{"エチオピア", 700, true, coffeeID, "SKU_SEARCH_002", "華やかな浅煎り", 0, "2026-01-02T00:00:00Z"},
+		{"デカフェ", 500, false, coffeeID, "SKU_SEARCH_003", "カフェインレス", 5, "2026-01-03T00:00:00Z"},
+		{"アールグレイ", 600, true, teaID, "SKU_SEARCH_004", "100%_ベルガモット", 3, "2026-01-04T00:00:00Z"},
+		{"ダージリン", 800, true, teaID, "SKU_SEARCH_005", "", 8, "2026-01-05T00:00:00Z"},
+	} {
+		var id int64
+		err := testDB.QueryRow(`
+			INSERT INTO products (name, price, is_available, category_id, sku, description, stock_quantity, created_at)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+			RETURNING id
+		`, p.name, p.price, p.available, p.categoryID, p.sku, p.description, p.stock, p.createdAt).Scan(&id)
+		if err != nil {
+			t.Fatalf("product insert failed:%v", err)
+		}
+		productIDs[p.name] = id
+	}
+
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	return coffeeID, productIDs
+}
+
+type productListResp struct {
+	Products   []handler.ProductResponse `json:"products"`
+	NextCursor *string                   `json:"next_cursor"`
+	Total      *int64                    `json:"total"`
+}
+
+func listProducts(t *testing.T, router *gin.Engine, query url.Values) productListResp {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/products?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp productListResp
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestListProductsHandler_Search(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	coffeeID, ids := seedProductCatalogue(t)
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.GET("/api/products", handler.ListProductsHandler(db.New(testDB)))
+
+	names := func(resp productListResp) []string {
+		var res []string
+		for _, p := range resp.Products {
+			res = append(res, p.Name)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantNames []string
+	}{
+		{
+			name:      "I1: カテゴリで絞り込む",
+			query:     url.Values{"category_id": {strconv.FormatInt(coffeeID, 10)}},
+			wantNames: []string{"ハウスブレンド", "エチオピア", "デカフェ"},
+		},
+		{
+			name:      "I2: 価格の範囲で絞り込む",
+			query:     url.Values{"min_price": {"600"}, "max_price": {"700"}},
+			wantNames: []string{"エチオピア", "アールグレイ"},
+		},
+		{
+			name:      "I3: 販売中かつ在庫ありのみ",
+			query:     url.Values{"is_available": {"true"}, "in_stock": {"true"}},
+			wantNames: []string{"ハウスブレンド", "アールグレイ", "ダージリン"},
+		},
+		{
+			name:      "I4: 商品名・説明・SKUの部分一致",
+			query:     url.Values{"q": {"煎り"}},
+			wantNames: []string{"ハウスブレンド", "エチオピア"},
+		},
+		{
+			name:      "I5: SKUで大文字小文字を区別しない",
+			query:     url.Values{"q": {"sku_search_005"}},
+			wantNames: []string{"ダージリン"},
+		},
+		{
+			name:      "I6: ワイルドカードは文字として扱う",
+			query:     url.Values{"q": {"%_"}},
+			wantNames: []string{"アールグレイ"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := listProducts(t, router, tt.query)
+			assert.Equal(t, tt.wantNames, names(resp))
+			if assert.NotNil(t, resp.Total) {
+				assert.Equal(t, int64(len(tt.wantNames)), *resp.Total)
+			}
+		})
+	}
+
+	// 全ページを辿ると並び順どおりに重複なく取得できる
+	sortCases := []struct {
+		sort      string
+		wantNames []string
+	}{
+		{handler.ProductSortID, []string{"ハウスブレンド", "エチオピア", "デカフェ", "アールグレイ", "ダージリン"}},
+		{handler.ProductSortPriceAsc, []string{"ハウスブレンド", "デカフェ", "アールグレイ", "エチオピア", "ダージリン"}},
+		{handler.ProductSortPriceDesc, []string{"ダージリン", "エチオピア", "アールグレイ", "デカフェ", "ハウスブレンド"}},
+		{handler.ProductSortNewest, []string{"ダージリン", "アールグレイ", "デカフェ", "エチオピア", "ハウスブレンド"}},
+	}
+	for _, sc := range sortCases {
+		t.Run("sort="+sc.sort, func(t *testing.T) {
+			var got []string
+			query := url.Values{"sort": {sc.sort}, "limit": {"2"}}
+			for i := 0; i < 5; i++ {
+				resp := listProducts(t, router, query)
+				// 件数は最初のページでだけ返す
+				if i == 0 {
+					if assert.NotNil(t, resp.Total) {
+						assert.Equal(t, int64(len(ids)), *resp.Total)
+					}
+				} else {
+					assert.Nil(t, resp.Total)
+				}
+				got = append(got, names(resp)...)
+				if resp.NextCursor == nil {
+					break
+				}
+				query.Set("cursor", *resp.NextCursor)
+			}
+			assert.Equal(t, sc.wantNames, got)
+		})
+	}
+}
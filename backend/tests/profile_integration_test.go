@@ -0,0 +1,49 @@
+//go:build integration
+
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateUserProfile_EmailChangeRequiresVerification(t *testing.T) {
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+	ctx := context.Background()
+	q := db.New(testDB)
+
+	var userID int64
+	err := testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash, role, status)
+		VALUES ('太郎', 'taro@example.com', 'hash', 'member', 'active')
+		RETURNING id
+	`).Scan(&userID)
+	require.NoError(t, err)
+
+	// 名前だけ・大文字小文字だけの変更では確認済みのまま
+	user, err := q.UpdateUserProfile(ctx, db.UpdateUserProfileParams{ID: userID, Name: sql.NullString{String: "次郎", Valid: true}})
+	require.NoError(t, err)
+	assert.Equal(t, auth.UserStatusActive, user.Status)
+	user, err = q.UpdateUserProfile(ctx, db.UpdateUserProfileParams{ID: userID, Email: sql.NullString{String: "Taro@example.com", Valid: true}})
+	require.NoError(t, err)
+	assert.Equal(t, auth.UserStatusActive, user.Status)
+
+	// 別のアドレスに変えると未確認に戻る
+	user, err = q.UpdateUserProfile(ctx, db.UpdateUserProfileParams{ID: userID, Email: sql.NullString{String: "jiro@example.com", Valid: true}})
+	require.NoError(t, err)
+	assert.Equal(t, "jiro@example.com", user.Email)
+	assert.Equal(t, auth.UserStatusUnverified, user.Status)
+
+	// 停止中のアカウントは停止のまま
+	_, err = testDB.Exec(`UPDATE users SET status = 'suspended' WHERE id = $1`, userID)
+	require.NoError(t, err)
+	user, err = q.UpdateUserProfile(ctx, db.UpdateUserProfileParams{ID: userID, Email: sql.NullString{String: "saburo@example.com", Valid: true}})
+	require.NoError(t, err)
+	assert.Equal(t, auth.UserStatusSuspended, user.Status)
+}
@@ -0,0 +1,246 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_coffeesys/backend/auth"
+	"sol_coffeesys/backend/db"
+	"sol_coffeesys/backend/handler"
+	"sol_coffeesys/backend/middleware"
+	"sol_coffeesys/backend/pkg/apperror"
+	"sol_coffeesys/backend/pkg/loginguard"
+	"sol_coffeesys/backend/pkg/txn"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "integration-secret")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash failed:%v", err)
+	}
+	var userID int64
+	err = testDB.QueryRow(`
+		INSERT INTO users(name, email, password_hash)
+		VALUES ('リフレッシュユーザー', 'refresh@example.com', $1)
+		RETURNING id
+	`, string(hash)).Scan(&userID)
+	if err != nil {
+		t.Fatalf("user insert failed:%v", err)
+	}
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	queries := db.New(testDB)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/login", handler.LoginUserHandler(queries, auth.DefaultTokenGenerator{}, loginguard.New(loginguard.NewPostgresStore(queries), loginguard.DefaultOptions())))
+	router.POST("/api/refresh", handler.RefreshTokenHandler(queries, txn.New(testDB), auth.DefaultTokenGenerator{}))
+
+	refreshCookie := func(w *httptest.ResponseRecorder) string {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "refresh_token" {
+				return c.Value
+			}
+		}
+		t.Fatalf("refresh_token cookie not set")
+		return ""
+	}
+	refresh := func(raw string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: raw})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := postJSON(router, "/api/login", `{"email":"refresh@example.com","password":"password123"}`)
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		return
+	}
+	first := refreshCookie(w)
+
+	// I1: rotate 後のトークンはログイン時と同じファミリーに属する
+	w = refresh(first)
+	assert.Equal(t, http.StatusOK, w.Code)
+	second := refreshCookie(w)
+
+	var families int
+	err = testDB.QueryRow(`SELECT COUNT(DISTINCT family_id) FROM refresh_tokens WHERE user_id = $1`, userID).Scan(&families)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, families)
+
+	// I2: rotate 済みのトークンを再提示するとファミリー全体が失効する
+	w = refresh(first)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var activeRefresh int
+	err = testDB.QueryRow(`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&activeRefresh)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, activeRefresh)
+
+	// I3: 正規の利用者が持つ最新トークンも使えなくなる
+	w = refresh(second)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// I4: 別ログインのファミリーは影響を受けない
+	w = postJSON(router, "/api/login", `{"email":"refresh@example.com","password":"password123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = refresh(refreshCookie(w))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// I5: 同じトークンで並行して rotate しても成功は1回だけで、残りは再利用としてファミリーごと失効する
+	w = postJSON(router, "/api/login", `{"email":"refresh@example.com","password":"password123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	raced := refreshCookie(w)
+
+	const parallel = 5
+	codes := make([]int, parallel)
+	var wg sync.WaitGroup
+	for i := range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = refresh(raced).Code
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		} else {
+			assert.Equal(t, http.StatusUnauthorized, code)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	err = testDB.QueryRow(`SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&activeRefresh)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, activeRefresh, "I4 のファミリーだけが残る")
+
+	// I6: rotate で失効したトークンだけに失効理由 'rotated' が付き、ログアウトで失効したトークンは再利用として扱わない
+	w = postJSON(router, "/api/login", `{"email":"refresh@example.com","password":"password123"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	loggedOut := refreshCookie(w)
+	assert.NoError(t, handler.RevokeRefreshByRaw(context.Background(), queries, loggedOut))
+	w = refresh(loggedOut)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var rotated, revokedOther int
+	err = testDB.QueryRow(`SELECT COUNT(*) FILTER (WHERE revoke_reason = 'rotated'), COUNT(*) FILTER (WHERE revoked_at IS NOT NULL AND revoke_reason IS NULL) FROM refresh_tokens WHERE user_id = $1`, userID).Scan(&rotated, &revokedOther)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rotated, "I1, I4, I5 の rotate")
+	assert.Equal(t, 3, revokedOther, "I2・I5 のファミリー失効と I6 のログアウト")
+}
+
+func TestSessionsListAndRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "integration-secret")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash failed:%v", err)
+	}
+	_, err = testDB.Exec(`
+		INSERT INTO users(name, email, password_hash)
+		VALUES ('セッションユーザー', 'sessions@example.com', $1)
+	`, string(hash))
+	if err != nil {
+		t.Fatalf("user insert failed:%v", err)
+	}
+	t.Cleanup(func() { cleanupOrderRelatedTables(t) })
+
+	queries := db.New(testDB)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(apperror.ToHTTP))
+	router.POST("/api/login", handler.LoginUserHandler(queries, auth.DefaultTokenGenerator{}, loginguard.New(loginguard.NewPostgresStore(queries), loginguard.DefaultOptions())))
+	router.GET("/api/me/sessions", auth.RequireAuth(queries), handler.ListSessionsHandler(queries))
+	router.DELETE("/api/me/sessions/:id", auth.RequireAuth(queries), handler.RevokeSessionHandler(queries))
+	router.POST("/api/me/sessions/revoke-others", auth.RequireAuth(queries), handler.RevokeOtherSessionsHandler(queries))
+
+	login := func(userAgent string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBufferString(`{"email":"sessions@example.com","password":"password123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if !assert.Equal(t, http.StatusOK, w.Code) {
+			t.FailNow()
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "access_token" {
+				return c.Value
+			}
+		}
+		t.Fatalf("access_token cookie not set")
+		return ""
+	}
+	do := func(method, path, accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type session struct {
+		ID        int64  `json:"id"`
+		UserAgent string `json:"user_agent"`
+		Current   bool   `json:"current"`
+	}
+	list := func(accessToken string) []session {
+		w := do(http.MethodGet, "/api/me/sessions", accessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Sessions []session `json:"sessions"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Sessions
+	}
+
+	tablet := login("CafeTablet/1.0")
+	phone := login("Phone/2.0")
+	login("Laptop/3.0")
+
+	// I1: 端末ごとのセッションが並び、操作中の端末にだけ current が付く
+	sessions := list(phone)
+	if !assert.Len(t, sessions, 3) {
+		return
+	}
+	var tabletSessionID int64
+	for _, s := range sessions {
+		assert.Equal(t, s.UserAgent == "Phone/2.0", s.Current)
+		if s.UserAgent == "CafeTablet/1.0" {
+			tabletSessionID = s.ID
+		}
+	}
+
+	// I2: 指定したセッションだけを終了できる
+	w := do(http.MethodDelete, "/api/me/sessions/"+strconv.FormatInt(tabletSessionID, 10), phone)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Len(t, list(tablet), 2)
+
+	// I3: 終了済みのセッションは404
+	w = do(http.MethodDelete, "/api/me/sessions/"+strconv.FormatInt(tabletSessionID, 10), phone)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// I4: 他の端末からログアウトすると操作中のセッションだけが残る
+	w = do(http.MethodPost, "/api/me/sessions/revoke-others", phone)
+	assert.Equal(t, http.StatusOK, w.Code)
+	sessions = list(phone)
+	if assert.Len(t, sessions, 1) {
+		assert.True(t, sessions[0].Current)
+	}
+}
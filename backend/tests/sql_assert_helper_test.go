@@ -81,8 +81,18 @@ func assertOrderStatus(t *testing.T, orderID int64, want string) {
 func cleanupOrderRelatedTables(t *testing.T) {
 	t.Helper()
 	_, err := testDB.Exec(`
-		TRUNCATE TABLE order_items, orders, cart_items, carts, products, categories, users
+		TRUNCATE TABLE order_items, orders, cart_items, carts, products, categories, users, login_throttles
 		RESTART IDENTITY CASCADE
 	`)
 	assert.NoError(t, err)
 }
+
+func assertPaymentStatusByOrder(t *testing.T, orderID int64, want string) {
+	t.Helper()
+	var status string
+	err := testDB.QueryRow(`
+		SELECT status FROM payments WHERE order_id = $1
+	`, orderID).Scan(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, want, status)
+}
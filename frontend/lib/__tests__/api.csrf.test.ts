@@ -0,0 +1,47 @@
+import { fetchWithAuth } from "../api";
+
+describe("fetchWithAuth CSRF", () => {
+  beforeEach(() => {
+    jest.resetAllMocks();
+  });
+
+  it("POST時は /api/csrf のトークンを X-CSRF-Token に付け、2回目以降は再利用する", async () => {
+    global.fetch = jest
+      .fn()
+      .mockResolvedValueOnce({
+        ok: true,
+        status: 200,
+        json: async () => ({ csrf_token: "csrf-abc" }),
+      } as unknown as Response)
+      .mockResolvedValue({
+        ok: true,
+        status: 201,
+        json: async () => ({}),
+      } as unknown as Response) as unknown as typeof global.fetch;
+
+    await fetchWithAuth("/api/cart/items", { method: "POST", body: "{}" });
+    await fetchWithAuth("/api/cart/items", { method: "POST", body: "{}" });
+
+    const calls = (global.fetch as jest.Mock).mock.calls;
+    expect(calls).toHaveLength(3);
+    expect(calls[0][0]).toContain("/api/csrf");
+    expect(calls[1][1].headers["X-CSRF-Token"]).toBe("csrf-abc");
+    expect(calls[2][1].headers["X-CSRF-Token"]).toBe("csrf-abc");
+  });
+
+  it("GET時はトークンを取得しない", async () => {
+    global.fetch = jest.fn(() =>
+      Promise.resolve({
+        ok: true,
+        status: 200,
+        json: async () => ({}),
+      }) as unknown as Response,
+    ) as unknown as typeof global.fetch;
+
+    await fetchWithAuth("/api/cart", { method: "GET" });
+
+    const calls = (global.fetch as jest.Mock).mock.calls;
+    expect(calls).toHaveLength(1);
+    expect(calls[0][1].headers["X-CSRF-Token"]).toBeUndefined();
+  });
+});
@@ -7,6 +7,7 @@ import {
   getCategories,
   getOrders,
   getProductById,
+  getProducts,
   setUserRole,
   updateCategory,
   updateProduct,
@@ -116,6 +117,38 @@ describe("ticket29 api functions", () => {
     await expect(setUserRole(3, "admin")).resolves.toEqual({ id: 3, role: "admin" });
   });
 
+  it("getProducts follows next_cursor until the last page", async () => {
+    const fetchMock = jest
+      .fn()
+      .mockImplementationOnce(() => mockResponse({ products: [{ id: 1 }], next_cursor: "c1", total: 2 }))
+      .mockImplementationOnce(() => mockResponse({ products: [{ id: 2 }], next_cursor: null, total: null }));
+    global.fetch = fetchMock as unknown as typeof global.fetch;
+
+    await expect(getProducts()).resolves.toEqual([{ id: 1 }, { id: 2 }]);
+    expect(fetchMock).toHaveBeenCalledTimes(2);
+    expect(String(fetchMock.mock.calls[0][0])).toContain("/api/products?limit=100");
+    expect(String(fetchMock.mock.calls[1][0])).toContain("cursor=c1");
+  });
+
+  it("getOrders follows next_cursor until the last page", async () => {
+    const order = (id: number) => ({
+      order: { ID: id, UserID: 1, Total: 500, Status: "pending", CreatedAt: "", UpdatedAt: "" },
+      items: [],
+    });
+    const fetchMock = jest
+      .fn()
+      .mockImplementationOnce(() => mockResponse({ orders: [order(2)], next_cursor: "c1" }))
+      .mockImplementationOnce(() => mockResponse({ orders: [order(1)], next_cursor: null }));
+    global.fetch = fetchMock as unknown as typeof global.fetch;
+
+    const orders = await getOrders("pending");
+
+    expect(orders.map((o) => o.order.id)).toEqual([2, 1]);
+    expect(fetchMock).toHaveBeenCalledTimes(2);
+    expect(String(fetchMock.mock.calls[0][0])).toContain("/api/orders?limit=100&status=pending");
+    expect(String(fetchMock.mock.calls[1][0])).toContain("cursor=c1");
+  });
+
   it("getOrders normalizes backend payload", async () => {
     global.fetch = jest.fn(() =>
       mockResponse({
@@ -12,6 +12,8 @@ export interface LoginResponse {
     name: string;
     email: string;
     role: "admin" | "member";
+    email_verified?: boolean;
+    has_password?: boolean;
   };
 }
 
@@ -109,6 +111,15 @@ interface CategoriesResponse {
 
 interface OrdersResponse {
   orders?: unknown[];
+  next_cursor?: string | null;
+}
+
+// 一覧APIはページ単位で返すので、next_cursor が無くなるまで続けて取得する
+const LIST_PAGE_LIMIT = 100;
+
+function nextCursorOf(data: unknown): string | null {
+  const cursor = (data as { next_cursor?: unknown } | null)?.next_cursor;
+  return typeof cursor === "string" && cursor !== "" ? cursor : null;
 }
 
 
@@ -200,6 +211,42 @@ async function refreshSessionOnce(): Promise<boolean> {
   return refreshSessionPromise;
 }
 
+/**
+ * Cookie認証で状態を変えるリクエストには X-CSRF-Token が必要。
+ * トークンは /api/csrf から取得してメモリに保持する。
+ */
+const CSRF_HEADER = "X-CSRF-Token";
+const SAFE_METHODS = ["GET", "HEAD", "OPTIONS"];
+
+let csrfTokenPromise: Promise<string> | null = null;
+
+async function fetchCsrfToken(): Promise<string> {
+  const res = await fetch(`${API_URL}/api/csrf`, {
+    method: "GET",
+    headers: { Accept: "application/json" },
+    credentials: "include",
+  });
+  const data = (await parseJsonSafe<{ csrf_token?: string }>(res)) as Record<string, unknown>;
+  return res.ok && typeof data.csrf_token === "string" ? data.csrf_token : "";
+}
+
+async function getCsrfToken(): Promise<string> {
+  if (!csrfTokenPromise) {
+    csrfTokenPromise = fetchCsrfToken();
+  }
+  try {
+    const token = await csrfTokenPromise;
+    // 取得できなかった場合はヘッダなしで送り、次回取り直す
+    if (!token) {
+      csrfTokenPromise = null;
+    }
+    return token;
+  } catch {
+    csrfTokenPromise = null;
+    return "";
+  }
+}
+
 async function fetchWithAuthInternal(
   url: string,
   options: RequestInit = {},
@@ -215,12 +262,26 @@ async function fetchWithAuthInternal(
     Object.assign(headers, existingHeaders);
   }
 
+  const method = (options.method ?? "GET").toUpperCase();
+  const needsCsrf = !SAFE_METHODS.includes(method);
+  if (needsCsrf) {
+    const csrfToken = await getCsrfToken();
+    if (csrfToken) {
+      headers[CSRF_HEADER] = csrfToken;
+    }
+  }
+
   const response = await fetch(url, {
     ...options,
     headers,
     credentials: "include",
   });
 
+  // CSRF Cookieが失効していると403になるため、次回は取り直す
+  if (needsCsrf && response.status === 403) {
+    csrfTokenPromise = null;
+  }
+
   // 401時はrefreshを一度だけ試行
   if (response.status === 401) {
     if (retryOnUnauthorized) {
@@ -255,17 +316,27 @@ export async function fetchWithAuth(
 }
 
 export async function getProducts(): Promise<Product[]> {
-  const res = await fetch(`${API_URL}/api/products`, {
-    method: "GET",
-    headers: { Accept: "application/json" },
-    credentials: "include",
-  });
-  const data = await parseJsonSafe<{ products?: Product[] }>(res);
-  if (!res.ok) {
-    const payload = data as Record<string, unknown>;
-    throw { status: res.status, ...payload } as ApiError;
-  }
-  return Array.isArray(data?.products) ? data.products : [];
+  const products: Product[] = [];
+  let cursor: string | null = null;
+  do {
+    const params = new URLSearchParams({ limit: String(LIST_PAGE_LIMIT) });
+    if (cursor) params.set("cursor", cursor);
+
+    const res = await fetch(`${API_URL}/api/products?${params.toString()}`, {
+      method: "GET",
+      headers: { Accept: "application/json" },
+      credentials: "include",
+    });
+    const data = await parseJsonSafe<{ products?: Product[]; next_cursor?: string | null }>(res);
+    if (!res.ok) {
+      const payload = data as Record<string, unknown>;
+      throw { status: res.status, ...payload } as ApiError;
+    }
+    if (Array.isArray(data?.products)) products.push(...data.products);
+    cursor = nextCursorOf(data);
+  } while (cursor);
+
+  return products;
 }
 
 export async function login(email: string, password: string): Promise<LoginResponse> {
@@ -439,21 +510,28 @@ export async function setUserRole(userId: number, role: "admin" | "member"): Pro
 }
 
 export async function getOrders(status?: "pending" | "cancelled"): Promise<OrderWithItems[]> {
-  const query = status ? `?status=${encodeURIComponent(status)}` : "";
-  const response = await fetchWithAuth(`${API_URL}/api/orders${query}`, {
-    method: "GET",
-    headers: { Accept: "application/json" },
-  });
+  const orders: unknown[] = [];
+  let cursor: string | null = null;
+  do {
+    const params = new URLSearchParams({ limit: String(LIST_PAGE_LIMIT) });
+    if (status) params.set("status", status);
+    if (cursor) params.set("cursor", cursor);
+
+    const response = await fetchWithAuth(`${API_URL}/api/orders?${params.toString()}`, {
+      method: "GET",
+      headers: { Accept: "application/json" },
+    });
 
-  const data = await parseJsonSafe<OrdersResponse>(response);
-  if (!response.ok) {
-    const payload = data as Record<string, unknown>;
-    throw { status: response.status, ...payload } as ApiError;
-  }
+    const data = await parseJsonSafe<OrdersResponse>(response);
+    if (!response.ok) {
+      const payload = data as Record<string, unknown>;
+      throw { status: response.status, ...payload } as ApiError;
+    }
 
-  const orders = Array.isArray((data as OrdersResponse).orders)
-    ? ((data as OrdersResponse).orders as unknown[])
-    : [];
+    const page = (data as OrdersResponse).orders;
+    if (Array.isArray(page)) orders.push(...page);
+    cursor = nextCursorOf(data);
+  } while (cursor);
 
   return orders.map((order) => normalizeOrderWithItems(order));
 }